@@ -2,7 +2,11 @@ package auth
 
 import (
 	"context"
+	"crypto/rand"
+	"errors"
 	"fmt"
+	"log"
+	"sync"
 
 	"user-notes-api/repositories"
 	"user-notes-api/utils"
@@ -16,14 +20,36 @@ type RegistrationManagerIfc interface {
 	RegisterUser(ctx context.Context, credentials *Credentials) (uint, error)
 }
 
+type PasswordManagerIfc interface {
+	VerifyPassword(ctx context.Context, userId uint, credentials *Credentials) (bool, error)
+	ChangePassword(ctx context.Context, userId uint, credentials *Credentials, newPassword string) (bool, error)
+	SetPassword(ctx context.Context, userId uint, username string, newPassword string) error
+}
+
+// LoginManager verifies passwords with the algorithm and parameters stored in their hash strings.
+// Passwords that PwdHasher reports as needing a rehash are hashed again on login.
 type LoginManager struct {
 	UserReader  repositories.UserReader
-	PwdComparer utils.PasswordComparer
+	UserUpdater repositories.UserUpdater
+	PwdHasher   utils.PasswordAlgorithm
+
+	dummyOnce sync.Once
+	dummyHash string
 }
 
 type RegistrationManager struct {
 	UserCreator repositories.UserCreator
-	PwdHasher   utils.PasswordHasher
+	PwdHasher   utils.PasswordAlgorithm
+	Policy      PasswordPolicy
+}
+
+// PasswordManager changes passwords. The current password is verified by the LoginManager, so the
+// same rules apply as for a login.
+type PasswordManager struct {
+	LoginManager LoginManagerIfc
+	UserUpdater  repositories.UserUpdater
+	PwdHasher    utils.PasswordAlgorithm
+	Policy       PasswordPolicy
 }
 
 type Credentials struct {
@@ -44,47 +70,96 @@ func (e *ErrorNotFound) Unwrap() error {
 	return e.Err
 }
 
-func NewLoginManager(user_reader repositories.UserReader, pwd_comparer utils.PasswordComparer) *LoginManager {
-	login_manager := LoginManager{UserReader: user_reader, PwdComparer: pwd_comparer}
+func NewLoginManager(user_reader repositories.UserReader, user_updater repositories.UserUpdater, pwd_hasher utils.PasswordAlgorithm) *LoginManager {
+	login_manager := LoginManager{UserReader: user_reader, UserUpdater: user_updater, PwdHasher: pwd_hasher}
 	return &login_manager
 }
 
-func NewRegistrationManager(user_creator repositories.UserCreator, pwd_hasher utils.PasswordHasher) *RegistrationManager {
-	registration_manager := RegistrationManager{UserCreator: user_creator, PwdHasher: pwd_hasher}
+func NewRegistrationManager(user_creator repositories.UserCreator, pwd_hasher utils.PasswordAlgorithm, policy PasswordPolicy) *RegistrationManager {
+	registration_manager := RegistrationManager{UserCreator: user_creator, PwdHasher: pwd_hasher, Policy: policy}
 	return &registration_manager
 }
 
+func NewPasswordManager(login_manager LoginManagerIfc, user_updater repositories.UserUpdater, pwd_hasher utils.PasswordAlgorithm,
+	policy PasswordPolicy) *PasswordManager {
+	password_manager := PasswordManager{LoginManager: login_manager, UserUpdater: user_updater, PwdHasher: pwd_hasher, Policy: policy}
+	return &password_manager
+}
+
+// LoginUser verifies the credentials. ErrorNotFound is returned for unknown users, only after the
+// password was hashed like for a known user, so the response time does not reveal which users exist.
 func (m *LoginManager) LoginUser(ctx context.Context, credentials *Credentials) (uint, bool, error) {
 	user, err := m.UserReader.FindUserByName(ctx, credentials.Username)
 	if err != nil {
+		m.verifyDummy(credentials.Password)
 		return 0, false, &ErrorNotFound{Username: credentials.Username, Err: err}
 	}
 
-	p, err := utils.ParseHashString(user.Password)
+	isValid, err := m.PwdHasher.Verify(user.Password, []byte(credentials.Password))
 	if err != nil {
-		return 0, false, fmt.Errorf("login user: could not parse hash string: %w", err)
+		return 0, false, fmt.Errorf("login user: could not verify password: %w", err)
+	}
+	if !isValid {
+		return user.ID, false, nil
 	}
 
-	isValid, err := m.PwdComparer.Compare(p.Hash, p.Salt, []byte(credentials.Password))
-	return user.ID, isValid, err
+	if m.PwdHasher.NeedsRehash(user.Password) {
+		m.rehash(ctx, user.ID, user.Password, credentials.Password)
+	}
+	return user.ID, true, nil
 }
 
-func (m *RegistrationManager) RegisterUser(ctx context.Context, credentials *Credentials) (uint, error) {
-	salt, err := m.PwdHasher.GenerateSalt()
+// verifyDummy verifies the password against the hash of a random password with the current parameters.
+// The result does not matter, only the time it takes.
+func (m *LoginManager) verifyDummy(password string) {
+	m.dummyOnce.Do(func() {
+		hash_string, err := m.PwdHasher.Hash([]byte(rand.Text()))
+		if err != nil {
+			log.Println("login user: could not hash dummy password:", err)
+			return
+		}
+		m.dummyHash = hash_string
+	})
+
+	if m.dummyHash != "" {
+		m.PwdHasher.Verify(m.dummyHash, []byte(password))
+	}
+}
+
+// rehash stores the password hashed with the current algorithm and parameters in place of the verified hash
+// string oldHash. A password changed concurrently is kept. The login succeeds even if this fails, the
+// password is hashed again on the next login then.
+func (m *LoginManager) rehash(ctx context.Context, userId uint, oldHash string, password string) {
+	hash_string, err := m.PwdHasher.Hash([]byte(password))
+	if err == nil {
+		err = m.UserUpdater.RehashPassword(ctx, userId, oldHash, hash_string)
+	}
+	if errors.Is(err, repositories.ErrPasswordChanged) {
+		return
+	}
 	if err != nil {
-		return 0, fmt.Errorf("register user: could not generate salt: %w", err)
+		log.Printf("login user: could not rehash password of user %d: %v", userId, err)
 	}
+}
 
-	hash, err := m.PwdHasher.GenerateHash([]byte(credentials.Password), salt)
+// RegisterUser creates a user with the given credentials. The username is validated and normalized in
+// credentials, then the password is checked against the policy, violations are returned as
+// ErrorPasswordPolicy. Both happens before the password is hashed.
+func (m *RegistrationManager) RegisterUser(ctx context.Context, credentials *Credentials) (uint, error) {
+	username, err := ValidateUsername(credentials.Username)
 	if err != nil {
-		return 0, fmt.Errorf("register user: could not generate hash: %w", err)
+		return 0, err
 	}
+	credentials.Username = username
 
-	p := utils.ParsedHashString{Id: "Argon2id", Version: 19, Hash: hash, Salt: salt}
+	violations := m.Policy.Check(credentials.Username, credentials.Password)
+	if len(violations) > 0 {
+		return 0, &ErrorPasswordPolicy{Violations: violations}
+	}
 
-	hash_string, err := utils.EncodeHashString(&p)
+	hash_string, err := m.PwdHasher.Hash([]byte(credentials.Password))
 	if err != nil {
-		return 0, fmt.Errorf("register user: failed to encode hash string: %w", err)
+		return 0, fmt.Errorf("register user: %w", err)
 	}
 
 	user, err := m.UserCreator.CreateUserByNameAndPassword(ctx, credentials.Username, hash_string)
@@ -95,3 +170,51 @@ func (m *RegistrationManager) RegisterUser(ctx context.Context, credentials *Cre
 
 	return user.ID, nil
 }
+
+// VerifyPassword reports whether credentials hold the username and password of the user with the given id,
+// for actions that require the password to be confirmed.
+func (m *PasswordManager) VerifyPassword(ctx context.Context, userId uint, credentials *Credentials) (bool, error) {
+	user_id, isValid, err := m.LoginManager.LoginUser(ctx, credentials)
+	if err != nil {
+		return false, err
+	}
+	if user_id != userId {
+		return false, &ErrorNotFound{Username: credentials.Username, Err: fmt.Errorf("user does not have id %d", userId)}
+	}
+	return isValid, nil
+}
+
+// ChangePassword replaces the password of the user if credentials holds its username and current password.
+// It reports false if the current password is wrong. The new password has to meet the policy.
+func (m *PasswordManager) ChangePassword(ctx context.Context, userId uint, credentials *Credentials, newPassword string) (bool, error) {
+	isValid, err := m.VerifyPassword(ctx, userId, credentials)
+	if err != nil || !isValid {
+		return false, err
+	}
+
+	err = m.SetPassword(ctx, userId, credentials.Username, newPassword)
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// SetPassword replaces the password of the user without verifying the current one, for users without a
+// password who confirmed their identity otherwise. The new password has to meet the policy.
+func (m *PasswordManager) SetPassword(ctx context.Context, userId uint, username string, newPassword string) error {
+	violations := m.Policy.Check(username, newPassword)
+	if len(violations) > 0 {
+		return &ErrorPasswordPolicy{Violations: violations}
+	}
+
+	hash_string, err := m.PwdHasher.Hash([]byte(newPassword))
+	if err != nil {
+		return fmt.Errorf("change password: %w", err)
+	}
+
+	err = m.UserUpdater.UpdatePassword(ctx, userId, hash_string)
+	if err != nil {
+		return fmt.Errorf("change password: %w", err)
+	}
+	return nil
+}
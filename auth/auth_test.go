@@ -3,12 +3,14 @@ package auth
 import (
 	"context"
 	"errors"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
 
 	"user-notes-api/models"
 	"user-notes-api/testing/testutils"
+	"user-notes-api/utils"
 )
 
 func TestRegisterAndLogin(t *testing.T) {
@@ -20,10 +22,10 @@ func TestRegisterAndLogin(t *testing.T) {
 
 	user := models.User{Username: username, Password: password}
 	repo := &testutils.MockUserCreatorReader{User: &user, Registered: false}
-	pwd_hasher := &testutils.MockPwdHasher{Hash: []byte(password)}
+	pwd_hasher := &testutils.MockPwdHasher{}
 
-	login_manager := NewLoginManager(repo, pwd_hasher)
-	registration_manager := NewRegistrationManager(repo, pwd_hasher)
+	login_manager := NewLoginManager(repo, repo, pwd_hasher)
+	registration_manager := NewRegistrationManager(repo, pwd_hasher, NewPasswordRules(10, 128, 72, 2))
 
 	// Get NotFoundError if user not registered
 	ctx := context.Background()
@@ -47,3 +49,242 @@ func TestRegisterAndLogin(t *testing.T) {
 	assert.NoError(t, err)
 	assert.False(t, isValid)
 }
+
+func TestChangePassword(t *testing.T) {
+	creds := Credentials{Username: "Alice", Password: "secret_password"}
+	repo := &testutils.MockUserCreatorReader{User: &models.User{}, Registered: false}
+	pwd_hasher := &testutils.MockPwdHasher{}
+
+	login_manager := NewLoginManager(repo, repo, pwd_hasher)
+	registration_manager := NewRegistrationManager(repo, pwd_hasher, NewPasswordRules(10, 128, 72, 2))
+	password_manager := NewPasswordManager(login_manager, repo, pwd_hasher, NewPasswordRules(10, 128, 72, 2))
+
+	ctx := context.Background()
+	id, err := registration_manager.RegisterUser(ctx, &creds)
+	assert.NoError(t, err)
+
+	// The current password is required
+	wrong_creds := Credentials{Username: "Alice", Password: "wrong_password"}
+	changed, err := password_manager.ChangePassword(ctx, id, &wrong_creds, "new_password")
+	assert.NoError(t, err)
+	assert.False(t, changed)
+
+	// Another user cannot change the password
+	changed, err = password_manager.ChangePassword(ctx, id+1, &creds, "new_password")
+	assert.Error(t, err)
+	assert.False(t, changed)
+
+	changed, err = password_manager.ChangePassword(ctx, id, &creds, "new_password")
+	assert.NoError(t, err)
+	assert.True(t, changed)
+
+	// Only the new password is accepted afterwards
+	_, logged_in, err := login_manager.LoginUser(ctx, &creds)
+	assert.NoError(t, err)
+	assert.False(t, logged_in)
+
+	new_creds := Credentials{Username: "Alice", Password: "new_password"}
+	_, logged_in, err = login_manager.LoginUser(ctx, &new_creds)
+	assert.NoError(t, err)
+	assert.True(t, logged_in)
+
+	// The new password has to meet the policy
+	changed, err = password_manager.ChangePassword(ctx, id, &new_creds, "short")
+	var errPolicy *ErrorPasswordPolicy
+	assert.True(t, errors.As(err, &errPolicy))
+	assert.False(t, changed)
+}
+
+func TestPasswordRules(t *testing.T) {
+	rules := NewPasswordRules(10, 20, 0, 3)
+
+	rulesOf := func(violations []PolicyViolation) []string {
+		var names []string
+		for _, violation := range violations {
+			names = append(names, violation.Rule)
+		}
+		return names
+	}
+
+	assert.Empty(t, rules.Check("Alice", "Correct-Horse-7"))
+	assert.Empty(t, rules.Check("Alice", "Grüße aus Köln 7"))
+
+	assert.Equal(t, []string{RuleMinLength}, rulesOf(rules.Check("Alice", "Sh0rt-pw")))
+	assert.Equal(t, []string{RuleMaxLength}, rulesOf(rules.Check("Alice", "Much-too-long-passw0rd")))
+	assert.Equal(t, []string{RuleCharacterClasses}, rulesOf(rules.Check("Alice", "onlylowercase")))
+	assert.Equal(t, []string{RuleContainsUsername}, rulesOf(rules.Check("Alice", "ALICE-in-w0nderland")))
+	assert.Equal(t, []string{RuleBreached}, rulesOf(rules.Check("Alice", "Password123")))
+	assert.Equal(t, []string{RuleMinLength, RuleCharacterClasses}, rulesOf(rules.Check("Alice", "")))
+
+	// characters are counted, not bytes
+	assert.Equal(t, []string{RuleMinLength}, rulesOf(rules.Check("Alice", "Ää-Öö-Üü1")))
+
+	// very short usernames are not checked
+	assert.Empty(t, rules.Check("Al", "Correct-Horse-Al-7"))
+
+	// MaxBytes counts bytes, like bcrypt
+	rules = NewPasswordRules(10, 20, 18, 3)
+	assert.Empty(t, rules.Check("Alice", "Correct-Horse-7"))
+	assert.Equal(t, []string{RuleMaxLength}, rulesOf(rules.Check("Alice", "Grüße aus Köln 7")))
+}
+
+func TestRegisterPasswordPolicy(t *testing.T) {
+	repo := &testutils.MockUserCreatorReader{User: &models.User{}, Registered: false}
+	registration_manager := NewRegistrationManager(repo, &testutils.MockPwdHasher{}, NewPasswordRules(10, 128, 72, 2))
+
+	_, err := registration_manager.RegisterUser(context.Background(), &Credentials{Username: "Alice", Password: ""})
+	var errPolicy *ErrorPasswordPolicy
+	assert.True(t, errors.As(err, &errPolicy))
+	assert.Equal(t, RuleMinLength, errPolicy.Violations[0].Rule)
+	assert.False(t, repo.Registered)
+}
+
+func TestValidateUsername(t *testing.T) {
+	for _, username := range []string{"Alice", "bob_99", "j.doe-2", "Zoë", "Ünal", "अनुज", "李小龙"} {
+		_, err := ValidateUsername(username)
+		assert.NoError(t, err, username)
+	}
+
+	normalized, err := ValidateUsername("Zoë")
+	assert.NoError(t, err)
+	assert.Equal(t, "Zoë", normalized)
+
+	for _, username := range []string{"", "Al", strings.Repeat("a", MaxUsernameLength+1), "_alice", "al ice", "alice!", "al/ice", "̈Zoe"} {
+		_, err := ValidateUsername(username)
+		var errInvalid *ErrorInvalidUsername
+		assert.True(t, errors.As(err, &errInvalid), username)
+	}
+}
+
+func TestRegisterInvalidUsername(t *testing.T) {
+	repo := &testutils.MockUserCreatorReader{User: &models.User{}, Registered: false}
+	registration_manager := NewRegistrationManager(repo, &testutils.MockPwdHasher{}, NewPasswordRules(10, 128, 72, 2))
+
+	_, err := registration_manager.RegisterUser(context.Background(), &Credentials{Username: "a!", Password: "Correct-Horse-7"})
+	var errInvalid *ErrorInvalidUsername
+	assert.True(t, errors.As(err, &errInvalid))
+	assert.False(t, repo.Registered)
+}
+
+func TestLoginUnknownUserVerifiesPassword(t *testing.T) {
+	repo := &testutils.MockUserCreatorReader{User: &models.User{}, Registered: false}
+	pwd_hasher := &testutils.MockPwdHasher{}
+	registration_manager := NewRegistrationManager(repo, pwd_hasher, NewPasswordRules(10, 128, 72, 2))
+	login_manager := NewLoginManager(repo, repo, pwd_hasher)
+
+	ctx := context.Background()
+	_, err := registration_manager.RegisterUser(ctx, &Credentials{Username: "Alice", Password: "Correct-Horse-7"})
+	assert.NoError(t, err)
+	pwd_hasher.Hashes = 0
+
+	_, isValid, err := login_manager.LoginUser(ctx, &Credentials{Username: "Alice", Password: "Wrong-Horse-7"})
+	assert.NoError(t, err)
+	assert.False(t, isValid)
+	assert.Equal(t, 1, pwd_hasher.Verifications)
+
+	// without verifying the password, logins for unknown users would take a fraction of the time
+	for i := 2; i <= 3; i++ {
+		_, _, err = login_manager.LoginUser(ctx, &Credentials{Username: "Mallory", Password: "Wrong-Horse-7"})
+		var errNotFound *ErrorNotFound
+		assert.True(t, errors.As(err, &errNotFound))
+		assert.Equal(t, i, pwd_hasher.Verifications)
+	}
+
+	// the dummy hash is only created once
+	assert.Equal(t, 1, pwd_hasher.Hashes)
+}
+
+func TestLoginRehash(t *testing.T) {
+	repo := &testutils.MockUserCreatorReader{User: &models.User{}, Registered: false}
+	weak := &utils.Argon2IdHasher{Time: 1, SaltLen: 16, Memory: 8 * 1024, Threads: 1, KeyLen: 32}
+	strong := &utils.Argon2IdHasher{Time: 2, SaltLen: 16, Memory: 16 * 1024, Threads: 1, KeyLen: 32}
+	registration_manager := NewRegistrationManager(repo, weak, NewPasswordRules(10, 128, 72, 2))
+
+	ctx := context.Background()
+	creds := Credentials{Username: "Alice", Password: "Correct-Horse-7"}
+	_, err := registration_manager.RegisterUser(ctx, &creds)
+	assert.NoError(t, err)
+	weak_hash := repo.User.Password
+	assert.Contains(t, weak_hash, "m=8192")
+
+	// the parameters of the hasher changed since the registration
+	login_manager := NewLoginManager(repo, repo, strong)
+
+	// a wrong password does not rehash
+	_, isValid, err := login_manager.LoginUser(ctx, &Credentials{Username: "Alice", Password: "Wrong-Horse-7"})
+	assert.NoError(t, err)
+	assert.False(t, isValid)
+	assert.Equal(t, weak_hash, repo.User.Password)
+
+	_, isValid, err = login_manager.LoginUser(ctx, &creds)
+	assert.NoError(t, err)
+	assert.True(t, isValid)
+	strong_hash := repo.User.Password
+	assert.NotEqual(t, weak_hash, strong_hash)
+	assert.Contains(t, strong_hash, "m=16384")
+
+	// the new hash is verified and not hashed again
+	_, isValid, err = login_manager.LoginUser(ctx, &creds)
+	assert.NoError(t, err)
+	assert.True(t, isValid)
+	assert.Equal(t, strong_hash, repo.User.Password)
+}
+
+// changingUserUpdater changes the password of the user right before it is hashed again.
+type changingUserUpdater struct {
+	*testutils.MockUserCreatorReader
+	password string
+}
+
+func (u *changingUserUpdater) RehashPassword(ctx context.Context, userId uint, oldPassword string, password string) error {
+	u.User.Password = u.password
+	return u.MockUserCreatorReader.RehashPassword(ctx, userId, oldPassword, password)
+}
+
+func TestLoginRehashKeepsChangedPassword(t *testing.T) {
+	repo := &testutils.MockUserCreatorReader{User: &models.User{}, Registered: false}
+	weak := &utils.Argon2IdHasher{Time: 1, SaltLen: 16, Memory: 8 * 1024, Threads: 1, KeyLen: 32}
+	strong := &utils.Argon2IdHasher{Time: 2, SaltLen: 16, Memory: 16 * 1024, Threads: 1, KeyLen: 32}
+	registration_manager := NewRegistrationManager(repo, weak, NewPasswordRules(10, 128, 72, 2))
+
+	ctx := context.Background()
+	creds := Credentials{Username: "Alice", Password: "Correct-Horse-7"}
+	_, err := registration_manager.RegisterUser(ctx, &creds)
+	assert.NoError(t, err)
+
+	// the password is changed by another request while the login verifies the old one
+	new_hash, err := strong.Hash([]byte("Battery-Staple-8"))
+	assert.NoError(t, err)
+	login_manager := NewLoginManager(repo, &changingUserUpdater{MockUserCreatorReader: repo, password: new_hash}, strong)
+
+	_, isValid, err := login_manager.LoginUser(ctx, &creds)
+	assert.NoError(t, err)
+	assert.True(t, isValid)
+	assert.Equal(t, new_hash, repo.User.Password)
+}
+
+func TestLoginUpgradesHashAlgorithm(t *testing.T) {
+	argon2id := &utils.Argon2IdHasher{Time: 1, SaltLen: 16, Memory: 8 * 1024, Threads: 1, KeyLen: 32}
+	bcrypt := &utils.BcryptHasher{Cost: 4}
+	hashers := utils.NewPasswordHashers(argon2id)
+	hashers.Register(argon2id, utils.Argon2IdId)
+	hashers.Register(bcrypt, utils.BcryptIds...)
+
+	// a user imported from a system using bcrypt
+	bcrypt_hash, err := bcrypt.Hash([]byte("Correct-Horse-7"))
+	assert.NoError(t, err)
+	user := models.User{Username: "Alice", Password: bcrypt_hash}
+	user.ID = 1
+	repo := &testutils.MockUserCreatorReader{User: &user, Registered: true}
+	login_manager := NewLoginManager(repo, repo, hashers)
+
+	ctx := context.Background()
+	_, isValid, err := login_manager.LoginUser(ctx, &Credentials{Username: "Alice", Password: "Correct-Horse-7"})
+	assert.NoError(t, err)
+	assert.True(t, isValid)
+	assert.True(t, strings.HasPrefix(repo.User.Password, "$argon2id$"))
+
+	_, isValid, err = login_manager.LoginUser(ctx, &Credentials{Username: "Alice", Password: "Correct-Horse-7"})
+	assert.NoError(t, err)
+	assert.True(t, isValid)
+}
@@ -0,0 +1,131 @@
+package auth
+
+import (
+	_ "embed"
+	"fmt"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Rules of PasswordRules, reported in PolicyViolation.Rule.
+const (
+	RuleMinLength        = "min_length"
+	RuleMaxLength        = "max_length"
+	RuleCharacterClasses = "character_classes"
+	RuleContainsUsername = "contains_username"
+	RuleBreached         = "breached"
+)
+
+// minUsernameLengthInPassword is the shortest username that passwords must not contain. Shorter names
+// would rule out too many passwords.
+const minUsernameLengthInPassword = 3
+
+//go:embed breached_passwords.txt
+var breachedPasswordsFile string
+
+// PasswordPolicy decides which passwords may be set. Check returns nil if the password is acceptable.
+type PasswordPolicy interface {
+	Check(username string, password string) []PolicyViolation
+}
+
+type PolicyViolation struct {
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+// ErrorPasswordPolicy is returned if a password is rejected by the PasswordPolicy.
+type ErrorPasswordPolicy struct {
+	Violations []PolicyViolation
+}
+
+func (e *ErrorPasswordPolicy) Error() string {
+	messages := make([]string, len(e.Violations))
+	for i, violation := range e.Violations {
+		messages[i] = violation.Message
+	}
+	return "password does not meet the policy: " + strings.Join(messages, "; ")
+}
+
+// PasswordRules is the PasswordPolicy of the API. Lengths are counted in characters. MaxLength bounds
+// the cost of hashing, MaxBytes the UTF-8 length for hashes like bcrypt that cannot hash longer passwords;
+// 0 disables it. Character classes are lower-case and upper-case letters, digits and all other characters.
+// Passwords found in Breached are rejected regardless of the other rules.
+type PasswordRules struct {
+	MinLength           int
+	MaxLength           int
+	MaxBytes            int
+	MinCharacterClasses int
+	Breached            map[string]struct{}
+}
+
+func NewPasswordRules(min_length int, max_length int, max_bytes int, min_character_classes int) *PasswordRules {
+	rules := PasswordRules{MinLength: min_length, MaxLength: max_length, MaxBytes: max_bytes,
+		MinCharacterClasses: min_character_classes, Breached: breachedPasswords()}
+	return &rules
+}
+
+// breachedPasswords returns the embedded list of breached passwords, parsed on first use.
+var breachedPasswords = sync.OnceValue(func() map[string]struct{} {
+	passwords := map[string]struct{}{}
+	for _, line := range strings.Split(breachedPasswordsFile, "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" && !strings.HasPrefix(line, "#") {
+			passwords[strings.ToLower(line)] = struct{}{}
+		}
+	}
+	return passwords
+})
+
+func (r *PasswordRules) Check(username string, password string) []PolicyViolation {
+	var violations []PolicyViolation
+
+	length := utf8.RuneCountInString(password)
+	if length < r.MinLength {
+		violations = append(violations, PolicyViolation{Rule: RuleMinLength,
+			Message: fmt.Sprintf("password must have at least %d characters", r.MinLength)})
+	}
+	if r.MaxLength > 0 && length > r.MaxLength {
+		violations = append(violations, PolicyViolation{Rule: RuleMaxLength,
+			Message: fmt.Sprintf("password must have at most %d characters", r.MaxLength)})
+	} else if r.MaxBytes > 0 && len(password) > r.MaxBytes {
+		violations = append(violations, PolicyViolation{Rule: RuleMaxLength,
+			Message: fmt.Sprintf("password must have at most %d bytes", r.MaxBytes)})
+	}
+
+	if characterClasses(password) < r.MinCharacterClasses {
+		violations = append(violations, PolicyViolation{Rule: RuleCharacterClasses,
+			Message: fmt.Sprintf("password must contain at least %d of lower-case letters, upper-case letters, digits and other characters",
+				r.MinCharacterClasses)})
+	}
+
+	lower_password := strings.ToLower(password)
+	if utf8.RuneCountInString(username) >= minUsernameLengthInPassword && strings.Contains(lower_password, strings.ToLower(username)) {
+		violations = append(violations, PolicyViolation{Rule: RuleContainsUsername, Message: "password must not contain the username"})
+	}
+
+	if _, found := r.Breached[lower_password]; found {
+		violations = append(violations, PolicyViolation{Rule: RuleBreached, Message: "password is too common"})
+	}
+
+	return violations
+}
+
+// characterClasses returns the number of character classes used in the password.
+func characterClasses(password string) int {
+	var lower, upper, digit, other int
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			lower = 1
+		case unicode.IsUpper(r):
+			upper = 1
+		case unicode.IsDigit(r):
+			digit = 1
+		default:
+			other = 1
+		}
+	}
+	return lower + upper + digit + other
+}
@@ -0,0 +1,50 @@
+package auth
+
+import (
+	"fmt"
+	"unicode"
+	"unicode/utf8"
+
+	"user-notes-api/utils"
+)
+
+const (
+	MinUsernameLength = 3
+	MaxUsernameLength = 32
+)
+
+// ErrorInvalidUsername is returned if a username does not follow the username rules.
+type ErrorInvalidUsername struct {
+	Username string
+	Reason   string
+}
+
+func (e *ErrorInvalidUsername) Error() string {
+	return fmt.Sprintf("invalid username %q: %s", e.Username, e.Reason)
+}
+
+// ValidateUsername returns the normalized form of the username if it follows the rules: between
+// MinUsernameLength and MaxUsernameLength characters, only letters, digits, '_', '-' and '.', starting
+// with a letter or digit.
+func ValidateUsername(username string) (string, error) {
+	normalized := utils.NormalizeUsername(username)
+
+	length := utf8.RuneCountInString(normalized)
+	if length < MinUsernameLength || length > MaxUsernameLength {
+		return "", &ErrorInvalidUsername{Username: username,
+			Reason: fmt.Sprintf("must have between %d and %d characters", MinUsernameLength, MaxUsernameLength)}
+	}
+
+	for i, r := range normalized {
+		switch {
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+		// combining marks without precomposed form, like in Devanagari
+		case i > 0 && unicode.Is(unicode.Mn, r):
+		case i > 0 && (r == '_' || r == '-' || r == '.'):
+		default:
+			return "", &ErrorInvalidUsername{Username: username,
+				Reason: "must only contain letters, digits, '_', '-' and '.' and start with a letter or digit"}
+		}
+	}
+	return normalized, nil
+}
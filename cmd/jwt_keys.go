@@ -0,0 +1,93 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"user-notes-api/config"
+	"user-notes-api/services"
+)
+
+const jwtKeysUsage = `usage: user-notes-api jwt-keys [-dir keyring] <command>
+
+commands:
+  list                                        list the keys of the keyring, * marks the signing key
+  generate [-alg EdDSA] [-kid kid] [-promote] generate a key for EdDSA, RS256 or HS256
+  promote <kid>                               make a key the signing key
+
+The keyring defaults to JWT_KEYRING_DIR. Restart the API after every change.`
+
+// runJwtKeys manages the keys of the JWT keyring. To rotate the signing key without rejecting tokens,
+// generate a key, restart all instances so they verify tokens signed with it, then promote it and restart
+// them again. The old key verifies tokens until its file is removed after they expired.
+func runJwtKeys(cfg *config.Config, args []string, out io.Writer) error {
+	flags := flag.NewFlagSet("jwt-keys", flag.ContinueOnError)
+	flags.SetOutput(out)
+	flags.Usage = func() { fmt.Fprintln(out, jwtKeysUsage) }
+	dir := flags.String("dir", cfg.JWTKeyringDir, "keyring directory")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *dir == "" {
+		return errors.New("no keyring directory, set JWT_KEYRING_DIR or -dir")
+	}
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return errors.New("missing command")
+	}
+
+	keyring := services.NewJwtKeyring(*dir)
+	command, command_args := flags.Arg(0), flags.Args()[1:]
+	switch command {
+	case "list":
+		keys, active, err := keyring.Keys()
+		if err != nil {
+			return err
+		}
+		for _, key := range keys {
+			marker := " "
+			if key.Kid == active {
+				marker = "*"
+			}
+			usage := "sign+verify"
+			if !key.CanSign() {
+				usage = "verify"
+			}
+			fmt.Fprintf(out, "%s %s\t%s\t%s\n", marker, key.Kid, key.Method.Alg(), usage)
+		}
+		return nil
+	case "generate":
+		generate_flags := flag.NewFlagSet("generate", flag.ContinueOnError)
+		generate_flags.SetOutput(out)
+		algorithm := generate_flags.String("alg", services.JwtAlgorithmEdDSA, "EdDSA, RS256 or HS256")
+		kid := generate_flags.String("kid", "", "kid of the key, defaults to the thumbprint of asymmetric keys")
+		promote := generate_flags.Bool("promote", false, "make the key the signing key right away")
+		if err := generate_flags.Parse(command_args); err != nil {
+			return err
+		}
+
+		key, err := keyring.Generate(*algorithm, *kid)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "generated %s key %s\n", key.Method.Alg(), key.Kid)
+		if !*promote {
+			return nil
+		}
+		command_args = []string{key.Kid}
+		fallthrough
+	case "promote":
+		if len(command_args) != 1 {
+			return errors.New("promote requires exactly one kid")
+		}
+		if err := keyring.Promote(command_args[0]); err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "promoted %s to signing key\n", command_args[0])
+		return nil
+	}
+
+	flags.Usage()
+	return fmt.Errorf("unknown command %q", command)
+}
@@ -1,13 +1,17 @@
 package main
 
 import (
+	"context"
 	"user-notes-api/config"
 	"user-notes-api/models"
+	"user-notes-api/repositories"
 	"user-notes-api/routes"
+	"user-notes-api/services"
 
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
 	"log"
+	"os"
 
 	"github.com/gin-gonic/gin"
 )
@@ -15,6 +19,13 @@ import (
 func main() {
 	cfg := config.LoadConfig()
 
+	if len(os.Args) > 1 && os.Args[1] == "jwt-keys" {
+		if err := runJwtKeys(cfg, os.Args[2:], os.Stdout); err != nil {
+			log.Fatal("jwt-keys: ", err)
+		}
+		return
+	}
+
 	dsn := "host=" + cfg.DBHost + " user=" + cfg.DBUser + " password=" + cfg.DBPassword + " dbname=" +
 		cfg.DBName + " port=" + cfg.DBPort + " sslmode=disable TimeZone=UTC"
 	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
@@ -22,10 +33,26 @@ func main() {
 		log.Fatal("Failed to connect DB:", err)
 	}
 
-	db.AutoMigrate(&models.User{}, &models.Note{})
+	db.AutoMigrate(&models.User{}, &models.Notebook{}, &models.Note{}, &models.Tag{}, &models.NoteRevision{}, &models.RefreshToken{}, &models.RevokedToken{}, &models.LoginAttempt{}, &models.PersonalAccessToken{}, &models.RecoveryCode{}, &models.OidcIdentity{})
+	if err := repositories.MigrateUsernameKeys(db); err != nil {
+		log.Fatal("Failed to migrate username keys:", err)
+	}
+	if err := repositories.MigrateNoteSearch(db); err != nil {
+		log.Fatal("Failed to migrate note search:", err)
+	}
+
+	if cfg.TrashRetention > 0 {
+		purger := services.NewTrashPurger(repositories.NewNoteRepository(db), cfg.TrashRetention)
+		go purger.Run(context.Background())
+	}
 
 	r := gin.Default()
-	routes.SetupRoutes(r, db, cfg.JWTSecret)
+	if err := r.SetTrustedProxies(cfg.TrustedProxies); err != nil {
+		log.Fatal("Invalid trusted proxies:", err)
+	}
+	if err := routes.SetupRoutes(r, db, cfg); err != nil {
+		log.Fatal("Failed to set up routes:", err)
+	}
 	r.Run(":" + cfg.AppPort)
 
 }
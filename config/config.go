@@ -3,10 +3,59 @@ package config
 import (
 	"log"
 	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/joho/godotenv"
 )
 
+const (
+	DefaultNoteRevisionLimit = 100
+	DefaultTrashRetention    = 30 * 24 * time.Hour
+
+	DefaultAccessTokenLifetime  = 4 * time.Hour
+	DefaultRefreshTokenLifetime = 30 * 24 * time.Hour
+
+	DefaultPasswordMinLength           = 10
+	DefaultPasswordMaxLength           = 128
+	DefaultPasswordMinCharacterClasses = 2
+
+	DefaultLoginUsernameMaxFailures = 5
+	DefaultLoginClientIpMaxFailures = 20
+	DefaultLoginBackoffBase         = time.Second
+	DefaultLoginBackoffMax          = 15 * time.Minute
+	DefaultLoginFailureWindow       = time.Hour
+	DefaultLoginAttemptStore        = LoginAttemptStoreDatabase
+
+	DefaultPasswordHashAlgorithm = PasswordHashArgon2id
+	// BcryptMaxPasswordBytes is the longest password bcrypt can hash.
+	BcryptMaxPasswordBytes = 72
+
+	DefaultTotpIssuer       = "User-Notes-API"
+	DefaultMfaTokenLifetime = 5 * time.Minute
+
+	DefaultReauthenticationMaxAge = 10 * time.Minute
+)
+
+// DefaultOidcScopes are requested from OpenID Connect providers without OIDC_<NAME>_SCOPES. The profile and
+// email claims suggest the username of new users.
+var DefaultOidcScopes = []string{"openid", "profile", "email"}
+
+// Algorithms for hashing new passwords. Passwords hashed with any of them can be verified.
+const (
+	PasswordHashArgon2id = "argon2id"
+	PasswordHashScrypt   = "scrypt"
+	PasswordHashBcrypt   = "bcrypt"
+)
+
+// Stores for failed logins: the database is shared by all instances of the API, memory is per instance.
+const (
+	LoginAttemptStoreDatabase = "database"
+	LoginAttemptStoreMemory   = "memory"
+)
+
 type Config struct {
 	AppPort    string
 	DBHost     string
@@ -15,6 +64,75 @@ type Config struct {
 	DBPassword string
 	DBName     string
 	JWTSecret  string
+	// JWTPrivateKeyFile is a PEM file with an RSA or Ed25519 private key that signs access tokens with RS256
+	// or EdDSA. Without it, access tokens are signed with JWTSecret and HS256.
+	JWTPrivateKeyFile string
+	// JWTKeyId is the kid of the signing key. Asymmetric keys default to their JWK thumbprint.
+	JWTKeyId string
+	// JWTKeyringDir is a directory with the signing key and verify-only keys, managed with the jwt-keys
+	// command. If it is set, the key of JWTPrivateKeyFile or JWTSecret only verifies tokens.
+	JWTKeyringDir string
+	// NoteRevisionLimit is the number of revisions kept per note, 0 keeps all revisions.
+	NoteRevisionLimit int
+	// TrashRetention is how long deleted notes stay in the trash before they are purged, 0 disables purging.
+	TrashRetention time.Duration
+	// AccessTokenLifetime is how long a JWT issued at login, registration or refresh is valid.
+	AccessTokenLifetime time.Duration
+	// RefreshTokenLifetime is how long a refresh token can be used. Each refresh issues a new refresh
+	// token with the full lifetime.
+	RefreshTokenLifetime time.Duration
+	// PasswordMinLength and PasswordMaxLength bound the number of characters of a password.
+	PasswordMinLength int
+	PasswordMaxLength int
+	// PasswordMaxBytes bounds the UTF-8 length of a password, 0 disables the limit. It is
+	// BcryptMaxPasswordBytes if new passwords are hashed with bcrypt, which cannot hash longer passwords.
+	PasswordMaxBytes int
+	// PasswordMinCharacterClasses is how many of lower-case and upper-case letters, digits and other
+	// characters a password has to contain.
+	PasswordMinCharacterClasses int
+	// LoginUsernameMaxFailures and LoginClientIpMaxFailures are the numbers of failed logins for a username
+	// or from a client IP after which logins are refused for LoginBackoffBase, doubling with every further
+	// failure up to LoginBackoffMax. 0 disables the limit.
+	LoginUsernameMaxFailures int
+	LoginClientIpMaxFailures int
+	LoginBackoffBase         time.Duration
+	LoginBackoffMax          time.Duration
+	// LoginFailureWindow is how long failed logins are counted.
+	LoginFailureWindow time.Duration
+	// LoginAttemptStore is where failed logins are counted, LoginAttemptStoreDatabase or LoginAttemptStoreMemory.
+	LoginAttemptStore string
+	// PasswordHashAlgorithm hashes new passwords and those of other algorithms on login, one of
+	// PasswordHashArgon2id, PasswordHashScrypt and PasswordHashBcrypt.
+	PasswordHashAlgorithm string
+	// PasswordPeppers are secrets by key id that passwords are combined with before they are hashed, new
+	// passwords use the highest id. Older keys have to be kept until all their passwords were rehashed.
+	PasswordPeppers map[uint32]string
+	// TrustedProxies are the addresses or CIDR ranges of reverse proxies whose X-Forwarded-For header is
+	// used for the client IP. Without any, the client IP is the remote address of the connection.
+	TrustedProxies []string
+	// TotpEncryptionKeys are secrets by key id that TOTP secrets are encrypted with, new secrets use the
+	// highest id. Without any, two-factor authentication cannot be enabled.
+	TotpEncryptionKeys map[uint32]string
+	// TotpIssuer names the API in authenticator apps.
+	TotpIssuer string
+	// MfaTokenLifetime is how long the second factor of a login with two-factor authentication can be entered.
+	MfaTokenLifetime time.Duration
+	// OidcProviders are the OpenID Connect providers users can log in with, see getEnvOidcProviders.
+	OidcProviders []OidcProvider
+	// ReauthenticationMaxAge is how recent the login of a user without a password has to be to confirm
+	// changes that other users confirm with their password.
+	ReauthenticationMaxAge time.Duration
+}
+
+// OidcProvider is an OpenID Connect provider that the API is registered at as a client. Name is the
+// :provider of the /auth/oidc routes, RedirectUrl the URL of its callback route.
+type OidcProvider struct {
+	Name         string
+	Issuer       string
+	ClientId     string
+	ClientSecret string
+	RedirectUrl  string
+	Scopes       []string
 }
 
 func LoadConfig() *Config {
@@ -22,13 +140,188 @@ func LoadConfig() *Config {
 		log.Println("No .env file found")
 	}
 
-	return &Config{
-		AppPort:    os.Getenv("APP_PORT"),
-		DBHost:     os.Getenv("DB_HOST"),
-		DBPort:     os.Getenv("DB_PORT"),
-		DBUser:     os.Getenv("DB_USER"),
-		DBPassword: os.Getenv("DB_PASSWORD"),
-		DBName:     os.Getenv("DB_NAME"),
-		JWTSecret:  os.Getenv("JWT_SECRET"),
+	cfg := Config{
+		AppPort:           os.Getenv("APP_PORT"),
+		DBHost:            os.Getenv("DB_HOST"),
+		DBPort:            os.Getenv("DB_PORT"),
+		DBUser:            os.Getenv("DB_USER"),
+		DBPassword:        os.Getenv("DB_PASSWORD"),
+		DBName:            os.Getenv("DB_NAME"),
+		JWTSecret:         os.Getenv("JWT_SECRET"),
+		JWTPrivateKeyFile: os.Getenv("JWT_PRIVATE_KEY_FILE"),
+		JWTKeyId:          os.Getenv("JWT_KEY_ID"),
+		JWTKeyringDir:     os.Getenv("JWT_KEYRING_DIR"),
+		NoteRevisionLimit: getEnvInt("NOTE_REVISION_LIMIT", DefaultNoteRevisionLimit),
+		TrashRetention:    getEnvDuration("TRASH_RETENTION", DefaultTrashRetention),
+
+		AccessTokenLifetime:  getEnvLifetime("ACCESS_TOKEN_LIFETIME", DefaultAccessTokenLifetime),
+		RefreshTokenLifetime: getEnvLifetime("REFRESH_TOKEN_LIFETIME", DefaultRefreshTokenLifetime),
+
+		PasswordMinLength:           getEnvInt("PASSWORD_MIN_LENGTH", DefaultPasswordMinLength),
+		PasswordMaxLength:           getEnvInt("PASSWORD_MAX_LENGTH", DefaultPasswordMaxLength),
+		PasswordMinCharacterClasses: getEnvInt("PASSWORD_MIN_CHARACTER_CLASSES", DefaultPasswordMinCharacterClasses),
+
+		LoginUsernameMaxFailures: getEnvInt("LOGIN_USERNAME_MAX_FAILURES", DefaultLoginUsernameMaxFailures),
+		LoginClientIpMaxFailures: getEnvInt("LOGIN_CLIENT_IP_MAX_FAILURES", DefaultLoginClientIpMaxFailures),
+		LoginBackoffBase:         getEnvLifetime("LOGIN_BACKOFF_BASE", DefaultLoginBackoffBase),
+		LoginBackoffMax:          getEnvLifetime("LOGIN_BACKOFF_MAX", DefaultLoginBackoffMax),
+		LoginFailureWindow:       getEnvLifetime("LOGIN_FAILURE_WINDOW", DefaultLoginFailureWindow),
+		LoginAttemptStore:        getEnvChoice("LOGIN_ATTEMPT_STORE", DefaultLoginAttemptStore, LoginAttemptStoreDatabase, LoginAttemptStoreMemory),
+		TrustedProxies:           getEnvList("TRUSTED_PROXIES"),
+
+		PasswordHashAlgorithm: getEnvChoice("PASSWORD_HASH_ALGORITHM", DefaultPasswordHashAlgorithm,
+			PasswordHashArgon2id, PasswordHashScrypt, PasswordHashBcrypt),
+		PasswordPeppers: getEnvPeppers("PASSWORD_PEPPERS"),
+
+		TotpEncryptionKeys: getEnvPeppers("TOTP_ENCRYPTION_KEYS"),
+		TotpIssuer:         getEnvString("TOTP_ISSUER", DefaultTotpIssuer),
+		MfaTokenLifetime:   getEnvLifetime("MFA_TOKEN_LIFETIME", DefaultMfaTokenLifetime),
+
+		OidcProviders:          getEnvOidcProviders("OIDC_PROVIDERS"),
+		ReauthenticationMaxAge: getEnvLifetime("REAUTHENTICATION_MAX_AGE", DefaultReauthenticationMaxAge),
+	}
+
+	if cfg.PasswordHashAlgorithm == PasswordHashBcrypt {
+		cfg.PasswordMaxBytes = BcryptMaxPasswordBytes
+		if _, ok := os.LookupEnv("PASSWORD_MAX_LENGTH"); !ok {
+			cfg.PasswordMaxLength = BcryptMaxPasswordBytes
+		} else if cfg.PasswordMaxLength == 0 || cfg.PasswordMaxLength > BcryptMaxPasswordBytes {
+			log.Printf("PASSWORD_MAX_LENGTH %d exceeds the %d bytes bcrypt can hash, using %d",
+				cfg.PasswordMaxLength, BcryptMaxPasswordBytes, BcryptMaxPasswordBytes)
+			cfg.PasswordMaxLength = BcryptMaxPasswordBytes
+		}
+	}
+	return &cfg
+}
+
+// getEnvString reads a string from the environment, falling back to the default if the variable is unset
+// or empty.
+func getEnvString(key string, fallback string) string {
+	value := strings.TrimSpace(os.Getenv(key))
+	if value == "" {
+		return fallback
+	}
+	return value
+}
+
+// getEnvInt reads a non-negative integer from the environment, falling back to the default if the
+// variable is unset or invalid.
+func getEnvInt(key string, fallback int) int {
+	value, ok := os.LookupEnv(key)
+	if !ok || value == "" {
+		return fallback
+	}
+
+	parsed, err := strconv.Atoi(value)
+	if err != nil || parsed < 0 {
+		log.Printf("Invalid value %q for %s, using %d", value, key, fallback)
+		return fallback
+	}
+	return parsed
+}
+
+// getEnvDuration reads a non-negative duration like "720h" from the environment, falling back to the default
+// if the variable is unset or invalid.
+func getEnvDuration(key string, fallback time.Duration) time.Duration {
+	value, ok := os.LookupEnv(key)
+	if !ok || value == "" {
+		return fallback
+	}
+
+	parsed, err := time.ParseDuration(value)
+	if err != nil || parsed < 0 {
+		log.Printf("Invalid value %q for %s, using %s", value, key, fallback)
+		return fallback
+	}
+	return parsed
+}
+
+// getEnvLifetime reads a positive duration from the environment, falling back to the default if the
+// variable is unset, invalid or zero.
+func getEnvLifetime(key string, fallback time.Duration) time.Duration {
+	lifetime := getEnvDuration(key, fallback)
+	if lifetime == 0 {
+		log.Printf("Invalid value 0 for %s, using %s", key, fallback)
+		return fallback
+	}
+	return lifetime
+}
+
+// getEnvChoice reads one of the given choices from the environment, falling back to the default if the
+// variable is unset or not one of them.
+func getEnvChoice(key string, fallback string, choices ...string) string {
+	value, ok := os.LookupEnv(key)
+	if !ok || value == "" {
+		return fallback
+	}
+
+	if !slices.Contains(choices, value) {
+		log.Printf("Invalid value %q for %s, using %s", value, key, fallback)
+		return fallback
+	}
+	return value
+}
+
+// getEnvList reads a comma separated list from the environment, empty entries are skipped.
+func getEnvList(key string) []string {
+	var list []string
+	for _, entry := range strings.Split(os.Getenv(key), ",") {
+		if entry = strings.TrimSpace(entry); entry != "" {
+			list = append(list, entry)
+		}
+	}
+	return list
+}
+
+// getEnvPeppers reads a comma separated list of "id:secret" entries with positive ids from the environment,
+// invalid entries are skipped.
+func getEnvPeppers(key string) map[uint32]string {
+	peppers := map[uint32]string{}
+	for _, entry := range getEnvList(key) {
+		id, secret, found := strings.Cut(entry, ":")
+		parsed, err := strconv.ParseUint(id, 10, 32)
+		if !found || err != nil || parsed == 0 || secret == "" {
+			log.Printf("Invalid entry in %s, expected id:secret with a positive id", key)
+			continue
+		}
+		peppers[uint32(parsed)] = secret
+	}
+	return peppers
+}
+
+// getEnvOidcProviders reads the comma separated names of OpenID Connect providers from the environment and
+// each provider from OIDC_<NAME>_ISSUER, OIDC_<NAME>_CLIENT_ID, OIDC_<NAME>_CLIENT_SECRET,
+// OIDC_<NAME>_REDIRECT_URL and OIDC_<NAME>_SCOPES, with the name in upper case and '-' replaced by '_'.
+// Names may only contain lower-case letters, digits and '-'. Providers with an invalid name or without
+// issuer, client id or redirect URL are skipped; the client secret is optional for public clients.
+func getEnvOidcProviders(key string) []OidcProvider {
+	var providers []OidcProvider
+	for _, name := range getEnvList(key) {
+		if strings.Trim(name, "abcdefghijklmnopqrstuvwxyz0123456789-") != "" {
+			log.Printf("Invalid provider name %q in %s, only a-z, 0-9 and '-' are allowed", name, key)
+			continue
+		}
+
+		prefix := "OIDC_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_")) + "_"
+		provider := OidcProvider{
+			Name:         name,
+			Issuer:       strings.TrimSpace(os.Getenv(prefix + "ISSUER")),
+			ClientId:     strings.TrimSpace(os.Getenv(prefix + "CLIENT_ID")),
+			ClientSecret: os.Getenv(prefix + "CLIENT_SECRET"),
+			RedirectUrl:  strings.TrimSpace(os.Getenv(prefix + "REDIRECT_URL")),
+			Scopes:       getEnvList(prefix + "SCOPES"),
+		}
+		if provider.Issuer == "" || provider.ClientId == "" || provider.RedirectUrl == "" {
+			log.Printf("Skipping OpenID Connect provider %q, %sISSUER, %sCLIENT_ID and %sREDIRECT_URL are required",
+				name, prefix, prefix, prefix)
+			continue
+		}
+		if len(provider.Scopes) == 0 {
+			provider.Scopes = slices.Clone(DefaultOidcScopes)
+		} else if !slices.Contains(provider.Scopes, "openid") {
+			provider.Scopes = append([]string{"openid"}, provider.Scopes...)
+		}
+		providers = append(providers, provider)
 	}
+	return providers
 }
@@ -3,6 +3,7 @@ package config
 import (
 	"os"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -25,5 +26,152 @@ func TestLoadConfig(t *testing.T) {
 	assert.Equal(t, "UserNotesAPI_DB", cfg.DBName)
 	assert.Equal(t, "43041", cfg.DBPort)
 	assert.Equal(t, "JWTsecret", cfg.JWTSecret)
+	assert.Empty(t, cfg.JWTPrivateKeyFile)
+	assert.Empty(t, cfg.JWTKeyId)
+	assert.Empty(t, cfg.JWTKeyringDir)
+	assert.Equal(t, DefaultNoteRevisionLimit, cfg.NoteRevisionLimit)
 
+	os.Setenv("JWT_PRIVATE_KEY_FILE", "/run/secrets/jwt.pem")
+	os.Setenv("JWT_KEY_ID", "2026-01")
+	os.Setenv("JWT_KEYRING_DIR", "/run/secrets/jwt-keys")
+	cfg = LoadConfig()
+	assert.Equal(t, "/run/secrets/jwt.pem", cfg.JWTPrivateKeyFile)
+	assert.Equal(t, "2026-01", cfg.JWTKeyId)
+	assert.Equal(t, "/run/secrets/jwt-keys", cfg.JWTKeyringDir)
+	os.Unsetenv("JWT_PRIVATE_KEY_FILE")
+	os.Unsetenv("JWT_KEY_ID")
+	os.Unsetenv("JWT_KEYRING_DIR")
+
+	os.Setenv("NOTE_REVISION_LIMIT", "10")
+	cfg = LoadConfig()
+	assert.Equal(t, 10, cfg.NoteRevisionLimit)
+
+	os.Setenv("NOTE_REVISION_LIMIT", "-1")
+	cfg = LoadConfig()
+	assert.Equal(t, DefaultNoteRevisionLimit, cfg.NoteRevisionLimit)
+	os.Unsetenv("NOTE_REVISION_LIMIT")
+
+	assert.Equal(t, DefaultTrashRetention, cfg.TrashRetention)
+	os.Setenv("TRASH_RETENTION", "48h")
+	cfg = LoadConfig()
+	assert.Equal(t, 48*time.Hour, cfg.TrashRetention)
+	os.Unsetenv("TRASH_RETENTION")
+
+	assert.Equal(t, DefaultAccessTokenLifetime, cfg.AccessTokenLifetime)
+	assert.Equal(t, DefaultRefreshTokenLifetime, cfg.RefreshTokenLifetime)
+	os.Setenv("ACCESS_TOKEN_LIFETIME", "15m")
+	os.Setenv("REFRESH_TOKEN_LIFETIME", "0")
+	cfg = LoadConfig()
+	assert.Equal(t, 15*time.Minute, cfg.AccessTokenLifetime)
+	assert.Equal(t, DefaultRefreshTokenLifetime, cfg.RefreshTokenLifetime)
+	os.Unsetenv("ACCESS_TOKEN_LIFETIME")
+	os.Unsetenv("REFRESH_TOKEN_LIFETIME")
+
+	assert.Equal(t, DefaultPasswordMinLength, cfg.PasswordMinLength)
+	assert.Equal(t, DefaultPasswordMaxLength, cfg.PasswordMaxLength)
+	assert.Equal(t, DefaultPasswordMinCharacterClasses, cfg.PasswordMinCharacterClasses)
+	os.Setenv("PASSWORD_MIN_LENGTH", "16")
+	cfg = LoadConfig()
+	assert.Equal(t, 16, cfg.PasswordMinLength)
+	os.Unsetenv("PASSWORD_MIN_LENGTH")
+
+	assert.Equal(t, DefaultLoginUsernameMaxFailures, cfg.LoginUsernameMaxFailures)
+	assert.Equal(t, DefaultLoginClientIpMaxFailures, cfg.LoginClientIpMaxFailures)
+	assert.Equal(t, DefaultLoginBackoffBase, cfg.LoginBackoffBase)
+	assert.Equal(t, DefaultLoginBackoffMax, cfg.LoginBackoffMax)
+	assert.Equal(t, DefaultLoginFailureWindow, cfg.LoginFailureWindow)
+	assert.Equal(t, LoginAttemptStoreDatabase, cfg.LoginAttemptStore)
+	os.Setenv("LOGIN_USERNAME_MAX_FAILURES", "0")
+	os.Setenv("LOGIN_BACKOFF_MAX", "1h")
+	os.Setenv("LOGIN_ATTEMPT_STORE", "memory")
+	cfg = LoadConfig()
+	assert.Equal(t, 0, cfg.LoginUsernameMaxFailures)
+	assert.Equal(t, time.Hour, cfg.LoginBackoffMax)
+	assert.Equal(t, LoginAttemptStoreMemory, cfg.LoginAttemptStore)
+	os.Setenv("LOGIN_ATTEMPT_STORE", "redis")
+	cfg = LoadConfig()
+	assert.Equal(t, LoginAttemptStoreDatabase, cfg.LoginAttemptStore)
+	os.Unsetenv("LOGIN_USERNAME_MAX_FAILURES")
+	os.Unsetenv("LOGIN_BACKOFF_MAX")
+	os.Unsetenv("LOGIN_ATTEMPT_STORE")
+
+	assert.Empty(t, cfg.TrustedProxies)
+	os.Setenv("TRUSTED_PROXIES", "10.0.0.1, 192.168.0.0/16,")
+	cfg = LoadConfig()
+	assert.Equal(t, []string{"10.0.0.1", "192.168.0.0/16"}, cfg.TrustedProxies)
+	os.Unsetenv("TRUSTED_PROXIES")
+
+	assert.Equal(t, PasswordHashArgon2id, cfg.PasswordHashAlgorithm)
+	assert.Equal(t, 0, cfg.PasswordMaxBytes)
+	os.Setenv("PASSWORD_HASH_ALGORITHM", "bcrypt")
+	cfg = LoadConfig()
+	assert.Equal(t, PasswordHashBcrypt, cfg.PasswordHashAlgorithm)
+	assert.Equal(t, BcryptMaxPasswordBytes, cfg.PasswordMaxLength)
+	assert.Equal(t, BcryptMaxPasswordBytes, cfg.PasswordMaxBytes)
+	os.Setenv("PASSWORD_MAX_LENGTH", "200")
+	cfg = LoadConfig()
+	assert.Equal(t, BcryptMaxPasswordBytes, cfg.PasswordMaxLength)
+	os.Setenv("PASSWORD_MAX_LENGTH", "64")
+	cfg = LoadConfig()
+	assert.Equal(t, 64, cfg.PasswordMaxLength)
+	assert.Equal(t, BcryptMaxPasswordBytes, cfg.PasswordMaxBytes)
+	os.Unsetenv("PASSWORD_MAX_LENGTH")
+	os.Setenv("PASSWORD_HASH_ALGORITHM", "md5")
+	cfg = LoadConfig()
+	assert.Equal(t, PasswordHashArgon2id, cfg.PasswordHashAlgorithm)
+	os.Unsetenv("PASSWORD_HASH_ALGORITHM")
+
+	assert.Empty(t, cfg.PasswordPeppers)
+	os.Setenv("PASSWORD_PEPPERS", "1:first, 2:sec:ond,0:zero,x:invalid,3:")
+	cfg = LoadConfig()
+	assert.Equal(t, map[uint32]string{1: "first", 2: "sec:ond"}, cfg.PasswordPeppers)
+	os.Unsetenv("PASSWORD_PEPPERS")
+
+	assert.Empty(t, cfg.TotpEncryptionKeys)
+	assert.Equal(t, DefaultTotpIssuer, cfg.TotpIssuer)
+	assert.Equal(t, DefaultMfaTokenLifetime, cfg.MfaTokenLifetime)
+	os.Setenv("TOTP_ENCRYPTION_KEYS", "1:key")
+	os.Setenv("TOTP_ISSUER", " Notes ")
+	os.Setenv("MFA_TOKEN_LIFETIME", "2m")
+	cfg = LoadConfig()
+	assert.Equal(t, map[uint32]string{1: "key"}, cfg.TotpEncryptionKeys)
+	assert.Equal(t, "Notes", cfg.TotpIssuer)
+	assert.Equal(t, 2*time.Minute, cfg.MfaTokenLifetime)
+	os.Unsetenv("TOTP_ENCRYPTION_KEYS")
+	os.Unsetenv("TOTP_ISSUER")
+	os.Unsetenv("MFA_TOKEN_LIFETIME")
+}
+
+func TestLoadConfigOidcProviders(t *testing.T) {
+	cfg := LoadConfig()
+	assert.Empty(t, cfg.OidcProviders)
+	assert.Equal(t, DefaultReauthenticationMaxAge, cfg.ReauthenticationMaxAge)
+	os.Setenv("REAUTHENTICATION_MAX_AGE", "1m")
+	cfg = LoadConfig()
+	assert.Equal(t, time.Minute, cfg.ReauthenticationMaxAge)
+	os.Unsetenv("REAUTHENTICATION_MAX_AGE")
+
+	os.Setenv("OIDC_PROVIDERS", "corp-sso, Bad_Name, incomplete,public")
+	os.Setenv("OIDC_CORP_SSO_ISSUER", "https://sso.example.com")
+	os.Setenv("OIDC_CORP_SSO_CLIENT_ID", "notes")
+	os.Setenv("OIDC_CORP_SSO_CLIENT_SECRET", "secret")
+	os.Setenv("OIDC_CORP_SSO_REDIRECT_URL", "https://notes.example.com/auth/oidc/corp-sso/callback")
+	os.Setenv("OIDC_INCOMPLETE_ISSUER", "https://incomplete.example.com")
+	os.Setenv("OIDC_PUBLIC_ISSUER", "https://public.example.com")
+	os.Setenv("OIDC_PUBLIC_CLIENT_ID", "public-notes")
+	os.Setenv("OIDC_PUBLIC_REDIRECT_URL", "http://localhost:8080/auth/oidc/public/callback")
+	os.Setenv("OIDC_PUBLIC_SCOPES", "email")
+	cfg = LoadConfig()
+	assert.Equal(t, []OidcProvider{
+		{Name: "corp-sso", Issuer: "https://sso.example.com", ClientId: "notes", ClientSecret: "secret",
+			RedirectUrl: "https://notes.example.com/auth/oidc/corp-sso/callback", Scopes: []string{"openid", "profile", "email"}},
+		{Name: "public", Issuer: "https://public.example.com", ClientId: "public-notes",
+			RedirectUrl: "http://localhost:8080/auth/oidc/public/callback", Scopes: []string{"openid", "email"}},
+	}, cfg.OidcProviders)
+
+	for _, key := range []string{"OIDC_PROVIDERS", "OIDC_CORP_SSO_ISSUER", "OIDC_CORP_SSO_CLIENT_ID", "OIDC_CORP_SSO_CLIENT_SECRET",
+		"OIDC_CORP_SSO_REDIRECT_URL", "OIDC_INCOMPLETE_ISSUER", "OIDC_PUBLIC_ISSUER", "OIDC_PUBLIC_CLIENT_ID",
+		"OIDC_PUBLIC_REDIRECT_URL", "OIDC_PUBLIC_SCOPES"} {
+		os.Unsetenv(key)
+	}
 }
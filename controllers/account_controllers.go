@@ -0,0 +1,121 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"user-notes-api/auth"
+	"user-notes-api/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+type AccountController struct {
+	AccountService services.AccountServiceIfc
+}
+
+func NewAccountController(account_service services.AccountServiceIfc) *AccountController {
+	controller := AccountController{AccountService: account_service}
+	return &controller
+}
+
+// ChangePassword responds with new tokens, since all tokens issued before are revoked.
+func (a *AccountController) ChangePassword(c *gin.Context) {
+	var change services.PasswordChange
+	err := c.ShouldBindJSON(&change)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid input"})
+		return
+	}
+
+	user_id, ok := userIdFromContext(c)
+	if !ok {
+		return
+	}
+
+	username, ok := usernameFromContext(c)
+	if !ok {
+		return
+	}
+
+	request_ctx := c.Request.Context()
+	tokens, err := a.AccountService.ChangePassword(request_ctx, user_id, username, authTimeFromContext(c), change)
+	if err != nil {
+		writeAccountError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, tokens)
+}
+
+// Delete deletes the account of the user after the password was confirmed and responds with what was deleted.
+func (a *AccountController) Delete(c *gin.Context) {
+	var deletion services.AccountDeletion
+	err := c.ShouldBindJSON(&deletion)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid input"})
+		return
+	}
+
+	user_id, ok := userIdFromContext(c)
+	if !ok {
+		return
+	}
+
+	username, ok := usernameFromContext(c)
+	if !ok {
+		return
+	}
+
+	request_ctx := c.Request.Context()
+	result, err := a.AccountService.DeleteAccount(request_ctx, user_id, username, authTimeFromContext(c), deletion)
+	if err != nil {
+		writeAccountError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, result)
+}
+
+func usernameFromContext(c *gin.Context) (string, bool) {
+	username, ok := c.Get("username")
+	if !ok {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to parse username from context"})
+		return "", false
+	}
+
+	uname, ok := username.(string)
+	if !ok {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "something went wrong when parsing username from token"})
+		return "", false
+	}
+	return uname, true
+}
+
+// authTimeFromContext returns the time of the login of the access token, zero if it is unknown.
+func authTimeFromContext(c *gin.Context) time.Time {
+	auth_time, _ := c.Get("auth_time")
+	authenticated_at, _ := auth_time.(time.Time)
+	return authenticated_at
+}
+
+func writeAccountError(c *gin.Context, err error) {
+	var wrongPwdError *services.ErrorWrongPassword
+	var throttledError *services.ErrorLoginThrottled
+	var notFoundError *auth.ErrorNotFound
+	var policyError *auth.ErrorPasswordPolicy
+	var reauthenticationError *services.ErrorReauthenticationRequired
+
+	if errors.As(err, &policyError) {
+		writePasswordPolicyError(c, policyError)
+	} else if errors.As(err, &wrongPwdError) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "wrong password"})
+	} else if errors.As(err, &reauthenticationError) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+	} else if errors.As(err, &throttledError) {
+		writeLoginThrottledError(c, throttledError)
+	} else if errors.As(err, &notFoundError) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+	} else {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
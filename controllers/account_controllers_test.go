@@ -0,0 +1,167 @@
+package controllers
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"user-notes-api/services"
+	"user-notes-api/testing/testutils/servicemocks"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAccountControllerChangePassword(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request, _ = http.NewRequest("PUT", "/me/password", bytes.NewBuffer([]byte(`{"old_password": "old", "new_password": "new"}`)))
+	c.Request.Header.Set("Content-Type", "application/json")
+	c.Set("user_id", uint(2))
+	c.Set("username", "Alice")
+
+	account_service := new(servicemocks.MockAccountService)
+	account_controller := NewAccountController(account_service)
+
+	change := services.PasswordChange{OldPassword: "old", NewPassword: "new"}
+	account_service.On("ChangePassword", c.Request.Context(), uint(2), "Alice", time.Time{}, change).
+		Return(services.AuthTokens{Token: "jwt", RefreshToken: "refresh"}, nil)
+
+	account_controller.ChangePassword(c)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"token":"jwt","refresh_token":"refresh"}`, w.Body.String())
+}
+
+func TestAccountControllerChangePasswordWrongPassword(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request, _ = http.NewRequest("PUT", "/me/password", bytes.NewBuffer([]byte(`{"old_password": "wrong", "new_password": "new"}`)))
+	c.Request.Header.Set("Content-Type", "application/json")
+	c.Set("user_id", uint(2))
+	c.Set("username", "Alice")
+
+	account_service := new(servicemocks.MockAccountService)
+	account_controller := NewAccountController(account_service)
+
+	change := services.PasswordChange{OldPassword: "wrong", NewPassword: "new"}
+	e := services.ErrorWrongPassword{Username: "Alice"}
+	account_service.On("ChangePassword", c.Request.Context(), uint(2), "Alice", time.Time{}, change).Return(services.AuthTokens{}, &e)
+
+	account_controller.ChangePassword(c)
+
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
+func TestAccountControllerChangePasswordThrottled(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request, _ = http.NewRequest("PUT", "/me/password", bytes.NewBuffer([]byte(`{"old_password": "old", "new_password": "new"}`)))
+	c.Request.Header.Set("Content-Type", "application/json")
+	c.Set("user_id", uint(2))
+	c.Set("username", "Alice")
+
+	account_service := new(servicemocks.MockAccountService)
+	account_controller := NewAccountController(account_service)
+
+	change := services.PasswordChange{OldPassword: "old", NewPassword: "new"}
+	e := services.ErrorLoginThrottled{RetryAfter: 90 * time.Second}
+	account_service.On("ChangePassword", c.Request.Context(), uint(2), "Alice", time.Time{}, change).Return(services.AuthTokens{}, &e)
+
+	account_controller.ChangePassword(c)
+
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "90", w.Header().Get("Retry-After"))
+}
+
+func TestAccountControllerChangePasswordMissingField(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request, _ = http.NewRequest("PUT", "/me/password", bytes.NewBuffer([]byte(`{"old_password": "old"}`)))
+	c.Request.Header.Set("Content-Type", "application/json")
+	c.Set("user_id", uint(2))
+	c.Set("username", "Alice")
+
+	account_service := new(servicemocks.MockAccountService)
+	account_controller := NewAccountController(account_service)
+
+	account_controller.ChangePassword(c)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	account_service.AssertNotCalled(t, "ChangePassword")
+}
+
+func TestAccountControllerDelete(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request, _ = http.NewRequest("DELETE", "/me", bytes.NewBuffer([]byte(`{"password": "pwd"}`)))
+	c.Request.Header.Set("Content-Type", "application/json")
+	c.Set("user_id", uint(2))
+	c.Set("username", "Alice")
+	auth_time := time.Now().Add(-time.Minute)
+	c.Set("auth_time", auth_time)
+
+	account_service := new(servicemocks.MockAccountService)
+	account_controller := NewAccountController(account_service)
+
+	account_service.On("DeleteAccount", c.Request.Context(), uint(2), "Alice", auth_time, services.AccountDeletion{Password: "pwd"}).
+		Return(services.DeleteAccountResult{Notes: 3, Notebooks: 1, Tags: 2}, nil)
+
+	account_controller.Delete(c)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"Notes":3,"Notebooks":1,"Tags":2}`, w.Body.String())
+}
+
+func TestAccountControllerDeleteReauthenticationRequired(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request, _ = http.NewRequest("DELETE", "/me", bytes.NewBuffer([]byte(`{}`)))
+	c.Request.Header.Set("Content-Type", "application/json")
+	c.Set("user_id", uint(2))
+	c.Set("username", "Alice")
+
+	account_service := new(servicemocks.MockAccountService)
+	account_controller := NewAccountController(account_service)
+
+	e := services.ErrorReauthenticationRequired{MaxAge: 10 * time.Minute}
+	account_service.On("DeleteAccount", c.Request.Context(), uint(2), "Alice", time.Time{}, services.AccountDeletion{}).
+		Return(services.DeleteAccountResult{}, &e)
+
+	account_controller.Delete(c)
+
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.JSONEq(t, `{"error": "log in again to confirm this change, the login must not be older than 10m0s"}`, w.Body.String())
+}
+
+func TestAccountControllerDeleteWithoutPassword(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request, _ = http.NewRequest("DELETE", "/me", http.NoBody)
+	c.Set("user_id", uint(2))
+	c.Set("username", "Alice")
+
+	account_service := new(servicemocks.MockAccountService)
+	account_controller := NewAccountController(account_service)
+
+	account_controller.Delete(c)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	account_service.AssertNotCalled(t, "DeleteAccount")
+}
@@ -2,9 +2,12 @@ package controllers
 
 import (
 	"errors"
+	"math"
 	"net/http"
+	"strconv"
 
 	"user-notes-api/auth"
+	"user-notes-api/repositories"
 	"user-notes-api/services"
 
 	"github.com/gin-gonic/gin"
@@ -30,15 +33,30 @@ func (a *AuthController) Register(c *gin.Context) {
 	}
 
 	request_ctx := c.Request.Context()
-	token_string, err := a.RegistrationService.Register(request_ctx, credentials)
+	tokens, err := a.RegistrationService.Register(request_ctx, credentials)
 
 	if err != nil {
+		var policyError *auth.ErrorPasswordPolicy
+		var invalidUsernameError *auth.ErrorInvalidUsername
+		var usernameTakenError *repositories.ErrorUsernameTaken
+		if errors.As(err, &policyError) {
+			writePasswordPolicyError(c, policyError)
+			return
+		} else if errors.As(err, &invalidUsernameError) {
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+			return
+		} else if errors.As(err, &usernameTakenError) {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
-	c.JSON(http.StatusOK, gin.H{"token": token_string})
+	c.JSON(http.StatusOK, tokens)
 }
 
+// Login responds with the tokens, or for users with two-factor authentication with the MFA token that
+// LoginMfa exchanges for them.
 func (a *AuthController) Login(c *gin.Context) {
 	var credentials auth.Credentials
 	err := c.Bind(&credentials)
@@ -49,22 +67,74 @@ func (a *AuthController) Login(c *gin.Context) {
 	}
 
 	request_ctx := c.Request.Context()
-	token_string, err := a.LoginService.Login(request_ctx, credentials)
+	result, err := a.LoginService.Login(request_ctx, credentials, c.ClientIP())
 
 	if err != nil {
 		var wrongPwdError *services.ErrorWrongPassword
 		var notFoundError *auth.ErrorNotFound
+		var throttledError *services.ErrorLoginThrottled
 
-		if errors.As(err, &wrongPwdError) {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "wrong password"})
+		if errors.As(err, &throttledError) {
+			writeLoginThrottledError(c, throttledError)
 			return
-		} else if errors.As(err, &notFoundError) {
-			c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+		} else if errors.As(err, &wrongPwdError) || errors.As(err, &notFoundError) {
+			// the same response for both, so it does not reveal which users exist
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid username or password"})
 			return
 		} else {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err})
 			return
 		}
 	}
-	c.JSON(http.StatusOK, gin.H{"token": token_string})
+
+	if result.Mfa != nil {
+		c.JSON(http.StatusOK, result.Mfa)
+		return
+	}
+	c.JSON(http.StatusOK, result.AuthTokens)
+}
+
+// LoginMfa completes a login with two-factor authentication by exchanging the MFA token and a TOTP code or
+// recovery code for the tokens.
+func (a *AuthController) LoginMfa(c *gin.Context) {
+	var verification services.MfaVerification
+	err := c.ShouldBindJSON(&verification)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid input"})
+		return
+	}
+
+	request_ctx := c.Request.Context()
+	tokens, err := a.LoginService.VerifyMfa(request_ctx, verification, c.ClientIP())
+
+	if err != nil {
+		var invalidTokenError *services.ErrorInvalidMfaToken
+		var wrongCodeError *services.ErrorWrongMfaCode
+		var stateError *services.ErrorMfaState
+		var throttledError *services.ErrorLoginThrottled
+
+		if errors.As(err, &throttledError) {
+			writeLoginThrottledError(c, throttledError)
+		} else if errors.As(err, &invalidTokenError) || errors.As(err, &stateError) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid or expired mfa token, log in again"})
+		} else if errors.As(err, &wrongCodeError) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid code"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+	c.JSON(http.StatusOK, tokens)
+}
+
+// writeLoginThrottledError responds with 429 and the seconds until the next login can be tried.
+func writeLoginThrottledError(c *gin.Context, err *services.ErrorLoginThrottled) {
+	retry_after := int(math.Ceil(err.RetryAfter.Seconds()))
+	c.Header("Retry-After", strconv.Itoa(retry_after))
+	c.JSON(http.StatusTooManyRequests, gin.H{"error": "too many failed logins"})
+}
+
+// writePasswordPolicyError responds with 422 and the rules the password violates.
+func writePasswordPolicyError(c *gin.Context, err *auth.ErrorPasswordPolicy) {
+	c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error(), "violations": err.Violations})
 }
@@ -5,19 +5,21 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"user-notes-api/auth"
+	"user-notes-api/repositories"
 	"user-notes-api/services"
 	"user-notes-api/testing/testutils/servicemocks"
 
 	"github.com/gin-gonic/gin"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
 )
 
 /*
-	1. username already exists
-	2. unknown error
-	3. empty password?
+	1. unknown error
+	2. empty password?
 */
 
 func TestAuthControllerRegistrationSuccess(t *testing.T) {
@@ -31,18 +33,73 @@ func TestAuthControllerRegistrationSuccess(t *testing.T) {
 
 	mockLoginService := new(servicemocks.MockLoginService)
 	mockRegistrationService := new(servicemocks.MockRegistrationService)
-	mockRegistrationService.On("Register", c.Request.Context(), auth.Credentials{Username: "Alice", Password: "pwd"}).Return("jwt", nil)
+	mockRegistrationService.On("Register", c.Request.Context(), auth.Credentials{Username: "Alice", Password: "pwd"}).Return(services.AuthTokens{Token: "jwt", RefreshToken: "refresh"}, nil)
 
 	authController := NewAuthController(mockLoginService, mockRegistrationService)
 
 	authController.Register(c)
 
 	assert.Equal(t, http.StatusOK, w.Code)
-	assert.Contains(t, w.Body.String(), "jwt")
+	assert.JSONEq(t, `{"token":"jwt","refresh_token":"refresh"}`, w.Body.String())
 	mockLoginService.AssertExpectations(t)
 
 }
 
+func TestAuthControllerRegistrationPasswordPolicy(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	body := []byte(`{"username": "Alice", "password": "pwd"}`)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request, _ = http.NewRequest("POST", "/register", bytes.NewBuffer(body))
+	c.Request.Header.Set("Content-Type", "application/json")
+
+	mockLoginService := new(servicemocks.MockLoginService)
+	mockRegistrationService := new(servicemocks.MockRegistrationService)
+	e := auth.ErrorPasswordPolicy{Violations: []auth.PolicyViolation{
+		{Rule: auth.RuleMinLength, Message: "password must have at least 10 characters"},
+		{Rule: auth.RuleCharacterClasses, Message: "not enough character classes"},
+	}}
+	mockRegistrationService.On("Register", c.Request.Context(), auth.Credentials{Username: "Alice", Password: "pwd"}).Return(services.AuthTokens{}, &e)
+
+	authController := NewAuthController(mockLoginService, mockRegistrationService)
+
+	authController.Register(c)
+
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	assert.Contains(t, w.Body.String(), `"violations":[{"rule":"min_length","message":"password must have at least 10 characters"},{"rule":"character_classes"`)
+}
+
+func TestAuthControllerRegistrationUsername(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	for _, test := range []struct {
+		err      error
+		code     int
+		contains string
+	}{
+		{err: &repositories.ErrorUsernameTaken{Username: "alice"}, code: http.StatusConflict, contains: "is already taken"},
+		{err: &auth.ErrorInvalidUsername{Username: "a!", Reason: "reason"}, code: http.StatusUnprocessableEntity, contains: "invalid username"},
+	} {
+		body := []byte(`{"username": "alice", "password": "pwd"}`)
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request, _ = http.NewRequest("POST", "/register", bytes.NewBuffer(body))
+		c.Request.Header.Set("Content-Type", "application/json")
+
+		mockLoginService := new(servicemocks.MockLoginService)
+		mockRegistrationService := new(servicemocks.MockRegistrationService)
+		mockRegistrationService.On("Register", c.Request.Context(), auth.Credentials{Username: "alice", Password: "pwd"}).Return(services.AuthTokens{}, test.err)
+
+		authController := NewAuthController(mockLoginService, mockRegistrationService)
+
+		authController.Register(c)
+
+		assert.Equal(t, test.code, w.Code)
+		assert.Contains(t, w.Body.String(), test.contains)
+	}
+}
+
 func TestAuthControllerLoginSuccess(t *testing.T) {
 	gin.SetMode(gin.TestMode)
 
@@ -53,7 +110,7 @@ func TestAuthControllerLoginSuccess(t *testing.T) {
 	c.Request.Header.Set("Content-Type", "application/json")
 
 	mockLoginService := new(servicemocks.MockLoginService)
-	mockLoginService.On("Login", c.Request.Context(), auth.Credentials{Username: "Alice", Password: "pwd"}).Return("jwt", nil)
+	mockLoginService.On("Login", c.Request.Context(), auth.Credentials{Username: "Alice", Password: "pwd"}, mock.Anything).Return(services.LoginResult{AuthTokens: services.AuthTokens{Token: "jwt", RefreshToken: "refresh"}}, nil)
 
 	mockRegistrationService := new(servicemocks.MockRegistrationService)
 
@@ -62,7 +119,7 @@ func TestAuthControllerLoginSuccess(t *testing.T) {
 	authController.Login(c)
 
 	assert.Equal(t, http.StatusOK, w.Code)
-	assert.Contains(t, w.Body.String(), "jwt")
+	assert.JSONEq(t, `{"token":"jwt","refresh_token":"refresh"}`, w.Body.String())
 	mockLoginService.AssertExpectations(t)
 }
 
@@ -79,7 +136,7 @@ func TestAuthControllerLoginFailure(t *testing.T) {
 	wrongPwdError.Username = "Alice"
 
 	mockLoginService := new(servicemocks.MockLoginService)
-	mockLoginService.On("Login", c.Request.Context(), auth.Credentials{Username: "Alice", Password: "wrong_pwd"}).Return("", wrongPwdError)
+	mockLoginService.On("Login", c.Request.Context(), auth.Credentials{Username: "Alice", Password: "wrong_pwd"}, mock.Anything).Return(services.LoginResult{}, wrongPwdError)
 
 	mockRegistrationService := new(servicemocks.MockRegistrationService)
 
@@ -88,7 +145,7 @@ func TestAuthControllerLoginFailure(t *testing.T) {
 	authController.Login(c)
 
 	assert.Equal(t, http.StatusUnauthorized, w.Code)
-	assert.Contains(t, w.Body.String(), "wrong password")
+	assert.JSONEq(t, `{"error":"invalid username or password"}`, w.Body.String())
 	mockLoginService.AssertExpectations(t)
 }
 
@@ -105,7 +162,34 @@ func TestAuthControllerLoginUserNotFound(t *testing.T) {
 	notFoundError.Username = "Unknown user"
 
 	mockLoginService := new(servicemocks.MockLoginService)
-	mockLoginService.On("Login", c.Request.Context(), auth.Credentials{Username: "Unknown user", Password: "pwd"}).Return("", notFoundError)
+	mockLoginService.On("Login", c.Request.Context(), auth.Credentials{Username: "Unknown user", Password: "pwd"}, mock.Anything).Return(services.LoginResult{}, notFoundError)
+
+	mockRegistrationService := new(servicemocks.MockRegistrationService)
+
+	authController := NewAuthController(mockLoginService, mockRegistrationService)
+
+	authController.Login(c)
+
+	// the same response as for a wrong password
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.JSONEq(t, `{"error":"invalid username or password"}`, w.Body.String())
+	mockLoginService.AssertExpectations(t)
+}
+
+func TestAuthControllerLoginThrottled(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	body := []byte(`{"username": "Alice", "password": "pwd"}`)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request, _ = http.NewRequest("POST", "/login", bytes.NewBuffer(body))
+	c.Request.Header.Set("Content-Type", "application/json")
+	c.Request.RemoteAddr = "10.0.0.1:54321"
+
+	throttledError := &services.ErrorLoginThrottled{RetryAfter: 1500 * time.Millisecond}
+
+	mockLoginService := new(servicemocks.MockLoginService)
+	mockLoginService.On("Login", c.Request.Context(), auth.Credentials{Username: "Alice", Password: "pwd"}, "10.0.0.1").Return(services.LoginResult{}, throttledError)
 
 	mockRegistrationService := new(servicemocks.MockRegistrationService)
 
@@ -113,7 +197,81 @@ func TestAuthControllerLoginUserNotFound(t *testing.T) {
 
 	authController.Login(c)
 
-	assert.Equal(t, http.StatusNotFound, w.Code)
-	assert.Contains(t, w.Body.String(), "user not found")
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "2", w.Header().Get("Retry-After"))
+	assert.Contains(t, w.Body.String(), "too many failed logins")
 	mockLoginService.AssertExpectations(t)
 }
+
+func TestAuthControllerLoginMfaRequired(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	body := []byte(`{"username": "Alice", "password": "pwd"}`)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request, _ = http.NewRequest("POST", "/login", bytes.NewBuffer(body))
+	c.Request.Header.Set("Content-Type", "application/json")
+
+	expires_at := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	mockLoginService := new(servicemocks.MockLoginService)
+	mockLoginService.On("Login", c.Request.Context(), auth.Credentials{Username: "Alice", Password: "pwd"}, mock.Anything).Return(services.LoginResult{
+		Mfa: &services.MfaChallenge{MfaRequired: true, MfaToken: "mfa_jwt", ExpiresAt: expires_at}}, nil)
+
+	mockRegistrationService := new(servicemocks.MockRegistrationService)
+
+	authController := NewAuthController(mockLoginService, mockRegistrationService)
+
+	authController.Login(c)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"mfa_required": true, "mfa_token": "mfa_jwt", "expires_at": "2026-01-02T03:04:05Z"}`, w.Body.String())
+	mockLoginService.AssertExpectations(t)
+}
+
+func TestAuthControllerLoginMfa(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	throttledError := &services.ErrorLoginThrottled{RetryAfter: 30 * time.Second}
+	for code, expected := range map[string]struct {
+		err    error
+		status int
+	}{
+		"123456":         {nil, http.StatusOK},
+		"654321":         {&services.ErrorWrongMfaCode{}, http.StatusUnauthorized},
+		"111111":         {&services.ErrorInvalidMfaToken{}, http.StatusUnauthorized},
+		"222222":         {&services.ErrorMfaState{Reason: "two-factor authentication is not enabled"}, http.StatusUnauthorized},
+		"333333":         {throttledError, http.StatusTooManyRequests},
+		"aaaa-bbbb-cccc": {repositories.ErrTotpCodeUsed, http.StatusInternalServerError},
+	} {
+		body := []byte(`{"mfa_token": "mfa_jwt", "code": "` + code + `"}`)
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request, _ = http.NewRequest("POST", "/login/mfa", bytes.NewBuffer(body))
+		c.Request.Header.Set("Content-Type", "application/json")
+
+		mockLoginService := new(servicemocks.MockLoginService)
+		mockLoginService.On("VerifyMfa", c.Request.Context(), services.MfaVerification{MfaToken: "mfa_jwt", Code: code}, mock.Anything).Return(
+			services.AuthTokens{Token: "jwt", RefreshToken: "refresh"}, expected.err)
+
+		authController := NewAuthController(mockLoginService, new(servicemocks.MockRegistrationService))
+
+		authController.LoginMfa(c)
+
+		assert.Equal(t, expected.status, w.Code, code)
+		if expected.err == nil {
+			assert.JSONEq(t, `{"token": "jwt", "refresh_token": "refresh"}`, w.Body.String())
+		}
+		mockLoginService.AssertExpectations(t)
+	}
+
+	// the code is required
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request, _ = http.NewRequest("POST", "/login/mfa", bytes.NewBuffer([]byte(`{"mfa_token": "mfa_jwt"}`)))
+	c.Request.Header.Set("Content-Type", "application/json")
+
+	authController := NewAuthController(new(servicemocks.MockLoginService), new(servicemocks.MockRegistrationService))
+	authController.LoginMfa(c)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
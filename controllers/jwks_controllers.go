@@ -0,0 +1,25 @@
+package controllers
+
+import (
+	"net/http"
+
+	"user-notes-api/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+type JwksController struct {
+	JwksProvider services.JwksProvider
+}
+
+func NewJwksController(jwks_provider services.JwksProvider) *JwksController {
+	controller := JwksController{JwksProvider: jwks_provider}
+	return &controller
+}
+
+// GetJwks publishes the public keys that access tokens are verified with, so other services can verify
+// them without the signing key.
+func (j *JwksController) GetJwks(c *gin.Context) {
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, j.JwksProvider.Jwks())
+}
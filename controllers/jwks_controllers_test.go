@@ -0,0 +1,33 @@
+package controllers
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"user-notes-api/services"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJwksControllerGetJwks(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request, _ = http.NewRequest("GET", "/.well-known/jwks.json", nil)
+
+	public_key, private_key, err := ed25519.GenerateKey(rand.Reader)
+	assert.NoError(t, err)
+	jwks_controller := NewJwksController(services.NewJwtKeys(services.NewEd25519JwtKey("ed", private_key)))
+
+	jwks_controller.GetJwks(c)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"keys":[{"kty":"OKP","use":"sig","alg":"EdDSA","kid":"ed","crv":"Ed25519","x":"`+
+		base64.RawURLEncoding.EncodeToString(public_key)+`"}]}`, w.Body.String())
+}
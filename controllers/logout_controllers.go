@@ -0,0 +1,71 @@
+package controllers
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"user-notes-api/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+type LogoutController struct {
+	LogoutService services.LogoutServiceIfc
+}
+
+// LogoutRequest is the optional body of POST /logout. If the refresh token is given, it is revoked as well.
+type LogoutRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+func NewLogoutController(logout_service services.LogoutServiceIfc) *LogoutController {
+	controller := LogoutController{LogoutService: logout_service}
+	return &controller
+}
+
+func (l *LogoutController) Logout(c *gin.Context) {
+	var request LogoutRequest
+	err := c.ShouldBindJSON(&request)
+	if err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid input"})
+		return
+	}
+
+	user_id, ok := userIdFromContext(c)
+	if !ok {
+		return
+	}
+
+	jti := c.GetString("jti")
+	expires_at, ok := c.Get("token_expires_at")
+	if jti == "" || !ok {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to parse token id from context"})
+		return
+	}
+
+	request_ctx := c.Request.Context()
+	err = l.LogoutService.Logout(request_ctx, user_id, jti, expires_at.(time.Time), request.RefreshToken)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "logged out"})
+}
+
+// LogoutAll revokes all tokens of the user, including the one of this request.
+func (l *LogoutController) LogoutAll(c *gin.Context) {
+	user_id, ok := userIdFromContext(c)
+	if !ok {
+		return
+	}
+
+	request_ctx := c.Request.Context()
+	err := l.LogoutService.LogoutAll(request_ctx, user_id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "logged out from all devices"})
+}
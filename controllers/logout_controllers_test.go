@@ -0,0 +1,79 @@
+package controllers
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"user-notes-api/testing/testutils/servicemocks"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLogoutControllerLogout(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request, _ = http.NewRequest("POST", "/logout", bytes.NewBuffer([]byte(`{"refresh_token": "refresh"}`)))
+	c.Request.Header.Set("Content-Type", "application/json")
+	expires_at := time.Now().Add(time.Hour)
+	c.Set("user_id", uint(2))
+	c.Set("jti", "jti")
+	c.Set("token_expires_at", expires_at)
+
+	logout_service := new(servicemocks.MockLogoutService)
+	logout_controller := NewLogoutController(logout_service)
+
+	logout_service.On("Logout", c.Request.Context(), uint(2), "jti", expires_at, "refresh").Return(nil)
+
+	logout_controller.Logout(c)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	logout_service.AssertExpectations(t)
+}
+
+func TestLogoutControllerLogoutWithoutBody(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request, _ = http.NewRequest("POST", "/logout", http.NoBody)
+	expires_at := time.Now().Add(time.Hour)
+	c.Set("user_id", uint(2))
+	c.Set("jti", "jti")
+	c.Set("token_expires_at", expires_at)
+
+	logout_service := new(servicemocks.MockLogoutService)
+	logout_controller := NewLogoutController(logout_service)
+
+	logout_service.On("Logout", c.Request.Context(), uint(2), "jti", expires_at, "").Return(nil)
+
+	logout_controller.Logout(c)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	logout_service.AssertExpectations(t)
+}
+
+func TestLogoutControllerLogoutAll(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request, _ = http.NewRequest("POST", "/logout/all", nil)
+	c.Set("user_id", uint(2))
+
+	logout_service := new(servicemocks.MockLogoutService)
+	logout_controller := NewLogoutController(logout_service)
+
+	logout_service.On("LogoutAll", c.Request.Context(), uint(2)).Return(errors.New("db down"))
+
+	logout_controller.LogoutAll(c)
+
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.Contains(t, w.Body.String(), "db down")
+}
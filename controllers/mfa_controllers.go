@@ -0,0 +1,175 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+
+	"user-notes-api/auth"
+	"user-notes-api/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+type MfaController struct {
+	MfaService services.MfaServiceIfc
+}
+
+func NewMfaController(mfa_service services.MfaServiceIfc) *MfaController {
+	controller := MfaController{MfaService: mfa_service}
+	return &controller
+}
+
+func (m *MfaController) GetStatus(c *gin.Context) {
+	user_id, ok := userIdFromContext(c)
+	if !ok {
+		return
+	}
+
+	request_ctx := c.Request.Context()
+	status, err := m.MfaService.GetStatus(request_ctx, user_id)
+	if err != nil {
+		writeMfaError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, status)
+}
+
+// StartTotpEnrollment responds with a new TOTP secret and its otpauth URI. Two-factor authentication is
+// enabled once a code of the secret is confirmed.
+func (m *MfaController) StartTotpEnrollment(c *gin.Context) {
+	var start services.TotpEnrollmentStart
+	err := c.ShouldBindJSON(&start)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid input"})
+		return
+	}
+
+	user_id, ok := userIdFromContext(c)
+	if !ok {
+		return
+	}
+
+	username, ok := usernameFromContext(c)
+	if !ok {
+		return
+	}
+
+	request_ctx := c.Request.Context()
+	enrollment, err := m.MfaService.StartTotpEnrollment(request_ctx, user_id, username, authTimeFromContext(c), start)
+	if err != nil {
+		writeMfaError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, enrollment)
+}
+
+// ConfirmTotpEnrollment enables two-factor authentication and responds with the recovery codes, which
+// are not shown again.
+func (m *MfaController) ConfirmTotpEnrollment(c *gin.Context) {
+	var confirmation services.MfaConfirmation
+	err := c.ShouldBindJSON(&confirmation)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid input"})
+		return
+	}
+
+	user_id, ok := userIdFromContext(c)
+	if !ok {
+		return
+	}
+
+	username, ok := usernameFromContext(c)
+	if !ok {
+		return
+	}
+
+	request_ctx := c.Request.Context()
+	recovery_codes, err := m.MfaService.ConfirmTotpEnrollment(request_ctx, user_id, username, authTimeFromContext(c), confirmation)
+	if err != nil {
+		writeMfaError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, recovery_codes)
+}
+
+func (m *MfaController) DisableTotp(c *gin.Context) {
+	var confirmation services.MfaConfirmation
+	err := c.ShouldBindJSON(&confirmation)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid input"})
+		return
+	}
+
+	user_id, ok := userIdFromContext(c)
+	if !ok {
+		return
+	}
+
+	username, ok := usernameFromContext(c)
+	if !ok {
+		return
+	}
+
+	request_ctx := c.Request.Context()
+	err = m.MfaService.DisableTotp(request_ctx, user_id, username, authTimeFromContext(c), confirmation)
+	if err != nil {
+		writeMfaError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"totp_enabled": false})
+}
+
+// RegenerateRecoveryCodes responds with new recovery codes, the previous ones become invalid.
+func (m *MfaController) RegenerateRecoveryCodes(c *gin.Context) {
+	var confirmation services.MfaConfirmation
+	err := c.ShouldBindJSON(&confirmation)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid input"})
+		return
+	}
+
+	user_id, ok := userIdFromContext(c)
+	if !ok {
+		return
+	}
+
+	username, ok := usernameFromContext(c)
+	if !ok {
+		return
+	}
+
+	request_ctx := c.Request.Context()
+	recovery_codes, err := m.MfaService.RegenerateRecoveryCodes(request_ctx, user_id, username, authTimeFromContext(c), confirmation)
+	if err != nil {
+		writeMfaError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, recovery_codes)
+}
+
+func writeMfaError(c *gin.Context, err error) {
+	var stateError *services.ErrorMfaState
+	var wrongCodeError *services.ErrorWrongMfaCode
+	var wrongPwdError *services.ErrorWrongPassword
+	var throttledError *services.ErrorLoginThrottled
+	var reauthenticationError *services.ErrorReauthenticationRequired
+	var notFoundError *auth.ErrorNotFound
+
+	if errors.Is(err, services.ErrMfaNotConfigured) {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+	} else if errors.As(err, &stateError) {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	} else if errors.As(err, &wrongCodeError) {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "invalid code"})
+	} else if errors.As(err, &wrongPwdError) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "wrong password"})
+	} else if errors.As(err, &reauthenticationError) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+	} else if errors.As(err, &throttledError) {
+		writeLoginThrottledError(c, throttledError)
+	} else if errors.As(err, &notFoundError) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+	} else {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
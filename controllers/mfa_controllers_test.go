@@ -0,0 +1,175 @@
+package controllers
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"user-notes-api/auth"
+	"user-notes-api/services"
+	"user-notes-api/testing/testutils/servicemocks"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestMfaControllerGetStatus(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request, _ = http.NewRequest("GET", "/me/mfa", nil)
+	c.Set("user_id", uint(1))
+
+	mfa_service := new(servicemocks.MockMfaService)
+	mfa_controller := NewMfaController(mfa_service)
+
+	mfa_service.On("GetStatus", c.Request.Context(), uint(1)).Return(services.MfaStatus{TotpEnabled: true, RecoveryCodesLeft: 8}, nil)
+
+	mfa_controller.GetStatus(c)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"totp_enabled": true, "recovery_codes_left": 8}`, w.Body.String())
+}
+
+func TestMfaControllerStartTotpEnrollment(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	mfa_service := new(servicemocks.MockMfaService)
+	mfa_controller := NewMfaController(mfa_service)
+
+	for user_id, expected := range map[uint]struct {
+		body   string
+		err    error
+		status int
+	}{
+		1: {`{"password": "right"}`, nil, http.StatusOK},
+		2: {`{"password": "right"}`, &services.ErrorMfaState{Reason: "two-factor authentication is already enabled"}, http.StatusConflict},
+		3: {`{"password": "right"}`, services.ErrMfaNotConfigured, http.StatusServiceUnavailable},
+		4: {`{"password": "wrong"}`, &services.ErrorWrongPassword{}, http.StatusUnauthorized},
+		5: {`{}`, &services.ErrorReauthenticationRequired{MaxAge: 10 * time.Minute}, http.StatusUnauthorized},
+		6: {``, nil, http.StatusBadRequest},
+	} {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request, _ = http.NewRequest("POST", "/me/mfa/totp", bytes.NewBuffer([]byte(expected.body)))
+		c.Request.Header.Set("Content-Type", "application/json")
+		c.Set("user_id", user_id)
+		c.Set("username", "Alice")
+
+		mfa_service.On("StartTotpEnrollment", c.Request.Context(), user_id, "Alice", time.Time{}, mock.Anything).Return(services.TotpEnrollment{
+			Secret: "JBSWY3DPEHPK3PXP", OtpauthUri: "otpauth://totp/User-Notes-API:Alice?secret=JBSWY3DPEHPK3PXP"}, expected.err)
+
+		mfa_controller.StartTotpEnrollment(c)
+
+		assert.Equal(t, expected.status, w.Code, user_id)
+		if expected.status == http.StatusOK {
+			assert.JSONEq(t, `{"secret": "JBSWY3DPEHPK3PXP", "otpauth_uri": "otpauth://totp/User-Notes-API:Alice?secret=JBSWY3DPEHPK3PXP"}`,
+				w.Body.String())
+		}
+	}
+}
+
+func TestMfaControllerConfirmTotpEnrollment(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	mfa_service := new(servicemocks.MockMfaService)
+	mfa_controller := NewMfaController(mfa_service)
+
+	for body, expected := range map[string]struct {
+		err    error
+		status int
+	}{
+		`{"password": "right", "code": "123456"}`: {nil, http.StatusOK},
+		`{"password": "right", "code": "654321"}`: {&services.ErrorWrongMfaCode{}, http.StatusUnprocessableEntity},
+		`{"password": "wrong", "code": "123456"}`: {&services.ErrorWrongPassword{}, http.StatusUnauthorized},
+		`{"password": "right"}`:                   {nil, http.StatusBadRequest},
+	} {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request, _ = http.NewRequest("POST", "/me/mfa/totp/confirm", bytes.NewBuffer([]byte(body)))
+		c.Request.Header.Set("Content-Type", "application/json")
+		c.Set("user_id", uint(1))
+		c.Set("username", "Alice")
+
+		mfa_service.On("ConfirmTotpEnrollment", c.Request.Context(), uint(1), "Alice", time.Time{},
+			services.MfaConfirmation{Password: "right", Code: "123456"}).Return(
+			services.RecoveryCodes{RecoveryCodes: []string{"abcd-efgh-ijkl-mnop"}}, nil)
+		mfa_service.On("ConfirmTotpEnrollment", c.Request.Context(), uint(1), "Alice", time.Time{},
+			services.MfaConfirmation{Password: "right", Code: "654321"}).Return(
+			services.RecoveryCodes{}, &services.ErrorWrongMfaCode{})
+		mfa_service.On("ConfirmTotpEnrollment", c.Request.Context(), uint(1), "Alice", time.Time{},
+			services.MfaConfirmation{Password: "wrong", Code: "123456"}).Return(
+			services.RecoveryCodes{}, &services.ErrorWrongPassword{})
+
+		mfa_controller.ConfirmTotpEnrollment(c)
+
+		assert.Equal(t, expected.status, w.Code, body)
+		if expected.status == http.StatusOK {
+			assert.JSONEq(t, `{"recovery_codes": ["abcd-efgh-ijkl-mnop"]}`, w.Body.String())
+		}
+	}
+}
+
+func TestMfaControllerDisableTotp(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	mfa_service := new(servicemocks.MockMfaService)
+	mfa_controller := NewMfaController(mfa_service)
+
+	for password, expected := range map[string]struct {
+		err    error
+		status int
+	}{
+		"right":     {nil, http.StatusOK},
+		"wrong":     {&services.ErrorWrongPassword{}, http.StatusUnauthorized},
+		"throttled": {&services.ErrorLoginThrottled{RetryAfter: time.Minute}, http.StatusTooManyRequests},
+		"disabled":  {&services.ErrorMfaState{Reason: "two-factor authentication is not enabled"}, http.StatusConflict},
+		"deleted":   {&auth.ErrorNotFound{}, http.StatusNotFound},
+	} {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request, _ = http.NewRequest("DELETE", "/me/mfa/totp",
+			bytes.NewBuffer([]byte(`{"password": "`+password+`", "code": "123456"}`)))
+		c.Request.Header.Set("Content-Type", "application/json")
+		c.Set("user_id", uint(1))
+		c.Set("username", "Alice")
+
+		confirmation := services.MfaConfirmation{Password: password, Code: "123456"}
+		mfa_service.On("DisableTotp", c.Request.Context(), uint(1), "Alice", time.Time{}, confirmation).Return(expected.err)
+
+		mfa_controller.DisableTotp(c)
+
+		assert.Equal(t, expected.status, w.Code, password)
+		if expected.err == nil {
+			assert.JSONEq(t, `{"totp_enabled": false}`, w.Body.String())
+		}
+	}
+}
+
+func TestMfaControllerRegenerateRecoveryCodes(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request, _ = http.NewRequest("POST", "/me/mfa/recovery-codes",
+		bytes.NewBuffer([]byte(`{"password": "pwd", "code": "abcd-efgh-ijkl-mnop"}`)))
+	c.Request.Header.Set("Content-Type", "application/json")
+	c.Set("user_id", uint(1))
+	c.Set("username", "Alice")
+
+	mfa_service := new(servicemocks.MockMfaService)
+	mfa_controller := NewMfaController(mfa_service)
+
+	confirmation := services.MfaConfirmation{Password: "pwd", Code: "abcd-efgh-ijkl-mnop"}
+	mfa_service.On("RegenerateRecoveryCodes", c.Request.Context(), uint(1), "Alice", time.Time{}, confirmation).Return(
+		services.RecoveryCodes{RecoveryCodes: []string{"qrst-uvwx-yz23-4567"}}, nil)
+
+	mfa_controller.RegenerateRecoveryCodes(c)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"recovery_codes": ["qrst-uvwx-yz23-4567"]}`, w.Body.String())
+}
@@ -4,6 +4,7 @@ import (
 	"errors"
 	"net/http"
 	"strconv"
+	"strings"
 
 	"user-notes-api/services"
 
@@ -45,7 +46,7 @@ func (n *NoteController) Create(c *gin.Context) {
 	id, err := n.ModificationService.CreateNote(request_ctx, note, uname)
 
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		writeNoteModificationError(c, err)
 		return
 	}
 	c.JSON(http.StatusOK, gin.H{"id": id})
@@ -53,21 +54,25 @@ func (n *NoteController) Create(c *gin.Context) {
 
 func (n *NoteController) GetNotes(c *gin.Context) {
 	request_ctx := c.Request.Context()
-	uid, ok := c.Get("user_id")
+	user_id, ok := userIdFromContext(c)
 	if !ok {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "failed to parse user id from context"})
 		return
 	}
 
-	user_id, ok := uid.(uint)
-
-	if !ok {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "failed to convert user id to uint"})
+	var options services.NoteListOptions
+	err := c.ShouldBindQuery(&options)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid query: " + err.Error()})
 		return
 	}
 
-	result, err := n.ReaderService.GetNotes(request_ctx, user_id)
+	result, err := n.ReaderService.GetNotes(request_ctx, user_id, options)
 	if err != nil {
+		var invalidOptionError *services.ErrorInvalidListOption
+		if errors.As(err, &invalidOptionError) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
 		c.JSON(http.StatusNotFound, gin.H{"error": "notes not found"})
 		return
 	}
@@ -76,26 +81,17 @@ func (n *NoteController) GetNotes(c *gin.Context) {
 
 func (n *NoteController) GetSingleNote(c *gin.Context) {
 	request_ctx := c.Request.Context()
-	note_id_str := c.Param("id")
-	note_id, err := strconv.Atoi(note_id_str)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "malformed id"})
-		return
-	}
-
-	uid, ok := c.Get("user_id")
+	note_id, ok := idFromParam(c)
 	if !ok {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to parse user id from context"})
 		return
 	}
 
-	user_id, ok := uid.(uint)
+	user_id, ok := userIdFromContext(c)
 	if !ok {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "malformed user id"})
 		return
 	}
 
-	note, err := n.ReaderService.GetNote(request_ctx, uint(note_id), user_id)
+	note, err := n.ReaderService.GetNote(request_ctx, note_id, user_id)
 	if err != nil {
 		var e *services.ErrorWrongOwner
 		if errors.As(err, &e) {
@@ -106,5 +102,198 @@ func (n *NoteController) GetSingleNote(c *gin.Context) {
 			return
 		}
 	}
+
+	etag := noteETag(note.Version)
+	c.Header("ETag", etag)
+	if if_none_match := c.GetHeader("If-None-Match"); if_none_match != "" && etagListMatches(if_none_match, etag) {
+		c.Status(http.StatusNotModified)
+		c.Writer.WriteHeaderNow()
+		return
+	}
 	c.JSON(http.StatusOK, note)
 }
+
+func (n *NoteController) Update(c *gin.Context) {
+	request_ctx := c.Request.Context()
+	note_id, ok := idFromParam(c)
+	if !ok {
+		return
+	}
+
+	var note services.Note
+	err := c.Bind(&note)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid input" + err.Error()})
+		return
+	}
+
+	user_id, ok := userIdFromContext(c)
+	if !ok {
+		return
+	}
+
+	version, ok := versionFromIfMatch(c)
+	if !ok {
+		return
+	}
+
+	new_version, err := n.ModificationService.UpdateNote(request_ctx, note_id, user_id, version, note)
+	if err != nil {
+		writeNoteModificationError(c, err)
+		return
+	}
+	c.Header("ETag", noteETag(new_version))
+	c.JSON(http.StatusOK, gin.H{"id": note_id})
+}
+
+func (n *NoteController) Delete(c *gin.Context) {
+	request_ctx := c.Request.Context()
+	note_id, ok := idFromParam(c)
+	if !ok {
+		return
+	}
+
+	user_id, ok := userIdFromContext(c)
+	if !ok {
+		return
+	}
+
+	version, ok := versionFromIfMatch(c)
+	if !ok {
+		return
+	}
+
+	err := n.ModificationService.DeleteNote(request_ctx, note_id, user_id, version)
+	if err != nil {
+		writeNoteModificationError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"id": note_id})
+}
+
+// writeNoteModificationError maps the ownership and lookup errors of the note service to a response.
+func writeNoteModificationError(c *gin.Context, err error) {
+	var wrongOwnerError *services.ErrorWrongOwner
+	var notFoundError *services.ErrorNoteNotFound
+	var invalidTagError *services.ErrorInvalidTag
+	var notebookWrongOwnerError *services.ErrorNotebookWrongOwner
+	var notebookNotFoundError *services.ErrorNotebookNotFound
+	var versionMismatchError *services.ErrorVersionMismatch
+
+	if errors.As(err, &versionMismatchError) {
+		writeVersionMismatch(c, versionMismatchError)
+	} else if errors.As(err, &wrongOwnerError) || errors.As(err, &notebookWrongOwnerError) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+	} else if errors.As(err, &notFoundError) || errors.As(err, &notebookNotFoundError) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	} else if errors.As(err, &invalidTagError) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	} else {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
+
+// noteETag returns the strong entity tag of the given version of a note. The version is incremented by every
+// change of the representation of the note: its title, content, notebook and tags, including moves of the note
+// when its notebook is deleted, restores from the trash and renames, merges and deletions of its tags.
+func noteETag(version uint) string {
+	return `"` + strconv.FormatUint(uint64(version), 10) + `"`
+}
+
+// etagListMatches reports whether the comma separated list of entity tags of an If-None-Match header
+// contains etag. Weak tags are compared by their value.
+func etagListMatches(header string, etag string) bool {
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" || strings.TrimPrefix(tag, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// versionFromIfMatch returns the note version of the If-Match header, which must hold a single strong
+// entity tag as returned by GetSingleNote. It writes an error response if the header is missing or
+// malformed.
+func versionFromIfMatch(c *gin.Context) (uint, bool) {
+	if_match := strings.TrimSpace(c.GetHeader("If-Match"))
+	if if_match == "" {
+		c.JSON(http.StatusPreconditionRequired, gin.H{"error": "If-Match header with the ETag of the note is required"})
+		return 0, false
+	}
+
+	value, found := strings.CutPrefix(if_match, `"`)
+	value, found_end := strings.CutSuffix(value, `"`)
+	version, err := strconv.ParseUint(value, 10, 0)
+	if !found || !found_end || err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "malformed If-Match header"})
+		return 0, false
+	}
+	return uint(version), true
+}
+
+// writeVersionMismatch responds with 412 and the current version of the note, both in the body and as ETag.
+func writeVersionMismatch(c *gin.Context, err *services.ErrorVersionMismatch) {
+	c.Header("ETag", noteETag(err.Version))
+	c.JSON(http.StatusPreconditionFailed, gin.H{"error": err.Error(), "version": err.Version})
+}
+
+func (n *NoteController) Move(c *gin.Context) {
+	request_ctx := c.Request.Context()
+	note_id, ok := idFromParam(c)
+	if !ok {
+		return
+	}
+
+	var move services.NoteMove
+	err := c.Bind(&move)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid input" + err.Error()})
+		return
+	}
+
+	user_id, ok := userIdFromContext(c)
+	if !ok {
+		return
+	}
+
+	version, ok := versionFromIfMatch(c)
+	if !ok {
+		return
+	}
+
+	new_version, err := n.ModificationService.MoveNote(request_ctx, note_id, user_id, version, move.Notebook)
+	if err != nil {
+		writeNoteModificationError(c, err)
+		return
+	}
+	c.Header("ETag", noteETag(new_version))
+	c.JSON(http.StatusOK, gin.H{"id": note_id})
+}
+
+func (n *NoteController) Search(c *gin.Context) {
+	request_ctx := c.Request.Context()
+	user_id, ok := userIdFromContext(c)
+	if !ok {
+		return
+	}
+
+	var options services.NoteSearchOptions
+	err := c.ShouldBindQuery(&options)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid query: " + err.Error()})
+		return
+	}
+
+	result, err := n.ReaderService.SearchNotes(request_ctx, user_id, options)
+	if err != nil {
+		var invalidOptionError *services.ErrorInvalidListOption
+		if errors.As(err, &invalidOptionError) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, result)
+}
@@ -7,11 +7,13 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 	"user-notes-api/services"
 	"user-notes-api/testing/testutils/servicemocks"
 
 	"github.com/gin-gonic/gin"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
 )
 
 func TestNoteControllerCreateSuccess(t *testing.T) {
@@ -138,7 +140,7 @@ func TestNoteControllerGetNotesSuccess(t *testing.T) {
 	var notes services.GetNotesResult
 	notes.Result = append(notes.Result, services.NoteListResult{Id: 1, Title: "Title1"})
 	notes.Result = append(notes.Result, services.NoteListResult{Id: 2, Title: "Title2"})
-	note_read_service.On("GetNotes", req_ctx, uint(1)).Return(notes, nil)
+	note_read_service.On("GetNotes", req_ctx, uint(1), services.NoteListOptions{}).Return(notes, nil)
 
 	note_controller.GetNotes(c)
 
@@ -221,6 +223,47 @@ func TestNoteControllerGetSingleNoteNotFound(t *testing.T) {
 	assert.Contains(t, w.Body.String(), "not found")
 }
 
+func TestNoteControllerGetSingleNoteETag(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	note_mod_service := new(servicemocks.MockNoteModificationService)
+	note_read_service := new(servicemocks.MockNoteReaderService)
+	note_controller := NewNoteController(note_mod_service, note_read_service)
+
+	note := services.Note{Title: "Title", Content: "Content", Version: 3}
+	note_read_service.On("GetNote", mock.Anything, uint(1), uint(1)).Return(note, nil)
+
+	for _, test := range []struct {
+		if_none_match string
+		code          int
+	}{
+		{"", http.StatusOK},
+		{`"2"`, http.StatusOK},
+		{`"3"`, http.StatusNotModified},
+		{`"2", W/"3"`, http.StatusNotModified},
+		{"*", http.StatusNotModified},
+	} {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request, _ = http.NewRequest("GET", "/notes/1", nil)
+		if test.if_none_match != "" {
+			c.Request.Header.Set("If-None-Match", test.if_none_match)
+		}
+		c.Params = append(c.Params, gin.Param{Key: "id", Value: "1"})
+		c.Set("user_id", uint(1))
+
+		note_controller.GetSingleNote(c)
+
+		assert.Equal(t, test.code, w.Code, test.if_none_match)
+		assert.Equal(t, `"3"`, w.Header().Get("ETag"))
+		if test.code == http.StatusNotModified {
+			assert.Empty(t, w.Body.String())
+		} else {
+			assert.JSONEq(t, `{"Title":"Title","Content":"Content"}`, w.Body.String())
+		}
+	}
+}
+
 func TestNoteControllerGetNotesNotesNotFound(t *testing.T) {
 	gin.SetMode(gin.TestMode)
 
@@ -238,10 +281,431 @@ func TestNoteControllerGetNotesNotesNotFound(t *testing.T) {
 	req_ctx := c.Request.Context()
 	var notes services.GetNotesResult
 	e := services.ErrorNotesNotFound{UserId: 1, Err: errors.New("user not found")}
-	note_read_service.On("GetNotes", req_ctx, uint(1)).Return(notes, &e)
+	note_read_service.On("GetNotes", req_ctx, uint(1), services.NoteListOptions{}).Return(notes, &e)
 
 	note_controller.GetNotes(c)
 
 	assert.Equal(t, http.StatusNotFound, w.Code)
 	assert.Contains(t, w.Body.String(), "error")
 }
+
+func TestNoteControllerUpdateSuccess(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	note := services.Note{Title: "new title", Content: "new content"}
+	marshalled, err := json.Marshal(note)
+	assert.NoError(t, err)
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request, _ = http.NewRequest("PUT", "/notes/1", bytes.NewBuffer(marshalled))
+	c.Request.Header.Set("Content-Type", "application/json")
+	c.Request.Header.Set("If-Match", `"3"`)
+	c.Params = append(c.Params, gin.Param{Key: "id", Value: "1"})
+	c.Set("user_id", uint(2))
+
+	note_mod_service := new(servicemocks.MockNoteModificationService)
+	note_read_service := new(servicemocks.MockNoteReaderService)
+	note_controller := NewNoteController(note_mod_service, note_read_service)
+
+	req_ctx := c.Request.Context()
+	note_mod_service.On("UpdateNote", req_ctx, uint(1), uint(2), uint(3), note).Return(4, nil)
+
+	note_controller.Update(c)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, `"4"`, w.Header().Get("ETag"))
+	assert.Contains(t, w.Body.String(), `"id":1`)
+	note_mod_service.AssertExpectations(t)
+}
+
+func TestNoteControllerUpdateTags(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	note_mod_service := new(servicemocks.MockNoteModificationService)
+	note_read_service := new(servicemocks.MockNoteReaderService)
+	note_controller := NewNoteController(note_mod_service, note_read_service)
+
+	// a missing Tags field is passed on as nil and keeps the tags, an empty list removes them
+	for body, expected := range map[string]services.Note{
+		`{"Title": "title"}`:             {Title: "title"},
+		`{"Title": "title", "Tags": []}`: {Title: "title", Tags: []string{}},
+	} {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request, _ = http.NewRequest("PUT", "/notes/1", bytes.NewBufferString(body))
+		c.Request.Header.Set("Content-Type", "application/json")
+		c.Request.Header.Set("If-Match", `"3"`)
+		c.Params = append(c.Params, gin.Param{Key: "id", Value: "1"})
+		c.Set("user_id", uint(2))
+
+		note_mod_service.On("UpdateNote", c.Request.Context(), uint(1), uint(2), uint(3), expected).Return(4, nil).Once()
+
+		note_controller.Update(c)
+
+		assert.Equal(t, http.StatusOK, w.Code, body)
+	}
+	note_mod_service.AssertExpectations(t)
+}
+
+func TestNoteControllerUpdateWrongUser(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	note := services.Note{Title: "new title", Content: "new content"}
+	marshalled, err := json.Marshal(note)
+	assert.NoError(t, err)
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request, _ = http.NewRequest("PUT", "/notes/1", bytes.NewBuffer(marshalled))
+	c.Request.Header.Set("Content-Type", "application/json")
+	c.Request.Header.Set("If-Match", `"3"`)
+	c.Params = append(c.Params, gin.Param{Key: "id", Value: "1"})
+	c.Set("user_id", uint(2))
+
+	note_mod_service := new(servicemocks.MockNoteModificationService)
+	note_read_service := new(servicemocks.MockNoteReaderService)
+	note_controller := NewNoteController(note_mod_service, note_read_service)
+
+	req_ctx := c.Request.Context()
+	e := services.ErrorWrongOwner{UserId: 2, NoteId: 1}
+	note_mod_service.On("UpdateNote", req_ctx, uint(1), uint(2), uint(3), note).Return(0, &e)
+
+	note_controller.Update(c)
+
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Contains(t, w.Body.String(), "does not own note")
+}
+
+func TestNoteControllerUpdateMalformedId(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request, _ = http.NewRequest("PUT", "/notes/abc", bytes.NewBuffer([]byte(`{}`)))
+	c.Request.Header.Set("Content-Type", "application/json")
+	c.Params = append(c.Params, gin.Param{Key: "id", Value: "abc"})
+	c.Set("user_id", uint(2))
+
+	note_mod_service := new(servicemocks.MockNoteModificationService)
+	note_read_service := new(servicemocks.MockNoteReaderService)
+	note_controller := NewNoteController(note_mod_service, note_read_service)
+
+	note_controller.Update(c)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "malformed id")
+}
+
+func TestNoteControllerUpdateIfMatch(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	note_mod_service := new(servicemocks.MockNoteModificationService)
+	note_read_service := new(servicemocks.MockNoteReaderService)
+	note_controller := NewNoteController(note_mod_service, note_read_service)
+
+	for _, test := range []struct {
+		if_match string
+		code     int
+		message  string
+	}{
+		{"", http.StatusPreconditionRequired, "If-Match header"},
+		{"3", http.StatusBadRequest, "malformed If-Match"},
+		{`W/"3"`, http.StatusBadRequest, "malformed If-Match"},
+		{"*", http.StatusBadRequest, "malformed If-Match"},
+	} {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request, _ = http.NewRequest("PUT", "/notes/1", bytes.NewBuffer([]byte(`{"Title":"Title"}`)))
+		c.Request.Header.Set("Content-Type", "application/json")
+		if test.if_match != "" {
+			c.Request.Header.Set("If-Match", test.if_match)
+		}
+		c.Params = append(c.Params, gin.Param{Key: "id", Value: "1"})
+		c.Set("user_id", uint(2))
+
+		note_controller.Update(c)
+
+		assert.Equal(t, test.code, w.Code, test.if_match)
+		assert.Contains(t, w.Body.String(), test.message)
+	}
+	note_mod_service.AssertNotCalled(t, "UpdateNote", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestNoteControllerUpdateVersionMismatch(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request, _ = http.NewRequest("PUT", "/notes/1", bytes.NewBuffer([]byte(`{"Title":"Title"}`)))
+	c.Request.Header.Set("Content-Type", "application/json")
+	c.Request.Header.Set("If-Match", `"3"`)
+	c.Params = append(c.Params, gin.Param{Key: "id", Value: "1"})
+	c.Set("user_id", uint(2))
+
+	note_mod_service := new(servicemocks.MockNoteModificationService)
+	note_read_service := new(servicemocks.MockNoteReaderService)
+	note_controller := NewNoteController(note_mod_service, note_read_service)
+
+	req_ctx := c.Request.Context()
+	e := services.ErrorVersionMismatch{NoteId: 1, Expected: 3, Version: 4}
+	note_mod_service.On("UpdateNote", req_ctx, uint(1), uint(2), uint(3), services.Note{Title: "Title"}).Return(0, &e)
+
+	note_controller.Update(c)
+
+	assert.Equal(t, http.StatusPreconditionFailed, w.Code)
+	assert.Equal(t, `"4"`, w.Header().Get("ETag"))
+	assert.JSONEq(t, `{"error":"note with id 1 has version 4, not 3","version":4}`, w.Body.String())
+}
+
+func TestNoteControllerDeleteSuccess(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request, _ = http.NewRequest("DELETE", "/notes/1", nil)
+	c.Request.Header.Set("If-Match", `"3"`)
+	c.Params = append(c.Params, gin.Param{Key: "id", Value: "1"})
+	c.Set("user_id", uint(2))
+
+	note_mod_service := new(servicemocks.MockNoteModificationService)
+	note_read_service := new(servicemocks.MockNoteReaderService)
+	note_controller := NewNoteController(note_mod_service, note_read_service)
+
+	req_ctx := c.Request.Context()
+	note_mod_service.On("DeleteNote", req_ctx, uint(1), uint(2), uint(3)).Return(nil)
+
+	note_controller.Delete(c)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"id":1`)
+	note_mod_service.AssertExpectations(t)
+}
+
+func TestNoteControllerDeleteNotFound(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request, _ = http.NewRequest("DELETE", "/notes/1", nil)
+	c.Request.Header.Set("If-Match", `"3"`)
+	c.Params = append(c.Params, gin.Param{Key: "id", Value: "1"})
+	c.Set("user_id", uint(2))
+
+	note_mod_service := new(servicemocks.MockNoteModificationService)
+	note_read_service := new(servicemocks.MockNoteReaderService)
+	note_controller := NewNoteController(note_mod_service, note_read_service)
+
+	req_ctx := c.Request.Context()
+	e := services.ErrorNoteNotFound{NoteId: 1}
+	note_mod_service.On("DeleteNote", req_ctx, uint(1), uint(2), uint(3)).Return(&e)
+
+	note_controller.Delete(c)
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Contains(t, w.Body.String(), "not found")
+}
+
+func TestNoteControllerGetNotesWithOptions(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request, _ = http.NewRequest("GET", "/notes?limit=2&sort=-title&cursor=abc&updated_since=2025-01-02T15:04:05Z", nil)
+
+	c.Set("user_id", uint(1))
+
+	note_mod_service := new(servicemocks.MockNoteModificationService)
+	note_read_service := new(servicemocks.MockNoteReaderService)
+	note_controller := NewNoteController(note_mod_service, note_read_service)
+
+	req_ctx := c.Request.Context()
+	updated_since := time.Date(2025, 1, 2, 15, 4, 5, 0, time.UTC)
+	options := services.NoteListOptions{Limit: 2, Sort: "-title", Cursor: "abc", UpdatedSince: &updated_since}
+	var notes services.GetNotesResult
+	notes.Result = append(notes.Result, services.NoteListResult{Id: 2, Title: "Title2"})
+	notes.Result = append(notes.Result, services.NoteListResult{Id: 1, Title: "Title1"})
+	notes.NextCursor = "next"
+	note_read_service.On("GetNotes", req_ctx, uint(1), options).Return(notes, nil)
+
+	note_controller.GetNotes(c)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"Id":2`)
+	assert.Contains(t, w.Body.String(), `"next_cursor":"next"`)
+	note_read_service.AssertExpectations(t)
+}
+
+func TestNoteControllerGetNotesMalformedQuery(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request, _ = http.NewRequest("GET", "/notes?created_before=yesterday", nil)
+
+	c.Set("user_id", uint(1))
+
+	note_mod_service := new(servicemocks.MockNoteModificationService)
+	note_read_service := new(servicemocks.MockNoteReaderService)
+	note_controller := NewNoteController(note_mod_service, note_read_service)
+
+	note_controller.GetNotes(c)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "invalid query")
+}
+
+func TestNoteControllerGetNotesInvalidOption(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request, _ = http.NewRequest("GET", "/notes?sort=size", nil)
+
+	c.Set("user_id", uint(1))
+
+	note_mod_service := new(servicemocks.MockNoteModificationService)
+	note_read_service := new(servicemocks.MockNoteReaderService)
+	note_controller := NewNoteController(note_mod_service, note_read_service)
+
+	req_ctx := c.Request.Context()
+	var notes services.GetNotesResult
+	e := services.ErrorInvalidListOption{Option: "sort", Value: "size", Err: errors.New("unsupported")}
+	note_read_service.On("GetNotes", req_ctx, uint(1), services.NoteListOptions{Sort: "size"}).Return(notes, &e)
+
+	note_controller.GetNotes(c)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "invalid value")
+}
+
+func TestNoteControllerSearchSuccess(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request, _ = http.NewRequest("GET", "/notes/search?q=bread&limit=5", nil)
+
+	c.Set("user_id", uint(1))
+
+	note_mod_service := new(servicemocks.MockNoteModificationService)
+	note_read_service := new(servicemocks.MockNoteReaderService)
+	note_controller := NewNoteController(note_mod_service, note_read_service)
+
+	req_ctx := c.Request.Context()
+	var result services.SearchNotesResult
+	result.Result = append(result.Result, services.NoteSearchResult{Id: 3, Title: "Bread recipe", Snippet: "<mark>Bread</mark>", Rank: 1.5})
+	note_read_service.On("SearchNotes", req_ctx, uint(1), services.NoteSearchOptions{Query: "bread", Limit: 5}).Return(result, nil)
+
+	note_controller.Search(c)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"Id":3`)
+	assert.Contains(t, w.Body.String(), `"Rank":1.5`)
+	note_read_service.AssertExpectations(t)
+}
+
+func TestNoteControllerSearchEmptyQuery(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request, _ = http.NewRequest("GET", "/notes/search", nil)
+
+	c.Set("user_id", uint(1))
+
+	note_mod_service := new(servicemocks.MockNoteModificationService)
+	note_read_service := new(servicemocks.MockNoteReaderService)
+	note_controller := NewNoteController(note_mod_service, note_read_service)
+
+	req_ctx := c.Request.Context()
+	e := services.ErrorInvalidListOption{Option: "q", Err: errors.New("search query must not be empty")}
+	note_read_service.On("SearchNotes", req_ctx, uint(1), services.NoteSearchOptions{}).Return(services.SearchNotesResult{}, &e)
+
+	note_controller.Search(c)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "must not be empty")
+}
+
+func TestNoteControllerMoveSuccess(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request, _ = http.NewRequest("PUT", "/notes/1/notebook", bytes.NewBuffer([]byte(`{"Notebook":3}`)))
+	c.Request.Header.Set("Content-Type", "application/json")
+	c.Request.Header.Set("If-Match", `"4"`)
+	c.Params = append(c.Params, gin.Param{Key: "id", Value: "1"})
+	c.Set("user_id", uint(2))
+
+	note_mod_service := new(servicemocks.MockNoteModificationService)
+	note_read_service := new(servicemocks.MockNoteReaderService)
+	note_controller := NewNoteController(note_mod_service, note_read_service)
+
+	req_ctx := c.Request.Context()
+	notebook_id := uint(3)
+	note_mod_service.On("MoveNote", req_ctx, uint(1), uint(2), uint(4), &notebook_id).Return(5, nil)
+
+	note_controller.Move(c)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, `"5"`, w.Header().Get("ETag"))
+	assert.Contains(t, w.Body.String(), `"id":1`)
+	note_mod_service.AssertExpectations(t)
+}
+
+func TestNoteControllerMoveNotebookWrongUser(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request, _ = http.NewRequest("PUT", "/notes/1/notebook", bytes.NewBuffer([]byte(`{"Notebook":3}`)))
+	c.Request.Header.Set("Content-Type", "application/json")
+	c.Request.Header.Set("If-Match", `"4"`)
+	c.Params = append(c.Params, gin.Param{Key: "id", Value: "1"})
+	c.Set("user_id", uint(2))
+
+	note_mod_service := new(servicemocks.MockNoteModificationService)
+	note_read_service := new(servicemocks.MockNoteReaderService)
+	note_controller := NewNoteController(note_mod_service, note_read_service)
+
+	req_ctx := c.Request.Context()
+	notebook_id := uint(3)
+	e := services.ErrorNotebookWrongOwner{NotebookId: 3, UserId: 2}
+	note_mod_service.On("MoveNote", req_ctx, uint(1), uint(2), uint(4), &notebook_id).Return(0, &e)
+
+	note_controller.Move(c)
+
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Contains(t, w.Body.String(), "does not own notebook")
+}
+
+func TestNoteControllerMoveVersion(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	note_mod_service := new(servicemocks.MockNoteModificationService)
+	note_read_service := new(servicemocks.MockNoteReaderService)
+	note_controller := NewNoteController(note_mod_service, note_read_service)
+
+	notebook_id := uint(3)
+	e := services.ErrorVersionMismatch{NoteId: 1, Expected: 4, Version: 5}
+	note_mod_service.On("MoveNote", mock.Anything, uint(1), uint(2), uint(4), &notebook_id).Return(0, &e)
+
+	for if_match, status := range map[string]int{"": http.StatusPreconditionRequired, `"4"`: http.StatusPreconditionFailed} {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request, _ = http.NewRequest("PUT", "/notes/1/notebook", bytes.NewBuffer([]byte(`{"Notebook":3}`)))
+		c.Request.Header.Set("Content-Type", "application/json")
+		if if_match != "" {
+			c.Request.Header.Set("If-Match", if_match)
+		}
+		c.Params = append(c.Params, gin.Param{Key: "id", Value: "1"})
+		c.Set("user_id", uint(2))
+
+		note_controller.Move(c)
+
+		assert.Equal(t, status, w.Code, if_match)
+	}
+	note_mod_service.AssertNumberOfCalls(t, "MoveNote", 1)
+}
@@ -0,0 +1,155 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"user-notes-api/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+type NoteRevisionController struct {
+	RevisionService services.NoteRevisionServiceIfc
+}
+
+// NoteRevisionDiffQuery are the query parameters of the diff endpoint.
+type NoteRevisionDiffQuery struct {
+	From uint `form:"from" binding:"required"`
+	To   uint `form:"to" binding:"required"`
+}
+
+func NewNoteRevisionController(revision_service services.NoteRevisionServiceIfc) *NoteRevisionController {
+	controller := NoteRevisionController{RevisionService: revision_service}
+	return &controller
+}
+
+// revisionFromParam parses the rev path parameter and writes an error response if it is malformed.
+func revisionFromParam(c *gin.Context) (uint, bool) {
+	revision, err := strconv.ParseUint(c.Param("rev"), 10, 0)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "malformed revision"})
+		return 0, false
+	}
+	return uint(revision), true
+}
+
+func (n *NoteRevisionController) GetRevisions(c *gin.Context) {
+	request_ctx := c.Request.Context()
+	note_id, ok := idFromParam(c)
+	if !ok {
+		return
+	}
+
+	user_id, ok := userIdFromContext(c)
+	if !ok {
+		return
+	}
+
+	result, err := n.RevisionService.GetRevisions(request_ctx, note_id, user_id)
+	if err != nil {
+		writeNoteRevisionError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, result)
+}
+
+func (n *NoteRevisionController) GetRevision(c *gin.Context) {
+	request_ctx := c.Request.Context()
+	note_id, ok := idFromParam(c)
+	if !ok {
+		return
+	}
+
+	revision, ok := revisionFromParam(c)
+	if !ok {
+		return
+	}
+
+	user_id, ok := userIdFromContext(c)
+	if !ok {
+		return
+	}
+
+	result, err := n.RevisionService.GetRevision(request_ctx, note_id, revision, user_id)
+	if err != nil {
+		writeNoteRevisionError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, result)
+}
+
+func (n *NoteRevisionController) Diff(c *gin.Context) {
+	request_ctx := c.Request.Context()
+	note_id, ok := idFromParam(c)
+	if !ok {
+		return
+	}
+
+	var query NoteRevisionDiffQuery
+	err := c.ShouldBindQuery(&query)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid query: " + err.Error()})
+		return
+	}
+
+	user_id, ok := userIdFromContext(c)
+	if !ok {
+		return
+	}
+
+	result, err := n.RevisionService.DiffRevisions(request_ctx, note_id, query.From, query.To, user_id)
+	if err != nil {
+		writeNoteRevisionError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, result)
+}
+
+func (n *NoteRevisionController) Restore(c *gin.Context) {
+	request_ctx := c.Request.Context()
+	note_id, ok := idFromParam(c)
+	if !ok {
+		return
+	}
+
+	revision, ok := revisionFromParam(c)
+	if !ok {
+		return
+	}
+
+	user_id, ok := userIdFromContext(c)
+	if !ok {
+		return
+	}
+
+	version, ok := versionFromIfMatch(c)
+	if !ok {
+		return
+	}
+
+	new_revision, err := n.RevisionService.RestoreRevision(request_ctx, note_id, revision, user_id, version)
+	if err != nil {
+		writeNoteRevisionError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"id": note_id, "revision": new_revision})
+}
+
+func writeNoteRevisionError(c *gin.Context, err error) {
+	var wrongOwnerError *services.ErrorWrongOwner
+	var notFoundError *services.ErrorNoteNotFound
+	var revisionNotFoundError *services.ErrorRevisionNotFound
+	var versionMismatchError *services.ErrorVersionMismatch
+
+	if errors.As(err, &versionMismatchError) {
+		writeVersionMismatch(c, versionMismatchError)
+	} else if errors.As(err, &wrongOwnerError) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+	} else if errors.As(err, &notFoundError) || errors.As(err, &revisionNotFoundError) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	} else {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
@@ -0,0 +1,190 @@
+package controllers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+	"user-notes-api/services"
+	"user-notes-api/testing/testutils/servicemocks"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestNoteRevisionControllerGetRevisionsSuccess(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request, _ = http.NewRequest("GET", "/notes/1/revisions", nil)
+	c.Params = append(c.Params, gin.Param{Key: "id", Value: "1"})
+	c.Set("user_id", uint(2))
+
+	revision_service := new(servicemocks.MockNoteRevisionService)
+	revision_controller := NewNoteRevisionController(revision_service)
+
+	req_ctx := c.Request.Context()
+	created_at := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	result := services.GetNoteRevisionsResult{Result: []services.NoteRevisionListResult{{Revision: 1, Title: "Title", AuthorId: 2, CreatedAt: created_at}}}
+	revision_service.On("GetRevisions", req_ctx, uint(1), uint(2)).Return(result, nil)
+
+	revision_controller.GetRevisions(c)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"Result":[{"Revision":1,"Title":"Title","AuthorId":2,"CreatedAt":"2025-01-01T12:00:00Z"}]}`, w.Body.String())
+}
+
+func TestNoteRevisionControllerGetRevisionNotFound(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request, _ = http.NewRequest("GET", "/notes/1/revisions/7", nil)
+	c.Params = append(c.Params, gin.Param{Key: "id", Value: "1"}, gin.Param{Key: "rev", Value: "7"})
+	c.Set("user_id", uint(2))
+
+	revision_service := new(servicemocks.MockNoteRevisionService)
+	revision_controller := NewNoteRevisionController(revision_service)
+
+	req_ctx := c.Request.Context()
+	e := services.ErrorRevisionNotFound{NoteId: 1, Revision: 7}
+	revision_service.On("GetRevision", req_ctx, uint(1), uint(7), uint(2)).Return(services.NoteRevision{}, &e)
+
+	revision_controller.GetRevision(c)
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Contains(t, w.Body.String(), "revision 7 of note with id 1 not found")
+}
+
+func TestNoteRevisionControllerGetRevisionMalformed(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request, _ = http.NewRequest("GET", "/notes/1/revisions/latest", nil)
+	c.Params = append(c.Params, gin.Param{Key: "id", Value: "1"}, gin.Param{Key: "rev", Value: "latest"})
+	c.Set("user_id", uint(2))
+
+	revision_service := new(servicemocks.MockNoteRevisionService)
+	revision_controller := NewNoteRevisionController(revision_service)
+
+	revision_controller.GetRevision(c)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "malformed revision")
+}
+
+func TestNoteRevisionControllerDiffSuccess(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request, _ = http.NewRequest("GET", "/notes/1/revisions/diff?from=1&to=2", nil)
+	c.Params = append(c.Params, gin.Param{Key: "id", Value: "1"})
+	c.Set("user_id", uint(2))
+
+	revision_service := new(servicemocks.MockNoteRevisionService)
+	revision_controller := NewNoteRevisionController(revision_service)
+
+	req_ctx := c.Request.Context()
+	diff := services.NoteRevisionDiff{From: 1, To: 2, Diff: "--- revision 1\n+++ revision 2\n"}
+	revision_service.On("DiffRevisions", req_ctx, uint(1), uint(1), uint(2), uint(2)).Return(diff, nil)
+
+	revision_controller.Diff(c)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"From":1`)
+}
+
+func TestNoteRevisionControllerDiffMissingRevision(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request, _ = http.NewRequest("GET", "/notes/1/revisions/diff?from=1", nil)
+	c.Params = append(c.Params, gin.Param{Key: "id", Value: "1"})
+	c.Set("user_id", uint(2))
+
+	revision_service := new(servicemocks.MockNoteRevisionService)
+	revision_controller := NewNoteRevisionController(revision_service)
+
+	revision_controller.Diff(c)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "invalid query")
+}
+
+func TestNoteRevisionControllerRestoreWrongUser(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request, _ = http.NewRequest("POST", "/notes/1/revisions/1/restore", nil)
+	c.Request.Header.Set("If-Match", `"4"`)
+	c.Params = append(c.Params, gin.Param{Key: "id", Value: "1"}, gin.Param{Key: "rev", Value: "1"})
+	c.Set("user_id", uint(2))
+
+	revision_service := new(servicemocks.MockNoteRevisionService)
+	revision_controller := NewNoteRevisionController(revision_service)
+
+	req_ctx := c.Request.Context()
+	e := services.ErrorWrongOwner{NoteId: 1, UserId: 2}
+	revision_service.On("RestoreRevision", req_ctx, uint(1), uint(1), uint(2), uint(4)).Return(0, &e)
+
+	revision_controller.Restore(c)
+
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
+func TestNoteRevisionControllerRestoreSuccess(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request, _ = http.NewRequest("POST", "/notes/1/revisions/1/restore", nil)
+	c.Request.Header.Set("If-Match", `"4"`)
+	c.Params = append(c.Params, gin.Param{Key: "id", Value: "1"}, gin.Param{Key: "rev", Value: "1"})
+	c.Set("user_id", uint(2))
+
+	revision_service := new(servicemocks.MockNoteRevisionService)
+	revision_controller := NewNoteRevisionController(revision_service)
+
+	req_ctx := c.Request.Context()
+	revision_service.On("RestoreRevision", req_ctx, uint(1), uint(1), uint(2), uint(4)).Return(3, nil)
+
+	revision_controller.Restore(c)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"id":1,"revision":3}`, w.Body.String())
+}
+
+func TestNoteRevisionControllerRestoreVersion(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	revision_service := new(servicemocks.MockNoteRevisionService)
+	revision_controller := NewNoteRevisionController(revision_service)
+
+	e := services.ErrorVersionMismatch{NoteId: 1, Expected: 4, Version: 5}
+	revision_service.On("RestoreRevision", mock.Anything, uint(1), uint(1), uint(2), uint(4)).Return(0, &e)
+
+	for if_match, status := range map[string]int{"": http.StatusPreconditionRequired, `"4"`: http.StatusPreconditionFailed} {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request, _ = http.NewRequest("POST", "/notes/1/revisions/1/restore", nil)
+		if if_match != "" {
+			c.Request.Header.Set("If-Match", if_match)
+		}
+		c.Params = append(c.Params, gin.Param{Key: "id", Value: "1"}, gin.Param{Key: "rev", Value: "1"})
+		c.Set("user_id", uint(2))
+
+		revision_controller.Restore(c)
+
+		assert.Equal(t, status, w.Code, if_match)
+		if status == http.StatusPreconditionFailed {
+			assert.Equal(t, `"5"`, w.Header().Get("ETag"))
+		}
+	}
+	revision_service.AssertNumberOfCalls(t, "RestoreRevision", 1)
+}
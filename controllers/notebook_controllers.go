@@ -0,0 +1,140 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+
+	"user-notes-api/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+type NotebookController struct {
+	NotebookService services.NotebookServiceIfc
+}
+
+func NewNotebookController(notebook_service services.NotebookServiceIfc) *NotebookController {
+	controller := NotebookController{NotebookService: notebook_service}
+	return &controller
+}
+
+func (n *NotebookController) Create(c *gin.Context) {
+	request_ctx := c.Request.Context()
+	var notebook services.Notebook
+	err := c.Bind(&notebook)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid input" + err.Error()})
+		return
+	}
+
+	user_id, ok := userIdFromContext(c)
+	if !ok {
+		return
+	}
+
+	id, err := n.NotebookService.CreateNotebook(request_ctx, notebook, user_id)
+	if err != nil {
+		writeNotebookError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"id": id})
+}
+
+func (n *NotebookController) GetNotebooks(c *gin.Context) {
+	request_ctx := c.Request.Context()
+	user_id, ok := userIdFromContext(c)
+	if !ok {
+		return
+	}
+
+	result, err := n.NotebookService.GetNotebooks(request_ctx, user_id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, result)
+}
+
+func (n *NotebookController) GetContents(c *gin.Context) {
+	request_ctx := c.Request.Context()
+	notebook_id, ok := idFromParam(c)
+	if !ok {
+		return
+	}
+
+	user_id, ok := userIdFromContext(c)
+	if !ok {
+		return
+	}
+
+	contents, err := n.NotebookService.GetNotebookContents(request_ctx, notebook_id, user_id)
+	if err != nil {
+		writeNotebookError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, contents)
+}
+
+func (n *NotebookController) Update(c *gin.Context) {
+	request_ctx := c.Request.Context()
+	notebook_id, ok := idFromParam(c)
+	if !ok {
+		return
+	}
+
+	var notebook services.Notebook
+	err := c.Bind(&notebook)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid input" + err.Error()})
+		return
+	}
+
+	user_id, ok := userIdFromContext(c)
+	if !ok {
+		return
+	}
+
+	err = n.NotebookService.UpdateNotebook(request_ctx, notebook_id, user_id, notebook)
+	if err != nil {
+		writeNotebookError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"id": notebook_id})
+}
+
+func (n *NotebookController) Delete(c *gin.Context) {
+	request_ctx := c.Request.Context()
+	notebook_id, ok := idFromParam(c)
+	if !ok {
+		return
+	}
+
+	user_id, ok := userIdFromContext(c)
+	if !ok {
+		return
+	}
+
+	err := n.NotebookService.DeleteNotebook(request_ctx, notebook_id, user_id, c.Query("mode"))
+	if err != nil {
+		writeNotebookError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"id": notebook_id})
+}
+
+func writeNotebookError(c *gin.Context, err error) {
+	var wrongOwnerError *services.ErrorNotebookWrongOwner
+	var notFoundError *services.ErrorNotebookNotFound
+	var invalidNotebookError *services.ErrorInvalidNotebook
+	var invalidOptionError *services.ErrorInvalidListOption
+
+	if errors.As(err, &wrongOwnerError) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+	} else if errors.As(err, &notFoundError) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	} else if errors.As(err, &invalidNotebookError) || errors.As(err, &invalidOptionError) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	} else {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
@@ -0,0 +1,168 @@
+package controllers
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"user-notes-api/services"
+	"user-notes-api/testing/testutils/servicemocks"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNotebookControllerCreateSuccess(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request, _ = http.NewRequest("POST", "/notebooks", bytes.NewBuffer([]byte(`{"Name":"projects","Parent":1}`)))
+	c.Request.Header.Set("Content-Type", "application/json")
+	c.Set("user_id", uint(2))
+
+	notebook_service := new(servicemocks.MockNotebookService)
+	notebook_controller := NewNotebookController(notebook_service)
+
+	req_ctx := c.Request.Context()
+	parent_id := uint(1)
+	notebook_service.On("CreateNotebook", req_ctx, services.Notebook{Name: "projects", Parent: &parent_id}, uint(2)).Return(3, nil)
+
+	notebook_controller.Create(c)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"id":3`)
+}
+
+func TestNotebookControllerCreateInvalidName(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request, _ = http.NewRequest("POST", "/notebooks", bytes.NewBuffer([]byte(`{"Name":""}`)))
+	c.Request.Header.Set("Content-Type", "application/json")
+	c.Set("user_id", uint(2))
+
+	notebook_service := new(servicemocks.MockNotebookService)
+	notebook_controller := NewNotebookController(notebook_service)
+
+	req_ctx := c.Request.Context()
+	e := services.ErrorInvalidNotebook{Name: "", Reason: "name must not be empty"}
+	notebook_service.On("CreateNotebook", req_ctx, services.Notebook{}, uint(2)).Return(0, &e)
+
+	notebook_controller.Create(c)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "name must not be empty")
+}
+
+func TestNotebookControllerGetContentsSuccess(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request, _ = http.NewRequest("GET", "/notebooks/1", nil)
+	c.Params = append(c.Params, gin.Param{Key: "id", Value: "1"})
+	c.Set("user_id", uint(2))
+
+	notebook_service := new(servicemocks.MockNotebookService)
+	notebook_controller := NewNotebookController(notebook_service)
+
+	req_ctx := c.Request.Context()
+	contents := services.NotebookContents{Id: 1, Name: "work", Notes: []services.NotebookNote{{Id: 10, Title: "Plan"}},
+		Notebooks: []services.NotebookContents{{Id: 2, Name: "projects", Notes: []services.NotebookNote{}, Notebooks: []services.NotebookContents{}}}}
+	notebook_service.On("GetNotebookContents", req_ctx, uint(1), uint(2)).Return(contents, nil)
+
+	notebook_controller.GetContents(c)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"Id":1,"Name":"work","Notes":[{"Id":10,"Title":"Plan"}],
+		"Notebooks":[{"Id":2,"Name":"projects","Notes":[],"Notebooks":[]}]}`, w.Body.String())
+}
+
+func TestNotebookControllerGetContentsWrongUser(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request, _ = http.NewRequest("GET", "/notebooks/1", nil)
+	c.Params = append(c.Params, gin.Param{Key: "id", Value: "1"})
+	c.Set("user_id", uint(2))
+
+	notebook_service := new(servicemocks.MockNotebookService)
+	notebook_controller := NewNotebookController(notebook_service)
+
+	req_ctx := c.Request.Context()
+	e := services.ErrorNotebookWrongOwner{NotebookId: 1, UserId: 2}
+	notebook_service.On("GetNotebookContents", req_ctx, uint(1), uint(2)).Return(services.NotebookContents{}, &e)
+
+	notebook_controller.GetContents(c)
+
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Contains(t, w.Body.String(), "does not own notebook")
+}
+
+func TestNotebookControllerUpdateNotFound(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request, _ = http.NewRequest("PUT", "/notebooks/1", bytes.NewBuffer([]byte(`{"Name":"work"}`)))
+	c.Request.Header.Set("Content-Type", "application/json")
+	c.Params = append(c.Params, gin.Param{Key: "id", Value: "1"})
+	c.Set("user_id", uint(2))
+
+	notebook_service := new(servicemocks.MockNotebookService)
+	notebook_controller := NewNotebookController(notebook_service)
+
+	req_ctx := c.Request.Context()
+	e := services.ErrorNotebookNotFound{NotebookId: 1}
+	notebook_service.On("UpdateNotebook", req_ctx, uint(1), uint(2), services.Notebook{Name: "work"}).Return(&e)
+
+	notebook_controller.Update(c)
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestNotebookControllerDeleteWithMode(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request, _ = http.NewRequest("DELETE", "/notebooks/1?mode=delete", nil)
+	c.Params = append(c.Params, gin.Param{Key: "id", Value: "1"})
+	c.Set("user_id", uint(2))
+
+	notebook_service := new(servicemocks.MockNotebookService)
+	notebook_controller := NewNotebookController(notebook_service)
+
+	req_ctx := c.Request.Context()
+	notebook_service.On("DeleteNotebook", req_ctx, uint(1), uint(2), "delete").Return(nil)
+
+	notebook_controller.Delete(c)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"id":1`)
+	notebook_service.AssertExpectations(t)
+}
+
+func TestNotebookControllerDeleteInvalidMode(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request, _ = http.NewRequest("DELETE", "/notebooks/1?mode=archive", nil)
+	c.Params = append(c.Params, gin.Param{Key: "id", Value: "1"})
+	c.Set("user_id", uint(2))
+
+	notebook_service := new(servicemocks.MockNotebookService)
+	notebook_controller := NewNotebookController(notebook_service)
+
+	req_ctx := c.Request.Context()
+	e := services.ErrorInvalidListOption{Option: "mode", Value: "archive"}
+	notebook_service.On("DeleteNotebook", req_ctx, uint(1), uint(2), "archive").Return(&e)
+
+	notebook_controller.Delete(c)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
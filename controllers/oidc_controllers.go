@@ -0,0 +1,94 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"user-notes-api/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// oidcStateCookie keeps the state token of a login with an OpenID Connect provider in the browser until
+// the callback, so the callback can only complete a login started in the same browser.
+const oidcStateCookie = "oidc_state"
+
+type OidcController struct {
+	OidcService services.OidcServiceIfc
+}
+
+func NewOidcController(oidc_service services.OidcServiceIfc) *OidcController {
+	controller := OidcController{OidcService: oidc_service}
+	return &controller
+}
+
+// Start redirects the browser to the provider for logging in and sets the state cookie, which is only sent
+// to the callback of the provider.
+func (o *OidcController) Start(c *gin.Context) {
+	provider := c.Param("provider")
+
+	request_ctx := c.Request.Context()
+	login, err := o.OidcService.StartLogin(request_ctx, provider)
+	if err != nil {
+		writeOidcError(c, err)
+		return
+	}
+
+	// Lax, since the provider redirects back with a top-level navigation from another site
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(oidcStateCookie, login.StateToken, int(time.Until(login.ExpiresAt).Seconds()),
+		oidcCallbackPath(provider), "", true, true)
+	c.Redirect(http.StatusFound, login.AuthorizationUrl)
+}
+
+// Callback completes the login the provider redirected back from and responds like Login: with the
+// tokens, or with the challenge for the second factor of users with two-factor authentication.
+func (o *OidcController) Callback(c *gin.Context) {
+	provider := c.Param("provider")
+
+	var callback services.OidcCallback
+	err := c.ShouldBindQuery(&callback)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid input"})
+		return
+	}
+
+	// the state token can only be used once, whatever the outcome
+	state_token, _ := c.Cookie(oidcStateCookie)
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(oidcStateCookie, "", -1, oidcCallbackPath(provider), "", true, true)
+
+	request_ctx := c.Request.Context()
+	result, err := o.OidcService.FinishLogin(request_ctx, provider, callback, state_token)
+	if err != nil {
+		writeOidcError(c, err)
+		return
+	}
+
+	if result.Mfa != nil {
+		c.JSON(http.StatusOK, result.Mfa)
+		return
+	}
+	c.JSON(http.StatusOK, result.AuthTokens)
+}
+
+func oidcCallbackPath(provider string) string {
+	return "/auth/oidc/" + provider + "/callback"
+}
+
+func writeOidcError(c *gin.Context, err error) {
+	var unknownProviderError *services.ErrorUnknownOidcProvider
+	var loginError *services.ErrorOidcLogin
+	var providerError *services.ErrorOidcProvider
+
+	if errors.As(err, &unknownProviderError) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "unknown provider"})
+	} else if errors.As(err, &loginError) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "login failed: " + loginError.Reason})
+	} else if errors.As(err, &providerError) {
+		c.JSON(http.StatusBadGateway, gin.H{"error": "the provider is not available"})
+	} else {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
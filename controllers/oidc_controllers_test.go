@@ -0,0 +1,111 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"user-notes-api/services"
+	"user-notes-api/testing/testutils/servicemocks"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOidcControllerStart(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request, _ = http.NewRequest("GET", "/auth/oidc/corp/start", nil)
+	c.Params = gin.Params{{Key: "provider", Value: "corp"}}
+
+	oidc_service := new(servicemocks.MockOidcService)
+	oidc_controller := NewOidcController(oidc_service)
+
+	oidc_service.On("StartLogin", c.Request.Context(), "corp").Return(services.OidcLogin{
+		AuthorizationUrl: "https://idp.example.com/authorize?state=abc", StateToken: "state_token",
+		ExpiresAt: time.Now().Add(10 * time.Minute)}, nil)
+
+	oidc_controller.Start(c)
+
+	assert.Equal(t, http.StatusFound, w.Code)
+	assert.Equal(t, "https://idp.example.com/authorize?state=abc", w.Header().Get("Location"))
+	cookies := w.Result().Cookies()
+	assert.Len(t, cookies, 1)
+	assert.Equal(t, "oidc_state", cookies[0].Name)
+	assert.Equal(t, "state_token", cookies[0].Value)
+	assert.Equal(t, "/auth/oidc/corp/callback", cookies[0].Path)
+	assert.InDelta(t, 600, cookies[0].MaxAge, 1)
+	assert.True(t, cookies[0].HttpOnly)
+	assert.True(t, cookies[0].Secure)
+	assert.Equal(t, http.SameSiteLaxMode, cookies[0].SameSite)
+}
+
+func TestOidcControllerStartUnknownProvider(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request, _ = http.NewRequest("GET", "/auth/oidc/other/start", nil)
+	c.Params = gin.Params{{Key: "provider", Value: "other"}}
+
+	oidc_service := new(servicemocks.MockOidcService)
+	oidc_controller := NewOidcController(oidc_service)
+
+	oidc_service.On("StartLogin", c.Request.Context(), "other").Return(services.OidcLogin{},
+		&services.ErrorUnknownOidcProvider{Provider: "other"})
+
+	oidc_controller.Start(c)
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.JSONEq(t, `{"error": "unknown provider"}`, w.Body.String())
+	assert.Empty(t, w.Result().Cookies())
+}
+
+func TestOidcControllerCallback(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	oidc_service := new(servicemocks.MockOidcService)
+	oidc_controller := NewOidcController(oidc_service)
+
+	expires_at := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+	for code, expected := range map[string]struct {
+		result services.LoginResult
+		err    error
+		status int
+		body   string
+	}{
+		"tokens": {services.LoginResult{AuthTokens: services.AuthTokens{Token: "jwt", RefreshToken: "refresh"}}, nil,
+			http.StatusOK, `{"token": "jwt", "refresh_token": "refresh"}`},
+		"mfa": {services.LoginResult{Mfa: &services.MfaChallenge{MfaRequired: true, MfaToken: "mfa_token", ExpiresAt: expires_at}}, nil,
+			http.StatusOK, `{"mfa_required": true, "mfa_token": "mfa_token", "expires_at": "2030-01-01T00:00:00Z"}`},
+		"invalid": {services.LoginResult{}, &services.ErrorOidcLogin{Reason: "the ID token is invalid"},
+			http.StatusUnauthorized, `{"error": "login failed: the ID token is invalid"}`},
+		"unavailable": {services.LoginResult{}, &services.ErrorOidcProvider{Provider: "corp", Err: errors.New("connection refused")},
+			http.StatusBadGateway, `{"error": "the provider is not available"}`},
+	} {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request, _ = http.NewRequest("GET", "/auth/oidc/corp/callback?code="+code+"&state=abc", nil)
+		c.Request.AddCookie(&http.Cookie{Name: "oidc_state", Value: "state_token"})
+		c.Params = gin.Params{{Key: "provider", Value: "corp"}}
+
+		oidc_service.On("FinishLogin", c.Request.Context(), "corp", services.OidcCallback{Code: code, State: "abc"}, "state_token").
+			Return(expected.result, expected.err)
+
+		oidc_controller.Callback(c)
+
+		assert.Equal(t, expected.status, w.Code, code)
+		assert.JSONEq(t, expected.body, w.Body.String(), code)
+
+		// the state cookie is deleted whatever the outcome
+		cookies := w.Result().Cookies()
+		assert.Len(t, cookies, 1)
+		assert.Equal(t, "oidc_state", cookies[0].Name)
+		assert.Equal(t, "/auth/oidc/corp/callback", cookies[0].Path)
+		assert.Negative(t, cookies[0].MaxAge)
+	}
+}
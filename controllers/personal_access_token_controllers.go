@@ -0,0 +1,90 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+
+	"user-notes-api/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+type PersonalAccessTokenController struct {
+	TokenService services.PersonalAccessTokenServiceIfc
+}
+
+func NewPersonalAccessTokenController(token_service services.PersonalAccessTokenServiceIfc) *PersonalAccessTokenController {
+	controller := PersonalAccessTokenController{TokenService: token_service}
+	return &controller
+}
+
+// Create responds with the new token, which is the only time it is shown.
+func (p *PersonalAccessTokenController) Create(c *gin.Context) {
+	var creation services.PersonalAccessTokenCreation
+	err := c.ShouldBindJSON(&creation)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid input"})
+		return
+	}
+
+	user_id, ok := userIdFromContext(c)
+	if !ok {
+		return
+	}
+
+	request_ctx := c.Request.Context()
+	token, err := p.TokenService.CreateToken(request_ctx, user_id, creation)
+	if err != nil {
+		writePersonalAccessTokenError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, token)
+}
+
+func (p *PersonalAccessTokenController) GetTokens(c *gin.Context) {
+	user_id, ok := userIdFromContext(c)
+	if !ok {
+		return
+	}
+
+	request_ctx := c.Request.Context()
+	tokens, err := p.TokenService.GetTokens(request_ctx, user_id)
+	if err != nil {
+		writePersonalAccessTokenError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, tokens)
+}
+
+func (p *PersonalAccessTokenController) Delete(c *gin.Context) {
+	token_id, ok := idFromParam(c)
+	if !ok {
+		return
+	}
+
+	user_id, ok := userIdFromContext(c)
+	if !ok {
+		return
+	}
+
+	request_ctx := c.Request.Context()
+	err := p.TokenService.DeleteToken(request_ctx, user_id, token_id)
+	if err != nil {
+		writePersonalAccessTokenError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"id": token_id})
+}
+
+func writePersonalAccessTokenError(c *gin.Context, err error) {
+	var invalidCreationError *services.ErrorInvalidTokenCreation
+	var notFoundError *services.ErrorPersonalAccessTokenNotFound
+
+	if errors.As(err, &invalidCreationError) {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+	} else if errors.As(err, &notFoundError) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	} else {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
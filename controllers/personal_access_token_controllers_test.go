@@ -0,0 +1,114 @@
+package controllers
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"user-notes-api/services"
+	"user-notes-api/testing/testutils/servicemocks"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+func TestPersonalAccessTokenControllerCreate(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request, _ = http.NewRequest("POST", "/me/tokens", bytes.NewBuffer([]byte(`{"name": "backup", "scopes": ["notes:read"]}`)))
+	c.Request.Header.Set("Content-Type", "application/json")
+	c.Set("user_id", uint(1))
+
+	token_service := new(servicemocks.MockPersonalAccessTokenService)
+	token_controller := NewPersonalAccessTokenController(token_service)
+
+	created_at := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	creation := services.PersonalAccessTokenCreation{Name: "backup", Scopes: []string{"notes:read"}}
+	token_service.On("CreateToken", c.Request.Context(), uint(1), creation).Return(services.CreatedPersonalAccessToken{
+		PersonalAccessTokenResult: services.PersonalAccessTokenResult{Id: 7, Name: "backup", Scopes: []string{"notes:read"}, CreatedAt: created_at},
+		Token:                     "unp_secret"}, nil)
+
+	token_controller.Create(c)
+
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.JSONEq(t, `{"id": 7, "name": "backup", "scopes": ["notes:read"], "expires_at": null, "last_used_at": null,
+		"created_at": "2026-01-02T03:04:05Z", "token": "unp_secret"}`, w.Body.String())
+}
+
+func TestPersonalAccessTokenControllerCreateInvalid(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	token_service := new(servicemocks.MockPersonalAccessTokenService)
+	token_controller := NewPersonalAccessTokenController(token_service)
+
+	for body, code := range map[string]int{
+		`{"name": "backup"}`:                            http.StatusBadRequest,
+		`{"name": "backup", "scopes": ["notes:admin"]}`: http.StatusUnprocessableEntity,
+	} {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request, _ = http.NewRequest("POST", "/me/tokens", bytes.NewBuffer([]byte(body)))
+		c.Request.Header.Set("Content-Type", "application/json")
+		c.Set("user_id", uint(1))
+
+		creation := services.PersonalAccessTokenCreation{Name: "backup", Scopes: []string{"notes:admin"}}
+		token_service.On("CreateToken", c.Request.Context(), uint(1), creation).Return(services.CreatedPersonalAccessToken{},
+			&services.ErrorInvalidTokenCreation{Reason: "unknown scope"})
+
+		token_controller.Create(c)
+
+		assert.Equal(t, code, w.Code, body)
+	}
+}
+
+func TestPersonalAccessTokenControllerGetTokens(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request, _ = http.NewRequest("GET", "/me/tokens", nil)
+	c.Set("user_id", uint(1))
+
+	token_service := new(servicemocks.MockPersonalAccessTokenService)
+	token_controller := NewPersonalAccessTokenController(token_service)
+
+	created_at := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	token_service.On("GetTokens", c.Request.Context(), uint(1)).Return(services.GetPersonalAccessTokensResult{
+		Result: []services.PersonalAccessTokenResult{{Id: 7, Name: "backup", Scopes: []string{"notes:read"}, CreatedAt: created_at,
+			LastUsedAt: &created_at}}}, nil)
+
+	token_controller.GetTokens(c)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"Result": [{"id": 7, "name": "backup", "scopes": ["notes:read"], "expires_at": null,
+		"last_used_at": "2026-01-02T03:04:05Z", "created_at": "2026-01-02T03:04:05Z"}]}`, w.Body.String())
+	assert.NotContains(t, w.Body.String(), "token\"")
+}
+
+func TestPersonalAccessTokenControllerDelete(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	token_service := new(servicemocks.MockPersonalAccessTokenService)
+	token_controller := NewPersonalAccessTokenController(token_service)
+
+	for id, code := range map[string]int{"7": http.StatusOK, "8": http.StatusNotFound, "x": http.StatusBadRequest} {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request, _ = http.NewRequest("DELETE", "/me/tokens/"+id, nil)
+		c.Params = gin.Params{{Key: "id", Value: id}}
+		c.Set("user_id", uint(1))
+
+		token_service.On("DeleteToken", c.Request.Context(), uint(1), uint(7)).Return(nil)
+		token_service.On("DeleteToken", c.Request.Context(), uint(1), uint(8)).Return(
+			&services.ErrorPersonalAccessTokenNotFound{TokenId: 8, Err: gorm.ErrRecordNotFound})
+
+		token_controller.Delete(c)
+
+		assert.Equal(t, code, w.Code, id)
+	}
+}
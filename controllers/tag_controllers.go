@@ -0,0 +1,154 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"user-notes-api/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+type TagController struct {
+	TagService services.TagServiceIfc
+}
+
+func NewTagController(tag_service services.TagServiceIfc) *TagController {
+	controller := TagController{TagService: tag_service}
+	return &controller
+}
+
+// userIdFromContext reads the user id set by the JWT middleware and writes an error response if it is missing.
+func userIdFromContext(c *gin.Context) (uint, bool) {
+	uid, ok := c.Get("user_id")
+	if !ok {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to parse user id from context"})
+		return 0, false
+	}
+
+	user_id, ok := uid.(uint)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "malformed user id"})
+		return 0, false
+	}
+	return user_id, true
+}
+
+// idFromParam parses the id path parameter and writes an error response if it is malformed.
+func idFromParam(c *gin.Context) (uint, bool) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 0)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "malformed id"})
+		return 0, false
+	}
+	return uint(id), true
+}
+
+func (t *TagController) GetTags(c *gin.Context) {
+	request_ctx := c.Request.Context()
+	user_id, ok := userIdFromContext(c)
+	if !ok {
+		return
+	}
+
+	result, err := t.TagService.GetTags(request_ctx, user_id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, result)
+}
+
+func (t *TagController) Rename(c *gin.Context) {
+	request_ctx := c.Request.Context()
+	tag_id, ok := idFromParam(c)
+	if !ok {
+		return
+	}
+
+	var rename services.TagRename
+	err := c.Bind(&rename)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid input" + err.Error()})
+		return
+	}
+
+	user_id, ok := userIdFromContext(c)
+	if !ok {
+		return
+	}
+
+	err = t.TagService.RenameTag(request_ctx, tag_id, user_id, rename.Name)
+	if err != nil {
+		writeTagError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"id": tag_id})
+}
+
+func (t *TagController) Merge(c *gin.Context) {
+	request_ctx := c.Request.Context()
+	tag_id, ok := idFromParam(c)
+	if !ok {
+		return
+	}
+
+	var merge services.TagMerge
+	err := c.Bind(&merge)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid input" + err.Error()})
+		return
+	}
+
+	user_id, ok := userIdFromContext(c)
+	if !ok {
+		return
+	}
+
+	err = t.TagService.MergeTags(request_ctx, tag_id, merge.Target, user_id)
+	if err != nil {
+		writeTagError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"id": merge.Target})
+}
+
+func (t *TagController) Delete(c *gin.Context) {
+	request_ctx := c.Request.Context()
+	tag_id, ok := idFromParam(c)
+	if !ok {
+		return
+	}
+
+	user_id, ok := userIdFromContext(c)
+	if !ok {
+		return
+	}
+
+	err := t.TagService.DeleteTag(request_ctx, tag_id, user_id)
+	if err != nil {
+		writeTagError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"id": tag_id})
+}
+
+func writeTagError(c *gin.Context, err error) {
+	var wrongOwnerError *services.ErrorTagWrongOwner
+	var notFoundError *services.ErrorTagNotFound
+	var existsError *services.ErrorTagExists
+	var invalidTagError *services.ErrorInvalidTag
+
+	if errors.As(err, &wrongOwnerError) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+	} else if errors.As(err, &notFoundError) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	} else if errors.As(err, &existsError) {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	} else if errors.As(err, &invalidTagError) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	} else {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
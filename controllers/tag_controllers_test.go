@@ -0,0 +1,159 @@
+package controllers
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"user-notes-api/services"
+	"user-notes-api/testing/testutils/servicemocks"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTagControllerGetTagsSuccess(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request, _ = http.NewRequest("GET", "/tags", nil)
+	c.Set("user_id", uint(2))
+
+	tag_service := new(servicemocks.MockTagService)
+	tag_controller := NewTagController(tag_service)
+
+	req_ctx := c.Request.Context()
+	result := services.GetTagsResult{Result: []services.TagResult{{Id: 1, Name: "work", NoteCount: 3}}}
+	tag_service.On("GetTags", req_ctx, uint(2)).Return(result, nil)
+
+	tag_controller.GetTags(c)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"Result":[{"Id":1,"Name":"work","NoteCount":3}]}`, w.Body.String())
+}
+
+func TestTagControllerGetTagsServiceError(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request, _ = http.NewRequest("GET", "/tags", nil)
+	c.Set("user_id", uint(2))
+
+	tag_service := new(servicemocks.MockTagService)
+	tag_controller := NewTagController(tag_service)
+
+	req_ctx := c.Request.Context()
+	tag_service.On("GetTags", req_ctx, uint(2)).Return(services.GetTagsResult{}, errors.New("db error"))
+
+	tag_controller.GetTags(c)
+
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+}
+
+func TestTagControllerRenameSuccess(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request, _ = http.NewRequest("PUT", "/tags/1", bytes.NewBuffer([]byte(`{"Name":"job"}`)))
+	c.Request.Header.Set("Content-Type", "application/json")
+	c.Params = append(c.Params, gin.Param{Key: "id", Value: "1"})
+	c.Set("user_id", uint(2))
+
+	tag_service := new(servicemocks.MockTagService)
+	tag_controller := NewTagController(tag_service)
+
+	req_ctx := c.Request.Context()
+	tag_service.On("RenameTag", req_ctx, uint(1), uint(2), "job").Return(nil)
+
+	tag_controller.Rename(c)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"id":1`)
+	tag_service.AssertExpectations(t)
+}
+
+func TestTagControllerRenameExists(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request, _ = http.NewRequest("PUT", "/tags/1", bytes.NewBuffer([]byte(`{"Name":"home"}`)))
+	c.Request.Header.Set("Content-Type", "application/json")
+	c.Params = append(c.Params, gin.Param{Key: "id", Value: "1"})
+	c.Set("user_id", uint(2))
+
+	tag_service := new(servicemocks.MockTagService)
+	tag_controller := NewTagController(tag_service)
+
+	req_ctx := c.Request.Context()
+	tag_service.On("RenameTag", req_ctx, uint(1), uint(2), "home").Return(&services.ErrorTagExists{Name: "home"})
+
+	tag_controller.Rename(c)
+
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.Contains(t, w.Body.String(), "already exists")
+}
+
+func TestTagControllerMergeWrongUser(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request, _ = http.NewRequest("POST", "/tags/1/merge", bytes.NewBuffer([]byte(`{"Target":3}`)))
+	c.Request.Header.Set("Content-Type", "application/json")
+	c.Params = append(c.Params, gin.Param{Key: "id", Value: "1"})
+	c.Set("user_id", uint(2))
+
+	tag_service := new(servicemocks.MockTagService)
+	tag_controller := NewTagController(tag_service)
+
+	req_ctx := c.Request.Context()
+	tag_service.On("MergeTags", req_ctx, uint(1), uint(3), uint(2)).Return(&services.ErrorTagWrongOwner{TagId: 3, UserId: 2})
+
+	tag_controller.Merge(c)
+
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Contains(t, w.Body.String(), "does not own tag")
+}
+
+func TestTagControllerDeleteNotFound(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request, _ = http.NewRequest("DELETE", "/tags/1", nil)
+	c.Params = append(c.Params, gin.Param{Key: "id", Value: "1"})
+	c.Set("user_id", uint(2))
+
+	tag_service := new(servicemocks.MockTagService)
+	tag_controller := NewTagController(tag_service)
+
+	req_ctx := c.Request.Context()
+	tag_service.On("DeleteTag", req_ctx, uint(1), uint(2)).Return(&services.ErrorTagNotFound{TagId: 1})
+
+	tag_controller.Delete(c)
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestTagControllerDeleteMalformedId(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request, _ = http.NewRequest("DELETE", "/tags/abc", nil)
+	c.Params = append(c.Params, gin.Param{Key: "id", Value: "abc"})
+	c.Set("user_id", uint(2))
+
+	tag_service := new(servicemocks.MockTagService)
+	tag_controller := NewTagController(tag_service)
+
+	tag_controller.Delete(c)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "malformed id")
+}
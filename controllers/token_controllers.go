@@ -0,0 +1,45 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+
+	"user-notes-api/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+type TokenController struct {
+	TokenRefresher services.TokenRefresher
+}
+
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token" binding:"required"`
+}
+
+func NewTokenController(token_refresher services.TokenRefresher) *TokenController {
+	controller := TokenController{TokenRefresher: token_refresher}
+	return &controller
+}
+
+func (t *TokenController) Refresh(c *gin.Context) {
+	var request RefreshRequest
+	err := c.ShouldBindJSON(&request)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid input"})
+		return
+	}
+
+	request_ctx := c.Request.Context()
+	tokens, err := t.TokenRefresher.Refresh(request_ctx, request.RefreshToken)
+	if err != nil {
+		var invalidTokenError *services.ErrorInvalidRefreshToken
+		if errors.As(err, &invalidTokenError) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": invalidTokenError.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, tokens)
+}
@@ -0,0 +1,70 @@
+package controllers
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"user-notes-api/services"
+	"user-notes-api/testing/testutils/servicemocks"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTokenControllerRefreshSuccess(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request, _ = http.NewRequest("POST", "/token/refresh", bytes.NewBuffer([]byte(`{"refresh_token": "old"}`)))
+	c.Request.Header.Set("Content-Type", "application/json")
+
+	token_service := new(servicemocks.MockTokenService)
+	token_controller := NewTokenController(token_service)
+
+	token_service.On("Refresh", c.Request.Context(), "old").Return(services.AuthTokens{Token: "jwt", RefreshToken: "new"}, nil)
+
+	token_controller.Refresh(c)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"token":"jwt","refresh_token":"new"}`, w.Body.String())
+}
+
+func TestTokenControllerRefreshReused(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request, _ = http.NewRequest("POST", "/token/refresh", bytes.NewBuffer([]byte(`{"refresh_token": "old"}`)))
+	c.Request.Header.Set("Content-Type", "application/json")
+
+	token_service := new(servicemocks.MockTokenService)
+	token_controller := NewTokenController(token_service)
+
+	e := services.ErrorInvalidRefreshToken{Reused: true}
+	token_service.On("Refresh", c.Request.Context(), "old").Return(services.AuthTokens{}, &e)
+
+	token_controller.Refresh(c)
+
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Contains(t, w.Body.String(), "already used")
+}
+
+func TestTokenControllerRefreshMissingToken(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request, _ = http.NewRequest("POST", "/token/refresh", bytes.NewBuffer([]byte(`{}`)))
+	c.Request.Header.Set("Content-Type", "application/json")
+
+	token_service := new(servicemocks.MockTokenService)
+	token_controller := NewTokenController(token_service)
+
+	token_controller.Refresh(c)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	token_service.AssertNotCalled(t, "Refresh")
+}
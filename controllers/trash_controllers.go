@@ -0,0 +1,73 @@
+package controllers
+
+import (
+	"net/http"
+
+	"user-notes-api/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+type TrashController struct {
+	TrashService services.TrashServiceIfc
+}
+
+func NewTrashController(trash_service services.TrashServiceIfc) *TrashController {
+	controller := TrashController{TrashService: trash_service}
+	return &controller
+}
+
+func (t *TrashController) GetTrash(c *gin.Context) {
+	request_ctx := c.Request.Context()
+	user_id, ok := userIdFromContext(c)
+	if !ok {
+		return
+	}
+
+	result, err := t.TrashService.GetTrash(request_ctx, user_id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, result)
+}
+
+func (t *TrashController) Restore(c *gin.Context) {
+	request_ctx := c.Request.Context()
+	note_id, ok := idFromParam(c)
+	if !ok {
+		return
+	}
+
+	user_id, ok := userIdFromContext(c)
+	if !ok {
+		return
+	}
+
+	err := t.TrashService.RestoreNote(request_ctx, note_id, user_id)
+	if err != nil {
+		writeNoteModificationError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"id": note_id})
+}
+
+func (t *TrashController) Purge(c *gin.Context) {
+	request_ctx := c.Request.Context()
+	note_id, ok := idFromParam(c)
+	if !ok {
+		return
+	}
+
+	user_id, ok := userIdFromContext(c)
+	if !ok {
+		return
+	}
+
+	err := t.TrashService.PurgeNote(request_ctx, note_id, user_id)
+	if err != nil {
+		writeNoteModificationError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"id": note_id})
+}
@@ -0,0 +1,98 @@
+package controllers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+	"user-notes-api/services"
+	"user-notes-api/testing/testutils/servicemocks"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTrashControllerGetTrashSuccess(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request, _ = http.NewRequest("GET", "/trash", nil)
+	c.Set("user_id", uint(2))
+
+	trash_service := new(servicemocks.MockTrashService)
+	trash_controller := NewTrashController(trash_service)
+
+	req_ctx := c.Request.Context()
+	deleted_at := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	result := services.GetTrashResult{Result: []services.TrashResult{{Id: 1, Title: "Title", DeletedAt: deleted_at}}}
+	trash_service.On("GetTrash", req_ctx, uint(2)).Return(result, nil)
+
+	trash_controller.GetTrash(c)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"Result":[{"Id":1,"Title":"Title","DeletedAt":"2025-01-01T12:00:00Z"}]}`, w.Body.String())
+}
+
+func TestTrashControllerRestoreSuccess(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request, _ = http.NewRequest("POST", "/trash/1/restore", nil)
+	c.Params = append(c.Params, gin.Param{Key: "id", Value: "1"})
+	c.Set("user_id", uint(2))
+
+	trash_service := new(servicemocks.MockTrashService)
+	trash_controller := NewTrashController(trash_service)
+
+	req_ctx := c.Request.Context()
+	trash_service.On("RestoreNote", req_ctx, uint(1), uint(2)).Return(nil)
+
+	trash_controller.Restore(c)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"id":1}`, w.Body.String())
+}
+
+func TestTrashControllerRestoreNotFound(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request, _ = http.NewRequest("POST", "/trash/1/restore", nil)
+	c.Params = append(c.Params, gin.Param{Key: "id", Value: "1"})
+	c.Set("user_id", uint(2))
+
+	trash_service := new(servicemocks.MockTrashService)
+	trash_controller := NewTrashController(trash_service)
+
+	req_ctx := c.Request.Context()
+	e := services.ErrorNoteNotFound{NoteId: 1}
+	trash_service.On("RestoreNote", req_ctx, uint(1), uint(2)).Return(&e)
+
+	trash_controller.Restore(c)
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestTrashControllerPurgeWrongOwner(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request, _ = http.NewRequest("DELETE", "/trash/1", nil)
+	c.Params = append(c.Params, gin.Param{Key: "id", Value: "1"})
+	c.Set("user_id", uint(2))
+
+	trash_service := new(servicemocks.MockTrashService)
+	trash_controller := NewTrashController(trash_service)
+
+	req_ctx := c.Request.Context()
+	e := services.ErrorWrongOwner{NoteId: 1, UserId: 2}
+	trash_service.On("PurgeNote", req_ctx, uint(1), uint(2)).Return(&e)
+
+	trash_controller.Purge(c)
+
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
@@ -1,7 +1,9 @@
 package middleware
 
 import (
+	"errors"
 	"net/http"
+	"slices"
 	"strings"
 	"time"
 
@@ -11,7 +13,11 @@ import (
 	"user-notes-api/services"
 )
 
-func JwtMiddleware(jwt_secret string) gin.HandlerFunc {
+// JwtMiddleware authenticates requests by the access token in the Authorization header, verified with the
+// key of jwt_keys named by its kid. Tokens without a jti or revoked according to revocations are rejected.
+// Personal access tokens are accepted as well and limit the request to their scopes, see RequireScope.
+func JwtMiddleware(jwt_keys *services.JwtKeys, revocations services.TokenRevocationChecker,
+	access_tokens services.PersonalAccessTokenAuthenticator) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		header := c.GetHeader("Authorization")
 		if header == "" {
@@ -30,9 +36,13 @@ func JwtMiddleware(jwt_secret string) gin.HandlerFunc {
 			return
 		}
 
-		token, err := jwt.ParseWithClaims(token_string, &services.JwtClaims{}, func(token *jwt.Token) (any, error) {
-			return []byte(jwt_secret), nil
-		}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}))
+		if strings.HasPrefix(token_string, services.PersonalAccessTokenPrefix) {
+			authenticatePersonalAccessToken(c, access_tokens, token_string)
+			return
+		}
+
+		token, err := jwt.ParseWithClaims(token_string, &services.JwtClaims{}, jwt_keys.Keyfunc,
+			jwt.WithValidMethods(jwt_keys.Methods()))
 
 		if err != nil {
 			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "failed to parse token: " + err.Error()})
@@ -79,6 +89,72 @@ func JwtMiddleware(jwt_secret string) gin.HandlerFunc {
 		}
 		c.Set("user_id", user_id)
 
+		if claims.ID == "" {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "missing token id"})
+			return
+		}
+
+		revoked, err := revocations.IsRevoked(c.Request.Context(), claims)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if revoked {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "token is revoked"})
+			return
+		}
+		c.Set("jti", claims.ID)
+		c.Set("token_expires_at", expirationTime.Time)
+		if claims.AuthTime != nil {
+			c.Set("auth_time", claims.AuthTime.Time)
+		}
+
+		c.Next()
+	}
+}
+
+// authenticatePersonalAccessToken sets the user and the scopes of a personal access token. Only requests
+// with a personal access token have scopes in the context.
+func authenticatePersonalAccessToken(c *gin.Context, access_tokens services.PersonalAccessTokenAuthenticator, token string) {
+	token_auth, err := access_tokens.Authenticate(c.Request.Context(), token)
+	if err != nil {
+		var invalidTokenError *services.ErrorInvalidPersonalAccessToken
+		if errors.As(err, &invalidTokenError) {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			return
+		}
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.Set("username", token_auth.Username)
+	c.Set("user_id", token_auth.UserId)
+	c.Set("scopes", token_auth.Scopes)
+
+	c.Next()
+}
+
+// RequireScope rejects requests with a personal access token that lacks the scope. Requests with a JWT
+// are not limited.
+func RequireScope(scope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		scopes, found := c.Get("scopes")
+		if found && !slices.Contains(scopes.([]string), scope) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "token lacks the scope " + scope})
+			return
+		}
+		c.Next()
+	}
+}
+
+// RequireSession rejects requests with a personal access token, for endpoints that manage the account
+// and its tokens and therefore require a login.
+func RequireSession() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if _, found := c.Get("scopes"); found {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "personal access tokens cannot be used for this endpoint"})
+			return
+		}
 		c.Next()
 	}
 }
@@ -1,15 +1,20 @@
 package middleware
 
 import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
 	"net/http"
 	"net/http/httptest"
 	"testing"
 	"time"
 	"user-notes-api/services"
+	"user-notes-api/testing/testutils/servicemocks"
 
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
 )
 
 // test missing fields in jwt?
@@ -20,15 +25,22 @@ func TestAuthMiddlewareSuccess(t *testing.T) {
 	router := gin.New()
 
 	jwt_secret := "jwt_secret"
-	router.Use(JwtMiddleware(jwt_secret))
+	revocations := new(servicemocks.MockTokenRevocationChecker)
+	revocations.On("IsRevoked", mock.Anything, mock.Anything).Return(false, nil)
+	router.Use(JwtMiddleware(services.NewJwtKeys(services.NewHmacJwtKey("", []byte(jwt_secret))), revocations, new(servicemocks.MockPersonalAccessTokenService)))
 
+	auth_time := time.Now().Add(-time.Hour).Truncate(time.Second)
 	router.GET("/protected", func(c *gin.Context) {
+		// the time of the login is passed on for changes that require a recent one
+		assert.Equal(t, auth_time, c.MustGet("auth_time").(time.Time).Local())
 		c.JSON(http.StatusOK, gin.H{"message": "success"})
 	})
 
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, services.JwtClaims{
-		UserId: 1,
+		UserId:   1,
+		AuthTime: jwt.NewNumericDate(auth_time),
 		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        "jti",
 			Issuer:    "auth.user-notes-api.local",
 			Subject:   "Alice",
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
@@ -56,7 +68,9 @@ func TestAuthMiddlewareTokenExpired(t *testing.T) {
 	router := gin.New()
 
 	jwt_secret := "jwt_secret"
-	router.Use(JwtMiddleware(jwt_secret))
+	revocations := new(servicemocks.MockTokenRevocationChecker)
+	revocations.On("IsRevoked", mock.Anything, mock.Anything).Return(false, nil)
+	router.Use(JwtMiddleware(services.NewJwtKeys(services.NewHmacJwtKey("", []byte(jwt_secret))), revocations, new(servicemocks.MockPersonalAccessTokenService)))
 
 	router.GET("/protected", func(c *gin.Context) {
 		c.JSON(http.StatusOK, gin.H{"message": "success"})
@@ -65,6 +79,7 @@ func TestAuthMiddlewareTokenExpired(t *testing.T) {
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, services.JwtClaims{
 		UserId: 1,
 		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        "jti",
 			Issuer:    "auth.user-notes-api.local",
 			Subject:   "Alice",
 			IssuedAt:  jwt.NewNumericDate(time.Now().Add(-8 * time.Hour)),
@@ -91,7 +106,9 @@ func TestAuthMiddlewareInvalidNotBefore(t *testing.T) {
 	router := gin.New()
 
 	jwt_secret := "jwt_secret"
-	router.Use(JwtMiddleware(jwt_secret))
+	revocations := new(servicemocks.MockTokenRevocationChecker)
+	revocations.On("IsRevoked", mock.Anything, mock.Anything).Return(false, nil)
+	router.Use(JwtMiddleware(services.NewJwtKeys(services.NewHmacJwtKey("", []byte(jwt_secret))), revocations, new(servicemocks.MockPersonalAccessTokenService)))
 
 	router.GET("/protected", func(c *gin.Context) {
 		c.JSON(http.StatusOK, gin.H{"message": "success"})
@@ -100,6 +117,7 @@ func TestAuthMiddlewareInvalidNotBefore(t *testing.T) {
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, services.JwtClaims{
 		UserId: 1,
 		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        "jti",
 			Issuer:    "auth.user-notes-api.local",
 			Subject:   "Alice",
 			IssuedAt:  jwt.NewNumericDate(time.Now().Add(0 * time.Hour)),
@@ -120,3 +138,206 @@ func TestAuthMiddlewareInvalidNotBefore(t *testing.T) {
 	assert.Contains(t, w.Body.String(), "token is not valid yet")
 
 }
+
+func TestAuthMiddlewareRevoked(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	router := gin.New()
+
+	jwt_secret := "jwt_secret"
+	revocations := new(servicemocks.MockTokenRevocationChecker)
+	revocations.On("IsRevoked", mock.Anything, mock.MatchedBy(func(claims *services.JwtClaims) bool {
+		return claims.ID == "revoked"
+	})).Return(true, nil)
+	revocations.On("IsRevoked", mock.Anything, mock.Anything).Return(false, nil)
+	router.Use(JwtMiddleware(services.NewJwtKeys(services.NewHmacJwtKey("", []byte(jwt_secret))), revocations, new(servicemocks.MockPersonalAccessTokenService)))
+
+	router.GET("/protected", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"message": "success", "jti": c.GetString("jti")})
+	})
+
+	for _, test := range []struct {
+		jti      string
+		code     int
+		contains string
+	}{
+		{jti: "revoked", code: http.StatusUnauthorized, contains: "token is revoked"},
+		{jti: "", code: http.StatusUnauthorized, contains: "missing token id"},
+		{jti: "valid", code: http.StatusOK, contains: `"jti":"valid"`},
+	} {
+		token := jwt.NewWithClaims(jwt.SigningMethodHS256, services.JwtClaims{
+			UserId: 1,
+			RegisteredClaims: jwt.RegisteredClaims{
+				ID:        test.jti,
+				Issuer:    "auth.user-notes-api.local",
+				Subject:   "Alice",
+				IssuedAt:  jwt.NewNumericDate(time.Now()),
+				NotBefore: jwt.NewNumericDate(time.Now()),
+				ExpiresAt: jwt.NewNumericDate(time.Now().Add(4 * time.Hour))},
+		})
+
+		token_string, err := token.SignedString([]byte(jwt_secret))
+		assert.NoError(t, err)
+
+		req, _ := http.NewRequest("GET", "/protected", nil)
+		req.Header.Set("Authorization", "Bearer "+token_string)
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, test.code, w.Code, test.jti)
+		assert.Contains(t, w.Body.String(), test.contains)
+	}
+}
+
+func TestAuthMiddlewareAsymmetricKeys(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	_, private_key, err := ed25519.GenerateKey(rand.Reader)
+	assert.NoError(t, err)
+	jwt_keys := services.NewJwtKeys(services.NewEd25519JwtKey("ed", private_key))
+
+	router := gin.New()
+	revocations := new(servicemocks.MockTokenRevocationChecker)
+	revocations.On("IsRevoked", mock.Anything, mock.Anything).Return(false, nil)
+	router.Use(JwtMiddleware(jwt_keys, revocations, new(servicemocks.MockPersonalAccessTokenService)))
+
+	router.GET("/protected", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"message": "success"})
+	})
+
+	claims := services.JwtClaims{
+		UserId: 1,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        "jti",
+			Issuer:    "auth.user-notes-api.local",
+			Subject:   "Alice",
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			NotBefore: jwt.NewNumericDate(time.Now()),
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(4 * time.Hour))},
+	}
+
+	signed, err := jwt_keys.Sign(claims)
+	assert.NoError(t, err)
+
+	unknown_kid := jwt.NewWithClaims(jwt.SigningMethodEdDSA, claims)
+	unknown_kid.Header["kid"] = "other"
+	unknown, err := unknown_kid.SignedString(private_key)
+	assert.NoError(t, err)
+
+	hmac, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte("jwt_secret"))
+	assert.NoError(t, err)
+
+	for _, test := range []struct {
+		token    string
+		code     int
+		contains string
+	}{
+		{token: signed, code: http.StatusOK, contains: "success"},
+		{token: unknown, code: http.StatusUnauthorized, contains: `no EdDSA key with kid \"other\"`},
+		{token: hmac, code: http.StatusUnauthorized, contains: "signing method HS256 is invalid"},
+	} {
+		req, _ := http.NewRequest("GET", "/protected", nil)
+		req.Header.Set("Authorization", "Bearer "+test.token)
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, test.code, w.Code)
+		assert.Contains(t, w.Body.String(), test.contains)
+	}
+}
+
+func TestAuthMiddlewarePersonalAccessToken(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	revocations := new(servicemocks.MockTokenRevocationChecker)
+	access_tokens := new(servicemocks.MockPersonalAccessTokenService)
+	access_tokens.On("Authenticate", mock.Anything, "unp_read").Return(services.PersonalAccessTokenAuth{UserId: 1,
+		Username: "Alice", Scopes: []string{services.ScopeNotesRead}}, nil)
+	access_tokens.On("Authenticate", mock.Anything, "unp_expired").Return(services.PersonalAccessTokenAuth{},
+		&services.ErrorInvalidPersonalAccessToken{Err: errors.New("token is expired")})
+
+	router := gin.New()
+	auth := router.Group("/", JwtMiddleware(services.NewJwtKeys(services.NewHmacJwtKey("", []byte("jwt_secret"))), revocations, access_tokens))
+	handler := func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"message": "success", "user_id": c.GetUint("user_id"), "username": c.GetString("username")})
+	}
+	auth.GET("/notes", RequireScope(services.ScopeNotesRead), handler)
+	auth.POST("/notes", RequireScope(services.ScopeNotesWrite), handler)
+	auth.GET("/me/tokens", RequireSession(), handler)
+
+	for _, test := range []struct {
+		method   string
+		path     string
+		token    string
+		code     int
+		contains string
+	}{
+		{method: "GET", path: "/notes", token: "unp_read", code: http.StatusOK, contains: `"username":"Alice"`},
+		{method: "POST", path: "/notes", token: "unp_read", code: http.StatusForbidden, contains: "token lacks the scope notes:write"},
+		{method: "GET", path: "/me/tokens", token: "unp_read", code: http.StatusForbidden, contains: "personal access tokens cannot be used"},
+		{method: "GET", path: "/notes", token: "unp_expired", code: http.StatusUnauthorized, contains: "token is expired"},
+	} {
+		req, _ := http.NewRequest(test.method, test.path, nil)
+		req.Header.Set("Authorization", "Bearer "+test.token)
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, test.code, w.Code, test.method+" "+test.path)
+		assert.Contains(t, w.Body.String(), test.contains)
+	}
+
+	// JWTs are not limited by scopes
+	revocations.On("IsRevoked", mock.Anything, mock.Anything).Return(false, nil)
+	token_string, err := jwt.NewWithClaims(jwt.SigningMethodHS256, services.JwtClaims{
+		UserId: 1,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        "jti",
+			Issuer:    "auth.user-notes-api.local",
+			Subject:   "Alice",
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			NotBefore: jwt.NewNumericDate(time.Now()),
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(4 * time.Hour))},
+	}).SignedString([]byte("jwt_secret"))
+	assert.NoError(t, err)
+
+	for _, path := range []string{"/notes", "/me/tokens"} {
+		req, _ := http.NewRequest("GET", path, nil)
+		req.Header.Set("Authorization", "Bearer "+token_string)
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code, path)
+	}
+}
+
+func TestAuthMiddlewareMfaToken(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	router := gin.New()
+
+	jwt_keys := services.NewJwtKeys(services.NewHmacJwtKey("", []byte("jwt_secret")))
+	revocations := new(servicemocks.MockTokenRevocationChecker)
+	revocations.On("IsRevoked", mock.Anything, mock.Anything).Return(false, nil)
+	router.Use(JwtMiddleware(jwt_keys, revocations, new(servicemocks.MockPersonalAccessTokenService)))
+
+	router.GET("/protected", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"message": "success"})
+	})
+
+	// the token of a login waiting for its second factor is signed with the same keys but grants no access
+	challenge, err := services.NewMfaTokenService(jwt_keys, 5*time.Minute).IssueMfaToken(1, "Alice")
+	assert.NoError(t, err)
+
+	req, _ := http.NewRequest("GET", "/protected", nil)
+	req.Header.Set("Authorization", "Bearer "+challenge.MfaToken)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.NotContains(t, w.Body.String(), "success")
+}
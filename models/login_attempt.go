@@ -0,0 +1,12 @@
+package models
+
+import "time"
+
+// LoginAttempt counts the failed logins for a key, like a username or a client IP, since the last
+// successful login. Logins for the key are refused until LockedUntil.
+type LoginAttempt struct {
+	Key           string    `gorm:"primaryKey"`
+	Failures      int       `gorm:"not null"`
+	LastFailureAt time.Time `gorm:"not null;index"`
+	LockedUntil   time.Time `gorm:"not null"`
+}
@@ -18,7 +18,7 @@ func prepareDatabase(t *testing.T) (db *gorm.DB) {
 	db.Exec("PRAGMA foreign_keys = ON;")
 
 	db.AutoMigrate(&User{})
-	db.AutoMigrate(&Note{})
+	db.AutoMigrate(&Notebook{}, &Note{}, &Tag{})
 
 	return db
 }
@@ -167,3 +167,67 @@ func TestCRUDNotes(t *testing.T) {
 	}
 	sqlDB.Close()
 }
+
+func TestCRUDTags(t *testing.T) {
+	//setup
+	db := prepareDatabase(t)
+	ctx := context.Background()
+	result := gorm.WithResult()
+
+	user := User{Username: "testName", Password: "pwd"}
+	err := gorm.G[User](db, result).Create(ctx, &user)
+	assert.NoError(t, err)
+
+	user2 := User{Username: "testName2", Password: "pwd"}
+	err = gorm.G[User](db, result).Create(ctx, &user2)
+	assert.NoError(t, err)
+
+	// Tag names are unique per user
+	tag := Tag{Name: "work", UserID: user.ID}
+	err = gorm.G[Tag](db, result).Create(ctx, &tag)
+	assert.NoError(t, err)
+
+	tag_duplicate := Tag{Name: "work", UserID: user.ID}
+	err = gorm.G[Tag](db, result).Create(ctx, &tag_duplicate)
+	assert.Error(t, err)
+
+	tag_other_user := Tag{Name: "work", UserID: user2.ID}
+	err = gorm.G[Tag](db, result).Create(ctx, &tag_other_user)
+	assert.NoError(t, err)
+
+	// Create note with tags
+	tag2 := Tag{Name: "home", UserID: user.ID}
+	err = gorm.G[Tag](db, result).Create(ctx, &tag2)
+	assert.NoError(t, err)
+
+	note := Note{Title: "Title", Body: "Body", UserID: user.ID, Tags: []Tag{tag, tag2}}
+	err = db.Omit("User").Create(&note).Error
+	assert.NoError(t, err)
+
+	var note_read Note
+	query_result := db.Preload("Tags").First(&note_read, "id = ?", note.ID)
+	assert.NoError(t, query_result.Error)
+	assert.Equal(t, 2, len(note_read.Tags))
+
+	var tag_read Tag
+	query_result = db.Preload("Notes").First(&tag_read, "id = ?", tag.ID)
+	assert.NoError(t, query_result.Error)
+	assert.Equal(t, 1, len(tag_read.Notes))
+	assert.Equal(t, note.ID, tag_read.Notes[0].ID)
+
+	// Hard deleting a tag removes it from the notes
+	count, err := gorm.G[Tag](db.Unscoped()).Where("id = ?", tag.ID).Delete(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, count)
+
+	query_result = db.Preload("Tags").First(&note_read, "id = ?", note.ID)
+	assert.NoError(t, query_result.Error)
+	assert.Equal(t, 1, len(note_read.Tags))
+	assert.Equal(t, "home", note_read.Tags[0].Name)
+
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB.Close()
+}
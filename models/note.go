@@ -4,8 +4,12 @@ import "gorm.io/gorm"
 
 type Note struct {
 	gorm.Model
-	Title  string `gorm:"not null"`
-	Body   string
-	UserID uint `gorm:"not null"`
-	User   User `gorm:"foreignKey:UserID;references:ID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE"`
+	Title      string `gorm:"not null"`
+	Body       string
+	UserID     uint  `gorm:"not null;index"`
+	User       User  `gorm:"foreignKey:UserID;references:ID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE"`
+	NotebookID *uint `gorm:"index"`
+	Tags       []Tag `gorm:"many2many:note_tags;constraint:OnDelete:CASCADE"`
+	// Version is incremented on every change of the note and used for optimistic concurrency control.
+	Version uint `gorm:"not null;default:1"`
 }
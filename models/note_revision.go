@@ -0,0 +1,17 @@
+package models
+
+import "time"
+
+// NoteRevision is the content of a note after one change. Revisions are never updated, they are numbered
+// per note starting at 1.
+type NoteRevision struct {
+	ID        uint   `gorm:"primarykey"`
+	NoteID    uint   `gorm:"not null;uniqueIndex:idx_note_revisions_note_revision"`
+	Note      Note   `gorm:"foreignKey:NoteID;references:ID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE"`
+	Revision  uint   `gorm:"not null;uniqueIndex:idx_note_revisions_note_revision"`
+	Title     string `gorm:"not null"`
+	Body      string
+	AuthorID  uint `gorm:"not null;index"`
+	Author    User `gorm:"foreignKey:AuthorID;references:ID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE"`
+	CreatedAt time.Time
+}
@@ -0,0 +1,15 @@
+package models
+
+import "gorm.io/gorm"
+
+// Notebook groups notes of a user. Notebooks can be nested, a notebook without parent is a top-level
+// notebook.
+type Notebook struct {
+	gorm.Model
+	Name     string     `gorm:"not null"`
+	UserID   uint       `gorm:"not null;index"`
+	User     User       `gorm:"foreignKey:UserID;references:ID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE"`
+	ParentID *uint      `gorm:"index"`
+	Children []Notebook `gorm:"foreignKey:ParentID;constraint:OnDelete:CASCADE"`
+	Notes    []Note     `gorm:"foreignKey:NotebookID;constraint:OnDelete:SET NULL"`
+}
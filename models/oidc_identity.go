@@ -0,0 +1,14 @@
+package models
+
+import "time"
+
+// OidcIdentity links a user to its account at an OpenID Connect provider. The account is identified by
+// the issuer and subject of its ID tokens, which never change, unlike the username or email.
+type OidcIdentity struct {
+	ID        uint   `gorm:"primarykey"`
+	Issuer    string `gorm:"not null;uniqueIndex:idx_oidc_identities_issuer_subject"`
+	Subject   string `gorm:"not null;uniqueIndex:idx_oidc_identities_issuer_subject"`
+	UserID    uint   `gorm:"not null;index"`
+	User      User   `gorm:"foreignKey:UserID;references:ID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE"`
+	CreatedAt time.Time
+}
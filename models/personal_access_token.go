@@ -0,0 +1,18 @@
+package models
+
+import "time"
+
+// PersonalAccessToken is a long-lived token for scripts and integrations, limited to its scopes. Only
+// the SHA-256 hash of the token is stored, the token itself is shown once when it is created.
+type PersonalAccessToken struct {
+	ID        uint   `gorm:"primarykey"`
+	Name      string `gorm:"not null"`
+	TokenHash string `gorm:"not null;uniqueIndex"`
+	// Scopes are separated by spaces, like the scope parameter of OAuth 2.0.
+	Scopes     string `gorm:"not null"`
+	UserID     uint   `gorm:"not null;index"`
+	User       User   `gorm:"foreignKey:UserID;references:ID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE"`
+	ExpiresAt  *time.Time
+	LastUsedAt *time.Time
+	CreatedAt  time.Time
+}
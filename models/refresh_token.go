@@ -0,0 +1,19 @@
+package models
+
+import "time"
+
+// RefreshToken is a long-lived token used to obtain new access tokens. Only the SHA-256 hash of the token
+// is stored. Tokens are rotated on every use; all tokens descending from the same login share a family.
+type RefreshToken struct {
+	ID        uint      `gorm:"primarykey"`
+	TokenHash string    `gorm:"not null;uniqueIndex"`
+	FamilyID  string    `gorm:"not null;index"`
+	UserID    uint      `gorm:"not null;index"`
+	User      User      `gorm:"foreignKey:UserID;references:ID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE"`
+	ExpiresAt time.Time `gorm:"not null"`
+	RotatedAt *time.Time
+	RevokedAt *time.Time
+	// AuthenticatedAt is the time of the login the family descends from, nil for families of older logins.
+	AuthenticatedAt *time.Time
+	CreatedAt       time.Time
+}
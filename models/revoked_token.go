@@ -0,0 +1,13 @@
+package models
+
+import "time"
+
+// RevokedToken is an access token that was revoked before it expired, identified by the jti claim. Rows
+// can be deleted once the token is expired, since it is rejected anyway then.
+type RevokedToken struct {
+	JTI       string    `gorm:"primaryKey"`
+	UserID    uint      `gorm:"not null;index"`
+	User      User      `gorm:"foreignKey:UserID;references:ID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE"`
+	ExpiresAt time.Time `gorm:"not null;index"`
+	CreatedAt time.Time
+}
@@ -0,0 +1,11 @@
+package models
+
+import "gorm.io/gorm"
+
+type Tag struct {
+	gorm.Model
+	Name   string `gorm:"not null;uniqueIndex:idx_tags_user_name"`
+	UserID uint   `gorm:"not null;uniqueIndex:idx_tags_user_name"`
+	User   User   `gorm:"foreignKey:UserID;references:ID;constraint:OnUpdate:CASCADE,OnDelete:CASCADE"`
+	Notes  []Note `gorm:"many2many:note_tags;constraint:OnDelete:CASCADE"`
+}
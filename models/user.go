@@ -1,10 +1,31 @@
 package models
 
-import "gorm.io/gorm"
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
 
 type User struct {
 	gorm.Model
 	Username string `gorm:"unique;not null"`
-	Password string `gorm:"not null"`
-	Notes    []Note
+	// UsernameKey is the case-insensitive form of Username, see utils.UsernameKey. Its unique index is
+	// created by repositories.MigrateUsernameKeys.
+	UsernameKey string `gorm:"not null;default:''"`
+	Password    string `gorm:"not null"`
+	// Passwordless is set for users created by an OpenID Connect login, whose Password is a random one that
+	// is never shown. It is cleared once the user sets a password.
+	Passwordless bool `gorm:"not null;default:false"`
+	Notes        []Note
+	// TokensRevokedAt is set on logout from all devices. Personal access tokens created at or before it are revoked.
+	TokensRevokedAt *time.Time
+	// TokenGeneration is incremented on logout from all devices. Access tokens carry the generation they were
+	// issued in, and the tokens of older generations are revoked.
+	TokenGeneration uint `gorm:"not null;default:0"`
+	// TotpSecret is the TOTP secret of two-factor authentication, encrypted with utils.SecretBox. It is set
+	// when the enrollment starts, but only required at login once the enrollment was confirmed at TotpEnabledAt.
+	TotpSecret    string `gorm:"not null;default:''"`
+	TotpEnabledAt *time.Time
+	// TotpLastCounter is the time step of the last accepted TOTP code, so every code can only be used once.
+	TotpLastCounter int64 `gorm:"not null;default:0"`
 }
@@ -0,0 +1,89 @@
+package repositories
+
+import (
+	"context"
+	"time"
+	"user-notes-api/models"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// LoginAttemptRepository stores failed logins in the database, so they are shared between all instances
+// of the API. All times are stored in UTC.
+type LoginAttemptRepository struct {
+	db *gorm.DB
+}
+
+func NewLoginAttemptRepository(db *gorm.DB) *LoginAttemptRepository {
+	return &LoginAttemptRepository{db: db}
+}
+
+// ReserveLoginAttempt counts a login for the key at now as failed before its password is checked and
+// returns the number of failures, unless logins for the key are locked at now. Then it returns 0 and the
+// time until which they are locked. Failures before since are forgotten. If delay returns a duration for the
+// number of failures, logins for the key are locked for it from now.
+func (r *LoginAttemptRepository) ReserveLoginAttempt(ctx context.Context, key string, now time.Time, since time.Time,
+	delay func(failures int) time.Duration) (int, time.Time, error) {
+	var attempt models.LoginAttempt
+	reserved := false
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		// the upsert locks the row until the end of the transaction, so concurrent logins for the key are
+		// counted and locked one after the other
+		attempt = models.LoginAttempt{Key: key, Failures: 1, LastFailureAt: now.UTC()}
+		err := tx.Clauses(clause.OnConflict{
+			Columns: []clause.Column{{Name: "key"}},
+			DoUpdates: clause.Assignments(map[string]any{
+				"failures": gorm.Expr("CASE WHEN login_attempts.locked_until > ? THEN login_attempts.failures "+
+					"WHEN login_attempts.last_failure_at < ? THEN 1 ELSE login_attempts.failures + 1 END", now.UTC(), since.UTC()),
+				"last_failure_at": gorm.Expr("CASE WHEN login_attempts.locked_until > ? THEN login_attempts.last_failure_at "+
+					"ELSE ? END", now.UTC(), now.UTC()),
+			}),
+		}).Create(&attempt).Error
+		if err != nil {
+			return err
+		}
+
+		attempt, err = gorm.G[models.LoginAttempt](tx).Where("key = ?", key).First(ctx)
+		if err != nil || attempt.LockedUntil.After(now) {
+			return err
+		}
+
+		reserved = true
+		lock := delay(attempt.Failures)
+		if lock == 0 {
+			return nil
+		}
+		_, err = gorm.G[models.LoginAttempt](tx).Where("key = ?", key).Update(ctx, "locked_until", now.Add(lock).UTC())
+		return err
+	})
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+	if !reserved {
+		return 0, attempt.LockedUntil, nil
+	}
+	return attempt.Failures, time.Time{}, nil
+}
+
+// RefundLoginAttempt gives back the reservation of a login with the given number of failures. Logins were
+// not locked before a reservation, so a lock is removed unless there were further reservations since.
+func (r *LoginAttemptRepository) RefundLoginAttempt(ctx context.Context, key string, failures int) error {
+	return r.db.WithContext(ctx).Model(&models.LoginAttempt{}).Where("key = ? AND failures > 0", key).Updates(map[string]any{
+		"failures":     gorm.Expr("failures - 1"),
+		"locked_until": gorm.Expr("CASE WHEN failures = ? THEN ? ELSE locked_until END", failures, time.Time{}.UTC()),
+	}).Error
+}
+
+// ResetLoginFailures forgets the failed logins for the key.
+func (r *LoginAttemptRepository) ResetLoginFailures(ctx context.Context, key string) error {
+	_, err := gorm.G[models.LoginAttempt](r.db).Where("key = ?", key).Delete(ctx)
+	return err
+}
+
+// DeleteStaleLoginAttempts deletes the failed logins for keys without failures since before and returns
+// their number.
+func (r *LoginAttemptRepository) DeleteStaleLoginAttempts(ctx context.Context, before time.Time) (int, error) {
+	count, err := gorm.G[models.LoginAttempt](r.db).Where("last_failure_at < ? AND locked_until < ?", before.UTC(), before.UTC()).Delete(ctx)
+	return count, err
+}
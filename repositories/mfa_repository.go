@@ -0,0 +1,130 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"time"
+	"user-notes-api/models"
+
+	"gorm.io/gorm"
+)
+
+type MfaReader interface {
+	CountRecoveryCodes(ctx context.Context, userId uint) (int, error)
+}
+
+type MfaUpdater interface {
+	StartTotpEnrollment(ctx context.Context, userId uint, secret string) error
+	EnableTotp(ctx context.Context, userId uint, counter int64, codeHashes []string) error
+	DisableTotp(ctx context.Context, userId uint) error
+	UseTotpCounter(ctx context.Context, userId uint, counter int64) error
+	UseRecoveryCode(ctx context.Context, userId uint, codeHash string) error
+	ReplaceRecoveryCodes(ctx context.Context, userId uint, codeHashes []string) error
+}
+
+var (
+	// ErrTotpEnabled is returned when an enrollment is started or confirmed for a user whose two-factor
+	// authentication is already enabled.
+	ErrTotpEnabled = errors.New("two-factor authentication is already enabled")
+	// ErrTotpCodeUsed is returned by UseTotpCounter if a code of the same or a later time step was accepted.
+	ErrTotpCodeUsed = errors.New("totp code was already used")
+	// ErrRecoveryCodeUsed is returned by UseRecoveryCode if the user has no unused recovery code with the hash.
+	ErrRecoveryCodeUsed = errors.New("recovery code is unknown or was already used")
+)
+
+type MfaRepository struct {
+	db *gorm.DB
+}
+
+func NewMfaRepository(db *gorm.DB) *MfaRepository {
+	return &MfaRepository{db: db}
+}
+
+// CountRecoveryCodes returns the number of unused recovery codes of the user.
+func (r *MfaRepository) CountRecoveryCodes(ctx context.Context, userId uint) (int, error) {
+	count, err := gorm.G[models.RecoveryCode](r.db).Where("user_id = ? AND used_at IS NULL", userId).Count(ctx, "id")
+	return int(count), err
+}
+
+// StartTotpEnrollment stores the encrypted secret of a new enrollment, replacing one that was not
+// confirmed. ErrTotpEnabled is returned if two-factor authentication is already enabled.
+func (r *MfaRepository) StartTotpEnrollment(ctx context.Context, userId uint, secret string) error {
+	result := r.db.WithContext(ctx).Model(&models.User{}).Where("id = ? AND totp_enabled_at IS NULL", userId).
+		Update("totp_secret", secret)
+	if result.Error == nil && result.RowsAffected != 1 {
+		return ErrTotpEnabled
+	}
+	return result.Error
+}
+
+// EnableTotp confirms the enrollment of the user with the time step of the first code and stores the hashes
+// of its recovery codes, both in one transaction. ErrTotpEnabled is returned if it is already enabled.
+func (r *MfaRepository) EnableTotp(ctx context.Context, userId uint, counter int64, codeHashes []string) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&models.User{}).Where("id = ? AND totp_enabled_at IS NULL", userId).
+			Updates(map[string]any{"totp_enabled_at": time.Now(), "totp_last_counter": counter})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected != 1 {
+			return ErrTotpEnabled
+		}
+
+		return replaceRecoveryCodes(ctx, tx, userId, codeHashes)
+	})
+}
+
+// DisableTotp removes the secret and the recovery codes of the user.
+func (r *MfaRepository) DisableTotp(ctx context.Context, userId uint) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&models.User{}).Where("id = ?", userId).
+			Updates(map[string]any{"totp_secret": "", "totp_enabled_at": nil, "totp_last_counter": 0}).Error
+		if err != nil {
+			return err
+		}
+
+		return replaceRecoveryCodes(ctx, tx, userId, nil)
+	})
+}
+
+// UseTotpCounter records that a code of the time step was accepted. The update is conditional, so even
+// concurrent requests can use a code only once; otherwise ErrTotpCodeUsed is returned.
+func (r *MfaRepository) UseTotpCounter(ctx context.Context, userId uint, counter int64) error {
+	result := r.db.WithContext(ctx).Model(&models.User{}).Where("id = ? AND totp_last_counter < ?", userId, counter).
+		Update("totp_last_counter", counter)
+	if result.Error == nil && result.RowsAffected != 1 {
+		return ErrTotpCodeUsed
+	}
+	return result.Error
+}
+
+// UseRecoveryCode marks the unused recovery code of the user with the given hash as used. If there is none,
+// ErrRecoveryCodeUsed is returned.
+func (r *MfaRepository) UseRecoveryCode(ctx context.Context, userId uint, codeHash string) error {
+	result := r.db.WithContext(ctx).Model(&models.RecoveryCode{}).
+		Where("user_id = ? AND code_hash = ? AND used_at IS NULL", userId, codeHash).Update("used_at", time.Now())
+	if result.Error == nil && result.RowsAffected == 0 {
+		return ErrRecoveryCodeUsed
+	}
+	return result.Error
+}
+
+// ReplaceRecoveryCodes deletes all recovery codes of the user, used or not, and stores the new ones.
+func (r *MfaRepository) ReplaceRecoveryCodes(ctx context.Context, userId uint, codeHashes []string) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		return replaceRecoveryCodes(ctx, tx, userId, codeHashes)
+	})
+}
+
+func replaceRecoveryCodes(ctx context.Context, tx *gorm.DB, userId uint, codeHashes []string) error {
+	_, err := gorm.G[models.RecoveryCode](tx).Where("user_id = ?", userId).Delete(ctx)
+	if err != nil || len(codeHashes) == 0 {
+		return err
+	}
+
+	codes := make([]models.RecoveryCode, 0, len(codeHashes))
+	for _, code_hash := range codeHashes {
+		codes = append(codes, models.RecoveryCode{UserID: userId, CodeHash: code_hash})
+	}
+	return tx.Omit("User").Create(&codes).Error
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"time"
 	"user-notes-api/models"
 
 	"gorm.io/gorm"
@@ -12,10 +13,51 @@ import (
 type NoteReader interface {
 	FindNoteById(ctx context.Context, id uint) (*models.Note, error)
 	FindNotesByUserId(ctx context.Context, userId uint) (*[]models.Note, error)
+	FindNotesPage(ctx context.Context, query NotePageQuery) (*[]models.Note, error)
+	SearchNotes(ctx context.Context, userId uint, query string, limit int) (*[]NoteSearchHit, error)
 }
 
-type NoteCreator interface {
-	CreateNote(ctx context.Context, note *models.Note) error
+type NoteUpdater interface {
+	MoveNote(ctx context.Context, note *models.Note, notebookId *uint) error
+}
+
+type NoteDeleter interface {
+	DeleteNote(ctx context.Context, note *models.Note) error
+}
+
+// ErrNoteVersionMismatch is returned by the conditional updates and deletes of notes if the note was
+// changed since the given version was read.
+var ErrNoteVersionMismatch = errors.New("note version does not match")
+
+type NoteSortField string
+
+const (
+	NoteSortCreated NoteSortField = "created_at"
+	NoteSortUpdated NoteSortField = "updated_at"
+	NoteSortTitle   NoteSortField = "title"
+)
+
+// NoteCursor marks the last note of the previous page. Depending on the sort field either Time or Title
+// holds the sort key of that note, the id breaks ties between notes with equal sort keys.
+type NoteCursor struct {
+	Time  time.Time
+	Title string
+	Id    uint
+}
+
+// NotePageQuery describes a single page of the notes of one user. Filters that are nil are not applied
+// and a nil cursor starts at the first page. If Tags is not empty, only notes with at least one of the
+// tags are returned, or only notes with all of them if MatchAllTags is set.
+type NotePageQuery struct {
+	UserId        uint
+	Limit         int
+	SortField     NoteSortField
+	Descending    bool
+	UpdatedSince  *time.Time
+	CreatedBefore *time.Time
+	Tags          []string
+	MatchAllTags  bool
+	After         *NoteCursor
 }
 
 type NoteRepository struct {
@@ -26,8 +68,10 @@ func NewNoteRepository(db *gorm.DB) *NoteRepository {
 	return &NoteRepository{db: db}
 }
 
+// CreateNote stores a new note with version 1.
 func (r *NoteRepository) CreateNote(ctx context.Context, note *models.Note) error {
-	tx := r.db.WithContext(ctx).Omit("User").Create(note)
+	note.Version = 1
+	tx := r.db.WithContext(ctx).Omit("User", "Tags.*").Create(note)
 
 	if tx.Error == nil && tx.RowsAffected != 1 {
 		return errors.New("number of affected rows not equal to 1")
@@ -37,7 +81,7 @@ func (r *NoteRepository) CreateNote(ctx context.Context, note *models.Note) erro
 }
 
 func (r *NoteRepository) FindNoteById(ctx context.Context, id uint) (*models.Note, error) {
-	note, err := gorm.G[models.Note](r.db).Where("id = ?", id).First(ctx)
+	note, err := gorm.G[models.Note](r.db).Preload("Tags", nil).Where("id = ?", id).First(ctx)
 	return &note, err
 }
 
@@ -46,21 +90,80 @@ func (r *NoteRepository) FindNotesByUserId(ctx context.Context, userId uint) (*[
 	return &notes, err
 }
 
-func (r *NoteRepository) DeleteNote(ctx context.Context, note *models.Note) error {
-	count, err := gorm.G[models.Note](r.db).Where("id = ?", note.ID).Delete(ctx)
-	if err == nil && count != 1 {
-		msg := fmt.Sprintf("unexpected count for deleting note. expected 1, received %d", count)
-		return errors.New(msg)
+func (r *NoteRepository) FindNotesPage(ctx context.Context, query NotePageQuery) (*[]models.Note, error) {
+	column := string(query.SortField)
+	if column != string(NoteSortCreated) && column != string(NoteSortUpdated) && column != string(NoteSortTitle) {
+		notes := []models.Note{}
+		return &notes, fmt.Errorf("unsupported sort field %q", column)
+	}
+
+	chain := gorm.G[models.Note](r.db).Preload("Tags", nil).Where("user_id = ?", query.UserId)
+
+	if query.UpdatedSince != nil {
+		chain = chain.Where("updated_at >= ?", *query.UpdatedSince)
 	}
-	return err
 
+	if query.CreatedBefore != nil {
+		chain = chain.Where("created_at < ?", *query.CreatedBefore)
+	}
+
+	if len(query.Tags) > 0 {
+		tagged := r.db.Table("note_tags").Select("note_tags.note_id").
+			Joins("JOIN tags ON tags.id = note_tags.tag_id").
+			Where("tags.user_id = ? AND tags.name IN ?", query.UserId, query.Tags)
+		if query.MatchAllTags {
+			tagged = tagged.Group("note_tags.note_id").Having("COUNT(DISTINCT tags.id) = ?", len(query.Tags))
+		}
+		chain = chain.Where("id IN (?)", tagged)
+	}
+
+	direction, comparison := "ASC", ">"
+	if query.Descending {
+		direction, comparison = "DESC", "<"
+	}
+
+	if query.After != nil {
+		var key any = query.After.Time
+		if query.SortField == NoteSortTitle {
+			key = query.After.Title
+		}
+		condition := fmt.Sprintf("(%s %s ? OR (%s = ? AND id %s ?))", column, comparison, column, comparison)
+		chain = chain.Where(condition, key, key, query.After.Id)
+	}
+
+	notes, err := chain.Order(column + " " + direction).Order("id " + direction).Limit(query.Limit).Find(ctx)
+	return &notes, err
 }
 
-func (r *NoteRepository) DeleteNoteById(ctx context.Context, id uint) error {
-	count, err := gorm.G[models.Note](r.db).Where("id = ?", id).Delete(ctx)
+// UpdateNote updates title and body of the note and replaces its tags with note.Tags, provided that the
+// stored note still has note.Version. On success note.Version is set to the new version, otherwise
+// ErrNoteVersionMismatch is returned.
+func (r *NoteRepository) UpdateNote(ctx context.Context, note *models.Note) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&models.Note{}).Where("id = ? AND version = ?", note.ID, note.Version).
+			Updates(map[string]any{"title": note.Title, "body": note.Body, "version": gorm.Expr("version + 1")})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected != 1 {
+			return ErrNoteVersionMismatch
+		}
+		note.Version++
+
+		tags := note.Tags
+		if tags == nil {
+			tags = []models.Tag{}
+		}
+		return tx.Model(note).Omit("Tags.*").Association("Tags").Replace(tags)
+	})
+}
+
+// DeleteNote moves the note to the trash, provided that the stored note still has note.Version.
+// Otherwise ErrNoteVersionMismatch is returned.
+func (r *NoteRepository) DeleteNote(ctx context.Context, note *models.Note) error {
+	count, err := gorm.G[models.Note](r.db).Where("id = ? AND version = ?", note.ID, note.Version).Delete(ctx)
 	if err == nil && count != 1 {
-		msg := fmt.Sprintf("unexpected count for deleting note. expected 1, received %d", count)
-		return errors.New(msg)
+		return ErrNoteVersionMismatch
 	}
 	return err
 
@@ -82,3 +185,19 @@ func (r *NoteRepository) DeleteNotesOfUserByUserID(ctx context.Context, id uint)
 	return count, err
 
 }
+
+// MoveNote puts the note into the notebook with the given id, or at the top level if notebookId is nil,
+// provided that the stored note still has note.Version. Otherwise ErrNoteVersionMismatch is returned.
+func (r *NoteRepository) MoveNote(ctx context.Context, note *models.Note, notebookId *uint) error {
+	tx := r.db.WithContext(ctx).Model(&models.Note{}).Where("id = ? AND version = ?", note.ID, note.Version).
+		Updates(map[string]any{"notebook_id": notebookId, "version": gorm.Expr("version + 1")})
+	if tx.Error != nil {
+		return tx.Error
+	}
+	if tx.RowsAffected != 1 {
+		return ErrNoteVersionMismatch
+	}
+	note.NotebookID = notebookId
+	note.Version++
+	return nil
+}
@@ -0,0 +1,98 @@
+package repositories
+
+import (
+	"context"
+	"user-notes-api/models"
+
+	"gorm.io/gorm"
+)
+
+type NoteRevisionReader interface {
+	FindRevisionsByNoteId(ctx context.Context, noteId uint) (*[]models.NoteRevision, error)
+	FindRevision(ctx context.Context, noteId uint, revision uint) (*models.NoteRevision, error)
+}
+
+// NoteRevisionCreator creates and updates notes together with a revision of their new title and body, in
+// one transaction so that the history of a note has no gaps. Both return the number of the new revision.
+type NoteRevisionCreator interface {
+	CreateNoteWithRevision(ctx context.Context, note *models.Note, authorId uint) (uint, error)
+	UpdateNoteWithRevision(ctx context.Context, note *models.Note, authorId uint) (uint, error)
+}
+
+// NoteRevisionRepository stores the revisions of notes. If limit is greater than 0, only the newest limit
+// revisions of each note are kept.
+type NoteRevisionRepository struct {
+	db    *gorm.DB
+	limit int
+}
+
+func NewNoteRevisionRepository(db *gorm.DB, limit int) *NoteRevisionRepository {
+	return &NoteRevisionRepository{db: db, limit: limit}
+}
+
+// FindRevisionsByNoteId returns the revisions of a note, newest first.
+func (r *NoteRevisionRepository) FindRevisionsByNoteId(ctx context.Context, noteId uint) (*[]models.NoteRevision, error) {
+	revisions, err := gorm.G[models.NoteRevision](r.db).Where("note_id = ?", noteId).Order("revision DESC").Find(ctx)
+	return &revisions, err
+}
+
+func (r *NoteRevisionRepository) FindRevision(ctx context.Context, noteId uint, revision uint) (*models.NoteRevision, error) {
+	note_revision, err := gorm.G[models.NoteRevision](r.db).Where("note_id = ? AND revision = ?", noteId, revision).First(ctx)
+	return &note_revision, err
+}
+
+// CreateNoteWithRevision creates the note like NoteRepository.CreateNote and records it as its first revision.
+func (r *NoteRevisionRepository) CreateNoteWithRevision(ctx context.Context, note *models.Note, authorId uint) (uint, error) {
+	var revision models.NoteRevision
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := NewNoteRepository(tx).CreateNote(ctx, note)
+		if err != nil {
+			return err
+		}
+
+		revision = models.NoteRevision{NoteID: note.ID, Title: note.Title, Body: note.Body, AuthorID: authorId}
+		return r.createRevision(ctx, tx, &revision)
+	})
+	return revision.Revision, err
+}
+
+// UpdateNoteWithRevision updates the note like NoteRepository.UpdateNote, including its check of the
+// version, and appends its new title and body to the history. The update locks the row of the note until
+// the transaction ends, so concurrent updates of a note number their revisions one after another.
+func (r *NoteRevisionRepository) UpdateNoteWithRevision(ctx context.Context, note *models.Note, authorId uint) (uint, error) {
+	var revision models.NoteRevision
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := NewNoteRepository(tx).UpdateNote(ctx, note)
+		if err != nil {
+			return err
+		}
+
+		revision = models.NoteRevision{NoteID: note.ID, Title: note.Title, Body: note.Body, AuthorID: authorId}
+		return r.createRevision(ctx, tx, &revision)
+	})
+	return revision.Revision, err
+}
+
+// createRevision appends the revision to the history of its note and sets its revision number. Revisions
+// beyond the retention limit are removed, oldest first. It has to run in the transaction that changed
+// the note.
+func (r *NoteRevisionRepository) createRevision(ctx context.Context, tx *gorm.DB, revision *models.NoteRevision) error {
+	var latest uint
+	err := tx.Model(&models.NoteRevision{}).Where("note_id = ?", revision.NoteID).
+		Select("COALESCE(MAX(revision), 0)").Scan(&latest).Error
+	if err != nil {
+		return err
+	}
+
+	revision.Revision = latest + 1
+	err = tx.Omit("Note", "Author").Create(revision).Error
+	if err != nil {
+		return err
+	}
+
+	if r.limit > 0 && revision.Revision > uint(r.limit) {
+		_, err = gorm.G[models.NoteRevision](tx).
+			Where("note_id = ? AND revision <= ?", revision.NoteID, revision.Revision-uint(r.limit)).Delete(ctx)
+	}
+	return err
+}
@@ -0,0 +1,140 @@
+package repositories
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// The database wraps matching terms in these private use characters instead of <mark> tags, so the body can
+// be HTML-escaped before the tags are inserted.
+const (
+	snippetMarkStart = '\uE000'
+	snippetMarkEnd   = '\uE001'
+)
+
+// NoteSearchHit is a single note matching a full-text search. Higher ranks are better matches and the
+// snippet is an HTML-escaped excerpt of the body with the matching terms wrapped in <mark> tags.
+type NoteSearchHit struct {
+	ID      uint
+	Title   string
+	Snippet string
+	Rank    float64
+}
+
+// MigrateNoteSearch creates the full-text index on the notes table. It has to run after the notes table
+// was migrated. Postgres uses a generated tsvector column with a GIN index, SQLite an FTS5 table that is
+// kept up to date by triggers (this requires building with the sqlite_fts5 tag).
+func MigrateNoteSearch(db *gorm.DB) error {
+	var statements []string
+	switch db.Dialector.Name() {
+	case "postgres":
+		statements = []string{
+			`ALTER TABLE notes ADD COLUMN IF NOT EXISTS search_vector tsvector GENERATED ALWAYS AS (
+				setweight(to_tsvector('english', coalesce(title, '')), 'A') ||
+				setweight(to_tsvector('english', coalesce(body, '')), 'B')) STORED`,
+			`CREATE INDEX IF NOT EXISTS idx_notes_search_vector ON notes USING GIN (search_vector)`,
+		}
+	case "sqlite":
+		statements = []string{
+			`CREATE VIRTUAL TABLE IF NOT EXISTS notes_fts USING fts5(title, body, content='notes', content_rowid='id')`,
+			`CREATE TRIGGER IF NOT EXISTS notes_fts_insert AFTER INSERT ON notes BEGIN
+				INSERT INTO notes_fts(rowid, title, body) VALUES (new.id, new.title, new.body);
+			END`,
+			`CREATE TRIGGER IF NOT EXISTS notes_fts_delete AFTER DELETE ON notes BEGIN
+				INSERT INTO notes_fts(notes_fts, rowid, title, body) VALUES ('delete', old.id, old.title, old.body);
+			END`,
+			`CREATE TRIGGER IF NOT EXISTS notes_fts_update AFTER UPDATE ON notes BEGIN
+				INSERT INTO notes_fts(notes_fts, rowid, title, body) VALUES ('delete', old.id, old.title, old.body);
+				INSERT INTO notes_fts(rowid, title, body) VALUES (new.id, new.title, new.body);
+			END`,
+			`INSERT INTO notes_fts(notes_fts) VALUES ('rebuild')`,
+		}
+	default:
+		return fmt.Errorf("migrate note search: unsupported dialect %q", db.Dialector.Name())
+	}
+
+	for _, statement := range statements {
+		err := db.Exec(statement).Error
+		if err != nil {
+			return fmt.Errorf("migrate note search: %w", err)
+		}
+	}
+	return nil
+}
+
+func (r *NoteRepository) SearchNotes(ctx context.Context, userId uint, query string, limit int) (*[]NoteSearchHit, error) {
+	hits := []NoteSearchHit{}
+	var tx *gorm.DB
+
+	switch r.db.Dialector.Name() {
+	case "postgres":
+		tx = r.db.WithContext(ctx).Raw(`SELECT notes.id, notes.title,
+				ts_headline('english', notes.body, q, 'StartSel=' || ? || ', StopSel=' || ? || ', MaxWords=35, MinWords=15') AS snippet,
+				ts_rank(notes.search_vector, q) AS rank
+			FROM notes, websearch_to_tsquery('english', ?) q
+			WHERE notes.user_id = ? AND notes.deleted_at IS NULL AND notes.search_vector @@ q
+			ORDER BY rank DESC, notes.id DESC
+			LIMIT ?`, string(snippetMarkStart), string(snippetMarkEnd), query, userId, limit).Scan(&hits)
+	case "sqlite":
+		match := ftsMatchExpression(query)
+		if match == "" {
+			return &hits, nil
+		}
+		// bm25 returns lower values for better matches, title matches weigh more than body matches
+		tx = r.db.WithContext(ctx).Raw(`SELECT notes.id, notes.title,
+				snippet(notes_fts, 1, ?, ?, '...', 16) AS snippet,
+				-bm25(notes_fts, 10.0, 1.0) AS rank
+			FROM notes_fts JOIN notes ON notes.id = notes_fts.rowid
+			WHERE notes_fts MATCH ? AND notes.user_id = ? AND notes.deleted_at IS NULL
+			ORDER BY rank DESC, notes.id DESC
+			LIMIT ?`, string(snippetMarkStart), string(snippetMarkEnd), match, userId, limit).Scan(&hits)
+	default:
+		return &hits, fmt.Errorf("search notes: unsupported dialect %q", r.db.Dialector.Name())
+	}
+
+	for i := range hits {
+		hits[i].Snippet = highlightSnippet(hits[i].Snippet)
+	}
+	return &hits, tx.Error
+}
+
+// highlightSnippet HTML-escapes a snippet and replaces the marks of the database with <mark> tags. Mark
+// characters that were part of the body are dropped, so the tags are always balanced.
+func highlightSnippet(snippet string) string {
+	var highlighted strings.Builder
+	marked := false
+	for _, r := range html.EscapeString(snippet) {
+		switch r {
+		case snippetMarkStart:
+			if !marked {
+				highlighted.WriteString("<mark>")
+			}
+			marked = true
+		case snippetMarkEnd:
+			if marked {
+				highlighted.WriteString("</mark>")
+			}
+			marked = false
+		default:
+			highlighted.WriteRune(r)
+		}
+	}
+	if marked {
+		highlighted.WriteString("</mark>")
+	}
+	return highlighted.String()
+}
+
+// ftsMatchExpression turns free text into an FTS5 query that matches notes containing all of the words,
+// so that user input cannot produce FTS5 syntax errors.
+func ftsMatchExpression(query string) string {
+	var terms []string
+	for _, word := range strings.Fields(query) {
+		terms = append(terms, `"`+strings.ReplaceAll(word, `"`, `""`)+`"`)
+	}
+	return strings.Join(terms, " ")
+}
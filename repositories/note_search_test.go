@@ -0,0 +1,118 @@
+package repositories
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"user-notes-api/models"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNoteSearch(t *testing.T) {
+	db := prepareDatabase(t)
+	ctx := context.Background()
+
+	err := MigrateNoteSearch(db)
+	if err != nil && strings.Contains(err.Error(), "no such module: fts5") {
+		t.Skip("sqlite driver built without FTS5, run the tests with -tags sqlite_fts5")
+	}
+	assert.NoError(t, err)
+
+	userRepo := UserRepository{db: db}
+	noteRepo := NoteRepository{db: db}
+
+	user := models.User{Username: "Alice", Password: "pwd"}
+	err = userRepo.CreateUser(ctx, &user)
+	assert.NoError(t, err)
+
+	other_user := models.User{Username: "Bob", Password: "pwd"}
+	err = userRepo.CreateUser(ctx, &other_user)
+	assert.NoError(t, err)
+
+	groceries := models.Note{Title: "Groceries", Body: "Buy milk, eggs and bread", UserID: user.ID, User: user}
+	recipe := models.Note{Title: "Bread recipe", Body: "Flour, water, salt and yeast", UserID: user.ID, User: user}
+	other := models.Note{Title: "Bread", Body: "Bob also likes bread", UserID: other_user.ID, User: other_user}
+	for _, note := range []*models.Note{&groceries, &recipe, &other} {
+		err = noteRepo.CreateNote(ctx, note)
+		assert.NoError(t, err)
+	}
+
+	// Results are scoped to the user and title matches rank higher
+	hits, err := noteRepo.SearchNotes(ctx, user.ID, "bread", 10)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(*hits))
+	assert.Equal(t, recipe.ID, (*hits)[0].ID)
+	assert.Equal(t, groceries.ID, (*hits)[1].ID)
+	assert.True(t, (*hits)[0].Rank > (*hits)[1].Rank)
+	assert.Contains(t, (*hits)[1].Snippet, "<mark>bread</mark>")
+
+	// The body is HTML-escaped, only the marks are tags
+	script := models.Note{Title: "Script", Body: `<script>alert("xss")</script> & <b>bold</b> alert`, UserID: user.ID, User: user}
+	err = noteRepo.CreateNote(ctx, &script)
+	assert.NoError(t, err)
+
+	hits, err = noteRepo.SearchNotes(ctx, user.ID, "alert", 10)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(*hits))
+	assert.Equal(t, "&lt;script&gt;<mark>alert</mark>(&#34;xss&#34;)&lt;/script&gt; &amp; &lt;b&gt;bold&lt;/b&gt; <mark>alert</mark>",
+		(*hits)[0].Snippet)
+	assert.NotContains(t, (*hits)[0].Snippet, "<script>")
+
+	err = noteRepo.DeleteNote(ctx, &script)
+	assert.NoError(t, err)
+
+	// All words have to match
+	hits, err = noteRepo.SearchNotes(ctx, user.ID, "milk bread", 10)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(*hits))
+	assert.Equal(t, groceries.ID, (*hits)[0].ID)
+
+	// FTS5 syntax in the query is treated as text
+	hits, err = noteRepo.SearchNotes(ctx, user.ID, `"milk OR (yeast`, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(*hits))
+
+	hits, err = noteRepo.SearchNotes(ctx, user.ID, `"milk" AND (`, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(*hits))
+
+	// The index follows updates and deletes
+	groceries.Body = "Buy cheese"
+	err = noteRepo.UpdateNote(ctx, &groceries)
+	assert.NoError(t, err)
+
+	hits, err = noteRepo.SearchNotes(ctx, user.ID, "milk", 10)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(*hits))
+
+	hits, err = noteRepo.SearchNotes(ctx, user.ID, "cheese", 10)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(*hits))
+
+	err = noteRepo.DeleteNote(ctx, &recipe)
+	assert.NoError(t, err)
+
+	hits, err = noteRepo.SearchNotes(ctx, user.ID, "yeast", 10)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(*hits))
+
+	// Limit
+	hits, err = noteRepo.SearchNotes(ctx, other_user.ID, "bread", 1)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(*hits))
+
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB.Close()
+}
+
+func TestHighlightSnippet(t *testing.T) {
+	assert.Equal(t, "a &lt;i&gt;<mark>b</mark>&lt;/i&gt;", highlightSnippet("a <i>\uE000b\uE001</i>"))
+	// marks that were part of the body cannot unbalance the tags
+	assert.Equal(t, "<mark>a</mark>b<mark>c</mark>", highlightSnippet("\uE000\uE000a\uE001b\uE001\uE000c"))
+	assert.Equal(t, "plain", highlightSnippet("plain"))
+}
@@ -0,0 +1,107 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+	"user-notes-api/models"
+
+	"gorm.io/gorm"
+)
+
+// NoteTrashReader finds soft-deleted notes. The generic gorm API has no Unscoped, so the trash queries
+// use the classic API.
+type NoteTrashReader interface {
+	FindDeletedNotesByUserId(ctx context.Context, userId uint) (*[]models.Note, error)
+	FindDeletedNoteById(ctx context.Context, id uint) (*models.Note, error)
+}
+
+type NoteRestorer interface {
+	RestoreNoteById(ctx context.Context, id uint) error
+}
+
+type NotePurger interface {
+	PurgeNoteById(ctx context.Context, id uint) error
+	PurgeNotesDeletedBefore(ctx context.Context, before time.Time) (int, error)
+}
+
+// FindDeletedNotesByUserId returns the notes in the trash of a user, most recently deleted first.
+func (r *NoteRepository) FindDeletedNotesByUserId(ctx context.Context, userId uint) (*[]models.Note, error) {
+	notes := []models.Note{}
+	tx := r.db.WithContext(ctx).Unscoped().Where("user_id = ? AND deleted_at IS NOT NULL", userId).
+		Order("deleted_at DESC").Order("id DESC").Find(&notes)
+	return &notes, tx.Error
+}
+
+func (r *NoteRepository) FindDeletedNoteById(ctx context.Context, id uint) (*models.Note, error) {
+	note := models.Note{}
+	tx := r.db.WithContext(ctx).Unscoped().Where("id = ? AND deleted_at IS NOT NULL", id).First(&note)
+	return &note, tx.Error
+}
+
+// RestoreNoteById takes the note out of the trash. If its notebook was deleted in the meantime, the note
+// is restored at the top level with a new version.
+func (r *NoteRepository) RestoreNoteById(ctx context.Context, id uint) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		result := tx.Unscoped().Model(&models.Note{}).Where("id = ? AND deleted_at IS NOT NULL", id).Update("deleted_at", nil)
+		if result.Error == nil && result.RowsAffected != 1 {
+			msg := fmt.Sprintf("unexpected count for restoring note. expected 1, received %d", result.RowsAffected)
+			return errors.New(msg)
+		}
+		if result.Error != nil {
+			return result.Error
+		}
+
+		deleted_notebooks := tx.Unscoped().Model(&models.Notebook{}).Select("id").Where("deleted_at IS NOT NULL")
+		return tx.Model(&models.Note{}).Where("id = ? AND notebook_id IN (?)", id, deleted_notebooks).
+			Updates(map[string]any{"notebook_id": nil, "version": gorm.Expr("version + 1")}).Error
+	})
+}
+
+// PurgeNoteById permanently deletes a note from the trash together with its tag assignments and revisions.
+func (r *NoteRepository) PurgeNoteById(ctx context.Context, id uint) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		count, err := purgeNotes(tx, tx.Unscoped().Model(&models.Note{}).Select("id").Where("id = ? AND deleted_at IS NOT NULL", id))
+		if err == nil && count != 1 {
+			msg := fmt.Sprintf("unexpected count for purging note. expected 1, received %d", count)
+			return errors.New(msg)
+		}
+		return err
+	})
+}
+
+// PurgeNotesDeletedBefore permanently deletes all notes that were moved to the trash before the given time
+// and returns their number.
+func (r *NoteRepository) PurgeNotesDeletedBefore(ctx context.Context, before time.Time) (int, error) {
+	var count int
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var err error
+		count, err = purgeNotes(tx, tx.Unscoped().Model(&models.Note{}).Select("id").Where("deleted_at < ?", before))
+		return err
+	})
+	return count, err
+}
+
+// purgeNotes hard deletes the notes whose ids are selected by the subquery. The rows referencing the
+// notes are removed explicitly, since SQLite only enforces foreign keys if they are enabled.
+func purgeNotes(tx *gorm.DB, ids *gorm.DB) (int, error) {
+	var note_ids []uint
+	err := ids.Scan(&note_ids).Error
+	if err != nil || len(note_ids) == 0 {
+		return 0, err
+	}
+
+	err = tx.Exec("DELETE FROM note_tags WHERE note_id IN ?", note_ids).Error
+	if err != nil {
+		return 0, err
+	}
+
+	err = tx.Where("note_id IN ?", note_ids).Delete(&models.NoteRevision{}).Error
+	if err != nil {
+		return 0, err
+	}
+
+	result := tx.Unscoped().Where("id IN ?", note_ids).Delete(&models.Note{})
+	return int(result.RowsAffected), result.Error
+}
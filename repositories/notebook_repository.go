@@ -0,0 +1,147 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"user-notes-api/models"
+
+	"gorm.io/gorm"
+)
+
+// NotebookDeleteMode decides what happens to the notes and notebooks inside a deleted notebook.
+type NotebookDeleteMode string
+
+const (
+	// NotebookDeleteMoveToParent moves the contents of the notebook to its parent, or to the top level
+	// if the notebook has no parent.
+	NotebookDeleteMoveToParent NotebookDeleteMode = "move"
+	// NotebookDeleteContents deletes all notes and notebooks inside the notebook, recursively.
+	NotebookDeleteContents NotebookDeleteMode = "delete"
+)
+
+type NotebookReader interface {
+	FindNotebookById(ctx context.Context, id uint) (*models.Notebook, error)
+	FindNotebooksByUserId(ctx context.Context, userId uint) (*[]models.Notebook, error)
+	FindNotebookTree(ctx context.Context, id uint) (*[]models.Notebook, error)
+	FindNotesInNotebooks(ctx context.Context, ids []uint) (*[]models.Note, error)
+}
+
+type NotebookCreator interface {
+	CreateNotebook(ctx context.Context, notebook *models.Notebook) error
+}
+
+type NotebookUpdater interface {
+	UpdateNotebook(ctx context.Context, notebook *models.Notebook) error
+}
+
+type NotebookDeleter interface {
+	DeleteNotebookById(ctx context.Context, id uint, mode NotebookDeleteMode) error
+}
+
+type NotebookRepository struct {
+	db *gorm.DB
+}
+
+func NewNotebookRepository(db *gorm.DB) *NotebookRepository {
+	return &NotebookRepository{db: db}
+}
+
+func (r *NotebookRepository) CreateNotebook(ctx context.Context, notebook *models.Notebook) error {
+	tx := r.db.WithContext(ctx).Omit("User").Create(notebook)
+
+	if tx.Error == nil && tx.RowsAffected != 1 {
+		return errors.New("number of affected rows not equal to 1")
+	}
+
+	return tx.Error
+}
+
+func (r *NotebookRepository) FindNotebookById(ctx context.Context, id uint) (*models.Notebook, error) {
+	notebook, err := gorm.G[models.Notebook](r.db).Where("id = ?", id).First(ctx)
+	return &notebook, err
+}
+
+func (r *NotebookRepository) FindNotebooksByUserId(ctx context.Context, userId uint) (*[]models.Notebook, error) {
+	notebooks, err := gorm.G[models.Notebook](r.db).Where("user_id = ?", userId).Order("name").Order("id").Find(ctx)
+	return &notebooks, err
+}
+
+// FindNotebookTree returns the notebook with the given id together with all notebooks nested inside of it.
+func (r *NotebookRepository) FindNotebookTree(ctx context.Context, id uint) (*[]models.Notebook, error) {
+	notebooks := []models.Notebook{}
+	tx := r.db.WithContext(ctx).Raw(`WITH RECURSIVE tree(id) AS (
+			SELECT id FROM notebooks WHERE id = ? AND deleted_at IS NULL
+			UNION
+			SELECT notebooks.id FROM notebooks JOIN tree ON notebooks.parent_id = tree.id
+			WHERE notebooks.deleted_at IS NULL
+		)
+		SELECT * FROM notebooks WHERE id IN (SELECT id FROM tree) ORDER BY name, id`, id).Scan(&notebooks)
+	return &notebooks, tx.Error
+}
+
+func (r *NotebookRepository) FindNotesInNotebooks(ctx context.Context, ids []uint) (*[]models.Note, error) {
+	notes, err := gorm.G[models.Note](r.db).Where("notebook_id IN ?", ids).Order("title").Order("id").Find(ctx)
+	return &notes, err
+}
+
+// UpdateNotebook updates name and parent of the notebook.
+func (r *NotebookRepository) UpdateNotebook(ctx context.Context, notebook *models.Notebook) error {
+	count, err := gorm.G[models.Notebook](r.db).Where("id = ?", notebook.ID).Select("name", "parent_id").Updates(ctx, *notebook)
+	if err == nil && count != 1 {
+		msg := fmt.Sprintf("unexpected count for updating notebook. expected 1, received %d", count)
+		return errors.New(msg)
+	}
+	return err
+}
+
+func (r *NotebookRepository) DeleteNotebookById(ctx context.Context, id uint, mode NotebookDeleteMode) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		notebook, err := gorm.G[models.Notebook](tx).Where("id = ?", id).First(ctx)
+		if err != nil {
+			return err
+		}
+
+		switch mode {
+		case NotebookDeleteMoveToParent:
+			err = tx.Model(&models.Notebook{}).Where("parent_id = ?", id).Update("parent_id", notebook.ParentID).Error
+			if err != nil {
+				return err
+			}
+			err = tx.Model(&models.Note{}).Where("notebook_id = ?", id).
+				Updates(map[string]any{"notebook_id": notebook.ParentID, "version": gorm.Expr("version + 1")}).Error
+			if err != nil {
+				return err
+			}
+			return deleteNotebooks(ctx, tx, []uint{id})
+		case NotebookDeleteContents:
+			var ids []uint
+			err = tx.Raw(`WITH RECURSIVE tree(id) AS (
+					SELECT id FROM notebooks WHERE id = ?
+					UNION
+					SELECT notebooks.id FROM notebooks JOIN tree ON notebooks.parent_id = tree.id
+					WHERE notebooks.deleted_at IS NULL
+				)
+				SELECT id FROM tree`, id).Scan(&ids).Error
+			if err != nil {
+				return err
+			}
+			_, err = gorm.G[models.Note](tx).Where("notebook_id IN ?", ids).Delete(ctx)
+			if err != nil {
+				return err
+			}
+			return deleteNotebooks(ctx, tx, ids)
+		default:
+			return fmt.Errorf("unsupported notebook delete mode %q", mode)
+		}
+	})
+}
+
+func deleteNotebooks(ctx context.Context, tx *gorm.DB, ids []uint) error {
+	count, err := gorm.G[models.Notebook](tx).Where("id IN ?", ids).Delete(ctx)
+	if err == nil && count != len(ids) {
+		msg := fmt.Sprintf("unexpected count for deleting notebooks. expected %d, received %d", len(ids), count)
+		return errors.New(msg)
+	}
+	return err
+}
@@ -0,0 +1,61 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"user-notes-api/models"
+
+	"gorm.io/gorm"
+)
+
+type OidcIdentityReader interface {
+	FindUserByOidcIdentity(ctx context.Context, issuer string, subject string) (*models.User, error)
+}
+
+type OidcIdentityCreator interface {
+	CreateUserWithOidcIdentity(ctx context.Context, user *models.User, issuer string, subject string) error
+}
+
+// ErrOidcIdentityNotFound is returned by FindUserByOidcIdentity if no user is linked to the identity yet.
+var ErrOidcIdentityNotFound = errors.New("no user with this oidc identity")
+
+// ErrOidcIdentityLinked is returned by CreateUserWithOidcIdentity if the identity already belongs to a user,
+// e.g. because it was created by a concurrent login.
+var ErrOidcIdentityLinked = errors.New("oidc identity is already linked to a user")
+
+type OidcIdentityRepository struct {
+	db *gorm.DB
+}
+
+func NewOidcIdentityRepository(db *gorm.DB) *OidcIdentityRepository {
+	return &OidcIdentityRepository{db: db}
+}
+
+// FindUserByOidcIdentity finds the user linked to the account with the subject at the issuer.
+func (r *OidcIdentityRepository) FindUserByOidcIdentity(ctx context.Context, issuer string, subject string) (*models.User, error) {
+	user, err := gorm.G[models.User](r.db).
+		Where("id = (SELECT user_id FROM oidc_identities WHERE issuer = ? AND subject = ?)", issuer, subject).First(ctx)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, fmt.Errorf("%w: %w", ErrOidcIdentityNotFound, err)
+	}
+	return &user, err
+}
+
+// CreateUserWithOidcIdentity creates the user like UserRepository.CreateUser and links the identity to it,
+// both in one transaction.
+func (r *OidcIdentityRepository) CreateUserWithOidcIdentity(ctx context.Context, user *models.User, issuer string, subject string) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := NewUserRepository(tx).CreateUser(ctx, user)
+		if err != nil {
+			return err
+		}
+
+		identity := models.OidcIdentity{Issuer: issuer, Subject: subject, UserID: user.ID}
+		err = tx.Omit("User").Create(&identity).Error
+		if errors.Is(translateError(tx, err), gorm.ErrDuplicatedKey) {
+			return ErrOidcIdentityLinked
+		}
+		return err
+	})
+}
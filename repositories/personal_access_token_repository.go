@@ -0,0 +1,68 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"time"
+	"user-notes-api/models"
+
+	"gorm.io/gorm"
+)
+
+type PersonalAccessTokenReader interface {
+	FindPersonalAccessTokenByHash(ctx context.Context, tokenHash string) (*models.PersonalAccessToken, error)
+	FindPersonalAccessTokens(ctx context.Context, userId uint) ([]models.PersonalAccessToken, error)
+}
+
+type PersonalAccessTokenCreator interface {
+	CreatePersonalAccessToken(ctx context.Context, token *models.PersonalAccessToken) error
+}
+
+type PersonalAccessTokenUpdater interface {
+	TouchPersonalAccessToken(ctx context.Context, id uint, usedAt time.Time) error
+	DeletePersonalAccessToken(ctx context.Context, userId uint, id uint) error
+}
+
+type PersonalAccessTokenRepository struct {
+	db *gorm.DB
+}
+
+func NewPersonalAccessTokenRepository(db *gorm.DB) *PersonalAccessTokenRepository {
+	return &PersonalAccessTokenRepository{db: db}
+}
+
+// FindPersonalAccessTokenByHash returns the token with the given hash together with its user.
+func (r *PersonalAccessTokenRepository) FindPersonalAccessTokenByHash(ctx context.Context, tokenHash string) (*models.PersonalAccessToken, error) {
+	token, err := gorm.G[models.PersonalAccessToken](r.db).Preload("User", nil).Where("token_hash = ?", tokenHash).First(ctx)
+	return &token, err
+}
+
+// FindPersonalAccessTokens returns the tokens of the user, newest first.
+func (r *PersonalAccessTokenRepository) FindPersonalAccessTokens(ctx context.Context, userId uint) ([]models.PersonalAccessToken, error) {
+	return gorm.G[models.PersonalAccessToken](r.db).Where("user_id = ?", userId).Order("created_at DESC, id DESC").Find(ctx)
+}
+
+func (r *PersonalAccessTokenRepository) CreatePersonalAccessToken(ctx context.Context, token *models.PersonalAccessToken) error {
+	tx := r.db.WithContext(ctx).Omit("User").Create(token)
+
+	if tx.Error == nil && tx.RowsAffected != 1 {
+		return errors.New("number of affected rows not equal to 1")
+	}
+
+	return tx.Error
+}
+
+// TouchPersonalAccessToken records when the token was last used.
+func (r *PersonalAccessTokenRepository) TouchPersonalAccessToken(ctx context.Context, id uint, usedAt time.Time) error {
+	return r.db.WithContext(ctx).Model(&models.PersonalAccessToken{}).Where("id = ?", id).Update("last_used_at", usedAt).Error
+}
+
+// DeletePersonalAccessToken deletes the token with the given id if it belongs to the user, otherwise
+// gorm.ErrRecordNotFound is returned.
+func (r *PersonalAccessTokenRepository) DeletePersonalAccessToken(ctx context.Context, userId uint, id uint) error {
+	count, err := gorm.G[models.PersonalAccessToken](r.db).Where("id = ? AND user_id = ?", id, userId).Delete(ctx)
+	if err == nil && count == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return err
+}
@@ -0,0 +1,74 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"time"
+	"user-notes-api/models"
+
+	"gorm.io/gorm"
+)
+
+type RefreshTokenReader interface {
+	FindRefreshTokenByHash(ctx context.Context, tokenHash string) (*models.RefreshToken, error)
+}
+
+type RefreshTokenCreator interface {
+	CreateRefreshToken(ctx context.Context, token *models.RefreshToken) error
+}
+
+type RefreshTokenUpdater interface {
+	RotateRefreshToken(ctx context.Context, oldId uint, token *models.RefreshToken) error
+	RevokeRefreshTokenFamily(ctx context.Context, familyId string) error
+}
+
+// ErrRefreshTokenUsed is returned by RotateRefreshToken if the old token was already rotated or revoked.
+var ErrRefreshTokenUsed = errors.New("refresh token was already used")
+
+type RefreshTokenRepository struct {
+	db *gorm.DB
+}
+
+func NewRefreshTokenRepository(db *gorm.DB) *RefreshTokenRepository {
+	return &RefreshTokenRepository{db: db}
+}
+
+// FindRefreshTokenByHash returns the token with the given hash together with its user.
+func (r *RefreshTokenRepository) FindRefreshTokenByHash(ctx context.Context, tokenHash string) (*models.RefreshToken, error) {
+	token, err := gorm.G[models.RefreshToken](r.db).Preload("User", nil).Where("token_hash = ?", tokenHash).First(ctx)
+	return &token, err
+}
+
+func (r *RefreshTokenRepository) CreateRefreshToken(ctx context.Context, token *models.RefreshToken) error {
+	tx := r.db.WithContext(ctx).Omit("User").Create(token)
+
+	if tx.Error == nil && tx.RowsAffected != 1 {
+		return errors.New("number of affected rows not equal to 1")
+	}
+
+	return tx.Error
+}
+
+// RotateRefreshToken marks the token with the given id as rotated and creates its successor. Marking the
+// old token is conditional, so a token can only be rotated once even by concurrent requests. If it was
+// already rotated or revoked, ErrRefreshTokenUsed is returned and no token is created.
+func (r *RefreshTokenRepository) RotateRefreshToken(ctx context.Context, oldId uint, token *models.RefreshToken) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&models.RefreshToken{}).Where("id = ? AND rotated_at IS NULL AND revoked_at IS NULL", oldId).
+			Update("rotated_at", time.Now())
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected != 1 {
+			return ErrRefreshTokenUsed
+		}
+
+		return tx.Omit("User").Create(token).Error
+	})
+}
+
+// RevokeRefreshTokenFamily revokes all tokens of the family, so none of them can be used anymore.
+func (r *RefreshTokenRepository) RevokeRefreshTokenFamily(ctx context.Context, familyId string) error {
+	return r.db.WithContext(ctx).Model(&models.RefreshToken{}).Where("family_id = ? AND revoked_at IS NULL", familyId).
+		Update("revoked_at", time.Now()).Error
+}
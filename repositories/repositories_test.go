@@ -2,7 +2,10 @@ package repositories
 
 import (
 	"context"
+	"errors"
+	"strconv"
 	"testing"
+	"time"
 
 	"user-notes-api/models"
 
@@ -20,7 +23,10 @@ func prepareDatabase(t *testing.T) (db *gorm.DB) {
 	db.Exec("PRAGMA foreign_keys = ON;")
 
 	db.AutoMigrate(&models.User{})
-	db.AutoMigrate(&models.Note{})
+	db.AutoMigrate(&models.Notebook{}, &models.Note{}, &models.Tag{}, &models.NoteRevision{}, &models.RefreshToken{}, &models.RevokedToken{}, &models.LoginAttempt{}, &models.PersonalAccessToken{}, &models.RecoveryCode{}, &models.OidcIdentity{})
+	if err := MigrateUsernameKeys(db); err != nil {
+		t.Fatal("Failed to migrate username keys:", err)
+	}
 
 	return db
 }
@@ -77,9 +83,9 @@ func TestUserRepository(t *testing.T) {
 	// Update user
 	// Delete user via Id
 	id = user.ID
-	count, err := userRepo.DeleteUserById(ctx, id)
+	deletion, err := userRepo.DeleteUserById(ctx, id)
 	assert.NoError(t, err)
-	assert.Equal(t, 0, count)
+	assert.Equal(t, 0, deletion.Notes)
 
 	_, err = userRepo.FindUserById(ctx, id)
 	assert.Error(t, err)
@@ -159,15 +165,34 @@ func TestNoteRepository(t *testing.T) {
 	// Find by list of Ids?
 
 	// Update
-	// Delete via id
+	note1.Title = "UpdatedTitle1"
+	note1.Body = ""
+	err = noteRepo.UpdateNote(ctx, &note1)
+	assert.NoError(t, err)
+
+	note_read, err = noteRepo.FindNoteById(ctx, note1.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, "UpdatedTitle1", note_read.Title)
+	assert.Equal(t, "", note_read.Body)
+	assert.Equal(t, user.ID, note_read.UserID)
+
+	// Updating a note that does not exist results in error
+	err = noteRepo.UpdateNote(ctx, &models.Note{Model: gorm.Model{ID: note2.ID + 1}, Title: "Title"})
+	assert.Error(t, err)
+
+	// Deleting a note with an outdated version fails
+	stale := note1
+	stale.Version--
+	err = noteRepo.DeleteNote(ctx, &stale)
+	assert.ErrorIs(t, err, ErrNoteVersionMismatch)
+
 	id := note1.ID
-	err = noteRepo.DeleteNoteById(ctx, id)
+	err = noteRepo.DeleteNote(ctx, &note1)
 	assert.NoError(t, err)
 
 	_, err = noteRepo.FindNoteById(ctx, id)
 	assert.Error(t, err)
 
-	// Delete via note object
 	id = note2.ID
 	err = noteRepo.DeleteNote(ctx, &note2)
 	assert.NoError(t, err)
@@ -210,9 +235,9 @@ func TestCascadingDelete(t *testing.T) {
 	user_id := user.ID
 
 	// Delete user by id
-	count, err := userRepo.DeleteUserById(ctx, user.ID)
+	deletion, err := userRepo.DeleteUserById(ctx, user.ID)
 	assert.NoError(t, err)
-	assert.Equal(t, 2, count)
+	assert.Equal(t, 2, deletion.Notes)
 
 	_, err = userRepo.FindUserById(ctx, user_id)
 	assert.Error(t, err)
@@ -262,3 +287,1175 @@ func TestCascadingDelete(t *testing.T) {
 	}
 	sqlDB.Close()
 }
+
+func TestNoteRepositoryPaging(t *testing.T) {
+	db := prepareDatabase(t)
+	ctx := context.Background()
+
+	userRepo := UserRepository{db: db}
+	noteRepo := NoteRepository{db: db}
+
+	user := models.User{Username: "Alice", Password: "pwd"}
+	err := userRepo.CreateUser(ctx, &user)
+	assert.NoError(t, err)
+
+	other_user := models.User{Username: "Bob", Password: "pwd"}
+	err = userRepo.CreateUser(ctx, &other_user)
+	assert.NoError(t, err)
+
+	// five notes with distinct timestamps, two of them share a title
+	base := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	titles := []string{"c", "a", "b", "a", "d"}
+	var notes []models.Note
+	for i, title := range titles {
+		note := models.Note{Title: title, UserID: user.ID, User: user}
+		note.CreatedAt = base.Add(time.Duration(i) * time.Hour)
+		note.UpdatedAt = base.Add(time.Duration(10-i) * time.Hour)
+		err = noteRepo.CreateNote(ctx, &note)
+		assert.NoError(t, err)
+		notes = append(notes, note)
+	}
+
+	other_note := models.Note{Title: "a", UserID: other_user.ID, User: other_user}
+	err = noteRepo.CreateNote(ctx, &other_note)
+	assert.NoError(t, err)
+
+	ids := func(page *[]models.Note) []uint {
+		var result []uint
+		for _, note := range *page {
+			result = append(result, note.ID)
+		}
+		return result
+	}
+
+	// Page through by creation date
+	query := NotePageQuery{UserId: user.ID, Limit: 2, SortField: NoteSortCreated}
+	page, err := noteRepo.FindNotesPage(ctx, query)
+	assert.NoError(t, err)
+	assert.Equal(t, []uint{notes[0].ID, notes[1].ID}, ids(page))
+
+	last := (*page)[len(*page)-1]
+	query.After = &NoteCursor{Time: last.CreatedAt, Id: last.ID}
+	page, err = noteRepo.FindNotesPage(ctx, query)
+	assert.NoError(t, err)
+	assert.Equal(t, []uint{notes[2].ID, notes[3].ID}, ids(page))
+
+	last = (*page)[len(*page)-1]
+	query.After = &NoteCursor{Time: last.CreatedAt, Id: last.ID}
+	page, err = noteRepo.FindNotesPage(ctx, query)
+	assert.NoError(t, err)
+	assert.Equal(t, []uint{notes[4].ID}, ids(page))
+
+	// Descending by update date
+	query = NotePageQuery{UserId: user.ID, Limit: 10, SortField: NoteSortUpdated, Descending: true}
+	page, err = noteRepo.FindNotesPage(ctx, query)
+	assert.NoError(t, err)
+	assert.Equal(t, []uint{notes[0].ID, notes[1].ID, notes[2].ID, notes[3].ID, notes[4].ID}, ids(page))
+
+	// Equal titles are ordered by id and the cursor continues between them
+	query = NotePageQuery{UserId: user.ID, Limit: 1, SortField: NoteSortTitle}
+	page, err = noteRepo.FindNotesPage(ctx, query)
+	assert.NoError(t, err)
+	assert.Equal(t, []uint{notes[1].ID}, ids(page))
+
+	query.After = &NoteCursor{Title: "a", Id: notes[1].ID}
+	query.Limit = 10
+	page, err = noteRepo.FindNotesPage(ctx, query)
+	assert.NoError(t, err)
+	assert.Equal(t, []uint{notes[3].ID, notes[2].ID, notes[0].ID, notes[4].ID}, ids(page))
+
+	query = NotePageQuery{UserId: user.ID, Limit: 10, SortField: NoteSortTitle, Descending: true,
+		After: &NoteCursor{Title: "b", Id: notes[2].ID}}
+	page, err = noteRepo.FindNotesPage(ctx, query)
+	assert.NoError(t, err)
+	assert.Equal(t, []uint{notes[3].ID, notes[1].ID}, ids(page))
+
+	// Filters
+	updated_since := base.Add(8 * time.Hour)
+	created_before := base.Add(1 * time.Hour)
+	query = NotePageQuery{UserId: user.ID, Limit: 10, SortField: NoteSortCreated, UpdatedSince: &updated_since}
+	page, err = noteRepo.FindNotesPage(ctx, query)
+	assert.NoError(t, err)
+	assert.Equal(t, []uint{notes[0].ID, notes[1].ID, notes[2].ID}, ids(page))
+
+	query.CreatedBefore = &created_before
+	page, err = noteRepo.FindNotesPage(ctx, query)
+	assert.NoError(t, err)
+	assert.Equal(t, []uint{notes[0].ID}, ids(page))
+
+	// Unknown sort fields are rejected
+	query = NotePageQuery{UserId: user.ID, Limit: 10, SortField: NoteSortField("body")}
+	_, err = noteRepo.FindNotesPage(ctx, query)
+	assert.Error(t, err)
+
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB.Close()
+}
+
+func TestTagRepository(t *testing.T) {
+	db := prepareDatabase(t)
+	ctx := context.Background()
+
+	userRepo := UserRepository{db: db}
+	noteRepo := NoteRepository{db: db}
+	tagRepo := TagRepository{db: db}
+
+	user := models.User{Username: "Alice", Password: "pwd"}
+	err := userRepo.CreateUser(ctx, &user)
+	assert.NoError(t, err)
+
+	other_user := models.User{Username: "Bob", Password: "pwd"}
+	err = userRepo.CreateUser(ctx, &other_user)
+	assert.NoError(t, err)
+
+	// Find or create returns existing tags and creates missing ones
+	tags, err := tagRepo.FindOrCreateTags(ctx, user.ID, []string{"work", "home"})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(*tags))
+	work, home := (*tags)[0], (*tags)[1]
+	assert.Equal(t, "work", work.Name)
+	assert.Equal(t, user.ID, work.UserID)
+
+	tags, err = tagRepo.FindOrCreateTags(ctx, user.ID, []string{"home", "ideas"})
+	assert.NoError(t, err)
+	assert.Equal(t, home.ID, (*tags)[0].ID)
+	ideas := (*tags)[1]
+
+	other_tags, err := tagRepo.FindOrCreateTags(ctx, other_user.ID, []string{"work"})
+	assert.NoError(t, err)
+	assert.NotEqual(t, work.ID, (*other_tags)[0].ID)
+
+	tag_read, err := tagRepo.FindTagByName(ctx, user.ID, "ideas")
+	assert.NoError(t, err)
+	assert.Equal(t, ideas.ID, tag_read.ID)
+
+	_, err = tagRepo.FindTagByName(ctx, other_user.ID, "ideas")
+	assert.Error(t, err)
+
+	// Notes with tags
+	note1 := models.Note{Title: "Title1", UserID: user.ID, User: user, Tags: []models.Tag{work, home}}
+	note2 := models.Note{Title: "Title2", UserID: user.ID, User: user, Tags: []models.Tag{work}}
+	note3 := models.Note{Title: "Title3", UserID: user.ID, User: user}
+	for _, note := range []*models.Note{&note1, &note2, &note3} {
+		err = noteRepo.CreateNote(ctx, note)
+		assert.NoError(t, err)
+	}
+
+	note_read, err := noteRepo.FindNoteById(ctx, note1.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(note_read.Tags))
+
+	counts, err := tagRepo.FindTagsWithNoteCount(ctx, user.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, []TagWithNoteCount{{ID: home.ID, Name: "home", NoteCount: 1}, {ID: ideas.ID, Name: "ideas", NoteCount: 0},
+		{ID: work.ID, Name: "work", NoteCount: 2}}, *counts)
+
+	// Updating a note replaces its tags
+	note3.Tags = []models.Tag{ideas, home}
+	err = noteRepo.UpdateNote(ctx, &note3)
+	assert.NoError(t, err)
+
+	note1.Tags = nil
+	err = noteRepo.UpdateNote(ctx, &note1)
+	assert.NoError(t, err)
+
+	note_read, err = noteRepo.FindNoteById(ctx, note1.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(note_read.Tags))
+
+	note_read, err = noteRepo.FindNoteById(ctx, note3.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(note_read.Tags))
+
+	// Filter pages by tags
+	note1.Tags = []models.Tag{work, home}
+	err = noteRepo.UpdateNote(ctx, &note1)
+	assert.NoError(t, err)
+
+	query := NotePageQuery{UserId: user.ID, Limit: 10, SortField: NoteSortCreated, Tags: []string{"work", "ideas"}}
+	page, err := noteRepo.FindNotesPage(ctx, query)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, len(*page))
+
+	query.Tags = []string{"work", "home"}
+	query.MatchAllTags = true
+	page, err = noteRepo.FindNotesPage(ctx, query)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(*page))
+	assert.Equal(t, note1.ID, (*page)[0].ID)
+	assert.Equal(t, 2, len((*page)[0].Tags))
+
+	// Tags of other users are not matched
+	query = NotePageQuery{UserId: other_user.ID, Limit: 10, SortField: NoteSortCreated, Tags: []string{"work"}}
+	page, err = noteRepo.FindNotesPage(ctx, query)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(*page))
+
+	// Changing tags increments the version of their notes, so their ETags change
+	versionOf := func(note models.Note) uint {
+		note_read, err := noteRepo.FindNoteById(ctx, note.ID)
+		assert.NoError(t, err)
+		return note_read.Version
+	}
+	versions := func() []uint {
+		return []uint{versionOf(note1), versionOf(note2), versionOf(note3)}
+	}
+	before := versions()
+
+	// Rename
+	err = tagRepo.RenameTag(ctx, ideas.ID, "thoughts")
+	assert.NoError(t, err)
+	assert.Equal(t, []uint{before[0], before[1], before[2] + 1}, versions())
+
+	tag_read, err = tagRepo.FindTagById(ctx, ideas.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, "thoughts", tag_read.Name)
+
+	err = tagRepo.RenameTag(ctx, ideas.ID, "work")
+	assert.Error(t, err)
+
+	// Merge home into work: note1 already has both, note3 moves from home to work
+	err = tagRepo.MergeTags(ctx, home.ID, work.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, []uint{before[0] + 1, before[1], before[2] + 2}, versions())
+
+	_, err = tagRepo.FindTagById(ctx, home.ID)
+	assert.Error(t, err)
+
+	counts, err = tagRepo.FindTagsWithNoteCount(ctx, user.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, []TagWithNoteCount{{ID: ideas.ID, Name: "thoughts", NoteCount: 1}, {ID: work.ID, Name: "work", NoteCount: 3}}, *counts)
+
+	// Delete removes the tag from its notes and the name can be reused
+	err = tagRepo.DeleteTagById(ctx, work.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, []uint{before[0] + 2, before[1] + 1, before[2] + 3}, versions())
+
+	note_read, err = noteRepo.FindNoteById(ctx, note1.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(note_read.Tags))
+
+	tags, err = tagRepo.FindOrCreateTags(ctx, user.ID, []string{"work"})
+	assert.NoError(t, err)
+	assert.NotEqual(t, work.ID, (*tags)[0].ID)
+
+	err = tagRepo.DeleteTagById(ctx, work.ID)
+	assert.Error(t, err)
+
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB.Close()
+}
+
+func notebookIds(notebooks []models.Notebook) []uint {
+	var ids []uint
+	for _, notebook := range notebooks {
+		ids = append(ids, notebook.ID)
+	}
+	return ids
+}
+
+func TestNotebookRepository(t *testing.T) {
+	db := prepareDatabase(t)
+	ctx := context.Background()
+
+	userRepo := UserRepository{db: db}
+	noteRepo := NoteRepository{db: db}
+	notebookRepo := NotebookRepository{db: db}
+
+	user := models.User{Username: "Alice", Password: "pwd"}
+	err := userRepo.CreateUser(ctx, &user)
+	assert.NoError(t, err)
+
+	// work > projects > archive, personal
+	work := models.Notebook{Name: "work", UserID: user.ID}
+	err = notebookRepo.CreateNotebook(ctx, &work)
+	assert.NoError(t, err)
+	projects := models.Notebook{Name: "projects", UserID: user.ID, ParentID: &work.ID}
+	err = notebookRepo.CreateNotebook(ctx, &projects)
+	assert.NoError(t, err)
+	archive := models.Notebook{Name: "archive", UserID: user.ID, ParentID: &projects.ID}
+	err = notebookRepo.CreateNotebook(ctx, &archive)
+	assert.NoError(t, err)
+	personal := models.Notebook{Name: "personal", UserID: user.ID}
+	err = notebookRepo.CreateNotebook(ctx, &personal)
+	assert.NoError(t, err)
+
+	notebooks, err := notebookRepo.FindNotebooksByUserId(ctx, user.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, []uint{archive.ID, personal.ID, projects.ID, work.ID}, notebookIds(*notebooks))
+
+	tree, err := notebookRepo.FindNotebookTree(ctx, work.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, []uint{archive.ID, projects.ID, work.ID}, notebookIds(*tree))
+
+	tree, err = notebookRepo.FindNotebookTree(ctx, personal.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, []uint{personal.ID}, notebookIds(*tree))
+
+	// Notes in notebooks
+	note1 := models.Note{Title: "Title1", UserID: user.ID, User: user, NotebookID: &work.ID}
+	note2 := models.Note{Title: "Title2", UserID: user.ID, User: user, NotebookID: &archive.ID}
+	note3 := models.Note{Title: "Title3", UserID: user.ID, User: user}
+	for _, note := range []*models.Note{&note1, &note2, &note3} {
+		err = noteRepo.CreateNote(ctx, note)
+		assert.NoError(t, err)
+	}
+
+	notes, err := notebookRepo.FindNotesInNotebooks(ctx, []uint{work.ID, projects.ID, archive.ID})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(*notes))
+
+	// Move note3 into projects and back to the top level
+	err = noteRepo.MoveNote(ctx, &note3, &projects.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, uint(2), note3.Version)
+
+	note_read, err := noteRepo.FindNoteById(ctx, note3.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, projects.ID, *note_read.NotebookID)
+
+	err = noteRepo.MoveNote(ctx, &note3, nil)
+	assert.NoError(t, err)
+
+	note_read, err = noteRepo.FindNoteById(ctx, note3.ID)
+	assert.NoError(t, err)
+	assert.Nil(t, note_read.NotebookID)
+
+	err = noteRepo.MoveNote(ctx, &note3, &projects.ID)
+	assert.NoError(t, err)
+
+	// Rename and move personal into work
+	personal.Name = "private"
+	personal.ParentID = &work.ID
+	err = notebookRepo.UpdateNotebook(ctx, &personal)
+	assert.NoError(t, err)
+
+	notebook_read, err := notebookRepo.FindNotebookById(ctx, personal.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, "private", notebook_read.Name)
+	assert.Equal(t, work.ID, *notebook_read.ParentID)
+
+	// Moving personal back to the top level clears the parent
+	personal.ParentID = nil
+	err = notebookRepo.UpdateNotebook(ctx, &personal)
+	assert.NoError(t, err)
+
+	notebook_read, err = notebookRepo.FindNotebookById(ctx, personal.ID)
+	assert.NoError(t, err)
+	assert.Nil(t, notebook_read.ParentID)
+
+	// Deleting projects moves archive and note3 to work
+	note_read, err = noteRepo.FindNoteById(ctx, note3.ID)
+	assert.NoError(t, err)
+	version := note_read.Version
+	err = notebookRepo.DeleteNotebookById(ctx, projects.ID, NotebookDeleteMoveToParent)
+	assert.NoError(t, err)
+
+	_, err = notebookRepo.FindNotebookById(ctx, projects.ID)
+	assert.Error(t, err)
+
+	notebook_read, err = notebookRepo.FindNotebookById(ctx, archive.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, work.ID, *notebook_read.ParentID)
+
+	note_read, err = noteRepo.FindNoteById(ctx, note3.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, work.ID, *note_read.NotebookID)
+	// the move gives the note a new version, so its ETag changes
+	assert.Equal(t, version+1, note_read.Version)
+
+	// Deleting work with its contents deletes archive and all notes inside
+	err = notebookRepo.DeleteNotebookById(ctx, work.ID, NotebookDeleteContents)
+	assert.NoError(t, err)
+
+	notebooks, err = notebookRepo.FindNotebooksByUserId(ctx, user.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, []uint{personal.ID}, notebookIds(*notebooks))
+
+	for _, note := range []models.Note{note1, note2, note3} {
+		_, err = noteRepo.FindNoteById(ctx, note.ID)
+		assert.Error(t, err)
+	}
+
+	// Deleting a top-level notebook moves its contents to the top level
+	note4 := models.Note{Title: "Title4", UserID: user.ID, User: user, NotebookID: &personal.ID}
+	err = noteRepo.CreateNote(ctx, &note4)
+	assert.NoError(t, err)
+
+	err = notebookRepo.DeleteNotebookById(ctx, personal.ID, NotebookDeleteMoveToParent)
+	assert.NoError(t, err)
+
+	note_read, err = noteRepo.FindNoteById(ctx, note4.ID)
+	assert.NoError(t, err)
+	assert.Nil(t, note_read.NotebookID)
+}
+
+func TestNoteRevisionRepository(t *testing.T) {
+	db := prepareDatabase(t)
+	ctx := context.Background()
+
+	userRepo := UserRepository{db: db}
+	noteRepo := NoteRepository{db: db}
+	revisionRepo := NewNoteRevisionRepository(db, 3)
+
+	user := models.User{Username: "Alice", Password: "pwd"}
+	err := userRepo.CreateUser(ctx, &user)
+	assert.NoError(t, err)
+
+	// Creating a note records its first revision
+	note := models.Note{Title: "Title", Body: "Body 1", UserID: user.ID, User: user}
+	revision, err := revisionRepo.CreateNoteWithRevision(ctx, &note, user.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, uint(1), revision)
+	assert.NotZero(t, note.ID)
+
+	other_note := models.Note{Title: "Other", UserID: user.ID, User: user}
+	revision, err = revisionRepo.CreateNoteWithRevision(ctx, &other_note, user.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, uint(1), revision)
+
+	// Revisions are numbered per note
+	for i := 2; i <= 4; i++ {
+		note.Body = "Body " + strconv.Itoa(i)
+		revision, err = revisionRepo.UpdateNoteWithRevision(ctx, &note, user.ID)
+		assert.NoError(t, err)
+		assert.Equal(t, uint(i), revision)
+	}
+
+	// Only the newest 3 revisions are kept
+	revisions, err := revisionRepo.FindRevisionsByNoteId(ctx, note.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, len(*revisions))
+	assert.Equal(t, uint(4), (*revisions)[0].Revision)
+	assert.Equal(t, uint(2), (*revisions)[2].Revision)
+
+	revision_read, err := revisionRepo.FindRevision(ctx, note.ID, 3)
+	assert.NoError(t, err)
+	assert.Equal(t, "Body 3", revision_read.Body)
+	assert.Equal(t, user.ID, revision_read.AuthorID)
+
+	_, err = revisionRepo.FindRevision(ctx, note.ID, 1)
+	assert.Error(t, err)
+
+	// Pruning continues from the latest revision
+	note.Body = "Body 5"
+	revision, err = revisionRepo.UpdateNoteWithRevision(ctx, &note, user.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, uint(5), revision)
+
+	revisions, err = revisionRepo.FindRevisionsByNoteId(ctx, note.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, len(*revisions))
+	assert.Equal(t, uint(3), (*revisions)[2].Revision)
+
+	// An outdated version neither changes the note nor records a revision
+	stale := note
+	stale.Version--
+	stale.Body = "Stale"
+	_, err = revisionRepo.UpdateNoteWithRevision(ctx, &stale, user.ID)
+	assert.ErrorIs(t, err, ErrNoteVersionMismatch)
+
+	revisions, err = revisionRepo.FindRevisionsByNoteId(ctx, note.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, uint(5), (*revisions)[0].Revision)
+
+	// If the revision cannot be recorded, the note is not changed either
+	err = db.Migrator().DropTable(&models.NoteRevision{})
+	assert.NoError(t, err)
+
+	failed := note
+	failed.Body = "Body 6"
+	_, err = revisionRepo.UpdateNoteWithRevision(ctx, &failed, user.ID)
+	assert.Error(t, err)
+
+	note_read, err := noteRepo.FindNoteById(ctx, note.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, "Body 5", note_read.Body)
+	assert.Equal(t, note.Version, note_read.Version)
+
+	failed_note := models.Note{Title: "Failed", UserID: user.ID, User: user}
+	_, err = revisionRepo.CreateNoteWithRevision(ctx, &failed_note, user.ID)
+	assert.Error(t, err)
+
+	notes, err := noteRepo.FindNotesByUserId(ctx, user.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(*notes))
+}
+
+func TestNoteTrash(t *testing.T) {
+	db := prepareDatabase(t)
+	ctx := context.Background()
+
+	userRepo := UserRepository{db: db}
+	noteRepo := NoteRepository{db: db}
+	notebookRepo := NotebookRepository{db: db}
+	tagRepo := TagRepository{db: db}
+	revisionRepo := NewNoteRevisionRepository(db, 0)
+
+	user := models.User{Username: "Alice", Password: "pwd"}
+	err := userRepo.CreateUser(ctx, &user)
+	assert.NoError(t, err)
+
+	work := models.Notebook{Name: "work", UserID: user.ID}
+	err = notebookRepo.CreateNotebook(ctx, &work)
+	assert.NoError(t, err)
+
+	tags, err := tagRepo.FindOrCreateTags(ctx, user.ID, []string{"work"})
+	assert.NoError(t, err)
+
+	var notes []models.Note
+	for _, title := range []string{"First", "Second", "Third"} {
+		note := models.Note{Title: title, UserID: user.ID, User: user, NotebookID: &work.ID, Tags: *tags}
+		_, err = revisionRepo.CreateNoteWithRevision(ctx, &note, user.ID)
+		assert.NoError(t, err)
+		notes = append(notes, note)
+	}
+
+	// Deleted notes are only found in the trash
+	for _, note := range notes {
+		err = noteRepo.DeleteNote(ctx, &note)
+		assert.NoError(t, err)
+	}
+
+	trash, err := noteRepo.FindDeletedNotesByUserId(ctx, user.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, len(*trash))
+	assert.Equal(t, "Third", (*trash)[0].Title)
+
+	deleted, err := noteRepo.FindDeletedNoteById(ctx, notes[0].ID)
+	assert.NoError(t, err)
+	assert.Equal(t, "First", deleted.Title)
+
+	// Restoring a note whose notebook was deleted moves it to the top level
+	err = notebookRepo.DeleteNotebookById(ctx, work.ID, NotebookDeleteMoveToParent)
+	assert.NoError(t, err)
+	err = noteRepo.RestoreNoteById(ctx, notes[0].ID)
+	assert.NoError(t, err)
+
+	restored, err := noteRepo.FindNoteById(ctx, notes[0].ID)
+	assert.NoError(t, err)
+	assert.Nil(t, restored.NotebookID)
+	assert.Equal(t, deleted.Version+1, restored.Version)
+	assert.Equal(t, 1, len(restored.Tags))
+
+	_, err = noteRepo.FindDeletedNoteById(ctx, notes[0].ID)
+	assert.Error(t, err)
+	err = noteRepo.RestoreNoteById(ctx, notes[0].ID)
+	assert.Error(t, err)
+
+	// Purging removes the note with its revisions and tag assignments
+	err = noteRepo.PurgeNoteById(ctx, notes[1].ID)
+	assert.NoError(t, err)
+	_, err = noteRepo.FindDeletedNoteById(ctx, notes[1].ID)
+	assert.Error(t, err)
+	revisions, err := revisionRepo.FindRevisionsByNoteId(ctx, notes[1].ID)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(*revisions))
+
+	var tag_count int64
+	db.Table("note_tags").Where("note_id = ?", notes[1].ID).Count(&tag_count)
+	assert.Equal(t, int64(0), tag_count)
+
+	// Notes that are not in the trash cannot be purged
+	err = noteRepo.PurgeNoteById(ctx, notes[0].ID)
+	assert.Error(t, err)
+
+	// Only notes deleted before the given time are purged
+	count, err := noteRepo.PurgeNotesDeletedBefore(ctx, time.Now().Add(-time.Hour))
+	assert.NoError(t, err)
+	assert.Equal(t, 0, count)
+
+	count, err = noteRepo.PurgeNotesDeletedBefore(ctx, time.Now().Add(time.Second))
+	assert.NoError(t, err)
+	assert.Equal(t, 1, count)
+
+	trash, err = noteRepo.FindDeletedNotesByUserId(ctx, user.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(*trash))
+
+	_, err = noteRepo.FindNoteById(ctx, notes[0].ID)
+	assert.NoError(t, err)
+}
+
+func TestNoteRepositoryVersion(t *testing.T) {
+	db := prepareDatabase(t)
+	ctx := context.Background()
+
+	userRepo := UserRepository{db: db}
+	noteRepo := NoteRepository{db: db}
+
+	user := models.User{Username: "Alice", Password: "pwd"}
+	err := userRepo.CreateUser(ctx, &user)
+	assert.NoError(t, err)
+
+	// New notes start at version 1
+	note := models.Note{Title: "Title", UserID: user.ID, User: user}
+	err = noteRepo.CreateNote(ctx, &note)
+	assert.NoError(t, err)
+	assert.Equal(t, uint(1), note.Version)
+
+	// Each update increments the version
+	stale := note
+	note.Title = "New title"
+	err = noteRepo.UpdateNote(ctx, &note)
+	assert.NoError(t, err)
+	assert.Equal(t, uint(2), note.Version)
+
+	note_read, err := noteRepo.FindNoteById(ctx, note.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, uint(2), note_read.Version)
+
+	// Updates and deletes of an outdated version fail
+	stale.Title = "Stale title"
+	err = noteRepo.UpdateNote(ctx, &stale)
+	assert.ErrorIs(t, err, ErrNoteVersionMismatch)
+	assert.Equal(t, uint(1), stale.Version)
+
+	err = noteRepo.DeleteNote(ctx, &stale)
+	assert.ErrorIs(t, err, ErrNoteVersionMismatch)
+
+	note_read, err = noteRepo.FindNoteById(ctx, note.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, "New title", note_read.Title)
+
+	// Moving a note changes its version as well, and is only possible with the current version
+	moved := note
+	err = noteRepo.MoveNote(ctx, &moved, nil)
+	assert.NoError(t, err)
+
+	err = noteRepo.MoveNote(ctx, &stale, nil)
+	assert.ErrorIs(t, err, ErrNoteVersionMismatch)
+
+	err = noteRepo.DeleteNote(ctx, &note)
+	assert.ErrorIs(t, err, ErrNoteVersionMismatch)
+
+	note.Version = 3
+	err = noteRepo.DeleteNote(ctx, &note)
+	assert.NoError(t, err)
+
+	_, err = noteRepo.FindNoteById(ctx, note.ID)
+	assert.Error(t, err)
+}
+
+func TestRefreshTokenRepository(t *testing.T) {
+	db := prepareDatabase(t)
+	ctx := context.Background()
+
+	userRepo := UserRepository{db: db}
+	tokenRepo := NewRefreshTokenRepository(db)
+
+	user := models.User{Username: "Alice", Password: "pwd"}
+	err := userRepo.CreateUser(ctx, &user)
+	assert.NoError(t, err)
+
+	expires_at := time.Now().Add(time.Hour)
+	token := models.RefreshToken{TokenHash: "hash1", FamilyID: "family", UserID: user.ID, ExpiresAt: expires_at}
+	err = tokenRepo.CreateRefreshToken(ctx, &token)
+	assert.NoError(t, err)
+
+	token_read, err := tokenRepo.FindRefreshTokenByHash(ctx, "hash1")
+	assert.NoError(t, err)
+	assert.Equal(t, token.ID, token_read.ID)
+	assert.Equal(t, "Alice", token_read.User.Username)
+	assert.Nil(t, token_read.RotatedAt)
+
+	_, err = tokenRepo.FindRefreshTokenByHash(ctx, "unknown")
+	assert.Error(t, err)
+
+	// Rotation marks the old token and creates the new one
+	next := models.RefreshToken{TokenHash: "hash2", FamilyID: "family", UserID: user.ID, ExpiresAt: expires_at}
+	err = tokenRepo.RotateRefreshToken(ctx, token.ID, &next)
+	assert.NoError(t, err)
+
+	token_read, err = tokenRepo.FindRefreshTokenByHash(ctx, "hash1")
+	assert.NoError(t, err)
+	assert.NotNil(t, token_read.RotatedAt)
+
+	// A token can only be rotated once
+	other := models.RefreshToken{TokenHash: "hash3", FamilyID: "family", UserID: user.ID, ExpiresAt: expires_at}
+	err = tokenRepo.RotateRefreshToken(ctx, token.ID, &other)
+	assert.ErrorIs(t, err, ErrRefreshTokenUsed)
+	_, err = tokenRepo.FindRefreshTokenByHash(ctx, "hash3")
+	assert.Error(t, err)
+
+	// Revoking the family revokes all of its tokens
+	other_family := models.RefreshToken{TokenHash: "hash4", FamilyID: "other", UserID: user.ID, ExpiresAt: expires_at}
+	err = tokenRepo.CreateRefreshToken(ctx, &other_family)
+	assert.NoError(t, err)
+
+	err = tokenRepo.RevokeRefreshTokenFamily(ctx, "family")
+	assert.NoError(t, err)
+
+	for _, hash := range []string{"hash1", "hash2"} {
+		token_read, err = tokenRepo.FindRefreshTokenByHash(ctx, hash)
+		assert.NoError(t, err)
+		assert.NotNil(t, token_read.RevokedAt)
+	}
+
+	token_read, err = tokenRepo.FindRefreshTokenByHash(ctx, "hash4")
+	assert.NoError(t, err)
+	assert.Nil(t, token_read.RevokedAt)
+
+	err = tokenRepo.RotateRefreshToken(ctx, next.ID, &other)
+	assert.ErrorIs(t, err, ErrRefreshTokenUsed)
+}
+
+func TestTokenRevocationRepository(t *testing.T) {
+	db := prepareDatabase(t)
+	ctx := context.Background()
+
+	userRepo := UserRepository{db: db}
+	tokenRepo := NewRefreshTokenRepository(db)
+	revocationRepo := NewTokenRevocationRepository(db)
+
+	user := models.User{Username: "Alice", Password: "pwd"}
+	err := userRepo.CreateUser(ctx, &user)
+	assert.NoError(t, err)
+
+	revoked, err := revocationRepo.IsTokenRevoked(ctx, "jti1")
+	assert.NoError(t, err)
+	assert.False(t, revoked)
+
+	now := time.Now()
+	err = revocationRepo.RevokeToken(ctx, &models.RevokedToken{JTI: "jti1", UserID: user.ID, ExpiresAt: now.Add(time.Hour)})
+	assert.NoError(t, err)
+	// revoking twice is fine
+	err = revocationRepo.RevokeToken(ctx, &models.RevokedToken{JTI: "jti1", UserID: user.ID, ExpiresAt: now.Add(time.Hour)})
+	assert.NoError(t, err)
+	err = revocationRepo.RevokeToken(ctx, &models.RevokedToken{JTI: "jti2", UserID: user.ID, ExpiresAt: now.Add(-time.Minute)})
+	assert.NoError(t, err)
+
+	revoked, err = revocationRepo.IsTokenRevoked(ctx, "jti1")
+	assert.NoError(t, err)
+	assert.True(t, revoked)
+
+	// only expired revocations are deleted
+	count, err := revocationRepo.DeleteExpiredRevokedTokens(ctx, now)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, count)
+	revoked, err = revocationRepo.IsTokenRevoked(ctx, "jti2")
+	assert.NoError(t, err)
+	assert.False(t, revoked)
+
+	// logout from all devices starts a new token generation, records the time and revokes the refresh tokens
+	generation, err := revocationRepo.FindTokenGeneration(ctx, user.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, uint(0), generation)
+
+	token := models.RefreshToken{TokenHash: "hash1", FamilyID: "family", UserID: user.ID, ExpiresAt: now.Add(time.Hour)}
+	err = tokenRepo.CreateRefreshToken(ctx, &token)
+	assert.NoError(t, err)
+
+	err = revocationRepo.RevokeAllTokens(ctx, user.ID, now)
+	assert.NoError(t, err)
+
+	generation, err = revocationRepo.FindTokenGeneration(ctx, user.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, uint(1), generation)
+
+	user_read, err := userRepo.FindUserById(ctx, user.ID)
+	assert.NoError(t, err)
+	if assert.NotNil(t, user_read.TokensRevokedAt) {
+		assert.True(t, now.Equal(*user_read.TokensRevokedAt))
+	}
+
+	token_read, err := tokenRepo.FindRefreshTokenByHash(ctx, "hash1")
+	assert.NoError(t, err)
+	assert.NotNil(t, token_read.RevokedAt)
+
+	err = revocationRepo.RevokeAllTokens(ctx, user.ID+1, now)
+	assert.Error(t, err)
+}
+
+func TestUserRepositoryUpdatePassword(t *testing.T) {
+	db := prepareDatabase(t)
+	ctx := context.Background()
+
+	userRepo := NewUserRepository(db)
+	user, err := userRepo.CreateUserByNameAndPassword(ctx, "Alice", "old_hash")
+	assert.NoError(t, err)
+	assert.NoError(t, db.Model(&models.User{}).Where("id = ?", user.ID).Update("passwordless", true).Error)
+
+	err = userRepo.UpdatePassword(ctx, user.ID, "new_hash")
+	assert.NoError(t, err)
+
+	// setting a password ends being passwordless
+	user_read, err := userRepo.FindUserById(ctx, user.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, "new_hash", user_read.Password)
+	assert.False(t, user_read.Passwordless)
+
+	err = userRepo.UpdatePassword(ctx, user.ID+1, "new_hash")
+	assert.Error(t, err)
+
+	// a rehash only replaces the hash it was computed for
+	err = userRepo.RehashPassword(ctx, user.ID, "old_hash", "rehashed")
+	assert.ErrorIs(t, err, ErrPasswordChanged)
+	err = userRepo.RehashPassword(ctx, user.ID, "new_hash", "rehashed")
+	assert.NoError(t, err)
+
+	user_read, err = userRepo.FindUserById(ctx, user.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, "rehashed", user_read.Password)
+}
+
+func TestDeleteUserByIdCascade(t *testing.T) {
+	db := prepareDatabase(t)
+	ctx := context.Background()
+
+	userRepo := NewUserRepository(db)
+	noteRepo := NewNoteRepository(db)
+	tagRepo := NewTagRepository(db)
+	tokenRepo := NewRefreshTokenRepository(db)
+	revocationRepo := NewTokenRevocationRepository(db)
+
+	user, err := userRepo.CreateUserByNameAndPassword(ctx, "Alice", "pwd")
+	assert.NoError(t, err)
+	other, err := userRepo.CreateUserByNameAndPassword(ctx, "Bob", "pwd")
+	assert.NoError(t, err)
+
+	for _, owner := range []*models.User{user, other} {
+		notebook := models.Notebook{Name: "Work", UserID: owner.ID}
+		assert.NoError(t, db.Create(&notebook).Error)
+		tags, err := tagRepo.FindOrCreateTags(ctx, owner.ID, []string{"job", "todo"})
+		assert.NoError(t, err)
+		note := models.Note{Title: "Title", UserID: owner.ID, NotebookID: &notebook.ID, Tags: *tags}
+		assert.NoError(t, noteRepo.CreateNote(ctx, &note))
+		token := models.RefreshToken{TokenHash: owner.Username, FamilyID: owner.Username, UserID: owner.ID, ExpiresAt: time.Now().Add(time.Hour)}
+		assert.NoError(t, tokenRepo.CreateRefreshToken(ctx, &token))
+	}
+
+	deletion, err := userRepo.DeleteUserById(ctx, user.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, UserDeletion{Notes: 1, Notebooks: 1, Tags: 2}, deletion)
+
+	// The user is gone and its name can be registered again
+	_, err = userRepo.FindUserByName(ctx, "Alice")
+	assert.Error(t, err)
+	_, err = userRepo.CreateUserByNameAndPassword(ctx, "Alice", "pwd")
+	assert.NoError(t, err)
+
+	// All tokens of the user are revoked
+	generation, err := revocationRepo.FindTokenGeneration(ctx, user.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, uint(1), generation)
+	token, err := tokenRepo.FindRefreshTokenByHash(ctx, "Alice")
+	assert.NoError(t, err)
+	assert.NotNil(t, token.RevokedAt)
+
+	// The data of other users is kept
+	token, err = tokenRepo.FindRefreshTokenByHash(ctx, "Bob")
+	assert.NoError(t, err)
+	assert.Nil(t, token.RevokedAt)
+	tags, err := tagRepo.FindTagsWithNoteCount(ctx, other.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(*tags))
+
+	// Nothing is deleted if the user does not exist
+	_, err = userRepo.DeleteUserById(ctx, user.ID)
+	assert.Error(t, err)
+}
+
+func TestUserRepositoryUsernameTaken(t *testing.T) {
+	db := prepareDatabase(t)
+	ctx := context.Background()
+
+	userRepo := NewUserRepository(db)
+	alice, err := userRepo.CreateUserByNameAndPassword(ctx, "Alice", "hashed")
+	assert.NoError(t, err)
+	_, err = userRepo.CreateUserByNameAndPassword(ctx, "Zoë", "hashed")
+	assert.NoError(t, err)
+
+	// same name, different case and decomposed Unicode form
+	for _, username := range []string{"Alice", "alice", "ALICE", "Zoe\u0308", "ZOË"} {
+		_, err = userRepo.CreateUserByNameAndPassword(ctx, username, "hashed")
+		var errTaken *ErrorUsernameTaken
+		assert.True(t, errors.As(err, &errTaken), username)
+		assert.Equal(t, username, errTaken.Username)
+	}
+
+	user_read, err := userRepo.FindUserByName(ctx, "aLiCe")
+	assert.NoError(t, err)
+	assert.Equal(t, alice.ID, user_read.ID)
+	assert.Equal(t, "Alice", user_read.Username)
+
+	// the name of a deleted user can be taken again
+	_, err = userRepo.DeleteUserById(ctx, alice.ID)
+	assert.NoError(t, err)
+	_, err = userRepo.CreateUserByNameAndPassword(ctx, "alice", "hashed")
+	assert.NoError(t, err)
+}
+
+func TestMigrateUsernameKeys(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatal("Failed to connect to SQLite db:", err)
+	}
+	db.AutoMigrate(&models.User{})
+
+	// users created before the username key existed
+	assert.NoError(t, db.Create(&models.User{Username: "Alice", Password: "pwd"}).Error)
+	assert.NoError(t, db.Create(&models.User{Username: "Bob", Password: "pwd"}).Error)
+
+	assert.NoError(t, MigrateUsernameKeys(db))
+	// running it again does nothing
+	assert.NoError(t, MigrateUsernameKeys(db))
+
+	userRepo := NewUserRepository(db)
+	user, err := userRepo.FindUserByName(context.Background(), "bob")
+	assert.NoError(t, err)
+	assert.Equal(t, "Bob", user.Username)
+	assert.Equal(t, "bob", user.UsernameKey)
+
+	_, err = userRepo.CreateUserByNameAndPassword(context.Background(), "ALICE", "hashed")
+	var errTaken *ErrorUsernameTaken
+	assert.True(t, errors.As(err, &errTaken))
+}
+
+func TestLoginAttemptRepository(t *testing.T) {
+	db := prepareDatabase(t)
+	ctx := context.Background()
+
+	attemptRepo := NewLoginAttemptRepository(db)
+	now := time.Now()
+	delay := func(failures int) time.Duration {
+		if failures < 3 {
+			return 0
+		}
+		return time.Minute
+	}
+	findAttempt := func(key string) models.LoginAttempt {
+		attempt, err := gorm.G[models.LoginAttempt](db).Where("key = ?", key).First(ctx)
+		assert.NoError(t, err)
+		return attempt
+	}
+
+	for i := 1; i <= 3; i++ {
+		failures, locked_until, err := attemptRepo.ReserveLoginAttempt(ctx, "user:alice", now, now.Add(-time.Hour), delay)
+		assert.NoError(t, err)
+		assert.Equal(t, i, failures)
+		assert.True(t, locked_until.IsZero())
+	}
+	assert.WithinDuration(t, now.Add(time.Minute), findAttempt("user:alice").LockedUntil, time.Millisecond)
+
+	// locked logins are refused without being counted
+	failures, locked_until, err := attemptRepo.ReserveLoginAttempt(ctx, "user:alice", now.Add(time.Second), now.Add(-time.Hour), delay)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, failures)
+	assert.WithinDuration(t, now.Add(time.Minute), locked_until, time.Millisecond)
+	assert.Equal(t, 3, findAttempt("user:alice").Failures)
+
+	// a refund removes the lock of the reservation
+	err = attemptRepo.RefundLoginAttempt(ctx, "user:alice", 3)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, findAttempt("user:alice").Failures)
+	assert.False(t, findAttempt("user:alice").LockedUntil.After(now))
+
+	failures, _, err = attemptRepo.ReserveLoginAttempt(ctx, "user:alice", now, now.Add(-time.Hour), delay)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, failures)
+
+	// but not the lock of a later reservation
+	for i := 1; i <= 3; i++ {
+		_, _, err = attemptRepo.ReserveLoginAttempt(ctx, "ip:127.0.0.1", now, now.Add(-time.Hour), delay)
+		assert.NoError(t, err)
+	}
+	err = attemptRepo.RefundLoginAttempt(ctx, "ip:127.0.0.1", 1)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, findAttempt("ip:127.0.0.1").Failures)
+	assert.True(t, findAttempt("ip:127.0.0.1").LockedUntil.After(now))
+
+	// failures before the window are forgotten once the lock expired
+	failures, _, err = attemptRepo.ReserveLoginAttempt(ctx, "user:alice", now.Add(2*time.Hour), now.Add(time.Hour), delay)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, failures)
+
+	// only attempts without failures and locks since before are stale
+	count, err := attemptRepo.DeleteStaleLoginAttempts(ctx, now.Add(time.Hour))
+	assert.NoError(t, err)
+	assert.Equal(t, 1, count)
+
+	err = attemptRepo.ResetLoginFailures(ctx, "user:alice")
+	assert.NoError(t, err)
+	_, err = gorm.G[models.LoginAttempt](db).Where("key = ?", "user:alice").First(ctx)
+	assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
+}
+
+func TestPersonalAccessTokenRepository(t *testing.T) {
+	db := prepareDatabase(t)
+	ctx := context.Background()
+
+	userRepo := UserRepository{db: db}
+	tokenRepo := NewPersonalAccessTokenRepository(db)
+
+	alice := models.User{Username: "Alice", Password: "pwd"}
+	assert.NoError(t, userRepo.CreateUser(ctx, &alice))
+	bob := models.User{Username: "Bob", Password: "pwd"}
+	assert.NoError(t, userRepo.CreateUser(ctx, &bob))
+
+	token := models.PersonalAccessToken{Name: "backup", TokenHash: "hash1", Scopes: "notes:read", UserID: alice.ID}
+	assert.NoError(t, tokenRepo.CreatePersonalAccessToken(ctx, &token))
+	newer := models.PersonalAccessToken{Name: "sync", TokenHash: "hash2", Scopes: "notes:read notes:write", UserID: alice.ID}
+	assert.NoError(t, tokenRepo.CreatePersonalAccessToken(ctx, &newer))
+
+	// hashes are unique
+	duplicate := models.PersonalAccessToken{Name: "other", TokenHash: "hash1", Scopes: "notes:read", UserID: bob.ID}
+	assert.Error(t, tokenRepo.CreatePersonalAccessToken(ctx, &duplicate))
+
+	token_read, err := tokenRepo.FindPersonalAccessTokenByHash(ctx, "hash1")
+	assert.NoError(t, err)
+	assert.Equal(t, token.ID, token_read.ID)
+	assert.Equal(t, "Alice", token_read.User.Username)
+	assert.Nil(t, token_read.LastUsedAt)
+
+	_, err = tokenRepo.FindPersonalAccessTokenByHash(ctx, "unknown")
+	assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
+
+	used_at := time.Now()
+	assert.NoError(t, tokenRepo.TouchPersonalAccessToken(ctx, token.ID, used_at))
+	token_read, err = tokenRepo.FindPersonalAccessTokenByHash(ctx, "hash1")
+	assert.NoError(t, err)
+	assert.WithinDuration(t, used_at, *token_read.LastUsedAt, time.Millisecond)
+
+	// newest first, only the tokens of the user
+	tokens, err := tokenRepo.FindPersonalAccessTokens(ctx, alice.ID)
+	assert.NoError(t, err)
+	assert.Len(t, tokens, 2)
+	assert.Equal(t, newer.ID, tokens[0].ID)
+	tokens, err = tokenRepo.FindPersonalAccessTokens(ctx, bob.ID)
+	assert.NoError(t, err)
+	assert.Empty(t, tokens)
+
+	// only the owner can delete a token
+	assert.ErrorIs(t, tokenRepo.DeletePersonalAccessToken(ctx, bob.ID, token.ID), gorm.ErrRecordNotFound)
+	assert.NoError(t, tokenRepo.DeletePersonalAccessToken(ctx, alice.ID, token.ID))
+	assert.ErrorIs(t, tokenRepo.DeletePersonalAccessToken(ctx, alice.ID, token.ID), gorm.ErrRecordNotFound)
+
+	// deleting the user deletes its tokens
+	_, err = userRepo.DeleteUserById(ctx, alice.ID)
+	assert.NoError(t, err)
+	_, err = tokenRepo.FindPersonalAccessTokenByHash(ctx, "hash2")
+	assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
+}
+
+func TestMfaRepository(t *testing.T) {
+	db := prepareDatabase(t)
+	ctx := context.Background()
+
+	userRepo := UserRepository{db: db}
+	mfaRepo := NewMfaRepository(db)
+
+	alice := models.User{Username: "Alice", Password: "pwd"}
+	assert.NoError(t, userRepo.CreateUser(ctx, &alice))
+	bob := models.User{Username: "Bob", Password: "pwd"}
+	assert.NoError(t, userRepo.CreateUser(ctx, &bob))
+
+	// an enrollment can be restarted until it is confirmed
+	assert.NoError(t, mfaRepo.StartTotpEnrollment(ctx, alice.ID, "first"))
+	assert.NoError(t, mfaRepo.StartTotpEnrollment(ctx, alice.ID, "second"))
+	user, err := userRepo.FindUserById(ctx, alice.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, "second", user.TotpSecret)
+	assert.Nil(t, user.TotpEnabledAt)
+
+	assert.NoError(t, mfaRepo.EnableTotp(ctx, alice.ID, 100, []string{"hash1", "hash2"}))
+	assert.ErrorIs(t, mfaRepo.EnableTotp(ctx, alice.ID, 101, []string{"hash3"}), ErrTotpEnabled)
+	assert.ErrorIs(t, mfaRepo.StartTotpEnrollment(ctx, alice.ID, "third"), ErrTotpEnabled)
+	user, err = userRepo.FindUserById(ctx, alice.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, "second", user.TotpSecret)
+	assert.NotNil(t, user.TotpEnabledAt)
+	assert.Equal(t, int64(100), user.TotpLastCounter)
+
+	count, err := mfaRepo.CountRecoveryCodes(ctx, alice.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, count)
+
+	// time steps can only be used once and in order
+	assert.ErrorIs(t, mfaRepo.UseTotpCounter(ctx, alice.ID, 100), ErrTotpCodeUsed)
+	assert.NoError(t, mfaRepo.UseTotpCounter(ctx, alice.ID, 101))
+	assert.ErrorIs(t, mfaRepo.UseTotpCounter(ctx, alice.ID, 99), ErrTotpCodeUsed)
+
+	// recovery codes can only be used once and by their user
+	assert.ErrorIs(t, mfaRepo.UseRecoveryCode(ctx, bob.ID, "hash1"), ErrRecoveryCodeUsed)
+	assert.NoError(t, mfaRepo.UseRecoveryCode(ctx, alice.ID, "hash1"))
+	assert.ErrorIs(t, mfaRepo.UseRecoveryCode(ctx, alice.ID, "hash1"), ErrRecoveryCodeUsed)
+	count, err = mfaRepo.CountRecoveryCodes(ctx, alice.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, count)
+
+	// new codes replace all previous ones
+	assert.NoError(t, mfaRepo.ReplaceRecoveryCodes(ctx, alice.ID, []string{"hash4", "hash5", "hash6"}))
+	assert.ErrorIs(t, mfaRepo.UseRecoveryCode(ctx, alice.ID, "hash2"), ErrRecoveryCodeUsed)
+	count, err = mfaRepo.CountRecoveryCodes(ctx, alice.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, count)
+
+	assert.NoError(t, mfaRepo.DisableTotp(ctx, alice.ID))
+	user, err = userRepo.FindUserById(ctx, alice.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, "", user.TotpSecret)
+	assert.Nil(t, user.TotpEnabledAt)
+	count, err = mfaRepo.CountRecoveryCodes(ctx, alice.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, count)
+
+	// deleting the user deletes its recovery codes
+	assert.NoError(t, mfaRepo.StartTotpEnrollment(ctx, bob.ID, "secret"))
+	assert.NoError(t, mfaRepo.EnableTotp(ctx, bob.ID, 1, []string{"hash7"}))
+	_, err = userRepo.DeleteUserById(ctx, bob.ID)
+	assert.NoError(t, err)
+	count, err = mfaRepo.CountRecoveryCodes(ctx, bob.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, count)
+}
+
+func TestOidcIdentityRepository(t *testing.T) {
+	db := prepareDatabase(t)
+	ctx := context.Background()
+
+	userRepo := UserRepository{db: db}
+	identityRepo := NewOidcIdentityRepository(db)
+
+	_, err := identityRepo.FindUserByOidcIdentity(ctx, "https://idp.example.com", "alice-sub")
+	assert.ErrorIs(t, err, ErrOidcIdentityNotFound)
+
+	alice := models.User{Username: "Alice", Password: "pwd"}
+	assert.NoError(t, identityRepo.CreateUserWithOidcIdentity(ctx, &alice, "https://idp.example.com", "alice-sub"))
+	assert.NotZero(t, alice.ID)
+
+	user, err := identityRepo.FindUserByOidcIdentity(ctx, "https://idp.example.com", "alice-sub")
+	assert.NoError(t, err)
+	assert.Equal(t, alice.ID, user.ID)
+	assert.Equal(t, "alice", user.UsernameKey)
+
+	// the subject is only unique per issuer
+	_, err = identityRepo.FindUserByOidcIdentity(ctx, "https://other.example.com", "alice-sub")
+	assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
+
+	// neither the user nor the identity is created if one of them exists
+	var errTaken *ErrorUsernameTaken
+	err = identityRepo.CreateUserWithOidcIdentity(ctx, &models.User{Username: "ALICE", Password: "pwd"}, "https://idp.example.com", "bob-sub")
+	assert.True(t, errors.As(err, &errTaken))
+	_, err = identityRepo.FindUserByOidcIdentity(ctx, "https://idp.example.com", "bob-sub")
+	assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
+
+	err = identityRepo.CreateUserWithOidcIdentity(ctx, &models.User{Username: "Bob", Password: "pwd"}, "https://idp.example.com", "alice-sub")
+	assert.ErrorIs(t, err, ErrOidcIdentityLinked)
+	_, err = userRepo.FindUserByName(ctx, "Bob")
+	assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
+
+	// deleting the user unlinks the identity
+	_, err = userRepo.DeleteUserById(ctx, alice.ID)
+	assert.NoError(t, err)
+	_, err = identityRepo.FindUserByOidcIdentity(ctx, "https://idp.example.com", "alice-sub")
+	assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
+	bob := models.User{Username: "Bob", Password: "pwd"}
+	assert.NoError(t, identityRepo.CreateUserWithOidcIdentity(ctx, &bob, "https://idp.example.com", "alice-sub"))
+}
@@ -0,0 +1,146 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"user-notes-api/models"
+
+	"gorm.io/gorm"
+)
+
+type TagWithNoteCount struct {
+	ID        uint
+	Name      string
+	NoteCount int
+}
+
+type TagReader interface {
+	FindTagById(ctx context.Context, id uint) (*models.Tag, error)
+	FindTagByName(ctx context.Context, userId uint, name string) (*models.Tag, error)
+	FindTagsWithNoteCount(ctx context.Context, userId uint) (*[]TagWithNoteCount, error)
+}
+
+type TagCreator interface {
+	FindOrCreateTags(ctx context.Context, userId uint, names []string) (*[]models.Tag, error)
+}
+
+type TagUpdater interface {
+	RenameTag(ctx context.Context, id uint, name string) error
+	MergeTags(ctx context.Context, sourceId uint, targetId uint) error
+}
+
+type TagDeleter interface {
+	DeleteTagById(ctx context.Context, id uint) error
+}
+
+type TagRepository struct {
+	db *gorm.DB
+}
+
+func NewTagRepository(db *gorm.DB) *TagRepository {
+	return &TagRepository{db: db}
+}
+
+func (r *TagRepository) FindTagById(ctx context.Context, id uint) (*models.Tag, error) {
+	tag, err := gorm.G[models.Tag](r.db).Where("id = ?", id).First(ctx)
+	return &tag, err
+}
+
+func (r *TagRepository) FindTagByName(ctx context.Context, userId uint, name string) (*models.Tag, error) {
+	tag, err := gorm.G[models.Tag](r.db).Where("user_id = ? AND name = ?", userId, name).First(ctx)
+	return &tag, err
+}
+
+// FindTagsWithNoteCount returns all tags of a user ordered by name. Deleted notes are not counted.
+func (r *TagRepository) FindTagsWithNoteCount(ctx context.Context, userId uint) (*[]TagWithNoteCount, error) {
+	tags := []TagWithNoteCount{}
+	tx := r.db.WithContext(ctx).Raw(`SELECT tags.id, tags.name, COUNT(notes.id) AS note_count
+		FROM tags
+		LEFT JOIN note_tags ON note_tags.tag_id = tags.id
+		LEFT JOIN notes ON notes.id = note_tags.note_id AND notes.deleted_at IS NULL
+		WHERE tags.user_id = ? AND tags.deleted_at IS NULL
+		GROUP BY tags.id, tags.name
+		ORDER BY tags.name`, userId).Scan(&tags)
+	return &tags, tx.Error
+}
+
+// FindOrCreateTags returns the tags of the user with the given names in the same order, creating the
+// ones that do not exist yet.
+func (r *TagRepository) FindOrCreateTags(ctx context.Context, userId uint, names []string) (*[]models.Tag, error) {
+	tags := []models.Tag{}
+	for _, name := range names {
+		tag := models.Tag{}
+		tx := r.db.WithContext(ctx).Where(models.Tag{UserID: userId, Name: name}).FirstOrCreate(&tag)
+		if tx.Error != nil {
+			return &tags, tx.Error
+		}
+		tags = append(tags, tag)
+	}
+	return &tags, nil
+}
+
+// RenameTag renames the tag and increments the version of its notes, whose tags change with it.
+func (r *TagRepository) RenameTag(ctx context.Context, id uint, name string) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		count, err := gorm.G[models.Tag](tx).Where("id = ?", id).Update(ctx, "name", name)
+		if err == nil && count != 1 {
+			msg := fmt.Sprintf("unexpected count for renaming tag. expected 1, received %d", count)
+			return errors.New(msg)
+		}
+		if err != nil {
+			return err
+		}
+
+		return incrementNoteVersions(tx, id)
+	})
+}
+
+// MergeTags moves all notes of the source tag to the target tag and deletes the source tag, which increments
+// the version of its notes.
+func (r *TagRepository) MergeTags(ctx context.Context, sourceId uint, targetId uint) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Exec(`INSERT INTO note_tags (note_id, tag_id)
+			SELECT note_id, ? FROM note_tags
+			WHERE tag_id = ? AND note_id NOT IN (SELECT note_id FROM note_tags WHERE tag_id = ?)`,
+			targetId, sourceId, targetId).Error
+		if err != nil {
+			return err
+		}
+
+		return deleteTag(tx, sourceId)
+	})
+}
+
+func (r *TagRepository) DeleteTagById(ctx context.Context, id uint) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		return deleteTag(tx, id)
+	})
+}
+
+// incrementNoteVersions increments the version of all notes with the tag, including those in the trash,
+// since the tags of the notes are part of their version.
+func incrementNoteVersions(tx *gorm.DB, tagId uint) error {
+	tagged := tx.Table("note_tags").Select("note_id").Where("tag_id = ?", tagId)
+	return tx.Unscoped().Model(&models.Note{}).Where("id IN (?)", tagged).Update("version", gorm.Expr("version + 1")).Error
+}
+
+// deleteTag removes the tag from all notes and deletes it permanently, so that its name can be reused.
+func deleteTag(tx *gorm.DB, id uint) error {
+	err := incrementNoteVersions(tx, id)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Exec("DELETE FROM note_tags WHERE tag_id = ?", id).Error
+	if err != nil {
+		return err
+	}
+
+	result := tx.Unscoped().Where("id = ?", id).Delete(&models.Tag{})
+	if result.Error == nil && result.RowsAffected != 1 {
+		msg := fmt.Sprintf("unexpected count for deleting tag. expected 1, received %d", result.RowsAffected)
+		return errors.New(msg)
+	}
+	return result.Error
+}
@@ -0,0 +1,75 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"time"
+	"user-notes-api/models"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type TokenRevocationReader interface {
+	IsTokenRevoked(ctx context.Context, jti string) (bool, error)
+	FindTokenGeneration(ctx context.Context, userId uint) (uint, error)
+}
+
+type TokenRevoker interface {
+	RevokeToken(ctx context.Context, token *models.RevokedToken) error
+	RevokeAllTokens(ctx context.Context, userId uint, revokedAt time.Time) error
+	DeleteExpiredRevokedTokens(ctx context.Context, now time.Time) (int, error)
+}
+
+type TokenRevocationRepository struct {
+	db *gorm.DB
+}
+
+func NewTokenRevocationRepository(db *gorm.DB) *TokenRevocationRepository {
+	return &TokenRevocationRepository{db: db}
+}
+
+func (r *TokenRevocationRepository) IsTokenRevoked(ctx context.Context, jti string) (bool, error) {
+	count, err := gorm.G[models.RevokedToken](r.db).Where("jti = ?", jti).Count(ctx, "jti")
+	return count > 0, err
+}
+
+// FindTokenGeneration returns the token generation of the user, which is incremented on every logout from
+// all devices. Deleted users are included, their tokens were revoked on deletion.
+func (r *TokenRevocationRepository) FindTokenGeneration(ctx context.Context, userId uint) (uint, error) {
+	var user models.User
+	err := r.db.WithContext(ctx).Unscoped().Select("id", "token_generation").Where("id = ?", userId).First(&user).Error
+	if err != nil {
+		return 0, err
+	}
+	return user.TokenGeneration, nil
+}
+
+// RevokeToken stores the revocation of a single access token. Revoking a token twice is not an error.
+func (r *TokenRevocationRepository) RevokeToken(ctx context.Context, token *models.RevokedToken) error {
+	return r.db.WithContext(ctx).Omit("User").Clauses(clause.OnConflict{DoNothing: true}).Create(token).Error
+}
+
+// RevokeAllTokens revokes the access tokens of the user by starting a new token generation, together with
+// its personal access tokens created at or before revokedAt and all of its refresh tokens.
+func (r *TokenRevocationRepository) RevokeAllTokens(ctx context.Context, userId uint, revokedAt time.Time) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&models.User{}).Where("id = ?", userId).
+			Updates(map[string]any{"tokens_revoked_at": revokedAt, "token_generation": gorm.Expr("token_generation + 1")})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected != 1 {
+			return errors.New("number of affected rows not equal to 1")
+		}
+
+		return tx.Model(&models.RefreshToken{}).Where("user_id = ? AND revoked_at IS NULL", userId).
+			Update("revoked_at", revokedAt).Error
+	})
+}
+
+// DeleteExpiredRevokedTokens deletes the revocations of tokens that expired before now and returns their number.
+func (r *TokenRevocationRepository) DeleteExpiredRevokedTokens(ctx context.Context, now time.Time) (int, error) {
+	count, err := gorm.G[models.RevokedToken](r.db).Where("expires_at < ?", now).Delete(ctx)
+	return count, err
+}
@@ -5,7 +5,9 @@ import (
 	"errors"
 	"fmt"
 	"strconv"
+	"time"
 	"user-notes-api/models"
+	"user-notes-api/utils"
 
 	"gorm.io/gorm"
 )
@@ -20,6 +22,41 @@ type UserCreator interface {
 	CreateUserByNameAndPassword(ctx context.Context, username string, password string) (*models.User, error)
 }
 
+type UserUpdater interface {
+	UpdatePassword(ctx context.Context, userId uint, password string) error
+	RehashPassword(ctx context.Context, userId uint, oldPassword string, password string) error
+}
+
+// ErrPasswordChanged is returned by RehashPassword if the password of the user is no longer the one that was
+// hashed again.
+var ErrPasswordChanged = errors.New("password was changed in the meantime")
+
+type UserDeleter interface {
+	DeleteUserById(ctx context.Context, id uint) (UserDeletion, error)
+}
+
+// UserDeletion counts what was deleted together with a user.
+type UserDeletion struct {
+	Notes     int
+	Notebooks int
+	Tags      int
+}
+
+// ErrorUsernameTaken is returned by CreateUser if the username only differs in case or Unicode
+// representation from the name of another user.
+type ErrorUsernameTaken struct {
+	Username string
+	Err      error
+}
+
+func (e *ErrorUsernameTaken) Error() string {
+	return fmt.Sprintf("username %q is already taken", e.Username)
+}
+
+func (e *ErrorUsernameTaken) Unwrap() error {
+	return e.Err
+}
+
 type UserRepository struct {
 	db *gorm.DB
 }
@@ -28,9 +65,15 @@ func NewUserRepository(db *gorm.DB) *UserRepository {
 	return &UserRepository{db: db}
 }
 
+// CreateUser creates the user and sets its UsernameKey. ErrorUsernameTaken is returned if the key is
+// already used.
 func (r *UserRepository) CreateUser(ctx context.Context, user *models.User) error {
+	user.UsernameKey = utils.UsernameKey(user.Username)
 	result := gorm.WithResult()
 	err := gorm.G[models.User](r.db, result).Create(ctx, user)
+	if errors.Is(translateError(r.db, err), gorm.ErrDuplicatedKey) {
+		return &ErrorUsernameTaken{Username: user.Username, Err: err}
+	}
 
 	if err == nil && result.RowsAffected != 1 {
 		return errors.New("number of affected rows not equal to 1")
@@ -50,70 +93,148 @@ func (r *UserRepository) FindUserById(ctx context.Context, id uint) (*models.Use
 	return &user, err
 }
 
+// FindUserByName finds the user by its username, ignoring case and Unicode representation.
 func (r *UserRepository) FindUserByName(ctx context.Context, username string) (*models.User, error) {
-	user, err := gorm.G[models.User](r.db).Where("username = ?", username).First(ctx)
+	user, err := gorm.G[models.User](r.db).Where("username_key = ?", utils.UsernameKey(username)).First(ctx)
 	return &user, err
 }
 
-func (r *UserRepository) DeleteUser(ctx context.Context, user *models.User) error {
-	noteRepo := NoteRepository{db: r.db}
-	err := noteRepo.DeleteNotesOfUser(ctx, user)
-	if err != nil {
-		return err
+// UpdatePassword stores the hash string of a new password for the user, which is no longer passwordless.
+func (r *UserRepository) UpdatePassword(ctx context.Context, userId uint, password string) error {
+	result := r.db.WithContext(ctx).Model(&models.User{}).Where("id = ?", userId).
+		Updates(map[string]any{"password": password, "passwordless": false})
+	if result.Error == nil && result.RowsAffected != 1 {
+		return errors.New("number of affected rows not equal to 1")
 	}
+	return result.Error
+}
 
-	count, err := gorm.G[models.User](r.db).Where("id = ?", user.ID).Update(ctx, "username", user.Username+"_deleted_"+strconv.Itoa(int(user.ID)))
-	if count != 1 {
-		msg := fmt.Sprintf("unexpected count for updating username. expected 1, received %d", count)
-		return errors.New(msg)
+// RehashPassword replaces the hash string oldPassword of the user with password, a new hash of the same
+// password. It returns ErrPasswordChanged if the password was changed since oldPassword was read.
+func (r *UserRepository) RehashPassword(ctx context.Context, userId uint, oldPassword string, password string) error {
+	count, err := gorm.G[models.User](r.db).Where("id = ? AND password = ?", userId, oldPassword).Update(ctx, "password", password)
+	if err == nil && count != 1 {
+		return ErrPasswordChanged
 	}
+	return err
+}
 
-	if err != nil {
-		return err
-	}
-
-	count, err = gorm.G[models.User](r.db).Where("id = ?", user.ID).Delete(ctx)
-
-	if count != 1 {
-		msg := fmt.Sprintf("unexpected count for deleting user. expected 1, received %d", count)
+func (r *UserRepository) DeleteUser(ctx context.Context, user *models.User) error {
+	deletion, err := r.DeleteUserById(ctx, user.ID)
+	if err == nil && deletion.Notes != len(user.Notes) {
+		msg := fmt.Sprintf("unexpected count for deleting notes. expected %d, received %d", len(user.Notes), deletion.Notes)
 		return errors.New(msg)
 	}
 	return err
 }
 
-func (r *UserRepository) DeleteUserById(ctx context.Context, id uint) (int, error) {
-	noteRepo := NoteRepository{db: r.db}
-	count, err := noteRepo.DeleteNotesOfUserByUserID(ctx, id)
+// DeleteUserById deletes the notes, notebooks and tags of the user and then the user itself. The user is
+// renamed, so the username can be registered again, and all of its tokens are revoked. Everything happens
+// in one transaction, so either the whole account is deleted or nothing.
+func (r *UserRepository) DeleteUserById(ctx context.Context, id uint) (UserDeletion, error) {
+	var deletion UserDeletion
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		username, err := getUsernameFromID(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+
+		deletion.Notes, err = gorm.G[models.Note](tx).Where("user_id = ?", id).Delete(ctx)
+		if err != nil {
+			return err
+		}
+
+		deletion.Notebooks, err = gorm.G[models.Notebook](tx).Where("user_id = ?", id).Delete(ctx)
+		if err != nil {
+			return err
+		}
+
+		deletion.Tags, err = gorm.G[models.Tag](tx).Where("user_id = ?", id).Delete(ctx)
+		if err != nil {
+			return err
+		}
+
+		now := time.Now()
+		err = tx.Model(&models.RefreshToken{}).Where("user_id = ? AND revoked_at IS NULL", id).Update("revoked_at", now).Error
+		if err != nil {
+			return err
+		}
+
+		_, err = gorm.G[models.PersonalAccessToken](tx).Where("user_id = ?", id).Delete(ctx)
+		if err != nil {
+			return err
+		}
+
+		_, err = gorm.G[models.RecoveryCode](tx).Where("user_id = ?", id).Delete(ctx)
+		if err != nil {
+			return err
+		}
+
+		// the accounts at OpenID Connect providers log in as new users afterwards
+		_, err = gorm.G[models.OidcIdentity](tx).Where("user_id = ?", id).Delete(ctx)
+		if err != nil {
+			return err
+		}
+
+		deleted_username := username + "_deleted_" + strconv.Itoa(int(id))
+		result := tx.Model(&models.User{}).Where("id = ?", id).
+			Updates(map[string]any{"username": deleted_username, "username_key": utils.UsernameKey(deleted_username),
+				"tokens_revoked_at": now, "token_generation": gorm.Expr("token_generation + 1")})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected != 1 {
+			msg := fmt.Sprintf("unexpected count for updating username. expected 1, received %d", result.RowsAffected)
+			return errors.New(msg)
+		}
+
+		count, err := gorm.G[models.User](tx).Where("id = ?", id).Delete(ctx)
+		if err == nil && count != 1 {
+			msg := fmt.Sprintf("unexpected count for deleting user. expected 1, received %d", count)
+			return errors.New(msg)
+		}
+		return err
+	})
 	if err != nil {
-		return count, err
+		return UserDeletion{}, err
 	}
+	return deletion, nil
+}
 
-	username, err := r.getUsernameFromID(ctx, id)
-
-	if err != nil {
-		return count, err
-	}
+func getUsernameFromID(ctx context.Context, db *gorm.DB, id uint) (string, error) {
+	user, err := gorm.G[models.User](db).Where("id = ?", id).First(ctx)
+	return user.Username, err
+}
 
-	ccount, err := gorm.G[models.User](r.db).Where("id = ?", id).Update(ctx, "username", username+"_deleted_"+strconv.Itoa(int(id)))
-	if ccount != 1 {
-		msg := fmt.Sprintf("unexpected count for updating username. expected 1, received %d", ccount)
-		return count, errors.New(msg)
+// translateError maps errors of the database driver to the errors of gorm, like gorm.ErrDuplicatedKey.
+// The connection may have been opened without gorm.Config.TranslateError.
+func translateError(db *gorm.DB, err error) error {
+	if translator, ok := db.Dialector.(gorm.ErrorTranslator); ok && err != nil {
+		return translator.Translate(err)
 	}
+	return err
+}
 
+// MigrateUsernameKeys sets the UsernameKey of users created before it existed and creates its unique
+// index. It has to run after the users table was migrated and fails if existing usernames only differ
+// in case; these have to be renamed first.
+func MigrateUsernameKeys(db *gorm.DB) error {
+	var users []models.User
+	err := db.Unscoped().Select("id", "username").Where("username_key = ''").Find(&users).Error
 	if err != nil {
-		return count, err
+		return fmt.Errorf("migrate username keys: %w", err)
 	}
 
-	ccount, err = gorm.G[models.User](r.db).Where("id = ?", id).Delete(ctx)
-
-	if ccount != 1 {
-		msg := fmt.Sprintf("unexpected count for deleting user. expected 1, received %d", ccount)
-		return count, errors.New(msg)
+	for _, user := range users {
+		err = db.Unscoped().Model(&models.User{}).Where("id = ?", user.ID).Update("username_key", utils.UsernameKey(user.Username)).Error
+		if err != nil {
+			return fmt.Errorf("migrate username keys: %w", err)
+		}
 	}
-	return count, err
-}
 
-func (r *UserRepository) getUsernameFromID(ctx context.Context, id uint) (string, error) {
-	user, err := gorm.G[models.User](r.db).Where("id = ?", id).First(ctx)
-	return user.Username, err
+	err = db.Exec(`CREATE UNIQUE INDEX IF NOT EXISTS idx_users_username_key ON users (username_key)`).Error
+	if err != nil {
+		return fmt.Errorf("migrate username keys: %w", err)
+	}
+	return nil
 }
@@ -1,8 +1,11 @@
 package routes
 
 import (
+	"context"
+	"errors"
 	"net/http"
 	"user-notes-api/auth"
+	"user-notes-api/config"
 	"user-notes-api/controllers"
 	"user-notes-api/middleware"
 	"user-notes-api/repositories"
@@ -15,22 +18,72 @@ import (
 	"runtime"
 )
 
-func SetupRoutes(r *gin.Engine, db *gorm.DB, jwt_secret string) {
+func SetupRoutes(r *gin.Engine, db *gorm.DB, cfg *config.Config) error {
+	jwt_keys, err := jwtKeys(cfg)
+	if err != nil {
+		return err
+	}
+
 	user_repo := repositories.NewUserRepository(db)
 	note_repo := repositories.NewNoteRepository(db)
+	tag_repo := repositories.NewTagRepository(db)
+	notebook_repo := repositories.NewNotebookRepository(db)
+	revision_repo := repositories.NewNoteRevisionRepository(db, cfg.NoteRevisionLimit)
+	refresh_token_repo := repositories.NewRefreshTokenRepository(db)
+	revocation_repo := repositories.NewTokenRevocationRepository(db)
+	access_token_repo := repositories.NewPersonalAccessTokenRepository(db)
+	mfa_repo := repositories.NewMfaRepository(db)
+	oidc_identity_repo := repositories.NewOidcIdentityRepository(db)
 
-	threads := uint8(runtime.GOMAXPROCS(0))
-	pwd_hasher := utils.Argon2IdHasher{Time: 1, SaltLen: 32, Memory: 64 * 1024, Threads: threads, KeyLen: 256}
+	pwd_hasher := passwordHashers(cfg.PasswordHashAlgorithm, cfg.PasswordPeppers)
 
-	login_manager := auth.LoginManager{UserReader: user_repo, PwdComparer: &pwd_hasher}
-	registration_manager := auth.RegistrationManager{UserCreator: user_repo, PwdHasher: &pwd_hasher}
+	login_manager := auth.NewLoginManager(user_repo, user_repo, pwd_hasher)
+	password_policy := auth.NewPasswordRules(cfg.PasswordMinLength, cfg.PasswordMaxLength, cfg.PasswordMaxBytes,
+		cfg.PasswordMinCharacterClasses)
+	registration_manager := auth.RegistrationManager{UserCreator: user_repo, PwdHasher: pwd_hasher, Policy: password_policy}
+	password_manager := auth.NewPasswordManager(login_manager, user_repo, pwd_hasher, password_policy)
 
-	login_service := services.NewLoginService(&login_manager, jwt_secret)
-	registration_service := services.NewRegistrationService(&registration_manager, jwt_secret)
+	token_service := services.NewTokenService(refresh_token_repo, refresh_token_repo, refresh_token_repo, revocation_repo, jwt_keys,
+		cfg.AccessTokenLifetime, cfg.RefreshTokenLifetime)
+	revocation_store := services.NewRevocationStore(revocation_repo, revocation_repo)
+	go revocation_store.Run(context.Background())
+	access_token_service := services.NewPersonalAccessTokenService(access_token_repo, access_token_repo, access_token_repo)
+	logout_service := services.NewLogoutService(revocation_store, refresh_token_repo, refresh_token_repo)
+	var login_attempts services.LoginAttemptTracker = repositories.NewLoginAttemptRepository(db)
+	if cfg.LoginAttemptStore == config.LoginAttemptStoreMemory {
+		login_attempts = services.NewMemoryLoginAttempts()
+	}
+	login_throttle := services.NewLoginThrottle(login_attempts,
+		services.LoginBackoff{MaxFailures: cfg.LoginUsernameMaxFailures, BaseDelay: cfg.LoginBackoffBase, MaxDelay: cfg.LoginBackoffMax},
+		services.LoginBackoff{MaxFailures: cfg.LoginClientIpMaxFailures, BaseDelay: cfg.LoginBackoffBase, MaxDelay: cfg.LoginBackoffMax},
+		cfg.LoginFailureWindow)
+	go login_throttle.Run(context.Background())
+	reauthentication := services.NewReauthentication(user_repo, password_manager, cfg.ReauthenticationMaxAge)
+	account_service := services.NewAccountService(password_manager, reauthentication, revocation_store, token_service, user_repo,
+		login_throttle)
+	mfa_service := services.NewMfaService(user_repo, mfa_repo, mfa_repo, reauthentication, login_throttle, totpSecretBox(cfg.TotpEncryptionKeys),
+		cfg.TotpIssuer)
+	mfa_token_service := services.NewMfaTokenService(jwt_keys, cfg.MfaTokenLifetime)
+	login_service := services.NewLoginService(login_manager, token_service, login_throttle, mfa_token_service, mfa_service)
+	registration_service := services.NewRegistrationService(&registration_manager, token_service)
+	oidc_service := services.NewOidcService(oidcProviders(cfg.OidcProviders), oidc_identity_repo, oidc_identity_repo,
+		pwd_hasher, token_service, mfa_token_service, mfa_service, jwt_keys)
 
-	note_service := services.NewNoteService(note_repo, note_repo, user_repo)
+	note_service := services.NewNoteService(note_repo, note_repo, note_repo, tag_repo, notebook_repo, revision_repo, user_repo)
 	note_controller := controllers.NewNoteController(note_service, note_service)
 
+	tag_service := services.NewTagService(tag_repo, tag_repo, tag_repo)
+	tag_controller := controllers.NewTagController(tag_service)
+
+	notebook_service := services.NewNotebookService(notebook_repo, notebook_repo, notebook_repo, notebook_repo)
+	notebook_controller := controllers.NewNotebookController(notebook_service)
+
+	revision_service := services.NewNoteRevisionService(note_repo, revision_repo, revision_repo)
+	revision_controller := controllers.NewNoteRevisionController(revision_service)
+
+	trash_service := services.NewTrashService(note_repo, note_repo, note_repo)
+	trash_controller := controllers.NewTrashController(trash_service)
+
 	r.GET("/health", func(c *gin.Context) {
 		c.String(http.StatusOK, "ok")
 	})
@@ -38,11 +91,137 @@ func SetupRoutes(r *gin.Engine, db *gorm.DB, jwt_secret string) {
 	auth_controller := controllers.NewAuthController(login_service, registration_service)
 	r.POST("/register", auth_controller.Register)
 	r.POST("/login", auth_controller.Login)
+	r.POST("/login/mfa", auth_controller.LoginMfa)
+
+	oidc_controller := controllers.NewOidcController(oidc_service)
+	r.GET("/auth/oidc/:provider/start", oidc_controller.Start)
+	r.GET("/auth/oidc/:provider/callback", oidc_controller.Callback)
+
+	token_controller := controllers.NewTokenController(token_service)
+	r.POST("/token/refresh", token_controller.Refresh)
+
+	jwks_controller := controllers.NewJwksController(jwt_keys)
+	r.GET("/.well-known/jwks.json", jwks_controller.GetJwks)
 
 	auth := r.Group("/")
-	auth.Use(middleware.JwtMiddleware(jwt_secret))
-	auth.POST("/notes", note_controller.Create)
-	auth.GET("/notes", note_controller.GetNotes)
-	auth.GET("/notes/:id", note_controller.GetSingleNote)
-	// Todo: GET /notes, PUT /notes/:id, DELETE /notes/:id
+	auth.Use(middleware.JwtMiddleware(jwt_keys, revocation_store, access_token_service))
+
+	// personal access tokens cannot manage the account, so a leaked token cannot lock out its user
+	session := auth.Group("/", middleware.RequireSession())
+	logout_controller := controllers.NewLogoutController(logout_service)
+	session.POST("/logout", logout_controller.Logout)
+	session.POST("/logout/all", logout_controller.LogoutAll)
+	account_controller := controllers.NewAccountController(account_service)
+	session.PUT("/me/password", account_controller.ChangePassword)
+	session.DELETE("/me", account_controller.Delete)
+	access_token_controller := controllers.NewPersonalAccessTokenController(access_token_service)
+	session.POST("/me/tokens", access_token_controller.Create)
+	session.GET("/me/tokens", access_token_controller.GetTokens)
+	session.DELETE("/me/tokens/:id", access_token_controller.Delete)
+	mfa_controller := controllers.NewMfaController(mfa_service)
+	session.GET("/me/mfa", mfa_controller.GetStatus)
+	session.POST("/me/mfa/totp", mfa_controller.StartTotpEnrollment)
+	session.POST("/me/mfa/totp/confirm", mfa_controller.ConfirmTotpEnrollment)
+	session.DELETE("/me/mfa/totp", mfa_controller.DisableTotp)
+	session.POST("/me/mfa/recovery-codes", mfa_controller.RegenerateRecoveryCodes)
+
+	read := auth.Group("/", middleware.RequireScope(services.ScopeNotesRead))
+	write := auth.Group("/", middleware.RequireScope(services.ScopeNotesWrite))
+	write.POST("/notes", note_controller.Create)
+	read.GET("/notes", note_controller.GetNotes)
+	read.GET("/notes/search", note_controller.Search)
+	read.GET("/notes/:id", note_controller.GetSingleNote)
+	write.PUT("/notes/:id", note_controller.Update)
+	write.DELETE("/notes/:id", note_controller.Delete)
+	write.PUT("/notes/:id/notebook", note_controller.Move)
+	read.GET("/notes/:id/revisions", revision_controller.GetRevisions)
+	read.GET("/notes/:id/revisions/diff", revision_controller.Diff)
+	read.GET("/notes/:id/revisions/:rev", revision_controller.GetRevision)
+	write.POST("/notes/:id/revisions/:rev/restore", revision_controller.Restore)
+	read.GET("/tags", tag_controller.GetTags)
+	write.PUT("/tags/:id", tag_controller.Rename)
+	write.POST("/tags/:id/merge", tag_controller.Merge)
+	write.DELETE("/tags/:id", tag_controller.Delete)
+	write.POST("/notebooks", notebook_controller.Create)
+	read.GET("/notebooks", notebook_controller.GetNotebooks)
+	read.GET("/notebooks/:id", notebook_controller.GetContents)
+	write.PUT("/notebooks/:id", notebook_controller.Update)
+	write.DELETE("/notebooks/:id", notebook_controller.Delete)
+	read.GET("/trash", trash_controller.GetTrash)
+	write.POST("/trash/:id/restore", trash_controller.Restore)
+	write.DELETE("/trash/:id", trash_controller.Purge)
+	return nil
+}
+
+// jwtKeys signs access tokens with the active key of the keyring in JWTKeyringDir if it is set. Otherwise
+// they are signed with the private key in JWTPrivateKeyFile or with JWTSecret, which only verify tokens
+// issued before the keyring was set up if there is one.
+func jwtKeys(cfg *config.Config) (*services.JwtKeys, error) {
+	var configured_keys []*services.JwtKey
+	if cfg.JWTPrivateKeyFile != "" {
+		key, err := services.LoadJwtKeyFile(cfg.JWTKeyId, cfg.JWTPrivateKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		configured_keys = append(configured_keys, key)
+	} else if cfg.JWTSecret != "" || cfg.JWTKeyringDir == "" {
+		configured_keys = append(configured_keys, services.NewHmacJwtKey(cfg.JWTKeyId, []byte(cfg.JWTSecret)))
+	}
+
+	if cfg.JWTKeyringDir == "" {
+		if !configured_keys[0].CanSign() {
+			return nil, errors.New("JWT_PRIVATE_KEY_FILE holds a public key, signing requires a private key")
+		}
+		return services.NewJwtKeys(configured_keys[0]), nil
+	}
+	return services.NewJwtKeyring(cfg.JWTKeyringDir).Load(configured_keys...)
+}
+
+// totpSecretBox encrypts TOTP secrets with the given keys, it is nil if there are none.
+func totpSecretBox(secrets map[uint32]string) *utils.SecretBox {
+	keys := map[uint32][]byte{}
+	for id, secret := range secrets {
+		keys[id] = []byte(secret)
+	}
+	return utils.NewSecretBox(keys)
+}
+
+// oidcProviders returns the configured OpenID Connect providers, their endpoints are discovered on first use.
+func oidcProviders(configs []config.OidcProvider) []*services.OidcProvider {
+	providers := make([]*services.OidcProvider, 0, len(configs))
+	for _, provider := range configs {
+		providers = append(providers, services.NewOidcProvider(provider.Name, provider.Issuer, provider.ClientId,
+			provider.ClientSecret, provider.RedirectUrl, provider.Scopes))
+	}
+	return providers
+}
+
+// passwordHashers hashes new passwords with the given algorithm and verifies passwords of all algorithms,
+// including those imported from other systems. Argon2id and scrypt combine passwords with the peppers,
+// bcrypt hash strings cannot record a pepper.
+func passwordHashers(algorithm string, pepper_secrets map[uint32]string) *utils.PasswordHashers {
+	pepper_keys := map[uint32][]byte{}
+	for id, secret := range pepper_secrets {
+		pepper_keys[id] = []byte(secret)
+	}
+	peppers := utils.NewPeppers(pepper_keys)
+
+	threads := uint8(runtime.GOMAXPROCS(0))
+	argon2id := &utils.Argon2IdHasher{Time: 1, SaltLen: 32, Memory: 64 * 1024, Threads: threads, KeyLen: 256, Peppers: peppers}
+	scrypt := &utils.ScryptHasher{LogN: 17, R: 8, P: 1, KeyLen: 32, SaltLen: 16, Peppers: peppers}
+	bcrypt := &utils.BcryptHasher{Cost: 12}
+
+	var default_algorithm utils.PasswordAlgorithm = argon2id
+	switch algorithm {
+	case config.PasswordHashScrypt:
+		default_algorithm = scrypt
+	case config.PasswordHashBcrypt:
+		default_algorithm = bcrypt
+	}
+
+	hashers := utils.NewPasswordHashers(default_algorithm)
+	hashers.Register(argon2id, utils.Argon2IdId, utils.Argon2IdLegacyId)
+	hashers.Register(scrypt, utils.ScryptId)
+	hashers.Register(bcrypt, utils.BcryptIds...)
+	return hashers
 }
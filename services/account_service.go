@@ -0,0 +1,166 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"user-notes-api/auth"
+	"user-notes-api/repositories"
+)
+
+// PasswordChange replaces the password of the user. Users without a password leave OldPassword empty.
+type PasswordChange struct {
+	OldPassword string `json:"old_password"`
+	NewPassword string `json:"new_password" binding:"required"`
+}
+
+// AccountDeletion is the input for deleting the account of the user, which has to be confirmed with its
+// password. Users without a password leave it empty.
+type AccountDeletion struct {
+	Password string `json:"password"`
+}
+
+// DeleteAccountResult counts what was deleted together with the account.
+type DeleteAccountResult struct {
+	Notes     int `json:"Notes"`
+	Notebooks int `json:"Notebooks"`
+	Tags      int `json:"Tags"`
+}
+
+type AccountServiceIfc interface {
+	ChangePassword(ctx context.Context, userId uint, username string, authTime time.Time, change PasswordChange) (AuthTokens, error)
+	DeleteAccount(ctx context.Context, userId uint, username string, authTime time.Time, deletion AccountDeletion) (DeleteAccountResult, error)
+}
+
+type AccountService struct {
+	PasswordManager  auth.PasswordManagerIfc
+	Reauthentication *Reauthentication
+	Revocations      TokenRevocations
+	TokenIssuer      TokenIssuer
+	UserDeleter      repositories.UserDeleter
+	Throttle         LoginThrottleIfc
+}
+
+// Reauthentication confirms changes of the account with the password of the user. Users without a password,
+// who log in with OpenID Connect, confirm them with a login at most MaxAge ago instead.
+type Reauthentication struct {
+	UserReader      repositories.UserReader
+	PasswordManager auth.PasswordManagerIfc
+	MaxAge          time.Duration
+}
+
+// ErrorReauthenticationRequired means that a user without a password has to log in again to confirm a
+// change, since its login is older than MaxAge.
+type ErrorReauthenticationRequired struct {
+	MaxAge time.Duration
+}
+
+func (e *ErrorReauthenticationRequired) Error() string {
+	return fmt.Sprintf("log in again to confirm this change, the login must not be older than %s", e.MaxAge)
+}
+
+func NewAccountService(password_manager auth.PasswordManagerIfc, reauthentication *Reauthentication, revocations TokenRevocations,
+	token_issuer TokenIssuer, user_deleter repositories.UserDeleter, throttle LoginThrottleIfc) *AccountService {
+	account_service := AccountService{PasswordManager: password_manager, Reauthentication: reauthentication, Revocations: revocations,
+		TokenIssuer: token_issuer, UserDeleter: user_deleter, Throttle: throttle}
+	return &account_service
+}
+
+func NewReauthentication(user_reader repositories.UserReader, password_manager auth.PasswordManagerIfc, max_age time.Duration) *Reauthentication {
+	reauthentication := Reauthentication{UserReader: user_reader, PasswordManager: password_manager, MaxAge: max_age}
+	return &reauthentication
+}
+
+// Passwordless reports whether the user has no password.
+func (r *Reauthentication) Passwordless(ctx context.Context, userId uint) (bool, error) {
+	user, err := r.UserReader.FindUserById(ctx, userId)
+	if err != nil {
+		return false, fmt.Errorf("find user: %w", err)
+	}
+	return user.Passwordless, nil
+}
+
+// Verify confirms the identity of the user by its password, or for users without a password by the time
+// of its login, authTime. Wrong passwords are returned as ErrorWrongPassword, logins that are too old as
+// ErrorReauthenticationRequired.
+func (r *Reauthentication) Verify(ctx context.Context, userId uint, username string, authTime time.Time, password string) error {
+	passwordless, err := r.Passwordless(ctx, userId)
+	if err != nil {
+		return err
+	}
+	if passwordless {
+		if authTime.IsZero() || time.Since(authTime) > r.MaxAge {
+			return &ErrorReauthenticationRequired{MaxAge: r.MaxAge}
+		}
+		return nil
+	}
+
+	credentials := auth.Credentials{Username: username, Password: password}
+	isValid, err := r.PasswordManager.VerifyPassword(ctx, userId, &credentials)
+	if err != nil {
+		return err
+	}
+	if !isValid {
+		return &ErrorWrongPassword{Username: username}
+	}
+	return nil
+}
+
+// ChangePassword replaces the password of the user and revokes all tokens issued so far, so other
+// sessions have to log in with the new password. The session changing the password continues with the
+// returned tokens. Wrong passwords are throttled like failed logins. Users without a password set one
+// after a recent login, see Reauthentication.
+func (s *AccountService) ChangePassword(ctx context.Context, userId uint, username string, authTime time.Time, change PasswordChange) (AuthTokens, error) {
+	credentials := auth.Credentials{Username: username, Password: change.OldPassword}
+	err := throttleVerification(ctx, s.Throttle, username, func() error {
+		passwordless, err := s.Reauthentication.Passwordless(ctx, userId)
+		if err != nil {
+			return err
+		}
+		if passwordless {
+			err = s.Reauthentication.Verify(ctx, userId, username, authTime, "")
+			if err != nil {
+				return err
+			}
+			return s.PasswordManager.SetPassword(ctx, userId, username, change.NewPassword)
+		}
+
+		changed, err := s.PasswordManager.ChangePassword(ctx, userId, &credentials, change.NewPassword)
+		if err == nil && !changed {
+			return &ErrorWrongPassword{Username: username}
+		}
+		return err
+	})
+	if err != nil {
+		return AuthTokens{}, err
+	}
+
+	err = s.Revocations.RevokeAll(ctx, userId, time.Now())
+	if err != nil {
+		return AuthTokens{}, err
+	}
+
+	return s.TokenIssuer.IssueTokens(ctx, userId, username)
+}
+
+// DeleteAccount deletes the user with its notes, notebooks and tags after its identity was confirmed, see
+// Reauthentication. All tokens of the user are revoked. Wrong passwords are throttled like failed logins.
+func (s *AccountService) DeleteAccount(ctx context.Context, userId uint, username string, authTime time.Time, deletion AccountDeletion) (DeleteAccountResult, error) {
+	err := throttleVerification(ctx, s.Throttle, username, func() error {
+		return s.Reauthentication.Verify(ctx, userId, username, authTime, deletion.Password)
+	})
+	if err != nil {
+		return DeleteAccountResult{}, err
+	}
+
+	result, err := s.UserDeleter.DeleteUserById(ctx, userId)
+	if err != nil {
+		return DeleteAccountResult{}, fmt.Errorf("delete account: %w", err)
+	}
+
+	// the deletion revoked the tokens in the database, this makes it take effect in the cache right away
+	s.Revocations.Forget(userId)
+
+	return DeleteAccountResult{Notes: result.Notes, Notebooks: result.Notebooks, Tags: result.Tags}, nil
+}
@@ -2,6 +2,7 @@ package services
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"time"
 	"user-notes-api/auth"
@@ -11,25 +12,47 @@ import (
 
 type JwtClaims struct {
 	UserId uint `json:"user_id,omitempty"`
+	// Generation is the token generation of the user when the token was issued, see models.User.
+	Generation uint `json:"gen,omitempty"`
+	// AuthTime is the time of the login the token descends from, kept when the token is refreshed.
+	AuthTime *jwt.NumericDate `json:"auth_time,omitempty"`
 	jwt.RegisteredClaims
 }
 
 type LoginServiceIfc interface {
-	Login(ctx context.Context, credentials auth.Credentials) (string, error)
+	Login(ctx context.Context, credentials auth.Credentials, clientIp string) (LoginResult, error)
+	VerifyMfa(ctx context.Context, verification MfaVerification, clientIp string) (AuthTokens, error)
 }
 
 type RegistrationServiceIfc interface {
-	Register(ctx context.Context, credentials auth.Credentials) (string, error)
+	Register(ctx context.Context, credentials auth.Credentials) (AuthTokens, error)
 }
 
 type LoginService struct {
 	LoginManager auth.LoginManagerIfc
-	jwt_secret   string
+	TokenIssuer  TokenIssuer
+	Throttle     LoginThrottleIfc
+	MfaTokens    MfaTokenIssuer
+	Mfa          MfaVerifier
+}
+
+// LoginResult holds the tokens of a login. For users with two-factor authentication, it holds the
+// challenge for the second factor instead.
+type LoginResult struct {
+	AuthTokens
+	Mfa *MfaChallenge
+}
+
+// MfaVerification is the second step of a login with two-factor authentication. Code is a TOTP code or a
+// recovery code.
+type MfaVerification struct {
+	MfaToken string `json:"mfa_token" binding:"required"`
+	Code     string `json:"code" binding:"required"`
 }
 
 type RegistrationService struct {
 	RegistrationManager auth.RegistrationManagerIfc
-	jwt_secret          string
+	TokenIssuer         TokenIssuer
 }
 
 type ErrorWrongPassword struct {
@@ -44,62 +67,109 @@ func (c *JwtClaims) GetUserId() (uint, error) {
 	return c.UserId, nil
 }
 
-func NewLoginService(login_manager auth.LoginManagerIfc, jwt_secret string) *LoginService {
-	login_service := LoginService{LoginManager: login_manager, jwt_secret: jwt_secret}
+func NewLoginService(login_manager auth.LoginManagerIfc, token_issuer TokenIssuer, throttle LoginThrottleIfc,
+	mfa_tokens MfaTokenIssuer, mfa MfaVerifier) *LoginService {
+	login_service := LoginService{LoginManager: login_manager, TokenIssuer: token_issuer, Throttle: throttle,
+		MfaTokens: mfa_tokens, Mfa: mfa}
 	return &login_service
 }
 
-func NewRegistrationService(registration_manager auth.RegistrationManagerIfc, jwt_secret string) *RegistrationService {
-	registration_service := RegistrationService{RegistrationManager: registration_manager, jwt_secret: jwt_secret}
+func NewRegistrationService(registration_manager auth.RegistrationManagerIfc, token_issuer TokenIssuer) *RegistrationService {
+	registration_service := RegistrationService{RegistrationManager: registration_manager, TokenIssuer: token_issuer}
 	return &registration_service
 }
 
-func (s *LoginService) Login(ctx context.Context, credentials auth.Credentials) (string, error) {
+// Login checks the credentials unless there were too many failed logins for the username or from the
+// client IP, then ErrorLoginThrottled is returned. Unknown users and wrong passwords count as failed logins.
+// For users with two-factor authentication, the result holds an MfaChallenge instead of tokens.
+func (s *LoginService) Login(ctx context.Context, credentials auth.Credentials, clientIp string) (LoginResult, error) {
+	username := credentials.Username
+	reservation, err := s.Throttle.Reserve(ctx, username, clientIp, time.Now())
+	if err != nil {
+		return LoginResult{}, err
+	}
+
+	// unknown users and wrong passwords keep the reservation as failed login
 	user_id, isValid, err := s.LoginManager.LoginUser(ctx, &credentials)
+	var errNotFound *auth.ErrorNotFound
+	if errors.As(err, &errNotFound) {
+		return LoginResult{}, err
+	}
 	if err != nil {
-		return "", err
+		return LoginResult{}, refundAttempt(ctx, s.Throttle, reservation, err)
 	}
 
 	if !isValid {
 		myErr := ErrorWrongPassword{Username: credentials.Username}
-		return "", &myErr
+		return LoginResult{}, &myErr
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, JwtClaims{
-		UserId: user_id,
-		RegisteredClaims: jwt.RegisteredClaims{
-			Issuer:    "auth.user-notes-api.local",
-			Subject:   credentials.Username,
-			IssuedAt:  jwt.NewNumericDate(time.Now()),
-			NotBefore: jwt.NewNumericDate(time.Now()),
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(4 * time.Hour)),
-		},
-	})
+	mfa_enabled, err := s.Mfa.MfaEnabled(ctx, user_id)
+	if err != nil {
+		return LoginResult{}, refundAttempt(ctx, s.Throttle, reservation, err)
+	}
+	if mfa_enabled {
+		// the failures are only reset by VerifyMfa, otherwise entering the password again would allow
+		// guessing codes without limit
+		err = s.Throttle.Refund(ctx, reservation)
+		if err != nil {
+			return LoginResult{}, err
+		}
+		challenge, err := s.MfaTokens.IssueMfaToken(user_id, credentials.Username)
+		if err != nil {
+			return LoginResult{}, err
+		}
+		return LoginResult{Mfa: &challenge}, nil
+	}
 
-	token_string, err := token.SignedString([]byte(s.jwt_secret))
+	err = s.Throttle.Success(ctx, username, clientIp)
+	if err != nil {
+		return LoginResult{}, err
+	}
 
-	return token_string, err
+	tokens, err := s.TokenIssuer.IssueTokens(ctx, user_id, credentials.Username)
+	if err != nil {
+		return LoginResult{}, err
+	}
+	return LoginResult{AuthTokens: tokens}, nil
 }
 
-func (s *RegistrationService) Register(ctx context.Context, credentials auth.Credentials) (string, error) {
-	user_id, err := s.RegistrationManager.RegisterUser(ctx, &credentials)
+// VerifyMfa exchanges the MFA token of a login and a TOTP code or recovery code for the tokens of the
+// login. Wrong codes count as failed logins of the username, so they are throttled like wrong passwords.
+func (s *LoginService) VerifyMfa(ctx context.Context, verification MfaVerification, clientIp string) (AuthTokens, error) {
+	claims, err := s.MfaTokens.ParseMfaToken(verification.MfaToken)
+	if err != nil {
+		return AuthTokens{}, err
+	}
 
+	reservation, err := s.Throttle.Reserve(ctx, claims.Username, clientIp, time.Now())
 	if err != nil {
-		return "", err
+		return AuthTokens{}, err
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, JwtClaims{
-		UserId: user_id,
-		RegisteredClaims: jwt.RegisteredClaims{
-			Issuer:    "auth.user-notes-api.local",
-			Subject:   credentials.Username,
-			IssuedAt:  jwt.NewNumericDate(time.Now()),
-			NotBefore: jwt.NewNumericDate(time.Now()),
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(4 * time.Hour)),
-		},
-	})
+	err = s.Mfa.VerifyCode(ctx, claims.UserId, verification.Code)
+	var errWrongCode *ErrorWrongMfaCode
+	if errors.As(err, &errWrongCode) {
+		return AuthTokens{}, err
+	}
+	if err != nil {
+		return AuthTokens{}, refundAttempt(ctx, s.Throttle, reservation, err)
+	}
 
-	token_string, err := token.SignedString([]byte(s.jwt_secret))
+	err = s.Throttle.Success(ctx, claims.Username, clientIp)
+	if err != nil {
+		return AuthTokens{}, err
+	}
+
+	return s.TokenIssuer.IssueTokens(ctx, claims.UserId, claims.Username)
+}
+
+func (s *RegistrationService) Register(ctx context.Context, credentials auth.Credentials) (AuthTokens, error) {
+	user_id, err := s.RegistrationManager.RegisterUser(ctx, &credentials)
+
+	if err != nil {
+		return AuthTokens{}, err
+	}
 
-	return token_string, err
+	return s.TokenIssuer.IssueTokens(ctx, user_id, credentials.Username)
 }
@@ -0,0 +1,282 @@
+package services
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Algorithms of keys generated by JwtKeyring.Generate.
+const (
+	JwtAlgorithmHS256 = "HS256"
+	JwtAlgorithmRS256 = "RS256"
+	JwtAlgorithmEdDSA = "EdDSA"
+)
+
+const (
+	// jwtKeyringActiveFile holds the kid of the signing key.
+	jwtKeyringActiveFile = "active"
+	// Key files are named after their kid. PEM files hold RSA or Ed25519 keys, secret files HMAC secrets.
+	jwtKeyPemExtension    = ".pem"
+	jwtKeySecretExtension = ".secret"
+
+	generatedRsaKeyBits = 3072
+	// generatedSecretBytes is the number of random bytes in a generated HMAC secret.
+	generatedSecretBytes = 32
+)
+
+// validKid matches kids that can be used as file names. Thumbprints are base64url and match as well.
+var validKid = regexp.MustCompile(`^[A-Za-z0-9_-][A-Za-z0-9_.-]{0,63}$`)
+
+// JwtKeyring is a directory of JWT keys, one file per key named after its kid, and a file named active
+// holding the kid of the signing key. All other keys only verify tokens, so tokens signed with a retired key
+// stay valid until they expire. Keys are read at startup, changes take effect when the API is restarted.
+type JwtKeyring struct {
+	Dir string
+}
+
+// ErrorInvalidKid is returned for kids that are empty, too long or contain characters other than letters,
+// digits, '_', '-' and '.'.
+type ErrorInvalidKid struct {
+	Kid string
+}
+
+func (e *ErrorInvalidKid) Error() string {
+	return fmt.Sprintf("invalid kid %q: kids consist of up to 64 letters, digits, '_', '-' and '.'", e.Kid)
+}
+
+func NewJwtKeyring(dir string) *JwtKeyring {
+	keyring := JwtKeyring{Dir: dir}
+	return &keyring
+}
+
+// Load returns the keys of the keyring signing with the active key. The legacy keys are added for
+// verification, like the key of JWT_SECRET that signed tokens without a kid before the keyring was used.
+func (r *JwtKeyring) Load(legacy_keys ...*JwtKey) (*JwtKeys, error) {
+	keys, active, err := r.Keys()
+	if err != nil {
+		return nil, err
+	}
+	if active == "" {
+		return nil, fmt.Errorf("jwt keyring %s has no active key", r.Dir)
+	}
+
+	var signing_key *JwtKey
+	var verification_keys []*JwtKey
+	for _, key := range keys {
+		if key.Kid == active {
+			signing_key = key
+		} else {
+			verification_keys = append(verification_keys, key)
+		}
+	}
+	if signing_key == nil {
+		return nil, fmt.Errorf("active jwt key %q is not in the keyring %s", active, r.Dir)
+	}
+	if !signing_key.CanSign() {
+		return nil, fmt.Errorf("active jwt key %q cannot sign tokens", active)
+	}
+
+	for _, legacy_key := range legacy_keys {
+		if r.keyPath(legacy_key.Kid) != "" {
+			return nil, fmt.Errorf("jwt key %q is both in the keyring and configured separately", legacy_key.Kid)
+		}
+		verification_keys = append(verification_keys, legacy_key)
+	}
+	return NewJwtKeys(signing_key, verification_keys...), nil
+}
+
+// Keys returns the keys of the keyring ordered by kid and the kid of the active key, which is empty if
+// no key was promoted yet.
+func (r *JwtKeyring) Keys() ([]*JwtKey, string, error) {
+	entries, err := os.ReadDir(r.Dir)
+	if err != nil {
+		return nil, "", fmt.Errorf("read jwt keyring: %w", err)
+	}
+
+	var keys []*JwtKey
+	for _, entry := range entries {
+		extension := filepath.Ext(entry.Name())
+		if !entry.Type().IsRegular() || (extension != jwtKeyPemExtension && extension != jwtKeySecretExtension) {
+			continue
+		}
+
+		key, err := r.readKey(strings.TrimSuffix(entry.Name(), extension), extension)
+		if err != nil {
+			return nil, "", err
+		}
+		keys = append(keys, key)
+	}
+
+	active, err := os.ReadFile(filepath.Join(r.Dir, jwtKeyringActiveFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return keys, "", nil
+	}
+	if err != nil {
+		return nil, "", fmt.Errorf("read active jwt key: %w", err)
+	}
+	return keys, strings.TrimSpace(string(active)), nil
+}
+
+// Generate creates a key for the algorithm, one of JwtAlgorithmHS256, JwtAlgorithmRS256 and
+// JwtAlgorithmEdDSA. Without a kid, asymmetric keys are named after their thumbprint and HMAC secrets get
+// a random kid. The key only verifies tokens until it is promoted.
+func (r *JwtKeyring) Generate(algorithm string, kid string) (*JwtKey, error) {
+	var key *JwtKey
+	var file_data []byte
+	extension := jwtKeyPemExtension
+	switch algorithm {
+	case JwtAlgorithmHS256:
+		if kid == "" {
+			random_kid, err := randomString(12)
+			if err != nil {
+				return nil, fmt.Errorf("generate kid: %w", err)
+			}
+			kid = random_kid
+		}
+		secret, err := randomString(generatedSecretBytes)
+		if err != nil {
+			return nil, fmt.Errorf("generate hmac secret: %w", err)
+		}
+		key = NewHmacJwtKey(kid, []byte(secret))
+		file_data = []byte(secret + "\n")
+		extension = jwtKeySecretExtension
+	case JwtAlgorithmRS256:
+		private_key, err := rsa.GenerateKey(rand.Reader, generatedRsaKeyBits)
+		if err != nil {
+			return nil, fmt.Errorf("generate rsa key: %w", err)
+		}
+		key, err = NewRsaJwtKey(kid, private_key)
+		if err != nil {
+			return nil, err
+		}
+		file_data, err = encodePrivateKeyPEM(private_key)
+		if err != nil {
+			return nil, err
+		}
+	case JwtAlgorithmEdDSA:
+		_, private_key, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, fmt.Errorf("generate ed25519 key: %w", err)
+		}
+		key = NewEd25519JwtKey(kid, private_key)
+		file_data, err = encodePrivateKeyPEM(private_key)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unsupported jwt algorithm %q", algorithm)
+	}
+
+	if !validKid.MatchString(key.Kid) {
+		return nil, &ErrorInvalidKid{Kid: key.Kid}
+	}
+	if r.keyPath(key.Kid) != "" {
+		return nil, fmt.Errorf("jwt key %q already exists", key.Kid)
+	}
+
+	err := os.MkdirAll(r.Dir, 0o700)
+	if err != nil {
+		return nil, fmt.Errorf("create jwt keyring: %w", err)
+	}
+
+	file, err := os.OpenFile(filepath.Join(r.Dir, key.Kid+extension), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("write jwt key: %w", err)
+	}
+	_, err = file.Write(file_data)
+	if close_err := file.Close(); err == nil {
+		err = close_err
+	}
+	if err != nil {
+		return nil, fmt.Errorf("write jwt key: %w", err)
+	}
+	return key, nil
+}
+
+// Promote makes the key with the kid the signing key. The previously active key keeps verifying tokens
+// until its file is removed.
+func (r *JwtKeyring) Promote(kid string) error {
+	path := r.keyPath(kid)
+	if path == "" {
+		return fmt.Errorf("jwt key %q is not in the keyring %s", kid, r.Dir)
+	}
+
+	key, err := r.readKey(kid, filepath.Ext(path))
+	if err != nil {
+		return err
+	}
+	if !key.CanSign() {
+		return fmt.Errorf("jwt key %q cannot sign tokens", kid)
+	}
+
+	// replace the active file at once, so the API never reads a partially written kid
+	tmp, err := os.CreateTemp(r.Dir, jwtKeyringActiveFile+".*")
+	if err != nil {
+		return fmt.Errorf("promote jwt key: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.WriteString(kid + "\n")
+	if close_err := tmp.Close(); err == nil {
+		err = close_err
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), filepath.Join(r.Dir, jwtKeyringActiveFile))
+	}
+	if err != nil {
+		return fmt.Errorf("promote jwt key: %w", err)
+	}
+	return nil
+}
+
+// keyPath returns the path of the file of the key with the kid, or an empty string if there is none.
+func (r *JwtKeyring) keyPath(kid string) string {
+	if !validKid.MatchString(kid) {
+		return ""
+	}
+	for _, extension := range []string{jwtKeyPemExtension, jwtKeySecretExtension} {
+		path := filepath.Join(r.Dir, kid+extension)
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+	}
+	return ""
+}
+
+func (r *JwtKeyring) readKey(kid string, extension string) (*JwtKey, error) {
+	if !validKid.MatchString(kid) {
+		return nil, &ErrorInvalidKid{Kid: kid}
+	}
+
+	path := filepath.Join(r.Dir, kid+extension)
+	if extension == jwtKeyPemExtension {
+		return LoadJwtKeyFile(kid, path)
+	}
+
+	secret, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read jwt key: %w", err)
+	}
+	secret = []byte(strings.TrimSpace(string(secret)))
+	if len(secret) == 0 {
+		return nil, fmt.Errorf("jwt secret %s is empty", path)
+	}
+	return NewHmacJwtKey(kid, secret), nil
+}
+
+func encodePrivateKeyPEM(private_key any) ([]byte, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(private_key)
+	if err != nil {
+		return nil, fmt.Errorf("encode jwt key: %w", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+}
@@ -0,0 +1,303 @@
+package services
+
+import (
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// minRsaKeyBits is the smallest RSA modulus accepted for signing access tokens.
+const minRsaKeyBits = 2048
+
+// JwtKey signs or verifies access tokens with one algorithm. Kid identifies the key in the token header
+// and in the JWKS.
+type JwtKey struct {
+	Kid    string
+	Method jwt.SigningMethod
+
+	signingKey      any
+	verificationKey any
+}
+
+// Jwk is the public part of a key in the JSON Web Key format of RFC 7517.
+type Jwk struct {
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+// Jwks is the JSON Web Key Set published at /.well-known/jwks.json.
+type Jwks struct {
+	Keys []Jwk `json:"keys"`
+}
+
+type JwksProvider interface {
+	Jwks() Jwks
+}
+
+// ErrorUnknownJwtKey is returned for tokens whose kid is unknown or whose algorithm does not match the key.
+type ErrorUnknownJwtKey struct {
+	Kid string
+	Alg string
+}
+
+func (e *ErrorUnknownJwtKey) Error() string {
+	return fmt.Sprintf("no %s key with kid %q", e.Alg, e.Kid)
+}
+
+// NewHmacJwtKey returns a key signing and verifying with HS256. The secret is shared by everyone who
+// verifies tokens, so it is never published.
+func NewHmacJwtKey(kid string, secret []byte) *JwtKey {
+	key := JwtKey{Kid: kid, Method: jwt.SigningMethodHS256, signingKey: secret, verificationKey: secret}
+	return &key
+}
+
+// NewRsaJwtKey returns a key signing with RS256. Without a kid, the RFC 7638 thumbprint of the public key
+// is used.
+func NewRsaJwtKey(kid string, private_key *rsa.PrivateKey) (*JwtKey, error) {
+	key, err := newRsaPublicJwtKey(kid, &private_key.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	key.signingKey = private_key
+	return key, nil
+}
+
+// newRsaPublicJwtKey returns a key that only verifies tokens signed with RS256.
+func newRsaPublicJwtKey(kid string, public_key *rsa.PublicKey) (*JwtKey, error) {
+	if public_key.N.BitLen() < minRsaKeyBits {
+		return nil, fmt.Errorf("rsa key has %d bits, at least %d are required", public_key.N.BitLen(), minRsaKeyBits)
+	}
+
+	key := JwtKey{Kid: kid, Method: jwt.SigningMethodRS256, verificationKey: public_key}
+	if key.Kid == "" {
+		key.Kid = key.thumbprint()
+	}
+	return &key, nil
+}
+
+// NewEd25519JwtKey returns a key signing with EdDSA. Without a kid, the RFC 7638 thumbprint of the public
+// key is used.
+func NewEd25519JwtKey(kid string, private_key ed25519.PrivateKey) *JwtKey {
+	key := newEd25519PublicJwtKey(kid, private_key.Public().(ed25519.PublicKey))
+	key.signingKey = private_key
+	return key
+}
+
+// newEd25519PublicJwtKey returns a key that only verifies tokens signed with EdDSA.
+func newEd25519PublicJwtKey(kid string, public_key ed25519.PublicKey) *JwtKey {
+	key := JwtKey{Kid: kid, Method: jwt.SigningMethodEdDSA, verificationKey: public_key}
+	if key.Kid == "" {
+		key.Kid = key.thumbprint()
+	}
+	return &key
+}
+
+// ParseJwtKeyPEM parses an RSA or Ed25519 key in PEM format, RSA keys sign with RS256 and Ed25519 keys with
+// EdDSA. Public keys only verify tokens.
+func ParseJwtKeyPEM(kid string, pem_data []byte) (*JwtKey, error) {
+	if rsa_key, err := jwt.ParseRSAPrivateKeyFromPEM(pem_data); err == nil {
+		return NewRsaJwtKey(kid, rsa_key)
+	}
+	if ed_key, err := jwt.ParseEdPrivateKeyFromPEM(pem_data); err == nil {
+		return NewEd25519JwtKey(kid, ed_key.(ed25519.PrivateKey)), nil
+	}
+	if rsa_key, err := jwt.ParseRSAPublicKeyFromPEM(pem_data); err == nil {
+		return newRsaPublicJwtKey(kid, rsa_key)
+	}
+	if ed_key, err := jwt.ParseEdPublicKeyFromPEM(pem_data); err == nil {
+		return newEd25519PublicJwtKey(kid, ed_key.(ed25519.PublicKey)), nil
+	}
+	return nil, errors.New("key is neither an RSA nor an Ed25519 key in PEM format")
+}
+
+// LoadJwtKeyFile reads a key in PEM format from a file, see ParseJwtKeyPEM.
+func LoadJwtKeyFile(kid string, path string) (*JwtKey, error) {
+	pem_data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read jwt key: %w", err)
+	}
+
+	key, err := ParseJwtKeyPEM(kid, pem_data)
+	if err != nil {
+		return nil, fmt.Errorf("parse jwt key %s: %w", path, err)
+	}
+	return key, nil
+}
+
+// CanSign reports whether the key holds a private key or secret, keys of other services only verify tokens.
+func (k *JwtKey) CanSign() bool {
+	return k.signingKey != nil
+}
+
+// Jwk returns the public key in JWK format. HMAC keys have no public part, so ok is false for them.
+func (k *JwtKey) Jwk() (Jwk, bool) {
+	switch public_key := k.verificationKey.(type) {
+	case *rsa.PublicKey:
+		return Jwk{Kty: "RSA", Use: "sig", Alg: k.Method.Alg(), Kid: k.Kid, N: encodeBigInt(public_key.N),
+			E: encodeBigInt(big.NewInt(int64(public_key.E)))}, true
+	case ed25519.PublicKey:
+		return Jwk{Kty: "OKP", Use: "sig", Alg: k.Method.Alg(), Kid: k.Kid, Crv: "Ed25519",
+			X: base64.RawURLEncoding.EncodeToString(public_key)}, true
+	}
+	return Jwk{}, false
+}
+
+// ParseJwk returns a key that verifies tokens with the public key in JWK format, the reverse of Jwk. Only
+// RSA keys for RS256 and Ed25519 keys for EdDSA are supported.
+func ParseJwk(jwk Jwk) (*JwtKey, error) {
+	switch {
+	case jwk.Kty == "RSA" && (jwk.Alg == "" || jwk.Alg == jwt.SigningMethodRS256.Alg()):
+		n, err := base64.RawURLEncoding.DecodeString(jwk.N)
+		if err != nil {
+			return nil, fmt.Errorf("invalid jwk %q: %w", jwk.Kid, err)
+		}
+		e, err := base64.RawURLEncoding.DecodeString(jwk.E)
+		if err != nil || len(e) == 0 || len(e) > 4 {
+			return nil, fmt.Errorf("invalid jwk %q: invalid exponent", jwk.Kid)
+		}
+		public_key := rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+		return newRsaPublicJwtKey(jwk.Kid, &public_key)
+	case jwk.Kty == "OKP" && jwk.Crv == "Ed25519" && (jwk.Alg == "" || jwk.Alg == jwt.SigningMethodEdDSA.Alg()):
+		x, err := base64.RawURLEncoding.DecodeString(jwk.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("invalid jwk %q: invalid ed25519 key", jwk.Kid)
+		}
+		return newEd25519PublicJwtKey(jwk.Kid, ed25519.PublicKey(x)), nil
+	}
+	return nil, fmt.Errorf("unsupported jwk %q with kty %q and alg %q", jwk.Kid, jwk.Kty, jwk.Alg)
+}
+
+// thumbprint returns the JWK thumbprint of RFC 7638, the hash of the required members of the public key
+// in lexicographic order.
+func (k *JwtKey) thumbprint() string {
+	jwk, _ := k.Jwk()
+
+	var members any
+	if jwk.Kty == "RSA" {
+		members = struct {
+			E   string `json:"e"`
+			Kty string `json:"kty"`
+			N   string `json:"n"`
+		}{E: jwk.E, Kty: jwk.Kty, N: jwk.N}
+	} else {
+		members = struct {
+			Crv string `json:"crv"`
+			Kty string `json:"kty"`
+			X   string `json:"x"`
+		}{Crv: jwk.Crv, Kty: jwk.Kty, X: jwk.X}
+	}
+
+	// marshaling structs of strings cannot fail
+	encoded, _ := json.Marshal(members)
+	hash := sha256.Sum256(encoded)
+	return base64.RawURLEncoding.EncodeToString(hash[:])
+}
+
+func encodeBigInt(n *big.Int) string {
+	return base64.RawURLEncoding.EncodeToString(n.Bytes())
+}
+
+// JwtKeys signs access tokens with its signing key and verifies them with the key named by the kid in
+// their header, which may be the signing key or one of the verify-only keys, e.g. retired signing keys
+// whose tokens have not expired yet. Tokens without a kid were issued before kids were introduced and are
+// verified with the key with an empty kid, or the signing key if there is none.
+type JwtKeys struct {
+	signing *JwtKey
+	keys    map[string]*JwtKey
+}
+
+// NewJwtKeys returns the keys signing with signing_key, which has to be able to sign. The kids of all keys
+// have to be different.
+func NewJwtKeys(signing_key *JwtKey, verification_keys ...*JwtKey) *JwtKeys {
+	keys := JwtKeys{signing: signing_key, keys: map[string]*JwtKey{signing_key.Kid: signing_key}}
+	for _, key := range verification_keys {
+		keys.keys[key.Kid] = key
+	}
+	return &keys
+}
+
+// SigningKey returns the key new tokens are signed with.
+func (k *JwtKeys) SigningKey() *JwtKey {
+	return k.signing
+}
+
+// Keys returns all keys ordered by kid.
+func (k *JwtKeys) Keys() []*JwtKey {
+	keys := make([]*JwtKey, 0, len(k.keys))
+	for _, key := range k.keys {
+		keys = append(keys, key)
+	}
+	slices.SortFunc(keys, func(a, b *JwtKey) int { return strings.Compare(a.Kid, b.Kid) })
+	return keys
+}
+
+// Sign returns the signed token with the kid of the signing key in its header.
+func (k *JwtKeys) Sign(claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(k.signing.Method, claims)
+	if k.signing.Kid != "" {
+		token.Header["kid"] = k.signing.Kid
+	}
+	return token.SignedString(k.signing.signingKey)
+}
+
+// Keyfunc returns the key to verify the token with. The algorithm of the token has to be the one of the
+// key, so a public key can never be used as HMAC secret.
+func (k *JwtKeys) Keyfunc(token *jwt.Token) (any, error) {
+	key := k.keys[""]
+	if key == nil {
+		key = k.signing
+	}
+	if kid, found := token.Header["kid"]; found {
+		kid_string, ok := kid.(string)
+		if !ok {
+			return nil, &ErrorUnknownJwtKey{Alg: token.Method.Alg()}
+		}
+		key = k.keys[kid_string]
+		if key == nil {
+			return nil, &ErrorUnknownJwtKey{Kid: kid_string, Alg: token.Method.Alg()}
+		}
+	}
+
+	if token.Method.Alg() != key.Method.Alg() {
+		return nil, &ErrorUnknownJwtKey{Kid: key.Kid, Alg: token.Method.Alg()}
+	}
+	return key.verificationKey, nil
+}
+
+// Methods returns the algorithms of the keys, to be passed to jwt.WithValidMethods.
+func (k *JwtKeys) Methods() []string {
+	var methods []string
+	for _, key := range k.keys {
+		if !slices.Contains(methods, key.Method.Alg()) {
+			methods = append(methods, key.Method.Alg())
+		}
+	}
+	return methods
+}
+
+// Jwks returns the public keys ordered by kid, HMAC keys are left out.
+func (k *JwtKeys) Jwks() Jwks {
+	jwks := Jwks{Keys: []Jwk{}}
+	for _, key := range k.Keys() {
+		if jwk, ok := key.Jwk(); ok {
+			jwks.Keys = append(jwks.Keys, jwk)
+		}
+	}
+	return jwks
+}
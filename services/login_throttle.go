@@ -0,0 +1,292 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"user-notes-api/utils"
+)
+
+// LoginAttemptTracker stores failed logins per key. MemoryLoginAttempts keeps them in memory,
+// repositories.LoginAttemptRepository in the database, so they are shared between instances of the API.
+//
+// ReserveLoginAttempt counts a login as failed before the password is checked, unless logins for the key
+// are locked at now. It returns the number of failures including the reserved one, or 0 and the time until
+// which logins are locked if they are. If delay returns a duration for the number of failures, logins for
+// the key are locked for it from now. Counting and locking are atomic, so concurrent logins cannot get
+// past the limit. RefundLoginAttempt gives back the reservation with the given number of failures.
+type LoginAttemptTracker interface {
+	ReserveLoginAttempt(ctx context.Context, key string, now time.Time, since time.Time,
+		delay func(failures int) time.Duration) (int, time.Time, error)
+	RefundLoginAttempt(ctx context.Context, key string, failures int) error
+	ResetLoginFailures(ctx context.Context, key string) error
+	DeleteStaleLoginAttempts(ctx context.Context, before time.Time) (int, error)
+}
+
+type LoginThrottleIfc interface {
+	Reserve(ctx context.Context, username string, clientIp string, now time.Time) (LoginReservation, error)
+	Refund(ctx context.Context, reservation LoginReservation) error
+	Success(ctx context.Context, username string, clientIp string) error
+}
+
+// LoginReservation holds the failures reserved for a login by LoginThrottle.Reserve, by tracked key.
+type LoginReservation struct {
+	failures map[string]int
+}
+
+// ErrorLoginThrottled is returned if logins for the username or from the client IP are refused for now.
+type ErrorLoginThrottled struct {
+	RetryAfter time.Duration
+}
+
+func (e *ErrorLoginThrottled) Error() string {
+	return fmt.Sprintf("too many failed logins, retry after %s", e.RetryAfter)
+}
+
+// LoginBackoff refuses logins after MaxFailures failed logins, first for BaseDelay, doubling the delay
+// with every further failure up to MaxDelay. A MaxFailures of 0 disables it.
+type LoginBackoff struct {
+	MaxFailures int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+}
+
+// Delay returns how long logins are refused after the given number of failed logins.
+func (b LoginBackoff) Delay(failures int) time.Duration {
+	if b.MaxFailures == 0 || failures < b.MaxFailures {
+		return 0
+	}
+
+	delay := b.BaseDelay
+	for i := b.MaxFailures; i < failures && delay < b.MaxDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, b.MaxDelay)
+}
+
+// LoginThrottle counts failed logins per username and per client IP and refuses further logins with an
+// exponential backoff. Failed logins older than Window are forgotten, a successful login resets both
+// counters.
+//
+// Every login is counted as failed up front by Reserve and only given back by Success or Refund, so
+// parallel guesses are limited like sequential ones. Since the username is tracked regardless of the
+// client IP, anyone can lock a user out for a while by failing to log in as it. This is accepted, keying
+// the failures on the username and the client IP instead would allow guessing a password from many
+// addresses.
+type LoginThrottle struct {
+	Tracker  LoginAttemptTracker
+	Username LoginBackoff
+	ClientIp LoginBackoff
+	Window   time.Duration
+}
+
+func NewLoginThrottle(tracker LoginAttemptTracker, username LoginBackoff, client_ip LoginBackoff, window time.Duration) *LoginThrottle {
+	throttle := LoginThrottle{Tracker: tracker, Username: username, ClientIp: client_ip, Window: window}
+	return &throttle
+}
+
+func usernameAttemptKey(username string) string {
+	return "user:" + utils.UsernameKey(username)
+}
+
+func clientIpAttemptKey(clientIp string) string {
+	return "ip:" + clientIp
+}
+
+// keys returns the tracked keys of the login together with their backoff. The client IP is not tracked
+// if it is unknown.
+func (t *LoginThrottle) keys(username string, clientIp string) map[string]LoginBackoff {
+	keys := map[string]LoginBackoff{usernameAttemptKey(username): t.Username}
+	if clientIp != "" {
+		keys[clientIpAttemptKey(clientIp)] = t.ClientIp
+	}
+	return keys
+}
+
+// Reserve counts the login as failed for the username and the client IP before the password is checked,
+// and returns ErrorLoginThrottled instead if logins for either of them are refused at now. The reservation
+// stays counted as failed login unless it is given back with Success or Refund.
+func (t *LoginThrottle) Reserve(ctx context.Context, username string, clientIp string, now time.Time) (LoginReservation, error) {
+	reservation := LoginReservation{failures: map[string]int{}}
+	var retry_after time.Duration
+	for key, backoff := range t.keys(username, clientIp) {
+		failures, locked_until, err := t.Tracker.ReserveLoginAttempt(ctx, key, now, now.Add(-t.Window), backoff.Delay)
+		if err != nil {
+			return LoginReservation{}, refundAttempt(ctx, t, reservation, fmt.Errorf("count login: %w", err))
+		}
+		if failures == 0 {
+			retry_after = max(retry_after, locked_until.Sub(now))
+			continue
+		}
+		reservation.failures[key] = failures
+	}
+
+	if retry_after > 0 {
+		return LoginReservation{}, refundAttempt(ctx, t, reservation, &ErrorLoginThrottled{RetryAfter: retry_after})
+	}
+	return reservation, nil
+}
+
+// Refund gives back a reservation of a login that failed for another reason than a wrong password or code.
+func (t *LoginThrottle) Refund(ctx context.Context, reservation LoginReservation) error {
+	for key, failures := range reservation.failures {
+		err := t.Tracker.RefundLoginAttempt(ctx, key, failures)
+		if err != nil {
+			return fmt.Errorf("refund login: %w", err)
+		}
+	}
+	return nil
+}
+
+// Success resets the failed logins for the username and from the client IP.
+func (t *LoginThrottle) Success(ctx context.Context, username string, clientIp string) error {
+	for key := range t.keys(username, clientIp) {
+		err := t.Tracker.ResetLoginFailures(ctx, key)
+		if err != nil {
+			return fmt.Errorf("reset failed logins: %w", err)
+		}
+	}
+	return nil
+}
+
+// refundAttempt gives back the reservation of a login that failed with err for another reason than a wrong
+// password or code and returns err.
+func refundAttempt(ctx context.Context, throttle LoginThrottleIfc, reservation LoginReservation, err error) error {
+	refund_err := throttle.Refund(ctx, reservation)
+	if refund_err != nil {
+		return fmt.Errorf("%w (%v)", err, refund_err)
+	}
+	return err
+}
+
+// throttleVerification checks the password or MFA code of a signed in user with verify like a login of the
+// username: it is refused while logins of the username are throttled, and a wrong password or code counts as
+// failed login. Otherwise a stolen session could be used to guess the password. The client IP is not tracked,
+// the request is authenticated already.
+func throttleVerification(ctx context.Context, throttle LoginThrottleIfc, username string, verify func() error) error {
+	reservation, err := throttle.Reserve(ctx, username, "", time.Now())
+	if err != nil {
+		return err
+	}
+
+	err = verify()
+	var errWrongPassword *ErrorWrongPassword
+	var errWrongCode *ErrorWrongMfaCode
+	if errors.As(err, &errWrongPassword) || errors.As(err, &errWrongCode) {
+		return err
+	}
+	if err != nil {
+		return refundAttempt(ctx, throttle, reservation, err)
+	}
+
+	return throttle.Success(ctx, username, "")
+}
+
+// PurgeStale deletes the failed logins that are forgotten and no longer lock logins at now.
+func (t *LoginThrottle) PurgeStale(ctx context.Context, now time.Time) (int, error) {
+	count, err := t.Tracker.DeleteStaleLoginAttempts(ctx, now.Add(-t.Window))
+	if err != nil {
+		return 0, fmt.Errorf("purge failed logins: %w", err)
+	}
+	return count, nil
+}
+
+// Run purges stale failed logins right away and then once every Window until the context is cancelled.
+func (t *LoginThrottle) Run(ctx context.Context) {
+	ticker := time.NewTicker(t.Window)
+	defer ticker.Stop()
+
+	for {
+		count, err := t.PurgeStale(ctx, time.Now())
+		if err != nil {
+			log.Println(err)
+		} else if count > 0 {
+			log.Printf("Purged %d stale failed logins", count)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// MemoryLoginAttempts is a LoginAttemptTracker for a single instance of the API.
+type MemoryLoginAttempts struct {
+	mu       sync.Mutex
+	attempts map[string]memoryLoginAttempt
+}
+
+type memoryLoginAttempt struct {
+	failures      int
+	lastFailureAt time.Time
+	lockedUntil   time.Time
+}
+
+func NewMemoryLoginAttempts() *MemoryLoginAttempts {
+	return &MemoryLoginAttempts{attempts: map[string]memoryLoginAttempt{}}
+}
+
+func (m *MemoryLoginAttempts) ReserveLoginAttempt(ctx context.Context, key string, now time.Time, since time.Time,
+	delay func(failures int) time.Duration) (int, time.Time, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	attempt := m.attempts[key]
+	if attempt.lockedUntil.After(now) {
+		return 0, attempt.lockedUntil, nil
+	}
+	if attempt.lastFailureAt.Before(since) {
+		attempt.failures = 0
+	}
+	attempt.failures++
+	attempt.lastFailureAt = now
+	if d := delay(attempt.failures); d > 0 {
+		attempt.lockedUntil = now.Add(d)
+	}
+	m.attempts[key] = attempt
+	return attempt.failures, time.Time{}, nil
+}
+
+func (m *MemoryLoginAttempts) RefundLoginAttempt(ctx context.Context, key string, failures int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	attempt, found := m.attempts[key]
+	if !found || attempt.failures == 0 {
+		return nil
+	}
+	// logins were not locked before the reservation, so a lock is its own unless there were later ones
+	if attempt.failures == failures {
+		attempt.lockedUntil = time.Time{}
+	}
+	attempt.failures--
+	m.attempts[key] = attempt
+	return nil
+}
+
+func (m *MemoryLoginAttempts) ResetLoginFailures(ctx context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.attempts, key)
+	return nil
+}
+
+func (m *MemoryLoginAttempts) DeleteStaleLoginAttempts(ctx context.Context, before time.Time) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	count := 0
+	for key, attempt := range m.attempts {
+		if attempt.lastFailureAt.Before(before) && attempt.lockedUntil.Before(before) {
+			delete(m.attempts, key)
+			count++
+		}
+	}
+	return count, nil
+}
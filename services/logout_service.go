@@ -0,0 +1,52 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"user-notes-api/repositories"
+)
+
+type LogoutServiceIfc interface {
+	Logout(ctx context.Context, userId uint, jti string, expiresAt time.Time, refreshToken string) error
+	LogoutAll(ctx context.Context, userId uint) error
+}
+
+type LogoutService struct {
+	Revocations         TokenRevocations
+	RefreshTokenReader  repositories.RefreshTokenReader
+	RefreshTokenUpdater repositories.RefreshTokenUpdater
+}
+
+func NewLogoutService(revocations TokenRevocations, refresh_token_reader repositories.RefreshTokenReader,
+	refresh_token_updater repositories.RefreshTokenUpdater) *LogoutService {
+	logout_service := LogoutService{Revocations: revocations, RefreshTokenReader: refresh_token_reader,
+		RefreshTokenUpdater: refresh_token_updater}
+	return &logout_service
+}
+
+// Logout revokes the access token with the given jti. If a refresh token is given, its family is revoked
+// as well. Unknown refresh tokens and those of other users are ignored, so logging out twice succeeds.
+func (s *LogoutService) Logout(ctx context.Context, userId uint, jti string, expiresAt time.Time, refreshToken string) error {
+	err := s.Revocations.Revoke(ctx, userId, jti, expiresAt)
+	if err != nil || refreshToken == "" {
+		return err
+	}
+
+	token_model, err := s.RefreshTokenReader.FindRefreshTokenByHash(ctx, hashRefreshToken(refreshToken))
+	if err != nil || token_model.UserID != userId {
+		return nil
+	}
+
+	err = s.RefreshTokenUpdater.RevokeRefreshTokenFamily(ctx, token_model.FamilyID)
+	if err != nil {
+		return fmt.Errorf("revoke refresh token family: %w", err)
+	}
+	return nil
+}
+
+// LogoutAll revokes all access and refresh tokens of the user issued so far.
+func (s *LogoutService) LogoutAll(ctx context.Context, userId uint) error {
+	return s.Revocations.RevokeAll(ctx, userId, time.Now())
+}
@@ -0,0 +1,480 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"user-notes-api/repositories"
+	"user-notes-api/utils"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	// recoveryCodeCount is the number of recovery codes generated at once.
+	recoveryCodeCount = 10
+	// recoveryCodeBytes is the number of random bytes in a recovery code, 16 characters in base32.
+	recoveryCodeBytes = 10
+	// totpSkew is the number of time steps a TOTP code may be off, for authenticators with a clock that is
+	// slightly off.
+	totpSkew = 1
+
+	// mfaTokenAudience tells MFA tokens apart from access tokens, which have no audience.
+	mfaTokenAudience = "user-notes-api:mfa"
+)
+
+// recoveryCodeEncoding is lower-case base32, which has no characters that are easily confused.
+var recoveryCodeEncoding = base32.NewEncoding("abcdefghijklmnopqrstuvwxyz234567").WithPadding(base32.NoPadding)
+
+// ErrMfaNotConfigured is returned by the enrollment if no key to encrypt TOTP secrets is configured.
+var ErrMfaNotConfigured = errors.New("two-factor authentication is not configured")
+
+// TotpEnrollment is the secret of a new enrollment, to be added to an authenticator app by the otpauth URI.
+type TotpEnrollment struct {
+	Secret     string `json:"secret"`
+	OtpauthUri string `json:"otpauth_uri"`
+}
+
+// TotpEnrollmentStart confirms the start of an enrollment with the password of the user, so an access token
+// alone cannot enable two-factor authentication and lock the user out. Users without a password leave it
+// empty, see Reauthentication.
+type TotpEnrollmentStart struct {
+	Password string `json:"password"`
+}
+
+// MfaConfirmation confirms changes to the two-factor authentication of the user with its password and a TOTP
+// code or recovery code. The confirmation of an enrollment takes a code of the enrolled secret. Users without
+// a password leave it empty, see Reauthentication.
+type MfaConfirmation struct {
+	Password string `json:"password"`
+	Code     string `json:"code" binding:"required"`
+}
+
+// RecoveryCodes are shown once when they are generated, only their hashes are stored.
+type RecoveryCodes struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+type MfaStatus struct {
+	TotpEnabled       bool `json:"totp_enabled"`
+	RecoveryCodesLeft int  `json:"recovery_codes_left"`
+}
+
+// MfaChallenge is the result of a login with the right password for a user with two-factor authentication.
+// POST /login/mfa exchanges the MFA token and a code for the tokens of the login.
+type MfaChallenge struct {
+	MfaRequired bool      `json:"mfa_required"`
+	MfaToken    string    `json:"mfa_token"`
+	ExpiresAt   time.Time `json:"expires_at"`
+}
+
+// MfaClaims are the claims of an MFA token. They have an audience and neither a subject nor a user_id, so
+// an MFA token is never accepted as access token.
+type MfaClaims struct {
+	UserId   uint   `json:"mfa_user_id"`
+	Username string `json:"mfa_username"`
+	jwt.RegisteredClaims
+}
+
+type MfaServiceIfc interface {
+	GetStatus(ctx context.Context, userId uint) (MfaStatus, error)
+	StartTotpEnrollment(ctx context.Context, userId uint, username string, authTime time.Time, start TotpEnrollmentStart) (TotpEnrollment, error)
+	ConfirmTotpEnrollment(ctx context.Context, userId uint, username string, authTime time.Time, confirmation MfaConfirmation) (RecoveryCodes, error)
+	DisableTotp(ctx context.Context, userId uint, username string, authTime time.Time, confirmation MfaConfirmation) error
+	RegenerateRecoveryCodes(ctx context.Context, userId uint, username string, authTime time.Time, confirmation MfaConfirmation) (RecoveryCodes, error)
+}
+
+// MfaVerifier is consulted by the login for the second factor.
+type MfaVerifier interface {
+	MfaEnabled(ctx context.Context, userId uint) (bool, error)
+	VerifyCode(ctx context.Context, userId uint, code string) error
+}
+
+type MfaTokenIssuer interface {
+	IssueMfaToken(userId uint, username string) (MfaChallenge, error)
+	ParseMfaToken(token string) (*MfaClaims, error)
+}
+
+// MfaService manages the TOTP two-factor authentication of users. TOTP secrets are encrypted with the
+// SecretBox, without one two-factor authentication cannot be enabled.
+type MfaService struct {
+	UserReader       repositories.UserReader
+	MfaReader        repositories.MfaReader
+	MfaUpdater       repositories.MfaUpdater
+	Reauthentication *Reauthentication
+	Throttle         LoginThrottleIfc
+	SecretBox        *utils.SecretBox
+	// Issuer names the API in authenticator apps.
+	Issuer string
+}
+
+// MfaTokenService issues the short-lived MFA tokens of logins that wait for the second factor. They are
+// signed with the keys of the access tokens.
+type MfaTokenService struct {
+	MfaTokenLifetime time.Duration
+	jwt_keys         *JwtKeys
+}
+
+// ErrorMfaState means that two-factor authentication of the user is not in the state the action requires,
+// e.g. an enrollment is confirmed that was never started.
+type ErrorMfaState struct {
+	Reason string
+}
+
+func (e *ErrorMfaState) Error() string {
+	return e.Reason
+}
+
+// ErrorWrongMfaCode means that a TOTP code is wrong or was already used, or that a recovery code is unknown
+// or was already used.
+type ErrorWrongMfaCode struct {
+	Err error
+}
+
+func (e *ErrorWrongMfaCode) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("invalid two-factor authentication code: %v", e.Err)
+	}
+	return "invalid two-factor authentication code"
+}
+
+func (e *ErrorWrongMfaCode) Unwrap() error {
+	return e.Err
+}
+
+// ErrorInvalidMfaToken means that an MFA token is malformed, expired or not signed by the API.
+type ErrorInvalidMfaToken struct {
+	Err error
+}
+
+func (e *ErrorInvalidMfaToken) Error() string {
+	return fmt.Sprintf("invalid mfa token: %v", e.Err)
+}
+
+func (e *ErrorInvalidMfaToken) Unwrap() error {
+	return e.Err
+}
+
+func NewMfaService(user_reader repositories.UserReader, mfa_reader repositories.MfaReader, mfa_updater repositories.MfaUpdater,
+	reauthentication *Reauthentication, throttle LoginThrottleIfc, secret_box *utils.SecretBox, issuer string) *MfaService {
+	mfa_service := MfaService{UserReader: user_reader, MfaReader: mfa_reader, MfaUpdater: mfa_updater,
+		Reauthentication: reauthentication, Throttle: throttle, SecretBox: secret_box, Issuer: issuer}
+	return &mfa_service
+}
+
+func NewMfaTokenService(jwt_keys *JwtKeys, mfa_token_lifetime time.Duration) *MfaTokenService {
+	mfa_token_service := MfaTokenService{MfaTokenLifetime: mfa_token_lifetime, jwt_keys: jwt_keys}
+	return &mfa_token_service
+}
+
+// totpSecretAdditionalData binds an encrypted TOTP secret to its user, so it cannot be copied to another one.
+func totpSecretAdditionalData(userId uint) []byte {
+	return []byte("totp:" + strconv.FormatUint(uint64(userId), 10))
+}
+
+// normalizeRecoveryCode ignores case, spaces and the dashes between the groups of a recovery code.
+func normalizeRecoveryCode(code string) string {
+	return strings.NewReplacer("-", "", " ", "").Replace(strings.ToLower(code))
+}
+
+// hashRecoveryCode returns the hash under which a recovery code is stored. Recovery codes are random, so
+// a fast unsalted hash suffices.
+func hashRecoveryCode(code string) string {
+	hash := sha256.Sum256([]byte(normalizeRecoveryCode(code)))
+	return hex.EncodeToString(hash[:])
+}
+
+// newRecoveryCodes returns new recovery codes like "abcd-efgh-ijkl-mnop" and their hashes.
+func newRecoveryCodes() ([]string, []string, error) {
+	codes := make([]string, 0, recoveryCodeCount)
+	hashes := make([]string, 0, recoveryCodeCount)
+	for range recoveryCodeCount {
+		random := make([]byte, recoveryCodeBytes)
+		_, err := rand.Read(random)
+		if err != nil {
+			return nil, nil, fmt.Errorf("generate recovery code: %w", err)
+		}
+
+		encoded := recoveryCodeEncoding.EncodeToString(random)
+		code := encoded[0:4] + "-" + encoded[4:8] + "-" + encoded[8:12] + "-" + encoded[12:16]
+		codes = append(codes, code)
+		hashes = append(hashes, hashRecoveryCode(code))
+	}
+	return codes, hashes, nil
+}
+
+// isTotpCode reports whether the code looks like a TOTP code rather than a recovery code.
+func isTotpCode(code string) bool {
+	if len(code) != utils.TotpDigits {
+		return false
+	}
+	for _, c := range code {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// totpSecret returns the decrypted TOTP secret of the user.
+func (s *MfaService) totpSecret(userId uint, sealed string) (string, error) {
+	if s.SecretBox == nil {
+		return "", ErrMfaNotConfigured
+	}
+	secret, err := s.SecretBox.Open(sealed, totpSecretAdditionalData(userId))
+	if err != nil {
+		return "", fmt.Errorf("decrypt totp secret: %w", err)
+	}
+	return string(secret), nil
+}
+
+func (s *MfaService) GetStatus(ctx context.Context, userId uint) (MfaStatus, error) {
+	user, err := s.UserReader.FindUserById(ctx, userId)
+	if err != nil {
+		return MfaStatus{}, fmt.Errorf("find user: %w", err)
+	}
+
+	count, err := s.MfaReader.CountRecoveryCodes(ctx, userId)
+	if err != nil {
+		return MfaStatus{}, fmt.Errorf("count recovery codes: %w", err)
+	}
+	return MfaStatus{TotpEnabled: user.TotpEnabledAt != nil, RecoveryCodesLeft: count}, nil
+}
+
+// StartTotpEnrollment generates a new TOTP secret for the user after its identity was confirmed. Two-factor
+// authentication is only enabled once the enrollment is confirmed with a code of the secret, until then the
+// enrollment can be restarted.
+func (s *MfaService) StartTotpEnrollment(ctx context.Context, userId uint, username string, authTime time.Time, start TotpEnrollmentStart) (TotpEnrollment, error) {
+	if s.SecretBox == nil {
+		return TotpEnrollment{}, ErrMfaNotConfigured
+	}
+
+	err := throttleVerification(ctx, s.Throttle, username, func() error {
+		return s.Reauthentication.Verify(ctx, userId, username, authTime, start.Password)
+	})
+	if err != nil {
+		return TotpEnrollment{}, err
+	}
+
+	secret, err := utils.GenerateTotpSecret()
+	if err != nil {
+		return TotpEnrollment{}, fmt.Errorf("generate totp secret: %w", err)
+	}
+
+	sealed, err := s.SecretBox.Seal([]byte(secret), totpSecretAdditionalData(userId))
+	if err != nil {
+		return TotpEnrollment{}, fmt.Errorf("encrypt totp secret: %w", err)
+	}
+
+	err = s.MfaUpdater.StartTotpEnrollment(ctx, userId, sealed)
+	if errors.Is(err, repositories.ErrTotpEnabled) {
+		return TotpEnrollment{}, &ErrorMfaState{Reason: err.Error()}
+	}
+	if err != nil {
+		return TotpEnrollment{}, fmt.Errorf("store totp secret: %w", err)
+	}
+
+	return TotpEnrollment{Secret: secret, OtpauthUri: utils.TotpUri(s.Issuer, username, secret)}, nil
+}
+
+// ConfirmTotpEnrollment enables two-factor authentication if the password is right and the code belongs to
+// the secret of the enrollment, and returns the recovery codes of the user. Wrong passwords and codes are
+// throttled like failed logins.
+func (s *MfaService) ConfirmTotpEnrollment(ctx context.Context, userId uint, username string, authTime time.Time, confirmation MfaConfirmation) (RecoveryCodes, error) {
+	var counter int64
+	err := throttleVerification(ctx, s.Throttle, username, func() error {
+		err := s.Reauthentication.Verify(ctx, userId, username, authTime, confirmation.Password)
+		if err != nil {
+			return err
+		}
+
+		user, err := s.UserReader.FindUserById(ctx, userId)
+		if err != nil {
+			return fmt.Errorf("find user: %w", err)
+		}
+		if user.TotpEnabledAt != nil {
+			return &ErrorMfaState{Reason: repositories.ErrTotpEnabled.Error()}
+		}
+		if user.TotpSecret == "" {
+			return &ErrorMfaState{Reason: "no two-factor authentication enrollment was started"}
+		}
+
+		secret, err := s.totpSecret(userId, user.TotpSecret)
+		if err != nil {
+			return err
+		}
+
+		var ok bool
+		counter, ok, err = utils.VerifyTotp(secret, strings.ReplaceAll(confirmation.Code, " ", ""), time.Now(), totpSkew)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return &ErrorWrongMfaCode{}
+		}
+		return nil
+	})
+	if err != nil {
+		return RecoveryCodes{}, err
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		return RecoveryCodes{}, err
+	}
+
+	err = s.MfaUpdater.EnableTotp(ctx, userId, counter, hashes)
+	if errors.Is(err, repositories.ErrTotpEnabled) {
+		return RecoveryCodes{}, &ErrorMfaState{Reason: err.Error()}
+	}
+	if err != nil {
+		return RecoveryCodes{}, fmt.Errorf("enable totp: %w", err)
+	}
+	return RecoveryCodes{RecoveryCodes: codes}, nil
+}
+
+// confirm verifies the identity of the user, see Reauthentication, and then its TOTP code or recovery code.
+// Wrong passwords and codes are throttled like failed logins.
+func (s *MfaService) confirm(ctx context.Context, userId uint, username string, authTime time.Time, confirmation MfaConfirmation) error {
+	return throttleVerification(ctx, s.Throttle, username, func() error {
+		err := s.Reauthentication.Verify(ctx, userId, username, authTime, confirmation.Password)
+		if err != nil {
+			return err
+		}
+		return s.VerifyCode(ctx, userId, confirmation.Code)
+	})
+}
+
+// DisableTotp removes the TOTP secret and the recovery codes of the user after its identity and a code
+// were confirmed.
+func (s *MfaService) DisableTotp(ctx context.Context, userId uint, username string, authTime time.Time, confirmation MfaConfirmation) error {
+	err := s.confirm(ctx, userId, username, authTime, confirmation)
+	if err != nil {
+		return err
+	}
+
+	err = s.MfaUpdater.DisableTotp(ctx, userId)
+	if err != nil {
+		return fmt.Errorf("disable totp: %w", err)
+	}
+	return nil
+}
+
+// RegenerateRecoveryCodes replaces all recovery codes of the user after its identity and a code were
+// confirmed.
+func (s *MfaService) RegenerateRecoveryCodes(ctx context.Context, userId uint, username string, authTime time.Time, confirmation MfaConfirmation) (RecoveryCodes, error) {
+	err := s.confirm(ctx, userId, username, authTime, confirmation)
+	if err != nil {
+		return RecoveryCodes{}, err
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		return RecoveryCodes{}, err
+	}
+
+	err = s.MfaUpdater.ReplaceRecoveryCodes(ctx, userId, hashes)
+	if err != nil {
+		return RecoveryCodes{}, fmt.Errorf("store recovery codes: %w", err)
+	}
+	return RecoveryCodes{RecoveryCodes: codes}, nil
+}
+
+// MfaEnabled reports whether a login of the user requires a second factor.
+func (s *MfaService) MfaEnabled(ctx context.Context, userId uint) (bool, error) {
+	user, err := s.UserReader.FindUserById(ctx, userId)
+	if err != nil {
+		return false, fmt.Errorf("find user: %w", err)
+	}
+	return user.TotpEnabledAt != nil, nil
+}
+
+// VerifyCode checks a TOTP code or a recovery code of the user, both can only be used once. Wrong codes are
+// returned as ErrorWrongMfaCode.
+func (s *MfaService) VerifyCode(ctx context.Context, userId uint, code string) error {
+	user, err := s.UserReader.FindUserById(ctx, userId)
+	if err != nil {
+		return fmt.Errorf("find user: %w", err)
+	}
+	if user.TotpEnabledAt == nil {
+		return &ErrorMfaState{Reason: "two-factor authentication is not enabled"}
+	}
+
+	code = strings.ReplaceAll(code, " ", "")
+	if !isTotpCode(code) {
+		err = s.MfaUpdater.UseRecoveryCode(ctx, userId, hashRecoveryCode(code))
+		if errors.Is(err, repositories.ErrRecoveryCodeUsed) {
+			return &ErrorWrongMfaCode{Err: err}
+		}
+		if err != nil {
+			return fmt.Errorf("use recovery code: %w", err)
+		}
+		return nil
+	}
+
+	secret, err := s.totpSecret(userId, user.TotpSecret)
+	if err != nil {
+		return err
+	}
+
+	counter, ok, err := utils.VerifyTotp(secret, code, time.Now(), totpSkew)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return &ErrorWrongMfaCode{}
+	}
+
+	err = s.MfaUpdater.UseTotpCounter(ctx, userId, counter)
+	if errors.Is(err, repositories.ErrTotpCodeUsed) {
+		return &ErrorWrongMfaCode{Err: err}
+	}
+	if err != nil {
+		return fmt.Errorf("use totp code: %w", err)
+	}
+	return nil
+}
+
+// IssueMfaToken returns the challenge of a login that waits for the second factor of the user.
+func (s *MfaTokenService) IssueMfaToken(userId uint, username string) (MfaChallenge, error) {
+	now := time.Now()
+	expires_at := now.Add(s.MfaTokenLifetime)
+	token, err := s.jwt_keys.Sign(MfaClaims{
+		UserId:   userId,
+		Username: username,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    "auth.user-notes-api.local",
+			Audience:  jwt.ClaimStrings{mfaTokenAudience},
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(expires_at),
+		},
+	})
+	if err != nil {
+		return MfaChallenge{}, fmt.Errorf("sign mfa token: %w", err)
+	}
+	return MfaChallenge{MfaRequired: true, MfaToken: token, ExpiresAt: expires_at}, nil
+}
+
+// ParseMfaToken verifies an MFA token and returns its claims. Access tokens are rejected, since they lack
+// the audience.
+func (s *MfaTokenService) ParseMfaToken(token string) (*MfaClaims, error) {
+	claims := MfaClaims{}
+	_, err := jwt.ParseWithClaims(token, &claims, s.jwt_keys.Keyfunc, jwt.WithValidMethods(s.jwt_keys.Methods()),
+		jwt.WithIssuer("auth.user-notes-api.local"), jwt.WithAudience(mfaTokenAudience), jwt.WithExpirationRequired())
+	if err != nil {
+		return nil, &ErrorInvalidMfaToken{Err: err}
+	}
+	if claims.UserId == 0 {
+		return nil, &ErrorInvalidMfaToken{Err: errors.New("missing user id")}
+	}
+	return &claims, nil
+}
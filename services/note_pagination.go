@@ -0,0 +1,148 @@
+package services
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"user-notes-api/models"
+	"user-notes-api/repositories"
+)
+
+const (
+	DefaultNotesPageSize = 50
+	MaxNotesPageSize     = 200
+)
+
+// NoteListOptions are the query parameters of GET /notes. Sort is one of created, updated or title,
+// prefixed with a minus sign for descending order. Cursor is the next_cursor of a previous response.
+// TagMode decides whether notes need all of the tags (and, the default) or at least one of them (or).
+type NoteListOptions struct {
+	Limit         int        `form:"limit"`
+	Cursor        string     `form:"cursor"`
+	Sort          string     `form:"sort"`
+	UpdatedSince  *time.Time `form:"updated_since"`
+	CreatedBefore *time.Time `form:"created_before"`
+	Tags          []string   `form:"tag"`
+	TagMode       string     `form:"tag_mode"`
+}
+
+type ErrorInvalidListOption struct {
+	Option string
+	Value  string
+	Err    error
+}
+
+func (e *ErrorInvalidListOption) Error() string {
+	return fmt.Sprintf("invalid value %q for %s: %v", e.Value, e.Option, e.Err)
+}
+
+func (e *ErrorInvalidListOption) Unwrap() error {
+	return e.Err
+}
+
+var noteSortFields = map[string]repositories.NoteSortField{
+	"created": repositories.NoteSortCreated,
+	"updated": repositories.NoteSortUpdated,
+	"title":   repositories.NoteSortTitle,
+}
+
+// noteCursor is the content of the opaque cursor handed out to clients. It records the sort order it
+// was created for, so that it cannot be combined with a different one.
+type noteCursor struct {
+	Sort  string    `json:"s"`
+	Time  time.Time `json:"t"`
+	Title string    `json:"k,omitempty"`
+	Id    uint      `json:"i"`
+}
+
+// buildNotePageQuery validates the list options and translates them into a repository query.
+// The returned sort string is the normalized sort order, which is stored in the cursors of the result.
+func buildNotePageQuery(userId uint, options NoteListOptions) (repositories.NotePageQuery, string, error) {
+	query := repositories.NotePageQuery{UserId: userId, UpdatedSince: options.UpdatedSince, CreatedBefore: options.CreatedBefore}
+
+	limit := options.Limit
+	if limit == 0 {
+		limit = DefaultNotesPageSize
+	}
+	if limit < 0 || limit > MaxNotesPageSize {
+		err := fmt.Errorf("limit must be between 1 and %d", MaxNotesPageSize)
+		return query, "", &ErrorInvalidListOption{Option: "limit", Value: strconv.Itoa(options.Limit), Err: err}
+	}
+	query.Limit = limit
+
+	sort := options.Sort
+	if sort == "" {
+		sort = "created"
+	}
+	field, ok := noteSortFields[strings.TrimPrefix(sort, "-")]
+	if !ok {
+		err := errors.New("sort must be one of created, updated or title, optionally prefixed with -")
+		return query, "", &ErrorInvalidListOption{Option: "sort", Value: options.Sort, Err: err}
+	}
+	query.SortField = field
+	query.Descending = strings.HasPrefix(sort, "-")
+
+	tags, err := normalizeTagNames(options.Tags)
+	if err != nil {
+		return query, "", &ErrorInvalidListOption{Option: "tag", Value: strings.Join(options.Tags, ","), Err: err}
+	}
+	query.Tags = tags
+
+	if options.TagMode != "" && options.TagMode != "and" && options.TagMode != "or" {
+		err := errors.New("tag_mode must be either and or or")
+		return query, "", &ErrorInvalidListOption{Option: "tag_mode", Value: options.TagMode, Err: err}
+	}
+	query.MatchAllTags = len(tags) > 0 && options.TagMode != "or"
+
+	if options.Cursor != "" {
+		cursor, err := decodeNoteCursor(options.Cursor, sort)
+		if err != nil {
+			return query, "", &ErrorInvalidListOption{Option: "cursor", Value: options.Cursor, Err: err}
+		}
+		query.After = cursor
+	}
+
+	return query, sort, nil
+}
+
+func encodeNoteCursor(sort string, note *models.Note) (string, error) {
+	cursor := noteCursor{Sort: sort, Id: note.ID}
+	switch noteSortFields[strings.TrimPrefix(sort, "-")] {
+	case repositories.NoteSortCreated:
+		cursor.Time = note.CreatedAt
+	case repositories.NoteSortUpdated:
+		cursor.Time = note.UpdatedAt
+	case repositories.NoteSortTitle:
+		cursor.Title = note.Title
+	}
+
+	marshalled, err := json.Marshal(cursor)
+	if err != nil {
+		return "", fmt.Errorf("encode note cursor: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(marshalled), nil
+}
+
+func decodeNoteCursor(cursor_string string, sort string) (*repositories.NoteCursor, error) {
+	marshalled, err := base64.RawURLEncoding.DecodeString(cursor_string)
+	if err != nil {
+		return nil, fmt.Errorf("decode note cursor: %w", err)
+	}
+
+	var cursor noteCursor
+	err = json.Unmarshal(marshalled, &cursor)
+	if err != nil {
+		return nil, fmt.Errorf("decode note cursor: %w", err)
+	}
+
+	if cursor.Sort != sort {
+		return nil, fmt.Errorf("cursor was created for sort order %q", cursor.Sort)
+	}
+
+	return &repositories.NoteCursor{Time: cursor.Time, Title: cursor.Title, Id: cursor.Id}, nil
+}
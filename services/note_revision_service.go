@@ -0,0 +1,161 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"user-notes-api/models"
+	"user-notes-api/repositories"
+	"user-notes-api/utils"
+)
+
+type NoteRevisionListResult struct {
+	Revision  uint      `json:"Revision"`
+	Title     string    `json:"Title"`
+	AuthorId  uint      `json:"AuthorId"`
+	CreatedAt time.Time `json:"CreatedAt"`
+}
+
+type GetNoteRevisionsResult struct {
+	Result []NoteRevisionListResult `json:"Result"`
+}
+
+type NoteRevision struct {
+	Revision  uint      `json:"Revision"`
+	Title     string    `json:"Title"`
+	Content   string    `json:"Content"`
+	AuthorId  uint      `json:"AuthorId"`
+	CreatedAt time.Time `json:"CreatedAt"`
+}
+
+// NoteRevisionDiff is the difference between two revisions of a note as unified diff. The diffed text of
+// a revision is its title, followed by an empty line and its content.
+type NoteRevisionDiff struct {
+	From uint   `json:"From"`
+	To   uint   `json:"To"`
+	Diff string `json:"Diff"`
+}
+
+type NoteRevisionServiceIfc interface {
+	GetRevisions(ctx context.Context, noteId uint, userId uint) (GetNoteRevisionsResult, error)
+	GetRevision(ctx context.Context, noteId uint, revision uint, userId uint) (NoteRevision, error)
+	DiffRevisions(ctx context.Context, noteId uint, from uint, to uint, userId uint) (NoteRevisionDiff, error)
+	RestoreRevision(ctx context.Context, noteId uint, revision uint, userId uint, version uint) (uint, error)
+}
+
+type NoteRevisionService struct {
+	NoteReader      repositories.NoteReader
+	RevisionReader  repositories.NoteRevisionReader
+	RevisionCreator repositories.NoteRevisionCreator
+}
+
+type ErrorRevisionNotFound struct {
+	NoteId   uint
+	Revision uint
+	Err      error
+}
+
+func (e *ErrorRevisionNotFound) Error() string {
+	return fmt.Sprintf("revision %d of note with id %d not found: %v", e.Revision, e.NoteId, e.Err)
+}
+
+func (e *ErrorRevisionNotFound) Unwrap() error {
+	return e.Err
+}
+
+func NewNoteRevisionService(note_reader repositories.NoteReader, revision_reader repositories.NoteRevisionReader,
+	revision_creator repositories.NoteRevisionCreator) *NoteRevisionService {
+	revision_service := NoteRevisionService{NoteReader: note_reader, RevisionReader: revision_reader, RevisionCreator: revision_creator}
+	return &revision_service
+}
+
+func (s *NoteRevisionService) findRevision(ctx context.Context, noteId uint, revision uint) (*models.NoteRevision, error) {
+	note_revision, err := s.RevisionReader.FindRevision(ctx, noteId, revision)
+	if err != nil {
+		return nil, &ErrorRevisionNotFound{NoteId: noteId, Revision: revision, Err: err}
+	}
+	return note_revision, nil
+}
+
+func (s *NoteRevisionService) GetRevisions(ctx context.Context, noteId uint, userId uint) (GetNoteRevisionsResult, error) {
+	var revision_array GetNoteRevisionsResult
+	_, err := findOwnedNote(ctx, s.NoteReader, noteId, userId)
+	if err != nil {
+		return revision_array, err
+	}
+
+	revisions, err := s.RevisionReader.FindRevisionsByNoteId(ctx, noteId)
+	if err != nil {
+		return revision_array, fmt.Errorf("get revisions: %w", err)
+	}
+
+	for _, revision := range *revisions {
+		revision_array.Result = append(revision_array.Result,
+			NoteRevisionListResult{Revision: revision.Revision, Title: revision.Title, AuthorId: revision.AuthorID, CreatedAt: revision.CreatedAt})
+	}
+	return revision_array, nil
+}
+
+func (s *NoteRevisionService) GetRevision(ctx context.Context, noteId uint, revision uint, userId uint) (NoteRevision, error) {
+	_, err := findOwnedNote(ctx, s.NoteReader, noteId, userId)
+	if err != nil {
+		return NoteRevision{}, err
+	}
+
+	note_revision, err := s.findRevision(ctx, noteId, revision)
+	if err != nil {
+		return NoteRevision{}, err
+	}
+
+	return NoteRevision{Revision: note_revision.Revision, Title: note_revision.Title, Content: note_revision.Body,
+		AuthorId: note_revision.AuthorID, CreatedAt: note_revision.CreatedAt}, nil
+}
+
+func (s *NoteRevisionService) DiffRevisions(ctx context.Context, noteId uint, from uint, to uint, userId uint) (NoteRevisionDiff, error) {
+	_, err := findOwnedNote(ctx, s.NoteReader, noteId, userId)
+	if err != nil {
+		return NoteRevisionDiff{}, err
+	}
+
+	from_revision, err := s.findRevision(ctx, noteId, from)
+	if err != nil {
+		return NoteRevisionDiff{}, err
+	}
+
+	to_revision, err := s.findRevision(ctx, noteId, to)
+	if err != nil {
+		return NoteRevisionDiff{}, err
+	}
+
+	diff := utils.UnifiedDiff(fmt.Sprintf("revision %d", from), fmt.Sprintf("revision %d", to),
+		from_revision.Title+"\n\n"+from_revision.Body, to_revision.Title+"\n\n"+to_revision.Body)
+	return NoteRevisionDiff{From: from, To: to, Diff: diff}, nil
+}
+
+// RestoreRevision sets title and content of the note to the ones of the given revision, if the note still
+// has the given version. The restored content is recorded as a new revision, whose number is returned.
+func (s *NoteRevisionService) RestoreRevision(ctx context.Context, noteId uint, revision uint, userId uint, version uint) (uint, error) {
+	note, err := findOwnedNote(ctx, s.NoteReader, noteId, userId)
+	if err != nil {
+		return 0, err
+	}
+
+	if note.Version != version {
+		return 0, &ErrorVersionMismatch{NoteId: noteId, Expected: version, Version: note.Version}
+	}
+
+	note_revision, err := s.findRevision(ctx, noteId, revision)
+	if err != nil {
+		return 0, err
+	}
+
+	note.Title = note_revision.Title
+	note.Body = note_revision.Body
+	new_revision, err := s.RevisionCreator.UpdateNoteWithRevision(ctx, note, userId)
+	if errors.Is(err, repositories.ErrNoteVersionMismatch) {
+		return 0, versionMismatch(ctx, s.NoteReader, noteId, version)
+	}
+	return new_revision, err
+}
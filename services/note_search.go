@@ -0,0 +1,60 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	DefaultSearchResults = 20
+	MaxSearchResults     = 100
+)
+
+// NoteSearchOptions are the query parameters of GET /notes/search.
+type NoteSearchOptions struct {
+	Query string `form:"q"`
+	Limit int    `form:"limit"`
+}
+
+type NoteSearchResult struct {
+	Id      uint    `json:"Id"`
+	Title   string  `json:"Title"`
+	Snippet string  `json:"Snippet"`
+	Rank    float64 `json:"Rank"`
+}
+
+type SearchNotesResult struct {
+	Result []NoteSearchResult `json:"Result"`
+}
+
+func (s *NoteService) SearchNotes(ctx context.Context, userId uint, options NoteSearchOptions) (SearchNotesResult, error) {
+	var search_result SearchNotesResult
+
+	query := strings.TrimSpace(options.Query)
+	if query == "" {
+		return search_result, &ErrorInvalidListOption{Option: "q", Value: options.Query, Err: errors.New("search query must not be empty")}
+	}
+
+	limit := options.Limit
+	if limit == 0 {
+		limit = DefaultSearchResults
+	}
+	if limit < 0 || limit > MaxSearchResults {
+		err := fmt.Errorf("limit must be between 1 and %d", MaxSearchResults)
+		return search_result, &ErrorInvalidListOption{Option: "limit", Value: strconv.Itoa(options.Limit), Err: err}
+	}
+
+	hits, err := s.NoteReader.SearchNotes(ctx, userId, query, limit)
+	if err != nil {
+		return search_result, fmt.Errorf("search notes: %w", err)
+	}
+
+	for _, hit := range *hits {
+		search_result.Result = append(search_result.Result,
+			NoteSearchResult{Id: hit.ID, Title: hit.Title, Snippet: hit.Snippet, Rank: hit.Rank})
+	}
+	return search_result, nil
+}
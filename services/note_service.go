@@ -2,35 +2,52 @@ package services
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"time"
 
 	"user-notes-api/models"
 	"user-notes-api/repositories"
 )
 
 type Note struct {
-	Title   string `json:"Title"`
-	Content string `json:"Content"`
+	Title    string   `json:"Title"`
+	Content  string   `json:"Content"`
+	Tags     []string `json:"Tags,omitempty"`
+	Notebook *uint    `json:"Notebook,omitempty"`
+	// Version is sent as ETag header instead of in the body.
+	Version uint `json:"-"`
+}
+
+// NoteMove is the input for moving a note. A nil notebook moves the note to the top level.
+type NoteMove struct {
+	Notebook *uint `json:"Notebook"`
 }
 
 type NoteListResult struct {
-	Id    uint   `json:"Id"`
-	Title string `json:"Title"`
+	Id        uint      `json:"Id"`
+	Title     string    `json:"Title"`
+	Tags      []string  `json:"Tags,omitempty"`
+	CreatedAt time.Time `json:"CreatedAt"`
+	UpdatedAt time.Time `json:"UpdatedAt"`
 }
 
 type GetNotesResult struct {
-	Result []NoteListResult `json:"Result"`
+	Result     []NoteListResult `json:"Result"`
+	NextCursor string           `json:"next_cursor,omitempty"`
 }
 
 type NoteReaderService interface {
-	GetNotes(ctx context.Context, userId uint) (GetNotesResult, error)
+	GetNotes(ctx context.Context, userId uint, options NoteListOptions) (GetNotesResult, error)
 	GetNote(ctx context.Context, noteId uint, userId uint) (Note, error)
+	SearchNotes(ctx context.Context, userId uint, options NoteSearchOptions) (SearchNotesResult, error)
 }
 
 type NoteModificationService interface {
 	CreateNote(ctx context.Context, note Note, username string) (uint, error)
-	UpdateNote(ctx context.Context, id uint, note Note) error
-	DeleteNote(ctx context.Context, id uint) error
+	UpdateNote(ctx context.Context, noteId uint, userId uint, version uint, note Note) (uint, error)
+	DeleteNote(ctx context.Context, noteId uint, userId uint, version uint) error
+	MoveNote(ctx context.Context, noteId uint, userId uint, version uint, notebookId *uint) (uint, error)
 }
 
 type ErrorUserNotFound struct {
@@ -53,6 +70,14 @@ type ErrorWrongOwner struct {
 	UserId uint
 }
 
+// ErrorVersionMismatch means that the note was changed since the client read the expected version.
+// Version is the current version of the note.
+type ErrorVersionMismatch struct {
+	NoteId   uint
+	Expected uint
+	Version  uint
+}
+
 func (e *ErrorUserNotFound) Error() string {
 	return fmt.Sprintf("user %s not found: %v", e.Username, e.Err)
 }
@@ -81,43 +106,102 @@ func (e *ErrorWrongOwner) Error() string {
 	return fmt.Sprintf("user with id %d does not own note with id %d", e.UserId, e.NoteId)
 }
 
+func (e *ErrorVersionMismatch) Error() string {
+	return fmt.Sprintf("note with id %d has version %d, not %d", e.NoteId, e.Version, e.Expected)
+}
+
 type NoteService struct {
-	UserRepo    repositories.UserReader
-	NoteCreator repositories.NoteCreator
-	NoteReader  repositories.NoteReader
+	UserRepo        repositories.UserReader
+	NoteReader      repositories.NoteReader
+	NoteUpdater     repositories.NoteUpdater
+	NoteDeleter     repositories.NoteDeleter
+	TagCreator      repositories.TagCreator
+	NotebookReader  repositories.NotebookReader
+	RevisionCreator repositories.NoteRevisionCreator
 }
 
-func NewNoteService(note_reader repositories.NoteReader, note_creator repositories.NoteCreator, user_repo repositories.UserReader) *NoteService {
-	note_service := NoteService{NoteReader: note_reader, NoteCreator: note_creator, UserRepo: user_repo}
+func NewNoteService(note_reader repositories.NoteReader, note_updater repositories.NoteUpdater,
+	note_deleter repositories.NoteDeleter, tag_creator repositories.TagCreator, notebook_reader repositories.NotebookReader,
+	revision_creator repositories.NoteRevisionCreator, user_repo repositories.UserReader) *NoteService {
+	note_service := NoteService{NoteReader: note_reader, NoteUpdater: note_updater,
+		NoteDeleter: note_deleter, TagCreator: tag_creator, NotebookReader: notebook_reader, RevisionCreator: revision_creator,
+		UserRepo: user_repo}
 	return &note_service
 }
 
-func (s *NoteService) GetNote(ctx context.Context, noteId uint, userId uint) (Note, error) {
-	note, err := s.NoteReader.FindNoteById(ctx, noteId)
+// findOrCreateTags resolves the tag names of a note to the tags of the user. It returns nil if there
+// are no tag names.
+func (s *NoteService) findOrCreateTags(ctx context.Context, userId uint, names []string) ([]models.Tag, error) {
+	normalized_names, err := normalizeTagNames(names)
+	if err != nil || len(normalized_names) == 0 {
+		return nil, err
+	}
+
+	tags, err := s.TagCreator.FindOrCreateTags(ctx, userId, normalized_names)
+	if err != nil {
+		return nil, fmt.Errorf("find or create tags: %w", err)
+	}
+	return *tags, nil
+}
+
+// findOwnedNote returns the note with the given id if it belongs to the user with the given id.
+func findOwnedNote(ctx context.Context, note_reader repositories.NoteReader, noteId uint, userId uint) (*models.Note, error) {
+	note, err := note_reader.FindNoteById(ctx, noteId)
 
 	if err != nil {
-		return Note{}, &ErrorNoteNotFound{NoteId: noteId, Err: err}
+		return nil, &ErrorNoteNotFound{NoteId: noteId, Err: err}
 	}
 
 	if note.UserID != userId {
-		return Note{}, &ErrorWrongOwner{NoteId: noteId, UserId: userId}
+		return nil, &ErrorWrongOwner{NoteId: noteId, UserId: userId}
+	}
+
+	return note, nil
+}
+
+func (s *NoteService) GetNote(ctx context.Context, noteId uint, userId uint) (Note, error) {
+	note, err := findOwnedNote(ctx, s.NoteReader, noteId, userId)
+	if err != nil {
+		return Note{}, err
 	}
 
-	return Note{Title: note.Title, Content: note.Body}, nil
+	return Note{Title: note.Title, Content: note.Body, Tags: tagNames(note.Tags), Notebook: note.NotebookID, Version: note.Version}, nil
 }
 
-func (s *NoteService) GetNotes(ctx context.Context, userId uint) (GetNotesResult, error) {
+func (s *NoteService) GetNotes(ctx context.Context, userId uint, options NoteListOptions) (GetNotesResult, error) {
 	var note_array GetNotesResult
-	notes, err := s.NoteReader.FindNotesByUserId(ctx, userId)
+	query, sort, err := buildNotePageQuery(userId, options)
+	if err != nil {
+		return note_array, err
+	}
+
+	// fetch one note more than requested to find out whether there is a next page
+	page_size := query.Limit
+	query.Limit++
+	notes, err := s.NoteReader.FindNotesPage(ctx, query)
 	if err != nil {
 		return note_array, &ErrorNotesNotFound{UserId: userId, Err: err}
 	}
 
-	for _, note := range *notes {
+	page := *notes
+	has_next := len(page) > page_size
+	if has_next {
+		page = page[:page_size]
+	}
+
+	for _, note := range page {
 		if note.UserID != userId {
-			return note_array, &ErrorWrongOwner{NoteId: note.ID, UserId: userId}
+			return GetNotesResult{}, &ErrorWrongOwner{NoteId: note.ID, UserId: userId}
+		}
+		note_array.Result = append(note_array.Result,
+			NoteListResult{Id: note.ID, Title: note.Title, Tags: tagNames(note.Tags), CreatedAt: note.CreatedAt, UpdatedAt: note.UpdatedAt})
+	}
+
+	if has_next {
+		note_array.NextCursor, err = encodeNoteCursor(sort, &page[len(page)-1])
+		if err != nil {
+			return GetNotesResult{}, err
 		}
-		note_array.Result = append(note_array.Result, NoteListResult{Id: note.ID, Title: note.Title})
 	}
 	return note_array, nil
 }
@@ -128,14 +212,113 @@ func (s *NoteService) CreateNote(ctx context.Context, note Note, username string
 		return 0, &ErrorUserNotFound{Username: username, Err: err}
 	}
 
-	note_model := models.Note{User: *user, UserID: user.ID, Title: note.Title, Body: note.Content}
-	err = s.NoteCreator.CreateNote(ctx, &note_model)
+	tags, err := s.findOrCreateTags(ctx, user.ID, note.Tags)
+	if err != nil {
+		return 0, err
+	}
+
+	if note.Notebook != nil {
+		_, err = findOwnedNotebook(ctx, s.NotebookReader, *note.Notebook, user.ID)
+		if err != nil {
+			return 0, err
+		}
+	}
 
-	return note_model.ID, err
+	note_model := models.Note{User: *user, UserID: user.ID, Title: note.Title, Body: note.Content, Tags: tags, NotebookID: note.Notebook}
+	_, err = s.RevisionCreator.CreateNoteWithRevision(ctx, &note_model, user.ID)
+	if err != nil {
+		return 0, err
+	}
+	return note_model.ID, nil
 }
-func (s *NoteService) UpdateNote(ctx context.Context, id uint, note Note) error {
-	return nil
+
+// versionMismatch returns the ErrorVersionMismatch for a failed conditional change of a note. The note
+// is read again, since it was changed after it was checked.
+func versionMismatch(ctx context.Context, note_reader repositories.NoteReader, noteId uint, version uint) error {
+	note, err := note_reader.FindNoteById(ctx, noteId)
+	if err != nil {
+		return &ErrorNoteNotFound{NoteId: noteId, Err: err}
+	}
+	return &ErrorVersionMismatch{NoteId: noteId, Expected: version, Version: note.Version}
+}
+
+// UpdateNote updates title, content and tags of the note if it still has the given version and returns its
+// new version. Tags are only replaced if note.Tags is not nil. The notebook of the note is changed with MoveNote.
+func (s *NoteService) UpdateNote(ctx context.Context, noteId uint, userId uint, version uint, note Note) (uint, error) {
+	note_model, err := findOwnedNote(ctx, s.NoteReader, noteId, userId)
+	if err != nil {
+		return 0, err
+	}
+
+	if note_model.Version != version {
+		return 0, &ErrorVersionMismatch{NoteId: noteId, Expected: version, Version: note_model.Version}
+	}
+
+	// without Tags the note keeps its tags, an empty list removes them
+	if note.Tags != nil {
+		tags, err := s.findOrCreateTags(ctx, userId, note.Tags)
+		if err != nil {
+			return 0, err
+		}
+		note_model.Tags = tags
+	}
+
+	note_model.Title = note.Title
+	note_model.Body = note.Content
+
+	_, err = s.RevisionCreator.UpdateNoteWithRevision(ctx, note_model, userId)
+	if errors.Is(err, repositories.ErrNoteVersionMismatch) {
+		return 0, versionMismatch(ctx, s.NoteReader, noteId, version)
+	}
+	if err != nil {
+		return 0, err
+	}
+	return note_model.Version, nil
 }
-func (s *NoteService) DeleteNote(ctx context.Context, id uint) error {
-	return nil
+
+// DeleteNote moves the note to the trash if it still has the given version.
+func (s *NoteService) DeleteNote(ctx context.Context, noteId uint, userId uint, version uint) error {
+	note_model, err := findOwnedNote(ctx, s.NoteReader, noteId, userId)
+	if err != nil {
+		return err
+	}
+
+	if note_model.Version != version {
+		return &ErrorVersionMismatch{NoteId: noteId, Expected: version, Version: note_model.Version}
+	}
+
+	err = s.NoteDeleter.DeleteNote(ctx, note_model)
+	if errors.Is(err, repositories.ErrNoteVersionMismatch) {
+		return versionMismatch(ctx, s.NoteReader, noteId, version)
+	}
+	return err
+}
+
+// MoveNote puts the note into the notebook with the given id, or at the top level if notebookId is nil,
+// if the note still has the given version, and returns its new version.
+func (s *NoteService) MoveNote(ctx context.Context, noteId uint, userId uint, version uint, notebookId *uint) (uint, error) {
+	note_model, err := findOwnedNote(ctx, s.NoteReader, noteId, userId)
+	if err != nil {
+		return 0, err
+	}
+
+	if note_model.Version != version {
+		return 0, &ErrorVersionMismatch{NoteId: noteId, Expected: version, Version: note_model.Version}
+	}
+
+	if notebookId != nil {
+		_, err = findOwnedNotebook(ctx, s.NotebookReader, *notebookId, userId)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	err = s.NoteUpdater.MoveNote(ctx, note_model, notebookId)
+	if errors.Is(err, repositories.ErrNoteVersionMismatch) {
+		return 0, versionMismatch(ctx, s.NoteReader, noteId, version)
+	}
+	if err != nil {
+		return 0, err
+	}
+	return note_model.Version, nil
 }
@@ -0,0 +1,266 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"unicode/utf8"
+
+	"user-notes-api/models"
+	"user-notes-api/repositories"
+)
+
+const MaxNotebookNameLength = 128
+
+// Notebook is the input for creating and updating notebooks. A notebook without parent is a top-level
+// notebook.
+type Notebook struct {
+	Name   string `json:"Name"`
+	Parent *uint  `json:"Parent"`
+}
+
+type NotebookResult struct {
+	Id     uint   `json:"Id"`
+	Name   string `json:"Name"`
+	Parent *uint  `json:"Parent"`
+}
+
+type GetNotebooksResult struct {
+	Result []NotebookResult `json:"Result"`
+}
+
+type NotebookNote struct {
+	Id    uint   `json:"Id"`
+	Title string `json:"Title"`
+}
+
+// NotebookContents is a notebook with its notes and, recursively, the notebooks nested inside of it.
+type NotebookContents struct {
+	Id        uint               `json:"Id"`
+	Name      string             `json:"Name"`
+	Notes     []NotebookNote     `json:"Notes"`
+	Notebooks []NotebookContents `json:"Notebooks"`
+}
+
+type NotebookServiceIfc interface {
+	CreateNotebook(ctx context.Context, notebook Notebook, userId uint) (uint, error)
+	GetNotebooks(ctx context.Context, userId uint) (GetNotebooksResult, error)
+	GetNotebookContents(ctx context.Context, notebookId uint, userId uint) (NotebookContents, error)
+	UpdateNotebook(ctx context.Context, notebookId uint, userId uint, notebook Notebook) error
+	DeleteNotebook(ctx context.Context, notebookId uint, userId uint, mode string) error
+}
+
+type NotebookService struct {
+	NotebookReader  repositories.NotebookReader
+	NotebookCreator repositories.NotebookCreator
+	NotebookUpdater repositories.NotebookUpdater
+	NotebookDeleter repositories.NotebookDeleter
+}
+
+type ErrorNotebookNotFound struct {
+	NotebookId uint
+	Err        error
+}
+
+type ErrorNotebookWrongOwner struct {
+	NotebookId uint
+	UserId     uint
+}
+
+type ErrorInvalidNotebook struct {
+	Name   string
+	Reason string
+}
+
+func (e *ErrorNotebookNotFound) Error() string {
+	return fmt.Sprintf("notebook with id %d not found: %v", e.NotebookId, e.Err)
+}
+
+func (e *ErrorNotebookNotFound) Unwrap() error {
+	return e.Err
+}
+
+func (e *ErrorNotebookWrongOwner) Error() string {
+	return fmt.Sprintf("user with id %d does not own notebook with id %d", e.UserId, e.NotebookId)
+}
+
+func (e *ErrorInvalidNotebook) Error() string {
+	return fmt.Sprintf("invalid notebook %q: %s", e.Name, e.Reason)
+}
+
+func NewNotebookService(notebook_reader repositories.NotebookReader, notebook_creator repositories.NotebookCreator,
+	notebook_updater repositories.NotebookUpdater, notebook_deleter repositories.NotebookDeleter) *NotebookService {
+	notebook_service := NotebookService{NotebookReader: notebook_reader, NotebookCreator: notebook_creator,
+		NotebookUpdater: notebook_updater, NotebookDeleter: notebook_deleter}
+	return &notebook_service
+}
+
+// findOwnedNotebook returns the notebook with the given id if it belongs to the user with the given id.
+// It is shared with the note service, which checks the notebooks notes are put into.
+func findOwnedNotebook(ctx context.Context, notebook_reader repositories.NotebookReader, notebookId uint, userId uint) (*models.Notebook, error) {
+	notebook, err := notebook_reader.FindNotebookById(ctx, notebookId)
+	if err != nil {
+		return nil, &ErrorNotebookNotFound{NotebookId: notebookId, Err: err}
+	}
+
+	if notebook.UserID != userId {
+		return nil, &ErrorNotebookWrongOwner{NotebookId: notebookId, UserId: userId}
+	}
+
+	return notebook, nil
+}
+
+func normalizeNotebookName(name string) (string, error) {
+	normalized := strings.TrimSpace(name)
+	if normalized == "" {
+		return "", &ErrorInvalidNotebook{Name: name, Reason: "name must not be empty"}
+	}
+	if utf8.RuneCountInString(normalized) > MaxNotebookNameLength {
+		return "", &ErrorInvalidNotebook{Name: name, Reason: fmt.Sprintf("name must not be longer than %d characters", MaxNotebookNameLength)}
+	}
+	return normalized, nil
+}
+
+func (s *NotebookService) CreateNotebook(ctx context.Context, notebook Notebook, userId uint) (uint, error) {
+	name, err := normalizeNotebookName(notebook.Name)
+	if err != nil {
+		return 0, err
+	}
+
+	if notebook.Parent != nil {
+		_, err = findOwnedNotebook(ctx, s.NotebookReader, *notebook.Parent, userId)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	notebook_model := models.Notebook{Name: name, UserID: userId, ParentID: notebook.Parent}
+	err = s.NotebookCreator.CreateNotebook(ctx, &notebook_model)
+
+	return notebook_model.ID, err
+}
+
+func (s *NotebookService) GetNotebooks(ctx context.Context, userId uint) (GetNotebooksResult, error) {
+	var notebook_array GetNotebooksResult
+	notebooks, err := s.NotebookReader.FindNotebooksByUserId(ctx, userId)
+	if err != nil {
+		return notebook_array, fmt.Errorf("get notebooks: %w", err)
+	}
+
+	for _, notebook := range *notebooks {
+		notebook_array.Result = append(notebook_array.Result,
+			NotebookResult{Id: notebook.ID, Name: notebook.Name, Parent: notebook.ParentID})
+	}
+	return notebook_array, nil
+}
+
+func (s *NotebookService) GetNotebookContents(ctx context.Context, notebookId uint, userId uint) (NotebookContents, error) {
+	_, err := findOwnedNotebook(ctx, s.NotebookReader, notebookId, userId)
+	if err != nil {
+		return NotebookContents{}, err
+	}
+
+	notebooks, err := s.NotebookReader.FindNotebookTree(ctx, notebookId)
+	if err != nil {
+		return NotebookContents{}, fmt.Errorf("get notebook contents: %w", err)
+	}
+
+	var ids []uint
+	children := make(map[uint][]models.Notebook)
+	var root *models.Notebook
+	for i, notebook := range *notebooks {
+		ids = append(ids, notebook.ID)
+		if notebook.ID == notebookId {
+			root = &(*notebooks)[i]
+		} else if notebook.ParentID != nil {
+			children[*notebook.ParentID] = append(children[*notebook.ParentID], notebook)
+		}
+	}
+	if root == nil {
+		return NotebookContents{}, &ErrorNotebookNotFound{NotebookId: notebookId, Err: fmt.Errorf("notebook missing in tree")}
+	}
+
+	notes, err := s.NotebookReader.FindNotesInNotebooks(ctx, ids)
+	if err != nil {
+		return NotebookContents{}, fmt.Errorf("get notebook contents: %w", err)
+	}
+
+	notes_by_notebook := make(map[uint][]NotebookNote)
+	for _, note := range *notes {
+		if note.UserID != userId {
+			return NotebookContents{}, &ErrorWrongOwner{NoteId: note.ID, UserId: userId}
+		}
+		notes_by_notebook[*note.NotebookID] = append(notes_by_notebook[*note.NotebookID], NotebookNote{Id: note.ID, Title: note.Title})
+	}
+
+	return buildNotebookContents(root, children, notes_by_notebook), nil
+}
+
+func buildNotebookContents(notebook *models.Notebook, children map[uint][]models.Notebook, notes map[uint][]NotebookNote) NotebookContents {
+	contents := NotebookContents{Id: notebook.ID, Name: notebook.Name, Notes: notes[notebook.ID], Notebooks: []NotebookContents{}}
+	if contents.Notes == nil {
+		contents.Notes = []NotebookNote{}
+	}
+	for _, child := range children[notebook.ID] {
+		contents.Notebooks = append(contents.Notebooks, buildNotebookContents(&child, children, notes))
+	}
+	return contents
+}
+
+// UpdateNotebook renames the notebook and moves it to the given parent. A notebook cannot be moved into
+// itself or into one of the notebooks nested inside of it.
+func (s *NotebookService) UpdateNotebook(ctx context.Context, notebookId uint, userId uint, notebook Notebook) error {
+	notebook_model, err := findOwnedNotebook(ctx, s.NotebookReader, notebookId, userId)
+	if err != nil {
+		return err
+	}
+
+	name, err := normalizeNotebookName(notebook.Name)
+	if err != nil {
+		return err
+	}
+
+	if notebook.Parent != nil {
+		_, err = findOwnedNotebook(ctx, s.NotebookReader, *notebook.Parent, userId)
+		if err != nil {
+			return err
+		}
+
+		tree, err := s.NotebookReader.FindNotebookTree(ctx, notebookId)
+		if err != nil {
+			return fmt.Errorf("update notebook: %w", err)
+		}
+		in_tree := slices.ContainsFunc(*tree, func(n models.Notebook) bool { return n.ID == *notebook.Parent })
+		if in_tree {
+			return &ErrorInvalidNotebook{Name: name, Reason: "a notebook cannot be moved into itself or one of its notebooks"}
+		}
+	}
+
+	notebook_model.Name = name
+	notebook_model.ParentID = notebook.Parent
+
+	return s.NotebookUpdater.UpdateNotebook(ctx, notebook_model)
+}
+
+// DeleteNotebook deletes the notebook. Mode is either move (the default), which moves the notes and
+// notebooks inside it to its parent, or delete, which deletes them as well.
+func (s *NotebookService) DeleteNotebook(ctx context.Context, notebookId uint, userId uint, mode string) error {
+	var delete_mode repositories.NotebookDeleteMode
+	switch mode {
+	case "", "move":
+		delete_mode = repositories.NotebookDeleteMoveToParent
+	case "delete":
+		delete_mode = repositories.NotebookDeleteContents
+	default:
+		err := fmt.Errorf("mode must be either move or delete")
+		return &ErrorInvalidListOption{Option: "mode", Value: mode, Err: err}
+	}
+
+	_, err := findOwnedNotebook(ctx, s.NotebookReader, notebookId, userId)
+	if err != nil {
+		return err
+	}
+
+	return s.NotebookDeleter.DeleteNotebookById(ctx, notebookId, delete_mode)
+}
@@ -0,0 +1,329 @@
+package services
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	// oidcHttpTimeout bounds every request to a provider.
+	oidcHttpTimeout = 10 * time.Second
+	// oidcMaxResponseBytes bounds the documents read from a provider.
+	oidcMaxResponseBytes = 1 << 20
+	// oidcJwksRefreshInterval is how often the keys of a provider are fetched again at most, when an ID token
+	// is signed with an unknown kid after the provider rotated its keys.
+	oidcJwksRefreshInterval = time.Minute
+	// oidcClockSkew is the leeway for the times in ID tokens, as the clocks of provider and API may differ.
+	oidcClockSkew = time.Minute
+)
+
+// oidcIdTokenMethods are the algorithms accepted for ID tokens. RS256 is the one every provider supports;
+// HMAC would require the client secret as key and "none" is never accepted.
+var oidcIdTokenMethods = []string{jwt.SigningMethodRS256.Alg(), jwt.SigningMethodEdDSA.Alg()}
+
+// OidcMetadata is the part of the discovery document at /.well-known/openid-configuration of an issuer
+// that the authorization code flow needs.
+type OidcMetadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JwksUri               string `json:"jwks_uri"`
+}
+
+// OidcIdTokenClaims are the claims of an ID token that a login uses. PreferredUsername and Email only
+// suggest the username of new users, users are identified by issuer and subject.
+type OidcIdTokenClaims struct {
+	Nonce             string `json:"nonce"`
+	AuthorizedParty   string `json:"azp,omitempty"`
+	PreferredUsername string `json:"preferred_username,omitempty"`
+	Email             string `json:"email,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// OidcProvider is an OpenID Connect provider that the API is registered at as a client. Its endpoints are
+// discovered from the issuer on first use and its keys are fetched when ID tokens are verified, both are
+// cached.
+type OidcProvider struct {
+	Name         string
+	Issuer       string
+	ClientId     string
+	ClientSecret string
+	RedirectUrl  string
+	Scopes       []string
+	HttpClient   *http.Client
+
+	mutex         sync.Mutex
+	metadata      *OidcMetadata
+	keys          map[string]*JwtKey
+	keysFetchedAt time.Time
+}
+
+// ErrorOidcProvider means that the provider could not be reached or answered with something unexpected,
+// so logins with it fail until it is fixed.
+type ErrorOidcProvider struct {
+	Provider string
+	Err      error
+}
+
+func (e *ErrorOidcProvider) Error() string {
+	return fmt.Sprintf("openid connect provider %q: %v", e.Provider, e.Err)
+}
+
+func (e *ErrorOidcProvider) Unwrap() error {
+	return e.Err
+}
+
+// NewOidcProvider returns a provider for the client registration. Without a client secret, the client is
+// a public client and only authenticated by PKCE.
+func NewOidcProvider(name string, issuer string, client_id string, client_secret string, redirect_url string,
+	scopes []string) *OidcProvider {
+	provider := OidcProvider{Name: name, Issuer: issuer, ClientId: client_id, ClientSecret: client_secret,
+		RedirectUrl: redirect_url, Scopes: scopes, HttpClient: &http.Client{Timeout: oidcHttpTimeout}}
+	return &provider
+}
+
+// Metadata returns the discovered endpoints of the provider. The issuer of the discovery document has to
+// be the configured one, as ID tokens are checked against it.
+func (p *OidcProvider) Metadata(ctx context.Context) (*OidcMetadata, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.discover(ctx)
+}
+
+// discover fetches the discovery document unless it is cached. The mutex has to be held.
+func (p *OidcProvider) discover(ctx context.Context) (*OidcMetadata, error) {
+	if p.metadata != nil {
+		return p.metadata, nil
+	}
+
+	var metadata OidcMetadata
+	err := p.getJson(ctx, strings.TrimSuffix(p.Issuer, "/")+"/.well-known/openid-configuration", &metadata)
+	if err != nil {
+		return nil, err
+	}
+	if metadata.Issuer != p.Issuer {
+		return nil, &ErrorOidcProvider{Provider: p.Name,
+			Err: fmt.Errorf("discovery document is for issuer %q, expected %q", metadata.Issuer, p.Issuer)}
+	}
+	if metadata.AuthorizationEndpoint == "" || metadata.TokenEndpoint == "" || metadata.JwksUri == "" {
+		return nil, &ErrorOidcProvider{Provider: p.Name, Err: errors.New("discovery document lacks endpoints")}
+	}
+
+	p.metadata = &metadata
+	return p.metadata, nil
+}
+
+// AuthorizationUrl returns the URL the browser is redirected to for logging in at the provider. The
+// provider redirects back to RedirectUrl with the state and a code for Exchange. The code challenge is
+// the S256 challenge of the PKCE code verifier.
+func (p *OidcProvider) AuthorizationUrl(ctx context.Context, state string, nonce string, code_challenge string) (string, error) {
+	metadata, err := p.Metadata(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	authorization_url, err := url.Parse(metadata.AuthorizationEndpoint)
+	if err != nil {
+		return "", &ErrorOidcProvider{Provider: p.Name, Err: fmt.Errorf("invalid authorization endpoint: %w", err)}
+	}
+
+	// the endpoint may already have query parameters, which have to be kept
+	query := authorization_url.Query()
+	query.Set("response_type", "code")
+	query.Set("client_id", p.ClientId)
+	query.Set("redirect_uri", p.RedirectUrl)
+	query.Set("scope", strings.Join(p.Scopes, " "))
+	query.Set("state", state)
+	query.Set("nonce", nonce)
+	query.Set("code_challenge", code_challenge)
+	query.Set("code_challenge_method", "S256")
+	authorization_url.RawQuery = query.Encode()
+	return authorization_url.String(), nil
+}
+
+// Exchange redeems the code of the callback with the PKCE code verifier at the token endpoint and returns
+// the ID token, which still has to be verified. Codes the provider rejects, e.g. because they expired or
+// the verifier does not match, are returned as ErrorOidcLogin.
+func (p *OidcProvider) Exchange(ctx context.Context, code string, code_verifier string) (string, error) {
+	metadata, err := p.Metadata(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", p.RedirectUrl)
+	form.Set("code_verifier", code_verifier)
+	form.Set("client_id", p.ClientId)
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, metadata.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", &ErrorOidcProvider{Provider: p.Name, Err: err}
+	}
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Accept", "application/json")
+	if p.ClientSecret != "" {
+		// client_secret_basic, the default authentication of clients, encodes both like form values
+		request.SetBasicAuth(url.QueryEscape(p.ClientId), url.QueryEscape(p.ClientSecret))
+	}
+
+	response, err := p.HttpClient.Do(request)
+	if err != nil {
+		return "", &ErrorOidcProvider{Provider: p.Name, Err: err}
+	}
+	defer response.Body.Close()
+
+	var token_response struct {
+		IdToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	err = json.NewDecoder(io.LimitReader(response.Body, oidcMaxResponseBytes)).Decode(&token_response)
+
+	if response.StatusCode == http.StatusBadRequest && token_response.Error != "" {
+		return "", &ErrorOidcLogin{Reason: "the provider rejected the code",
+			Err: fmt.Errorf("%s: %s", token_response.Error, token_response.ErrorDescription)}
+	}
+	if response.StatusCode != http.StatusOK {
+		return "", &ErrorOidcProvider{Provider: p.Name, Err: fmt.Errorf("token endpoint responded with %s", response.Status)}
+	}
+	if err != nil {
+		return "", &ErrorOidcProvider{Provider: p.Name, Err: fmt.Errorf("decode token response: %w", err)}
+	}
+	if token_response.IdToken == "" {
+		return "", &ErrorOidcProvider{Provider: p.Name, Err: errors.New("token response has no id_token, is the openid scope requested?")}
+	}
+	return token_response.IdToken, nil
+}
+
+// VerifyIdToken checks the signature of the ID token with the keys of the provider and its claims as
+// OpenID Connect Core 1.0 requires for the authorization code flow: the issuer, the audience and, for
+// several audiences, the authorized party have to match the client, the token must not have expired and
+// the nonce has to be the one of the login. Invalid tokens are returned as ErrorOidcLogin.
+func (p *OidcProvider) VerifyIdToken(ctx context.Context, id_token string, nonce string) (*OidcIdTokenClaims, error) {
+	claims := OidcIdTokenClaims{}
+	_, err := jwt.ParseWithClaims(id_token, &claims, func(token *jwt.Token) (any, error) {
+		return p.keyfunc(ctx, token)
+	}, jwt.WithValidMethods(oidcIdTokenMethods), jwt.WithIssuer(p.Issuer), jwt.WithAudience(p.ClientId),
+		jwt.WithExpirationRequired(), jwt.WithIssuedAt(), jwt.WithLeeway(oidcClockSkew))
+
+	var providerErr *ErrorOidcProvider
+	if errors.As(err, &providerErr) {
+		return nil, providerErr
+	}
+	if err != nil {
+		return nil, &ErrorOidcLogin{Reason: "invalid id token", Err: err}
+	}
+
+	if claims.Subject == "" {
+		return nil, &ErrorOidcLogin{Reason: "id token has no subject"}
+	}
+	if (len(claims.Audience) > 1 || claims.AuthorizedParty != "") && claims.AuthorizedParty != p.ClientId {
+		return nil, &ErrorOidcLogin{Reason: "id token was issued to another client"}
+	}
+	if subtle.ConstantTimeCompare([]byte(claims.Nonce), []byte(nonce)) != 1 {
+		return nil, &ErrorOidcLogin{Reason: "id token belongs to another login"}
+	}
+	return &claims, nil
+}
+
+// keyfunc returns the key of the provider that signed the token. If the kid is unknown, the keys are
+// fetched again, at most every oidcJwksRefreshInterval. Tokens without a kid are only accepted if the
+// provider has a single key.
+func (p *OidcProvider) keyfunc(ctx context.Context, token *jwt.Token) (any, error) {
+	kid, _ := token.Header["kid"].(string)
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	key := p.key(kid)
+	if key == nil && time.Since(p.keysFetchedAt) >= oidcJwksRefreshInterval {
+		err := p.fetchKeys(ctx)
+		if err != nil {
+			return nil, err
+		}
+		key = p.key(kid)
+	}
+
+	if key == nil || token.Method.Alg() != key.Method.Alg() {
+		return nil, &ErrorUnknownJwtKey{Kid: kid, Alg: token.Method.Alg()}
+	}
+	return key.verificationKey, nil
+}
+
+// key returns the cached key with the kid. The mutex has to be held.
+func (p *OidcProvider) key(kid string) *JwtKey {
+	if kid == "" && len(p.keys) == 1 {
+		for _, key := range p.keys {
+			return key
+		}
+	}
+	return p.keys[kid]
+}
+
+// fetchKeys replaces the cached keys with the JWKS of the provider. Keys that are not for signatures or
+// of unsupported types are skipped. The mutex has to be held.
+func (p *OidcProvider) fetchKeys(ctx context.Context) error {
+	metadata, err := p.discover(ctx)
+	if err != nil {
+		return err
+	}
+
+	var jwks Jwks
+	err = p.getJson(ctx, metadata.JwksUri, &jwks)
+	if err != nil {
+		return err
+	}
+
+	keys := map[string]*JwtKey{}
+	for _, jwk := range jwks.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		key, err := ParseJwk(jwk)
+		if err != nil {
+			continue
+		}
+		keys[key.Kid] = key
+	}
+
+	p.keys = keys
+	p.keysFetchedAt = time.Now()
+	return nil
+}
+
+// getJson decodes the JSON document at the URL into v.
+func (p *OidcProvider) getJson(ctx context.Context, document_url string, v any) error {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, document_url, nil)
+	if err != nil {
+		return &ErrorOidcProvider{Provider: p.Name, Err: err}
+	}
+	request.Header.Set("Accept", "application/json")
+
+	response, err := p.HttpClient.Do(request)
+	if err != nil {
+		return &ErrorOidcProvider{Provider: p.Name, Err: err}
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return &ErrorOidcProvider{Provider: p.Name, Err: fmt.Errorf("GET %s responded with %s", document_url, response.Status)}
+	}
+	err = json.NewDecoder(io.LimitReader(response.Body, oidcMaxResponseBytes)).Decode(v)
+	if err != nil {
+		return &ErrorOidcProvider{Provider: p.Name, Err: fmt.Errorf("decode %s: %w", document_url, err)}
+	}
+	return nil
+}
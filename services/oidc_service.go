@@ -0,0 +1,318 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"user-notes-api/auth"
+	"user-notes-api/models"
+	"user-notes-api/repositories"
+	"user-notes-api/utils"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	// oidcStateLifetime is how long a login can take at the provider before the callback is rejected.
+	oidcStateLifetime = 10 * time.Minute
+	// oidcStateAudience is the audience of state tokens, which tells them apart from the other JWTs signed
+	// with the same keys.
+	oidcStateAudience = "user-notes-api:oidc"
+	// oidcRandomBytes is the number of random bytes in the state, the nonce and the PKCE code verifier.
+	oidcRandomBytes = 32
+	// oidcUsernameAttempts is how often a random suffix is tried when the username suggested by the provider
+	// is already taken.
+	oidcUsernameAttempts = 5
+	// oidcUsernameSuffixLength is the number of random characters appended to a taken username.
+	oidcUsernameSuffixLength = 4
+	// oidcDefaultUsername is the username of new users if the provider suggests no valid one.
+	oidcDefaultUsername = "user"
+)
+
+type OidcServiceIfc interface {
+	StartLogin(ctx context.Context, provider string) (OidcLogin, error)
+	FinishLogin(ctx context.Context, provider string, callback OidcCallback, stateToken string) (LoginResult, error)
+}
+
+// OidcLogin starts a login at a provider. The browser is redirected to AuthorizationUrl and keeps the
+// StateToken until the callback, e.g. in a cookie.
+type OidcLogin struct {
+	AuthorizationUrl string
+	StateToken       string
+	ExpiresAt        time.Time
+}
+
+// OidcCallback holds the query parameters the provider redirects back with. Error is set instead of Code
+// if the login was cancelled or failed at the provider.
+type OidcCallback struct {
+	Code             string `form:"code"`
+	State            string `form:"state"`
+	Error            string `form:"error"`
+	ErrorDescription string `form:"error_description"`
+}
+
+// OidcStateClaims keep the state, the nonce and the PKCE code verifier of a login from its start to the
+// callback. Like MfaClaims, they have no subject and no user id, so they are never accepted as access token.
+type OidcStateClaims struct {
+	Provider     string `json:"oidc_provider"`
+	State        string `json:"oidc_state"`
+	Nonce        string `json:"oidc_nonce"`
+	CodeVerifier string `json:"oidc_code_verifier"`
+	jwt.RegisteredClaims
+}
+
+// OidcService logs users in with OpenID Connect providers by the authorization code flow with PKCE. Users
+// are identified by the issuer and subject of their ID tokens; users that log in for the first time are
+// created with a username suggested by the provider.
+type OidcService struct {
+	Providers       map[string]*OidcProvider
+	IdentityReader  repositories.OidcIdentityReader
+	IdentityCreator repositories.OidcIdentityCreator
+	PwdHasher       utils.PasswordAlgorithm
+	TokenIssuer     TokenIssuer
+	MfaTokens       MfaTokenIssuer
+	Mfa             MfaVerifier
+	jwt_keys        *JwtKeys
+}
+
+// ErrorUnknownOidcProvider is returned for providers that are not configured.
+type ErrorUnknownOidcProvider struct {
+	Provider string
+}
+
+func (e *ErrorUnknownOidcProvider) Error() string {
+	return fmt.Sprintf("unknown openid connect provider %q", e.Provider)
+}
+
+// ErrorOidcLogin means that a login with a provider was rejected, e.g. because it was cancelled, took too
+// long, was started in another browser or its ID token is invalid. The user can start a new login.
+type ErrorOidcLogin struct {
+	Reason string
+	Err    error
+}
+
+func (e *ErrorOidcLogin) Error() string {
+	if e.Err == nil {
+		return "openid connect login failed: " + e.Reason
+	}
+	return fmt.Sprintf("openid connect login failed: %s: %v", e.Reason, e.Err)
+}
+
+func (e *ErrorOidcLogin) Unwrap() error {
+	return e.Err
+}
+
+func NewOidcService(providers []*OidcProvider, identity_reader repositories.OidcIdentityReader,
+	identity_creator repositories.OidcIdentityCreator, pwd_hasher utils.PasswordAlgorithm, token_issuer TokenIssuer,
+	mfa_tokens MfaTokenIssuer, mfa MfaVerifier, jwt_keys *JwtKeys) *OidcService {
+	oidc_service := OidcService{Providers: map[string]*OidcProvider{}, IdentityReader: identity_reader,
+		IdentityCreator: identity_creator, PwdHasher: pwd_hasher, TokenIssuer: token_issuer, MfaTokens: mfa_tokens,
+		Mfa: mfa, jwt_keys: jwt_keys}
+	for _, provider := range providers {
+		oidc_service.Providers[provider.Name] = provider
+	}
+	return &oidc_service
+}
+
+// StartLogin creates the state, nonce and PKCE code verifier of a new login and returns the authorization
+// URL of the provider with them. The state token holds all three signed, so no login has to be stored
+// until the callback.
+func (s *OidcService) StartLogin(ctx context.Context, provider string) (OidcLogin, error) {
+	oidc_provider, found := s.Providers[provider]
+	if !found {
+		return OidcLogin{}, &ErrorUnknownOidcProvider{Provider: provider}
+	}
+
+	var values [3]string
+	for i := range values {
+		value, err := newOidcRandomValue()
+		if err != nil {
+			return OidcLogin{}, err
+		}
+		values[i] = value
+	}
+	state, nonce, code_verifier := values[0], values[1], values[2]
+
+	authorization_url, err := oidc_provider.AuthorizationUrl(ctx, state, nonce, oidcCodeChallenge(code_verifier))
+	if err != nil {
+		return OidcLogin{}, err
+	}
+
+	now := time.Now()
+	expires_at := now.Add(oidcStateLifetime)
+	state_token, err := s.jwt_keys.Sign(OidcStateClaims{
+		Provider:     provider,
+		State:        state,
+		Nonce:        nonce,
+		CodeVerifier: code_verifier,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    "auth.user-notes-api.local",
+			Audience:  jwt.ClaimStrings{oidcStateAudience},
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(expires_at),
+		},
+	})
+	if err != nil {
+		return OidcLogin{}, fmt.Errorf("sign oidc state: %w", err)
+	}
+
+	return OidcLogin{AuthorizationUrl: authorization_url, StateToken: state_token, ExpiresAt: expires_at}, nil
+}
+
+// FinishLogin checks that the callback belongs to the login of the state token, exchanges its code for
+// the ID token and logs in the user linked to it, creating one on its first login. Like LoginService.Login,
+// the result holds an MfaChallenge instead of tokens for users with two-factor authentication.
+func (s *OidcService) FinishLogin(ctx context.Context, provider string, callback OidcCallback, stateToken string) (LoginResult, error) {
+	oidc_provider, found := s.Providers[provider]
+	if !found {
+		return LoginResult{}, &ErrorUnknownOidcProvider{Provider: provider}
+	}
+
+	state, err := s.parseStateToken(stateToken)
+	if err != nil {
+		return LoginResult{}, err
+	}
+	if state.Provider != provider || subtle.ConstantTimeCompare([]byte(state.State), []byte(callback.State)) != 1 {
+		return LoginResult{}, &ErrorOidcLogin{Reason: "the callback does not belong to the login started in this browser"}
+	}
+
+	if callback.Error != "" {
+		return LoginResult{}, &ErrorOidcLogin{Reason: "the provider returned an error",
+			Err: fmt.Errorf("%s: %s", callback.Error, callback.ErrorDescription)}
+	}
+	if callback.Code == "" {
+		return LoginResult{}, &ErrorOidcLogin{Reason: "the callback has no code"}
+	}
+
+	id_token, err := oidc_provider.Exchange(ctx, callback.Code, state.CodeVerifier)
+	if err != nil {
+		return LoginResult{}, err
+	}
+	claims, err := oidc_provider.VerifyIdToken(ctx, id_token, state.Nonce)
+	if err != nil {
+		return LoginResult{}, err
+	}
+
+	user, err := s.findOrCreateUser(ctx, oidc_provider, claims)
+	if err != nil {
+		return LoginResult{}, err
+	}
+
+	mfa_enabled, err := s.Mfa.MfaEnabled(ctx, user.ID)
+	if err != nil {
+		return LoginResult{}, err
+	}
+	if mfa_enabled {
+		challenge, err := s.MfaTokens.IssueMfaToken(user.ID, user.Username)
+		if err != nil {
+			return LoginResult{}, err
+		}
+		return LoginResult{Mfa: &challenge}, nil
+	}
+
+	tokens, err := s.TokenIssuer.IssueTokens(ctx, user.ID, user.Username)
+	if err != nil {
+		return LoginResult{}, err
+	}
+	return LoginResult{AuthTokens: tokens}, nil
+}
+
+// parseStateToken verifies the state token of a login, expired tokens are returned as ErrorOidcLogin.
+func (s *OidcService) parseStateToken(stateToken string) (*OidcStateClaims, error) {
+	if stateToken == "" {
+		return nil, &ErrorOidcLogin{Reason: "no login was started in this browser"}
+	}
+
+	claims := OidcStateClaims{}
+	_, err := jwt.ParseWithClaims(stateToken, &claims, s.jwt_keys.Keyfunc, jwt.WithValidMethods(s.jwt_keys.Methods()),
+		jwt.WithIssuer("auth.user-notes-api.local"), jwt.WithAudience(oidcStateAudience), jwt.WithExpirationRequired())
+	if err != nil {
+		return nil, &ErrorOidcLogin{Reason: "the login expired or was not started in this browser", Err: err}
+	}
+	return &claims, nil
+}
+
+// findOrCreateUser returns the user linked to the identity of the ID token. Without one, a user is created
+// with the username suggested by the provider, or the username with a random suffix if it is taken.
+func (s *OidcService) findOrCreateUser(ctx context.Context, provider *OidcProvider, claims *OidcIdTokenClaims) (*models.User, error) {
+	user, err := s.IdentityReader.FindUserByOidcIdentity(ctx, provider.Issuer, claims.Subject)
+	if !errors.Is(err, repositories.ErrOidcIdentityNotFound) {
+		return user, err
+	}
+
+	// the user is passwordless, a random password that is never shown keeps the password login closed
+	hash_string, err := s.PwdHasher.Hash([]byte(rand.Text()))
+	if err != nil {
+		return nil, fmt.Errorf("create oidc user: %w", err)
+	}
+
+	username := oidcUsername(claims)
+	for attempt := 0; ; attempt++ {
+		user := models.User{Username: username, Password: hash_string, Passwordless: true}
+		err = s.IdentityCreator.CreateUserWithOidcIdentity(ctx, &user, provider.Issuer, claims.Subject)
+
+		var errTaken *repositories.ErrorUsernameTaken
+		if errors.As(err, &errTaken) && attempt < oidcUsernameAttempts {
+			username = oidcUsernameWithSuffix(oidcUsername(claims))
+			continue
+		}
+		if errors.Is(err, repositories.ErrOidcIdentityLinked) {
+			// a concurrent login of the same user created it first
+			return s.IdentityReader.FindUserByOidcIdentity(ctx, provider.Issuer, claims.Subject)
+		}
+		if err != nil {
+			return nil, err
+		}
+		return &user, nil
+	}
+}
+
+// oidcUsername returns the preferred username of the ID token or the local part of its email, whichever is
+// a valid username first, or oidcDefaultUsername.
+func oidcUsername(claims *OidcIdTokenClaims) string {
+	email_name, _, _ := strings.Cut(claims.Email, "@")
+	for _, suggestion := range []string{claims.PreferredUsername, email_name} {
+		username, err := auth.ValidateUsername(suggestion)
+		if err == nil {
+			return username
+		}
+	}
+	return oidcDefaultUsername
+}
+
+// oidcUsernameWithSuffix appends '-' and random characters to the username, shortening it if the result
+// would be too long.
+func oidcUsernameWithSuffix(username string) string {
+	max_length := auth.MaxUsernameLength - oidcUsernameSuffixLength - 1
+	for utf8.RuneCountInString(username) > max_length {
+		_, size := utf8.DecodeLastRuneInString(username)
+		username = username[:len(username)-size]
+	}
+	return username + "-" + strings.ToLower(rand.Text()[:oidcUsernameSuffixLength])
+}
+
+// newOidcRandomValue returns oidcRandomBytes random bytes in URL-safe base64, 43 characters, which is also
+// the minimum length of a PKCE code verifier of RFC 7636.
+func newOidcRandomValue() (string, error) {
+	value := make([]byte, oidcRandomBytes)
+	_, err := rand.Read(value)
+	if err != nil {
+		return "", fmt.Errorf("generate oidc login: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(value), nil
+}
+
+// oidcCodeChallenge returns the S256 code challenge of the code verifier.
+func oidcCodeChallenge(code_verifier string) string {
+	challenge := sha256.Sum256([]byte(code_verifier))
+	return base64.RawURLEncoding.EncodeToString(challenge[:])
+}
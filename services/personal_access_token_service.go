@@ -0,0 +1,254 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"user-notes-api/models"
+	"user-notes-api/repositories"
+)
+
+// Scopes of personal access tokens. Requests authenticated with a JWT are not limited by scopes.
+const (
+	ScopeNotesRead  = "notes:read"
+	ScopeNotesWrite = "notes:write"
+)
+
+// PersonalAccessTokenScopes are the scopes a personal access token can be created with.
+var PersonalAccessTokenScopes = []string{ScopeNotesRead, ScopeNotesWrite}
+
+const (
+	// PersonalAccessTokenPrefix starts every personal access token, which tells them apart from JWTs and
+	// makes leaked tokens easy to find.
+	PersonalAccessTokenPrefix = "unp_"
+
+	MaxPersonalAccessTokenNameLength = 100
+
+	// personalAccessTokenBytes is the number of random bytes in a personal access token.
+	personalAccessTokenBytes = 32
+	// personalAccessTokenTouchInterval is how often the last use of a token is written to the database.
+	personalAccessTokenTouchInterval = time.Minute
+)
+
+// PersonalAccessTokenCreation is the input for creating a token. Without ExpiresAt, the token is valid
+// until it is deleted.
+type PersonalAccessTokenCreation struct {
+	Name      string     `json:"name" binding:"required"`
+	Scopes    []string   `json:"scopes" binding:"required"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+// PersonalAccessTokenResult describes a token without the token itself, which is only known when it is
+// created.
+type PersonalAccessTokenResult struct {
+	Id         uint       `json:"id"`
+	Name       string     `json:"name"`
+	Scopes     []string   `json:"scopes"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+type CreatedPersonalAccessToken struct {
+	PersonalAccessTokenResult
+	Token string `json:"token"`
+}
+
+type GetPersonalAccessTokensResult struct {
+	Result []PersonalAccessTokenResult `json:"Result"`
+}
+
+// PersonalAccessTokenAuth is the user and the scopes of an authenticated personal access token.
+type PersonalAccessTokenAuth struct {
+	UserId   uint
+	Username string
+	Scopes   []string
+}
+
+type PersonalAccessTokenServiceIfc interface {
+	CreateToken(ctx context.Context, userId uint, creation PersonalAccessTokenCreation) (CreatedPersonalAccessToken, error)
+	GetTokens(ctx context.Context, userId uint) (GetPersonalAccessTokensResult, error)
+	DeleteToken(ctx context.Context, userId uint, tokenId uint) error
+}
+
+// PersonalAccessTokenAuthenticator is consulted by the auth middleware for requests with a personal
+// access token.
+type PersonalAccessTokenAuthenticator interface {
+	Authenticate(ctx context.Context, token string) (PersonalAccessTokenAuth, error)
+}
+
+type PersonalAccessTokenService struct {
+	TokenReader  repositories.PersonalAccessTokenReader
+	TokenCreator repositories.PersonalAccessTokenCreator
+	TokenUpdater repositories.PersonalAccessTokenUpdater
+}
+
+// ErrorInvalidTokenCreation means that the name, scopes or expiry of a new token are invalid.
+type ErrorInvalidTokenCreation struct {
+	Reason string
+}
+
+func (e *ErrorInvalidTokenCreation) Error() string {
+	return fmt.Sprintf("invalid personal access token: %s", e.Reason)
+}
+
+type ErrorPersonalAccessTokenNotFound struct {
+	TokenId uint
+	Err     error
+}
+
+func (e *ErrorPersonalAccessTokenNotFound) Error() string {
+	return fmt.Sprintf("personal access token with id %d not found: %v", e.TokenId, e.Err)
+}
+
+func (e *ErrorPersonalAccessTokenNotFound) Unwrap() error {
+	return e.Err
+}
+
+// ErrorInvalidPersonalAccessToken means that a token presented for authentication is unknown, expired or
+// revoked.
+type ErrorInvalidPersonalAccessToken struct {
+	Err error
+}
+
+func (e *ErrorInvalidPersonalAccessToken) Error() string {
+	return fmt.Sprintf("invalid personal access token: %v", e.Err)
+}
+
+func (e *ErrorInvalidPersonalAccessToken) Unwrap() error {
+	return e.Err
+}
+
+func NewPersonalAccessTokenService(token_reader repositories.PersonalAccessTokenReader,
+	token_creator repositories.PersonalAccessTokenCreator, token_updater repositories.PersonalAccessTokenUpdater) *PersonalAccessTokenService {
+	token_service := PersonalAccessTokenService{TokenReader: token_reader, TokenCreator: token_creator, TokenUpdater: token_updater}
+	return &token_service
+}
+
+// hashPersonalAccessToken returns the hash under which a personal access token is stored. Like refresh
+// tokens, personal access tokens are random, so a fast unsalted hash suffices.
+func hashPersonalAccessToken(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hash[:])
+}
+
+// normalizeScopes checks that all scopes are known and returns them without duplicates in the order of
+// PersonalAccessTokenScopes.
+func normalizeScopes(scopes []string) ([]string, error) {
+	if len(scopes) == 0 {
+		return nil, &ErrorInvalidTokenCreation{Reason: "at least one scope is required"}
+	}
+
+	for _, scope := range scopes {
+		if !slices.Contains(PersonalAccessTokenScopes, scope) {
+			return nil, &ErrorInvalidTokenCreation{Reason: fmt.Sprintf("unknown scope %q, valid scopes are %s", scope,
+				strings.Join(PersonalAccessTokenScopes, ", "))}
+		}
+	}
+
+	var normalized []string
+	for _, scope := range PersonalAccessTokenScopes {
+		if slices.Contains(scopes, scope) {
+			normalized = append(normalized, scope)
+		}
+	}
+	return normalized, nil
+}
+
+func personalAccessTokenResult(token *models.PersonalAccessToken) PersonalAccessTokenResult {
+	return PersonalAccessTokenResult{Id: token.ID, Name: token.Name, Scopes: strings.Fields(token.Scopes),
+		ExpiresAt: token.ExpiresAt, LastUsedAt: token.LastUsedAt, CreatedAt: token.CreatedAt}
+}
+
+// CreateToken creates a token for the user. The returned token is not stored, so it cannot be shown again.
+func (s *PersonalAccessTokenService) CreateToken(ctx context.Context, userId uint, creation PersonalAccessTokenCreation) (CreatedPersonalAccessToken, error) {
+	name := strings.TrimSpace(creation.Name)
+	if name == "" || utf8.RuneCountInString(name) > MaxPersonalAccessTokenNameLength {
+		return CreatedPersonalAccessToken{}, &ErrorInvalidTokenCreation{
+			Reason: fmt.Sprintf("name must have between 1 and %d characters", MaxPersonalAccessTokenNameLength)}
+	}
+
+	scopes, err := normalizeScopes(creation.Scopes)
+	if err != nil {
+		return CreatedPersonalAccessToken{}, err
+	}
+
+	if creation.ExpiresAt != nil && !creation.ExpiresAt.After(time.Now()) {
+		return CreatedPersonalAccessToken{}, &ErrorInvalidTokenCreation{Reason: "expires_at must be in the future"}
+	}
+
+	random, err := randomString(personalAccessTokenBytes)
+	if err != nil {
+		return CreatedPersonalAccessToken{}, fmt.Errorf("generate personal access token: %w", err)
+	}
+	token := PersonalAccessTokenPrefix + random
+
+	token_model := models.PersonalAccessToken{Name: name, TokenHash: hashPersonalAccessToken(token),
+		Scopes: strings.Join(scopes, " "), UserID: userId, ExpiresAt: creation.ExpiresAt}
+	err = s.TokenCreator.CreatePersonalAccessToken(ctx, &token_model)
+	if err != nil {
+		return CreatedPersonalAccessToken{}, fmt.Errorf("store personal access token: %w", err)
+	}
+
+	return CreatedPersonalAccessToken{PersonalAccessTokenResult: personalAccessTokenResult(&token_model), Token: token}, nil
+}
+
+func (s *PersonalAccessTokenService) GetTokens(ctx context.Context, userId uint) (GetPersonalAccessTokensResult, error) {
+	result := GetPersonalAccessTokensResult{Result: []PersonalAccessTokenResult{}}
+	tokens, err := s.TokenReader.FindPersonalAccessTokens(ctx, userId)
+	if err != nil {
+		return result, fmt.Errorf("get personal access tokens: %w", err)
+	}
+
+	for i := range tokens {
+		result.Result = append(result.Result, personalAccessTokenResult(&tokens[i]))
+	}
+	return result, nil
+}
+
+// DeleteToken revokes the token by deleting it. Tokens of other users are reported as not found.
+func (s *PersonalAccessTokenService) DeleteToken(ctx context.Context, userId uint, tokenId uint) error {
+	err := s.TokenUpdater.DeletePersonalAccessToken(ctx, userId, tokenId)
+	if err != nil {
+		return &ErrorPersonalAccessTokenNotFound{TokenId: tokenId, Err: err}
+	}
+	return nil
+}
+
+// Authenticate returns the user and scopes of the token. Expired tokens are rejected, as are tokens
+// created before the user logged out from all devices or changed the password.
+func (s *PersonalAccessTokenService) Authenticate(ctx context.Context, token string) (PersonalAccessTokenAuth, error) {
+	token_model, err := s.TokenReader.FindPersonalAccessTokenByHash(ctx, hashPersonalAccessToken(token))
+	if err != nil {
+		return PersonalAccessTokenAuth{}, &ErrorInvalidPersonalAccessToken{Err: err}
+	}
+
+	now := time.Now()
+	if token_model.ExpiresAt != nil && !now.Before(*token_model.ExpiresAt) {
+		return PersonalAccessTokenAuth{}, &ErrorInvalidPersonalAccessToken{Err: errors.New("token is expired")}
+	}
+
+	// the user is not loaded if it was deleted
+	user := token_model.User
+	if user.ID == 0 || user.TokensRevokedAt != nil && !token_model.CreatedAt.After(*user.TokensRevokedAt) {
+		return PersonalAccessTokenAuth{}, &ErrorInvalidPersonalAccessToken{Err: errors.New("token is revoked")}
+	}
+
+	if token_model.LastUsedAt == nil || now.Sub(*token_model.LastUsedAt) >= personalAccessTokenTouchInterval {
+		// the last use is informational, so a failure does not fail the request
+		err = s.TokenUpdater.TouchPersonalAccessToken(ctx, token_model.ID, now)
+		if err != nil {
+			log.Printf("Failed to record use of personal access token %d: %v", token_model.ID, err)
+		}
+	}
+
+	return PersonalAccessTokenAuth{UserId: token_model.UserID, Username: user.Username, Scopes: strings.Fields(token_model.Scopes)}, nil
+}
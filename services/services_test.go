@@ -2,9 +2,25 @@ package services
 
 import (
 	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
 	"errors"
+	"math/big"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
+	"unicode/utf8"
 
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/stretchr/testify/assert"
@@ -13,8 +29,12 @@ import (
 
 	"user-notes-api/auth"
 	"user-notes-api/models"
+	"user-notes-api/repositories"
 	"user-notes-api/testing/testutils"
+	"user-notes-api/testing/testutils/authmocks"
+	"user-notes-api/testing/testutils/oidcmocks"
 	"user-notes-api/testing/testutils/repositorymocks"
+	"user-notes-api/utils"
 )
 
 func TestAuthServices(t *testing.T) {
@@ -28,30 +48,40 @@ func TestAuthServices(t *testing.T) {
 
 	user := models.User{Username: username, Password: password}
 	repo := testutils.MockUserCreatorReader{User: &user, Registered: false}
-	pwd_hasher := testutils.MockPwdHasher{Hash: []byte(password)}
+	pwd_hasher := testutils.MockPwdHasher{}
 
-	login_manager := auth.LoginManager{UserReader: &repo, PwdComparer: &pwd_hasher}
-	registration_manager := auth.RegistrationManager{UserCreator: &repo, PwdHasher: &pwd_hasher}
+	login_manager := auth.LoginManager{UserReader: &repo, UserUpdater: &repo, PwdHasher: &pwd_hasher}
+	registration_manager := auth.RegistrationManager{UserCreator: &repo, PwdHasher: &pwd_hasher, Policy: auth.NewPasswordRules(10, 128, 72, 2)}
 
-	login_service := NewLoginService(&login_manager, jwt_secret)
+	refresh_token_creator := new(repositorymocks.RefreshTokenCreatorMock)
+	refresh_token_creator.On("CreateRefreshToken", ctx, mock.Anything).Return(nil)
+	jwt_keys := NewJwtKeys(NewHmacJwtKey("", []byte(jwt_secret)))
+	generation_reader := new(repositorymocks.TokenRevocationReaderMock)
+	generation_reader.On("FindTokenGeneration", mock.Anything, mock.Anything).Return(uint(0), nil)
+	token_service := NewTokenService(nil, refresh_token_creator, nil, generation_reader, jwt_keys, 4*time.Hour, 30*24*time.Hour)
+
+	login_service := NewLoginService(&login_manager, token_service, NewLoginThrottle(NewMemoryLoginAttempts(), LoginBackoff{}, LoginBackoff{}, time.Hour),
+		NewMfaTokenService(jwt_keys, 5*time.Minute), NewMfaService(&repo, nil, nil, nil, nil, nil, "User-Notes-API"))
 
 	// Login fails if user does not exist and we get a NotFound error
-	token_string, err := login_service.Login(ctx, creds)
+	result, err := login_service.Login(ctx, creds, "127.0.0.1")
+	tokens := result.AuthTokens
 
 	assert.Error(t, err)
-	assert.Equal(t, 0, len(token_string))
+	assert.Equal(t, 0, len(tokens.Token))
 	var errNotFound *auth.ErrorNotFound
 	assert.True(t, errors.As(err, &errNotFound))
 
-	registration_service := NewRegistrationService(&registration_manager, jwt_secret)
+	registration_service := NewRegistrationService(&registration_manager, token_service)
 
-	// First registration succesful and jwt token is not empty
-	token_string, err = registration_service.Register(ctx, creds)
+	// First registration succesful and jwt and refresh token are not empty
+	tokens, err = registration_service.Register(ctx, creds)
 	assert.NoError(t, err)
-	assert.True(t, len(token_string) > 0)
+	assert.True(t, len(tokens.Token) > 0)
+	assert.True(t, len(tokens.RefreshToken) > 0)
 
 	// check the claims in the token
-	token, err := jwt.ParseWithClaims(token_string, &JwtClaims{}, func(token *jwt.Token) (any, error) {
+	token, err := jwt.ParseWithClaims(tokens.Token, &JwtClaims{}, func(token *jwt.Token) (any, error) {
 		return []byte(jwt_secret), nil
 	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}))
 	assert.NoError(t, err)
@@ -80,12 +110,15 @@ func TestAuthServices(t *testing.T) {
 	assert.True(t, expirationTime.After(time.Now()))
 
 	// After registration login is possible
-	token_string, err = login_service.Login(ctx, creds)
+	result, err = login_service.Login(ctx, creds, "127.0.0.1")
+	tokens = result.AuthTokens
 	assert.NoError(t, err)
-	assert.True(t, len(token_string) > 0)
+	assert.Nil(t, result.Mfa)
+	assert.True(t, len(tokens.Token) > 0)
+	assert.True(t, len(tokens.RefreshToken) > 0)
 
 	// check the claims in the token
-	token, err = jwt.ParseWithClaims(token_string, &JwtClaims{}, func(token *jwt.Token) (any, error) {
+	token, err = jwt.ParseWithClaims(tokens.Token, &JwtClaims{}, func(token *jwt.Token) (any, error) {
 		return []byte(jwt_secret), nil
 	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}))
 	assert.NoError(t, err)
@@ -114,24 +147,123 @@ func TestAuthServices(t *testing.T) {
 	assert.True(t, expirationTime.After(time.Now()))
 
 	// Registration fails if user already exists
-	token_string, err = registration_service.Register(ctx, creds)
+	tokens, err = registration_service.Register(ctx, creds)
 	assert.Error(t, err)
-	assert.False(t, len(token_string) > 0)
+	assert.False(t, len(tokens.Token) > 0)
 
 	// Login fails with the wrong password
 	wrong_creds := auth.Credentials{Username: username, Password: wrong_pwd}
-	token_string, err = login_service.Login(ctx, wrong_creds)
+	result, err = login_service.Login(ctx, wrong_creds, "127.0.0.1")
+	tokens = result.AuthTokens
 
 	assert.Error(t, err)
-	assert.Equal(t, 0, len(token_string))
+	assert.Equal(t, 0, len(tokens.Token))
+	refresh_token_creator.AssertNumberOfCalls(t, "CreateRefreshToken", 2)
+}
+
+func TestTokenServiceRefresh(t *testing.T) {
+	refresh_token_reader := new(repositorymocks.RefreshTokenReaderMock)
+	refresh_token_creator := new(repositorymocks.RefreshTokenCreatorMock)
+	refresh_token_updater := new(repositorymocks.RefreshTokenUpdaterMock)
+
+	generation_reader := new(repositorymocks.TokenRevocationReaderMock)
+	generation_reader.On("FindTokenGeneration", mock.Anything, uint(2)).Return(uint(3), nil)
+	token_service := NewTokenService(refresh_token_reader, refresh_token_creator, refresh_token_updater, generation_reader, NewJwtKeys(NewHmacJwtKey("", []byte("jwt_secret"))),
+		time.Hour, 24*time.Hour)
+
+	ctx := context.Background()
+	var stored *models.RefreshToken
+	refresh_token_creator.On("CreateRefreshToken", ctx, mock.Anything).
+		Run(func(args mock.Arguments) {
+			stored = args.Get(1).(*models.RefreshToken)
+		}).
+		Return(nil)
+
+	tokens, err := token_service.IssueTokens(ctx, 2, "Alice")
+	assert.NoError(t, err)
+	assert.Equal(t, uint(2), stored.UserID)
+	assert.NotEqual(t, tokens.RefreshToken, stored.TokenHash)
+	assert.Equal(t, hashRefreshToken(tokens.RefreshToken), stored.TokenHash)
+	assert.True(t, stored.ExpiresAt.After(time.Now().Add(23*time.Hour)))
+	assert.WithinDuration(t, time.Now(), *stored.AuthenticatedAt, time.Second)
+
+	// Refreshing rotates the token within the same family
+	stored.ID = 1
+	stored.User = models.User{Model: gorm.Model{ID: 2}, Username: "Alice"}
+	refresh_token_reader.On("FindRefreshTokenByHash", ctx, stored.TokenHash).Return(stored, nil)
+	var rotated *models.RefreshToken
+	refresh_token_updater.On("RotateRefreshToken", ctx, uint(1), mock.Anything).
+		Run(func(args mock.Arguments) {
+			rotated = args.Get(2).(*models.RefreshToken)
+		}).
+		Return(nil).Once()
+
+	new_tokens, err := token_service.Refresh(ctx, tokens.RefreshToken)
+	assert.NoError(t, err)
+	assert.NotEqual(t, tokens.RefreshToken, new_tokens.RefreshToken)
+	assert.Equal(t, hashRefreshToken(new_tokens.RefreshToken), rotated.TokenHash)
+	assert.Equal(t, stored.FamilyID, rotated.FamilyID)
+	assert.Equal(t, stored.AuthenticatedAt, rotated.AuthenticatedAt)
+
+	token, err := jwt.ParseWithClaims(new_tokens.Token, &JwtClaims{}, func(token *jwt.Token) (any, error) {
+		return []byte("jwt_secret"), nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}))
+	assert.NoError(t, err)
+	claims := token.Claims.(*JwtClaims)
+	assert.Equal(t, uint(2), claims.UserId)
+	assert.Equal(t, "Alice", claims.Subject)
+	assert.NotEmpty(t, claims.ID)
+	assert.Equal(t, uint(3), claims.Generation)
+	// the refreshed token keeps the time of the login
+	assert.Equal(t, stored.AuthenticatedAt.Unix(), claims.AuthTime.Unix())
+
+	// Reusing the rotated token revokes the family
+	now := time.Now()
+	stored.RotatedAt = &now
+	refresh_token_updater.On("RevokeRefreshTokenFamily", ctx, stored.FamilyID).Return(nil)
+
+	_, err = token_service.Refresh(ctx, tokens.RefreshToken)
+	var errInvalidToken *ErrorInvalidRefreshToken
+	assert.True(t, errors.As(err, &errInvalidToken))
+	assert.True(t, errInvalidToken.Reused)
+	refresh_token_updater.AssertNumberOfCalls(t, "RevokeRefreshTokenFamily", 1)
+
+	// Losing the race against a concurrent refresh counts as reuse as well
+	stored.RotatedAt = nil
+	refresh_token_updater.On("RotateRefreshToken", ctx, uint(1), mock.Anything).Return(repositories.ErrRefreshTokenUsed).Once()
+
+	_, err = token_service.Refresh(ctx, tokens.RefreshToken)
+	assert.True(t, errors.As(err, &errInvalidToken))
+	assert.True(t, errInvalidToken.Reused)
+	refresh_token_updater.AssertNumberOfCalls(t, "RevokeRefreshTokenFamily", 2)
+
+	// Expired, revoked and unknown tokens are rejected
+	stored.ExpiresAt = now.Add(-time.Minute)
+	_, err = token_service.Refresh(ctx, tokens.RefreshToken)
+	assert.True(t, errors.As(err, &errInvalidToken))
+	assert.False(t, errInvalidToken.Reused)
+
+	stored.RevokedAt = &now
+	stored.ExpiresAt = now.Add(time.Hour)
+	_, err = token_service.Refresh(ctx, tokens.RefreshToken)
+	assert.True(t, errors.As(err, &errInvalidToken))
+
+	refresh_token_reader.On("FindRefreshTokenByHash", ctx, hashRefreshToken("unknown")).Return(&models.RefreshToken{}, gorm.ErrRecordNotFound)
+	_, err = token_service.Refresh(ctx, "unknown")
+	assert.True(t, errors.As(err, &errInvalidToken))
+	refresh_token_updater.AssertNumberOfCalls(t, "RotateRefreshToken", 2)
 }
 
 func TestNoteServiceGetNoteSuccess(t *testing.T) {
 	note_reader := new(repositorymocks.NoteReaderMock)
-	note_creator := new(repositorymocks.NoteCreatorMock)
+	note_updater := new(repositorymocks.NoteUpdaterMock)
+	note_deleter := new(repositorymocks.NoteDeleterMock)
+	tag_creator := new(repositorymocks.TagCreatorMock)
+	notebook_reader := new(repositorymocks.NotebookReaderMock)
+	revision_creator := new(repositorymocks.NoteRevisionCreatorMock)
 	user_repo := new(repositorymocks.UserRepoMock)
 
-	note_service := NewNoteService(note_reader, note_creator, user_repo)
+	note_service := NewNoteService(note_reader, note_updater, note_deleter, tag_creator, notebook_reader, revision_creator, user_repo)
 
 	noteId := uint(1)
 	userId := uint(2)
@@ -146,10 +278,14 @@ func TestNoteServiceGetNoteSuccess(t *testing.T) {
 
 func TestNoteServiceGetNoteWrongOwner(t *testing.T) {
 	note_reader := new(repositorymocks.NoteReaderMock)
-	note_creator := new(repositorymocks.NoteCreatorMock)
+	note_updater := new(repositorymocks.NoteUpdaterMock)
+	note_deleter := new(repositorymocks.NoteDeleterMock)
+	tag_creator := new(repositorymocks.TagCreatorMock)
+	notebook_reader := new(repositorymocks.NotebookReaderMock)
+	revision_creator := new(repositorymocks.NoteRevisionCreatorMock)
 	user_repo := new(repositorymocks.UserRepoMock)
 
-	note_service := NewNoteService(note_reader, note_creator, user_repo)
+	note_service := NewNoteService(note_reader, note_updater, note_deleter, tag_creator, notebook_reader, revision_creator, user_repo)
 
 	noteId := uint(1)
 	userId := uint(2)
@@ -164,10 +300,14 @@ func TestNoteServiceGetNoteWrongOwner(t *testing.T) {
 
 func TestNoteServiceGetNoteNotFound(t *testing.T) {
 	note_reader := new(repositorymocks.NoteReaderMock)
-	note_creator := new(repositorymocks.NoteCreatorMock)
+	note_updater := new(repositorymocks.NoteUpdaterMock)
+	note_deleter := new(repositorymocks.NoteDeleterMock)
+	tag_creator := new(repositorymocks.TagCreatorMock)
+	notebook_reader := new(repositorymocks.NotebookReaderMock)
+	revision_creator := new(repositorymocks.NoteRevisionCreatorMock)
 	user_repo := new(repositorymocks.UserRepoMock)
 
-	note_service := NewNoteService(note_reader, note_creator, user_repo)
+	note_service := NewNoteService(note_reader, note_updater, note_deleter, tag_creator, notebook_reader, revision_creator, user_repo)
 
 	noteId := uint(1)
 	userId := uint(2)
@@ -182,10 +322,14 @@ func TestNoteServiceGetNoteNotFound(t *testing.T) {
 
 func TestNoteServiceCreateNoteUser(t *testing.T) {
 	note_reader := new(repositorymocks.NoteReaderMock)
-	note_creator := new(repositorymocks.NoteCreatorMock)
+	note_updater := new(repositorymocks.NoteUpdaterMock)
+	note_deleter := new(repositorymocks.NoteDeleterMock)
+	tag_creator := new(repositorymocks.TagCreatorMock)
+	notebook_reader := new(repositorymocks.NotebookReaderMock)
+	revision_creator := new(repositorymocks.NoteRevisionCreatorMock)
 	user_repo := new(repositorymocks.UserRepoMock)
 
-	note_service := NewNoteService(note_reader, note_creator, user_repo)
+	note_service := NewNoteService(note_reader, note_updater, note_deleter, tag_creator, notebook_reader, revision_creator, user_repo)
 
 	username := "Alice"
 	password := "secret_password"
@@ -196,25 +340,29 @@ func TestNoteServiceCreateNoteUser(t *testing.T) {
 
 	note_model := models.Note{User: models.User{Model: gorm.Model{ID: 2}, Username: username, Password: password},
 		UserID: 2, Title: note.Title, Body: note.Content}
-	note_creator.On("CreateNote", ctx, &note_model).
+	revision_creator.On("CreateNoteWithRevision", ctx, &note_model, uint(2)).
 		Run(func(args mock.Arguments) {
 			note := args.Get(1).(*models.Note)
 			note.ID = 4
 		}).
-		Return(nil)
+		Return(uint(1), nil)
 
 	id, err := note_service.CreateNote(ctx, note, username)
 	assert.NoError(t, err)
 	assert.Equal(t, uint(4), id)
-
+	revision_creator.AssertExpectations(t)
 }
 
 func TestNoteServiceCreateNoteUserNotFound(t *testing.T) {
 	note_reader := new(repositorymocks.NoteReaderMock)
-	note_creator := new(repositorymocks.NoteCreatorMock)
+	note_updater := new(repositorymocks.NoteUpdaterMock)
+	note_deleter := new(repositorymocks.NoteDeleterMock)
+	tag_creator := new(repositorymocks.TagCreatorMock)
+	notebook_reader := new(repositorymocks.NotebookReaderMock)
+	revision_creator := new(repositorymocks.NoteRevisionCreatorMock)
 	user_repo := new(repositorymocks.UserRepoMock)
 
-	note_service := NewNoteService(note_reader, note_creator, user_repo)
+	note_service := NewNoteService(note_reader, note_updater, note_deleter, tag_creator, notebook_reader, revision_creator, user_repo)
 
 	username := "Alice"
 	note := Note{Title: "title", Content: "content"}
@@ -226,3 +374,2159 @@ func TestNoteServiceCreateNoteUserNotFound(t *testing.T) {
 	var errNotFound *ErrorUserNotFound
 	assert.True(t, errors.As(err, &errNotFound))
 }
+
+func TestNoteServiceUpdateNoteSuccess(t *testing.T) {
+	note_reader := new(repositorymocks.NoteReaderMock)
+	note_updater := new(repositorymocks.NoteUpdaterMock)
+	note_deleter := new(repositorymocks.NoteDeleterMock)
+	tag_creator := new(repositorymocks.TagCreatorMock)
+	notebook_reader := new(repositorymocks.NotebookReaderMock)
+	revision_creator := new(repositorymocks.NoteRevisionCreatorMock)
+	user_repo := new(repositorymocks.UserRepoMock)
+
+	note_service := NewNoteService(note_reader, note_updater, note_deleter, tag_creator, notebook_reader, revision_creator, user_repo)
+
+	noteId := uint(1)
+	userId := uint(2)
+	ctx := context.Background()
+	note_reader.On("FindNoteById", ctx, noteId).
+		Return(&models.Note{Model: gorm.Model{ID: 1}, UserID: 2, Title: "Title", Body: "Content", Version: 3}, nil)
+	revision_creator.On("UpdateNoteWithRevision", ctx,
+		&models.Note{Model: gorm.Model{ID: 1}, UserID: 2, Title: "New title", Body: "New content", Version: 3}, uint(2)).
+		Run(func(args mock.Arguments) { args.Get(1).(*models.Note).Version++ }).
+		Return(uint(2), nil)
+
+	version, err := note_service.UpdateNote(ctx, noteId, userId, 3, Note{Title: "New title", Content: "New content"})
+	assert.NoError(t, err)
+	assert.Equal(t, uint(4), version)
+	revision_creator.AssertExpectations(t)
+}
+
+func TestNoteServiceUpdateNoteWrongOwner(t *testing.T) {
+	note_reader := new(repositorymocks.NoteReaderMock)
+	note_updater := new(repositorymocks.NoteUpdaterMock)
+	note_deleter := new(repositorymocks.NoteDeleterMock)
+	tag_creator := new(repositorymocks.TagCreatorMock)
+	notebook_reader := new(repositorymocks.NotebookReaderMock)
+	revision_creator := new(repositorymocks.NoteRevisionCreatorMock)
+	user_repo := new(repositorymocks.UserRepoMock)
+
+	note_service := NewNoteService(note_reader, note_updater, note_deleter, tag_creator, notebook_reader, revision_creator, user_repo)
+
+	noteId := uint(1)
+	userId := uint(2)
+	ctx := context.Background()
+	note_reader.On("FindNoteById", ctx, noteId).Return(&models.Note{UserID: 1, Title: "Title", Body: "Content"}, nil)
+
+	_, err := note_service.UpdateNote(ctx, noteId, userId, 0, Note{Title: "New title", Content: "New content"})
+	assert.Error(t, err)
+	var errWrongOwner *ErrorWrongOwner
+	assert.True(t, errors.As(err, &errWrongOwner))
+	revision_creator.AssertNotCalled(t, "UpdateNoteWithRevision", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestNoteServiceUpdateNoteNotFound(t *testing.T) {
+	note_reader := new(repositorymocks.NoteReaderMock)
+	note_updater := new(repositorymocks.NoteUpdaterMock)
+	note_deleter := new(repositorymocks.NoteDeleterMock)
+	tag_creator := new(repositorymocks.TagCreatorMock)
+	notebook_reader := new(repositorymocks.NotebookReaderMock)
+	revision_creator := new(repositorymocks.NoteRevisionCreatorMock)
+	user_repo := new(repositorymocks.UserRepoMock)
+
+	note_service := NewNoteService(note_reader, note_updater, note_deleter, tag_creator, notebook_reader, revision_creator, user_repo)
+
+	noteId := uint(1)
+	userId := uint(2)
+	ctx := context.Background()
+	note_reader.On("FindNoteById", ctx, noteId).Return(&models.Note{}, errors.New("note not found"))
+
+	_, err := note_service.UpdateNote(ctx, noteId, userId, 1, Note{Title: "New title", Content: "New content"})
+	assert.Error(t, err)
+	var errNotFound *ErrorNoteNotFound
+	assert.True(t, errors.As(err, &errNotFound))
+}
+
+func TestNoteServiceDeleteNoteSuccess(t *testing.T) {
+	note_reader := new(repositorymocks.NoteReaderMock)
+	note_updater := new(repositorymocks.NoteUpdaterMock)
+	note_deleter := new(repositorymocks.NoteDeleterMock)
+	tag_creator := new(repositorymocks.TagCreatorMock)
+	notebook_reader := new(repositorymocks.NotebookReaderMock)
+	revision_creator := new(repositorymocks.NoteRevisionCreatorMock)
+	user_repo := new(repositorymocks.UserRepoMock)
+
+	note_service := NewNoteService(note_reader, note_updater, note_deleter, tag_creator, notebook_reader, revision_creator, user_repo)
+
+	noteId := uint(1)
+	userId := uint(2)
+	ctx := context.Background()
+	note_reader.On("FindNoteById", ctx, noteId).Return(&models.Note{Model: gorm.Model{ID: 1}, UserID: 2, Title: "Title", Body: "Content", Version: 2}, nil)
+	note_deleter.On("DeleteNote", ctx, &models.Note{Model: gorm.Model{ID: 1}, UserID: 2, Title: "Title", Body: "Content", Version: 2}).Return(nil)
+
+	err := note_service.DeleteNote(ctx, noteId, userId, 2)
+	assert.NoError(t, err)
+	note_deleter.AssertExpectations(t)
+}
+
+func TestNoteServiceDeleteNoteWrongOwner(t *testing.T) {
+	note_reader := new(repositorymocks.NoteReaderMock)
+	note_updater := new(repositorymocks.NoteUpdaterMock)
+	note_deleter := new(repositorymocks.NoteDeleterMock)
+	tag_creator := new(repositorymocks.TagCreatorMock)
+	notebook_reader := new(repositorymocks.NotebookReaderMock)
+	revision_creator := new(repositorymocks.NoteRevisionCreatorMock)
+	user_repo := new(repositorymocks.UserRepoMock)
+
+	note_service := NewNoteService(note_reader, note_updater, note_deleter, tag_creator, notebook_reader, revision_creator, user_repo)
+
+	noteId := uint(1)
+	userId := uint(2)
+	ctx := context.Background()
+	note_reader.On("FindNoteById", ctx, noteId).Return(&models.Note{UserID: 1, Title: "Title", Body: "Content"}, nil)
+
+	err := note_service.DeleteNote(ctx, noteId, userId, 0)
+	assert.Error(t, err)
+	var errWrongOwner *ErrorWrongOwner
+	assert.True(t, errors.As(err, &errWrongOwner))
+	note_deleter.AssertNotCalled(t, "DeleteNote", mock.Anything, mock.Anything)
+}
+
+func TestNoteServiceUpdateNoteVersionMismatch(t *testing.T) {
+	note_reader := new(repositorymocks.NoteReaderMock)
+	note_updater := new(repositorymocks.NoteUpdaterMock)
+	note_deleter := new(repositorymocks.NoteDeleterMock)
+	tag_creator := new(repositorymocks.TagCreatorMock)
+	notebook_reader := new(repositorymocks.NotebookReaderMock)
+	revision_creator := new(repositorymocks.NoteRevisionCreatorMock)
+	user_repo := new(repositorymocks.UserRepoMock)
+
+	note_service := NewNoteService(note_reader, note_updater, note_deleter, tag_creator, notebook_reader, revision_creator, user_repo)
+
+	noteId := uint(1)
+	userId := uint(2)
+	ctx := context.Background()
+	note_reader.On("FindNoteById", ctx, noteId).
+		Return(&models.Note{Model: gorm.Model{ID: 1}, UserID: 2, Title: "Title", Body: "Content", Version: 3}, nil).Once()
+
+	// the client read an older version
+	_, err := note_service.UpdateNote(ctx, noteId, userId, 2, Note{Title: "New title", Content: "New content"})
+	var errVersionMismatch *ErrorVersionMismatch
+	assert.True(t, errors.As(err, &errVersionMismatch))
+	assert.Equal(t, uint(3), errVersionMismatch.Version)
+	revision_creator.AssertNotCalled(t, "UpdateNoteWithRevision", mock.Anything, mock.Anything, mock.Anything)
+
+	// the note is changed between reading and updating it
+	note_reader.On("FindNoteById", ctx, noteId).
+		Return(&models.Note{Model: gorm.Model{ID: 1}, UserID: 2, Title: "Title", Body: "Content", Version: 3}, nil).Once()
+	revision_creator.On("UpdateNoteWithRevision", ctx, mock.Anything, uint(2)).Return(uint(0), repositories.ErrNoteVersionMismatch)
+	note_reader.On("FindNoteById", ctx, noteId).
+		Return(&models.Note{Model: gorm.Model{ID: 1}, UserID: 2, Title: "Other title", Body: "Content", Version: 4}, nil).Once()
+
+	_, err = note_service.UpdateNote(ctx, noteId, userId, 3, Note{Title: "New title", Content: "New content"})
+	assert.True(t, errors.As(err, &errVersionMismatch))
+	assert.Equal(t, uint(3), errVersionMismatch.Expected)
+	assert.Equal(t, uint(4), errVersionMismatch.Version)
+}
+
+func TestNoteServiceDeleteNoteVersionMismatch(t *testing.T) {
+	note_reader := new(repositorymocks.NoteReaderMock)
+	note_updater := new(repositorymocks.NoteUpdaterMock)
+	note_deleter := new(repositorymocks.NoteDeleterMock)
+	tag_creator := new(repositorymocks.TagCreatorMock)
+	notebook_reader := new(repositorymocks.NotebookReaderMock)
+	revision_creator := new(repositorymocks.NoteRevisionCreatorMock)
+	user_repo := new(repositorymocks.UserRepoMock)
+
+	note_service := NewNoteService(note_reader, note_updater, note_deleter, tag_creator, notebook_reader, revision_creator, user_repo)
+
+	noteId := uint(1)
+	userId := uint(2)
+	ctx := context.Background()
+	note_reader.On("FindNoteById", ctx, noteId).Return(&models.Note{Model: gorm.Model{ID: 1}, UserID: 2, Version: 5}, nil)
+
+	err := note_service.DeleteNote(ctx, noteId, userId, 4)
+	var errVersionMismatch *ErrorVersionMismatch
+	assert.True(t, errors.As(err, &errVersionMismatch))
+	assert.Equal(t, uint(5), errVersionMismatch.Version)
+	note_deleter.AssertNotCalled(t, "DeleteNote", mock.Anything, mock.Anything)
+}
+
+func TestNoteServiceGetNotesPaging(t *testing.T) {
+	note_reader := new(repositorymocks.NoteReaderMock)
+	note_updater := new(repositorymocks.NoteUpdaterMock)
+	note_deleter := new(repositorymocks.NoteDeleterMock)
+	tag_creator := new(repositorymocks.TagCreatorMock)
+	notebook_reader := new(repositorymocks.NotebookReaderMock)
+	revision_creator := new(repositorymocks.NoteRevisionCreatorMock)
+	user_repo := new(repositorymocks.UserRepoMock)
+
+	note_service := NewNoteService(note_reader, note_updater, note_deleter, tag_creator, notebook_reader, revision_creator, user_repo)
+
+	userId := uint(2)
+	ctx := context.Background()
+	created := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	notes := []models.Note{
+		{Model: gorm.Model{ID: 1, CreatedAt: created}, UserID: userId, Title: "Title1"},
+		{Model: gorm.Model{ID: 2, CreatedAt: created.Add(time.Hour)}, UserID: userId, Title: "Title2"},
+		{Model: gorm.Model{ID: 3, CreatedAt: created.Add(2 * time.Hour)}, UserID: userId, Title: "Title3"},
+	}
+
+	// the repository is asked for one note more than the limit
+	first_query := repositories.NotePageQuery{UserId: userId, Limit: 3, SortField: repositories.NoteSortCreated}
+	note_reader.On("FindNotesPage", ctx, first_query).Return(&notes, nil)
+
+	result, err := note_service.GetNotes(ctx, userId, NoteListOptions{Limit: 2})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(result.Result))
+	assert.Equal(t, uint(1), result.Result[0].Id)
+	assert.Equal(t, uint(2), result.Result[1].Id)
+	assert.NotEmpty(t, result.NextCursor)
+
+	// the cursor points at the last note of the page
+	last_page := notes[2:]
+	second_query := repositories.NotePageQuery{UserId: userId, Limit: 3, SortField: repositories.NoteSortCreated,
+		After: &repositories.NoteCursor{Time: notes[1].CreatedAt, Id: 2}}
+	note_reader.On("FindNotesPage", ctx, second_query).Return(&last_page, nil)
+
+	result, err = note_service.GetNotes(ctx, userId, NoteListOptions{Limit: 2, Cursor: result.NextCursor})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(result.Result))
+	assert.Equal(t, uint(3), result.Result[0].Id)
+	assert.Empty(t, result.NextCursor)
+	note_reader.AssertExpectations(t)
+}
+
+func TestNoteServiceGetNotesSortAndDefaults(t *testing.T) {
+	note_reader := new(repositorymocks.NoteReaderMock)
+	note_updater := new(repositorymocks.NoteUpdaterMock)
+	note_deleter := new(repositorymocks.NoteDeleterMock)
+	tag_creator := new(repositorymocks.TagCreatorMock)
+	notebook_reader := new(repositorymocks.NotebookReaderMock)
+	revision_creator := new(repositorymocks.NoteRevisionCreatorMock)
+	user_repo := new(repositorymocks.UserRepoMock)
+
+	note_service := NewNoteService(note_reader, note_updater, note_deleter, tag_creator, notebook_reader, revision_creator, user_repo)
+
+	userId := uint(2)
+	ctx := context.Background()
+	created_before := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	notes := []models.Note{}
+	query := repositories.NotePageQuery{UserId: userId, Limit: DefaultNotesPageSize + 1, SortField: repositories.NoteSortTitle,
+		Descending: true, CreatedBefore: &created_before}
+	note_reader.On("FindNotesPage", ctx, query).Return(&notes, nil)
+
+	result, err := note_service.GetNotes(ctx, userId, NoteListOptions{Sort: "-title", CreatedBefore: &created_before})
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(result.Result))
+	assert.Empty(t, result.NextCursor)
+	note_reader.AssertExpectations(t)
+}
+
+func TestNoteServiceGetNotesInvalidOptions(t *testing.T) {
+	note_reader := new(repositorymocks.NoteReaderMock)
+	note_updater := new(repositorymocks.NoteUpdaterMock)
+	note_deleter := new(repositorymocks.NoteDeleterMock)
+	tag_creator := new(repositorymocks.TagCreatorMock)
+	notebook_reader := new(repositorymocks.NotebookReaderMock)
+	revision_creator := new(repositorymocks.NoteRevisionCreatorMock)
+	user_repo := new(repositorymocks.UserRepoMock)
+
+	note_service := NewNoteService(note_reader, note_updater, note_deleter, tag_creator, notebook_reader, revision_creator, user_repo)
+
+	userId := uint(2)
+	ctx := context.Background()
+	var errInvalidOption *ErrorInvalidListOption
+
+	_, err := note_service.GetNotes(ctx, userId, NoteListOptions{Limit: MaxNotesPageSize + 1})
+	assert.True(t, errors.As(err, &errInvalidOption))
+	assert.Equal(t, "limit", errInvalidOption.Option)
+
+	_, err = note_service.GetNotes(ctx, userId, NoteListOptions{Sort: "body"})
+	assert.True(t, errors.As(err, &errInvalidOption))
+	assert.Equal(t, "sort", errInvalidOption.Option)
+
+	_, err = note_service.GetNotes(ctx, userId, NoteListOptions{Cursor: "not a cursor"})
+	assert.True(t, errors.As(err, &errInvalidOption))
+	assert.Equal(t, "cursor", errInvalidOption.Option)
+
+	// a cursor cannot be reused for a different sort order
+	cursor, err := encodeNoteCursor("title", &models.Note{Model: gorm.Model{ID: 1}, Title: "Title"})
+	assert.NoError(t, err)
+	_, err = note_service.GetNotes(ctx, userId, NoteListOptions{Sort: "-title", Cursor: cursor})
+	assert.True(t, errors.As(err, &errInvalidOption))
+	assert.Equal(t, "cursor", errInvalidOption.Option)
+
+	note_reader.AssertNotCalled(t, "FindNotesPage", mock.Anything, mock.Anything)
+}
+
+func TestNoteServiceSearchNotes(t *testing.T) {
+	note_reader := new(repositorymocks.NoteReaderMock)
+	note_updater := new(repositorymocks.NoteUpdaterMock)
+	note_deleter := new(repositorymocks.NoteDeleterMock)
+	tag_creator := new(repositorymocks.TagCreatorMock)
+	notebook_reader := new(repositorymocks.NotebookReaderMock)
+	revision_creator := new(repositorymocks.NoteRevisionCreatorMock)
+	user_repo := new(repositorymocks.UserRepoMock)
+
+	note_service := NewNoteService(note_reader, note_updater, note_deleter, tag_creator, notebook_reader, revision_creator, user_repo)
+
+	userId := uint(2)
+	ctx := context.Background()
+	hits := []repositories.NoteSearchHit{
+		{ID: 3, Title: "Bread recipe", Snippet: "<mark>Bread</mark>", Rank: 2},
+		{ID: 1, Title: "Groceries", Snippet: "Buy <mark>bread</mark>", Rank: 1},
+	}
+	note_reader.On("SearchNotes", ctx, userId, "bread", DefaultSearchResults).Return(&hits, nil)
+
+	result, err := note_service.SearchNotes(ctx, userId, NoteSearchOptions{Query: "  bread "})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(result.Result))
+	assert.Equal(t, uint(3), result.Result[0].Id)
+	assert.Equal(t, "Buy <mark>bread</mark>", result.Result[1].Snippet)
+
+	// empty query and invalid limits are rejected
+	var errInvalidOption *ErrorInvalidListOption
+	_, err = note_service.SearchNotes(ctx, userId, NoteSearchOptions{Query: " "})
+	assert.True(t, errors.As(err, &errInvalidOption))
+	assert.Equal(t, "q", errInvalidOption.Option)
+
+	_, err = note_service.SearchNotes(ctx, userId, NoteSearchOptions{Query: "bread", Limit: MaxSearchResults + 1})
+	assert.True(t, errors.As(err, &errInvalidOption))
+	assert.Equal(t, "limit", errInvalidOption.Option)
+
+	note_reader.AssertNumberOfCalls(t, "SearchNotes", 1)
+}
+
+func TestNoteServiceCreateNoteWithTags(t *testing.T) {
+	note_reader := new(repositorymocks.NoteReaderMock)
+	note_updater := new(repositorymocks.NoteUpdaterMock)
+	note_deleter := new(repositorymocks.NoteDeleterMock)
+	tag_creator := new(repositorymocks.TagCreatorMock)
+	notebook_reader := new(repositorymocks.NotebookReaderMock)
+	revision_creator := new(repositorymocks.NoteRevisionCreatorMock)
+	user_repo := new(repositorymocks.UserRepoMock)
+
+	note_service := NewNoteService(note_reader, note_updater, note_deleter, tag_creator, notebook_reader, revision_creator, user_repo)
+
+	username := "Alice"
+	user := models.User{Model: gorm.Model{ID: 2}, Username: username}
+	ctx := context.Background()
+	user_repo.On("FindUserByName", ctx, username).Return(&user, nil)
+
+	// tag names are normalized and deduplicated before they are looked up
+	tags := []models.Tag{{Model: gorm.Model{ID: 5}, Name: "work", UserID: 2}, {Model: gorm.Model{ID: 6}, Name: "home", UserID: 2}}
+	tag_creator.On("FindOrCreateTags", ctx, uint(2), []string{"work", "home"}).Return(&tags, nil)
+
+	note_model := models.Note{User: user, UserID: 2, Title: "title", Body: "content", Tags: tags}
+	revision_creator.On("CreateNoteWithRevision", ctx, &note_model, uint(2)).
+		Run(func(args mock.Arguments) {
+			note := args.Get(1).(*models.Note)
+			note.ID = 4
+		}).
+		Return(uint(1), nil)
+
+	note := Note{Title: "title", Content: "content", Tags: []string{"Work", " home", "work"}}
+	id, err := note_service.CreateNote(ctx, note, username)
+	assert.NoError(t, err)
+	assert.Equal(t, uint(4), id)
+
+	// empty tag names are rejected
+	note = Note{Title: "title", Content: "content", Tags: []string{" "}}
+	_, err = note_service.CreateNote(ctx, note, username)
+	var errInvalidTag *ErrorInvalidTag
+	assert.True(t, errors.As(err, &errInvalidTag))
+	revision_creator.AssertNumberOfCalls(t, "CreateNoteWithRevision", 1)
+}
+
+func TestNoteServiceUpdateNoteReplacesTags(t *testing.T) {
+	note_reader := new(repositorymocks.NoteReaderMock)
+	note_updater := new(repositorymocks.NoteUpdaterMock)
+	note_deleter := new(repositorymocks.NoteDeleterMock)
+	tag_creator := new(repositorymocks.TagCreatorMock)
+	notebook_reader := new(repositorymocks.NotebookReaderMock)
+	revision_creator := new(repositorymocks.NoteRevisionCreatorMock)
+	user_repo := new(repositorymocks.UserRepoMock)
+
+	note_service := NewNoteService(note_reader, note_updater, note_deleter, tag_creator, notebook_reader, revision_creator, user_repo)
+
+	noteId := uint(1)
+	userId := uint(2)
+	ctx := context.Background()
+	old_tags := []models.Tag{{Model: gorm.Model{ID: 5}, Name: "work", UserID: 2}}
+	note_reader.On("FindNoteById", ctx, noteId).
+		Return(&models.Note{Model: gorm.Model{ID: 1}, UserID: 2, Title: "Title", Body: "Content", Tags: old_tags}, nil)
+
+	new_tags := []models.Tag{{Model: gorm.Model{ID: 6}, Name: "home", UserID: 2}}
+	tag_creator.On("FindOrCreateTags", ctx, userId, []string{"home"}).Return(&new_tags, nil)
+	revision_creator.On("UpdateNoteWithRevision", ctx,
+		&models.Note{Model: gorm.Model{ID: 1}, UserID: 2, Title: "Title", Body: "Content", Tags: new_tags}, userId).
+		Return(uint(2), nil)
+
+	_, err := note_service.UpdateNote(ctx, noteId, userId, 0, Note{Title: "Title", Content: "Content", Tags: []string{"home"}})
+	assert.NoError(t, err)
+	revision_creator.AssertExpectations(t)
+
+	// the updated note is returned with the new tag names
+	note, err := note_service.GetNote(ctx, noteId, userId)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"home"}, note.Tags)
+}
+
+func TestNoteServiceUpdateNoteKeepsTags(t *testing.T) {
+	note_reader := new(repositorymocks.NoteReaderMock)
+	note_updater := new(repositorymocks.NoteUpdaterMock)
+	note_deleter := new(repositorymocks.NoteDeleterMock)
+	tag_creator := new(repositorymocks.TagCreatorMock)
+	notebook_reader := new(repositorymocks.NotebookReaderMock)
+	revision_creator := new(repositorymocks.NoteRevisionCreatorMock)
+	user_repo := new(repositorymocks.UserRepoMock)
+
+	note_service := NewNoteService(note_reader, note_updater, note_deleter, tag_creator, notebook_reader, revision_creator, user_repo)
+
+	ctx := context.Background()
+	old_tags := []models.Tag{{Model: gorm.Model{ID: 5}, Name: "work", UserID: 2}}
+	note_reader.On("FindNoteById", ctx, uint(1)).
+		Return(&models.Note{Model: gorm.Model{ID: 1}, UserID: 2, Title: "Title", Body: "Content", Tags: old_tags}, nil)
+
+	// without tags the note keeps its tags
+	revision_creator.On("UpdateNoteWithRevision", ctx,
+		&models.Note{Model: gorm.Model{ID: 1}, UserID: 2, Title: "New title", Body: "Content", Tags: old_tags}, uint(2)).
+		Return(uint(2), nil).Once()
+	_, err := note_service.UpdateNote(ctx, 1, 2, 0, Note{Title: "New title", Content: "Content"})
+	assert.NoError(t, err)
+
+	// an empty list removes them
+	revision_creator.On("UpdateNoteWithRevision", ctx,
+		&models.Note{Model: gorm.Model{ID: 1}, UserID: 2, Title: "New title", Body: "Content"}, uint(2)).
+		Return(uint(3), nil).Once()
+	_, err = note_service.UpdateNote(ctx, 1, 2, 0, Note{Title: "New title", Content: "Content", Tags: []string{}})
+	assert.NoError(t, err)
+
+	revision_creator.AssertExpectations(t)
+	tag_creator.AssertNotCalled(t, "FindOrCreateTags", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestNoteServiceGetNotesTagFilter(t *testing.T) {
+	note_reader := new(repositorymocks.NoteReaderMock)
+	note_updater := new(repositorymocks.NoteUpdaterMock)
+	note_deleter := new(repositorymocks.NoteDeleterMock)
+	tag_creator := new(repositorymocks.TagCreatorMock)
+	notebook_reader := new(repositorymocks.NotebookReaderMock)
+	revision_creator := new(repositorymocks.NoteRevisionCreatorMock)
+	user_repo := new(repositorymocks.UserRepoMock)
+
+	note_service := NewNoteService(note_reader, note_updater, note_deleter, tag_creator, notebook_reader, revision_creator, user_repo)
+
+	userId := uint(2)
+	ctx := context.Background()
+	notes := []models.Note{{Model: gorm.Model{ID: 1}, UserID: userId, Title: "Title1",
+		Tags: []models.Tag{{Name: "home"}, {Name: "work"}}}}
+
+	and_query := repositories.NotePageQuery{UserId: userId, Limit: DefaultNotesPageSize + 1, SortField: repositories.NoteSortCreated,
+		Tags: []string{"work", "home"}, MatchAllTags: true}
+	note_reader.On("FindNotesPage", ctx, and_query).Return(&notes, nil)
+
+	result, err := note_service.GetNotes(ctx, userId, NoteListOptions{Tags: []string{"Work", "home"}})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"home", "work"}, result.Result[0].Tags)
+
+	or_query := and_query
+	or_query.MatchAllTags = false
+	note_reader.On("FindNotesPage", ctx, or_query).Return(&notes, nil)
+
+	_, err = note_service.GetNotes(ctx, userId, NoteListOptions{Tags: []string{"work", "home"}, TagMode: "or"})
+	assert.NoError(t, err)
+	note_reader.AssertExpectations(t)
+
+	var errInvalidOption *ErrorInvalidListOption
+	_, err = note_service.GetNotes(ctx, userId, NoteListOptions{Tags: []string{"work"}, TagMode: "xor"})
+	assert.True(t, errors.As(err, &errInvalidOption))
+	assert.Equal(t, "tag_mode", errInvalidOption.Option)
+}
+
+func TestTagServiceGetTags(t *testing.T) {
+	tag_reader := new(repositorymocks.TagReaderMock)
+	tag_updater := new(repositorymocks.TagUpdaterMock)
+	tag_deleter := new(repositorymocks.TagDeleterMock)
+
+	tag_service := NewTagService(tag_reader, tag_updater, tag_deleter)
+
+	ctx := context.Background()
+	tags := []repositories.TagWithNoteCount{{ID: 1, Name: "home", NoteCount: 0}, {ID: 2, Name: "work", NoteCount: 3}}
+	tag_reader.On("FindTagsWithNoteCount", ctx, uint(2)).Return(&tags, nil)
+
+	result, err := tag_service.GetTags(ctx, uint(2))
+	assert.NoError(t, err)
+	assert.Equal(t, []TagResult{{Id: 1, Name: "home", NoteCount: 0}, {Id: 2, Name: "work", NoteCount: 3}}, result.Result)
+}
+
+func TestTagServiceRenameTag(t *testing.T) {
+	tag_reader := new(repositorymocks.TagReaderMock)
+	tag_updater := new(repositorymocks.TagUpdaterMock)
+	tag_deleter := new(repositorymocks.TagDeleterMock)
+
+	tag_service := NewTagService(tag_reader, tag_updater, tag_deleter)
+
+	ctx := context.Background()
+	tag_reader.On("FindTagById", ctx, uint(1)).Return(&models.Tag{Model: gorm.Model{ID: 1}, Name: "work", UserID: 2}, nil)
+	tag_reader.On("FindTagByName", ctx, uint(2), "job").Return(&models.Tag{}, gorm.ErrRecordNotFound)
+	tag_reader.On("FindTagByName", ctx, uint(2), "home").Return(&models.Tag{Model: gorm.Model{ID: 3}, Name: "home", UserID: 2}, nil)
+	tag_updater.On("RenameTag", ctx, uint(1), "job").Return(nil)
+
+	err := tag_service.RenameTag(ctx, 1, 2, " Job")
+	assert.NoError(t, err)
+
+	// renaming to the name of another tag is a conflict
+	err = tag_service.RenameTag(ctx, 1, 2, "home")
+	var errExists *ErrorTagExists
+	assert.True(t, errors.As(err, &errExists))
+
+	// other users cannot rename the tag
+	err = tag_service.RenameTag(ctx, 1, 3, "job")
+	var errWrongOwner *ErrorTagWrongOwner
+	assert.True(t, errors.As(err, &errWrongOwner))
+
+	tag_updater.AssertNumberOfCalls(t, "RenameTag", 1)
+}
+
+func TestTagServiceMergeAndDeleteTags(t *testing.T) {
+	tag_reader := new(repositorymocks.TagReaderMock)
+	tag_updater := new(repositorymocks.TagUpdaterMock)
+	tag_deleter := new(repositorymocks.TagDeleterMock)
+
+	tag_service := NewTagService(tag_reader, tag_updater, tag_deleter)
+
+	ctx := context.Background()
+	tag_reader.On("FindTagById", ctx, uint(1)).Return(&models.Tag{Model: gorm.Model{ID: 1}, Name: "work", UserID: 2}, nil)
+	tag_reader.On("FindTagById", ctx, uint(2)).Return(&models.Tag{Model: gorm.Model{ID: 2}, Name: "job", UserID: 2}, nil)
+	tag_reader.On("FindTagById", ctx, uint(3)).Return(&models.Tag{Model: gorm.Model{ID: 3}, Name: "job", UserID: 4}, nil)
+	tag_reader.On("FindTagById", ctx, uint(5)).Return(&models.Tag{}, gorm.ErrRecordNotFound)
+	tag_updater.On("MergeTags", ctx, uint(2), uint(1)).Return(nil)
+	tag_deleter.On("DeleteTagById", ctx, uint(1)).Return(nil)
+
+	err := tag_service.MergeTags(ctx, 2, 1, 2)
+	assert.NoError(t, err)
+
+	// merging into a tag of another user is not possible
+	err = tag_service.MergeTags(ctx, 2, 3, 2)
+	var errWrongOwner *ErrorTagWrongOwner
+	assert.True(t, errors.As(err, &errWrongOwner))
+
+	err = tag_service.MergeTags(ctx, 2, 5, 2)
+	var errNotFound *ErrorTagNotFound
+	assert.True(t, errors.As(err, &errNotFound))
+
+	err = tag_service.MergeTags(ctx, 1, 1, 2)
+	var errInvalidTag *ErrorInvalidTag
+	assert.True(t, errors.As(err, &errInvalidTag))
+
+	tag_updater.AssertNumberOfCalls(t, "MergeTags", 1)
+
+	err = tag_service.DeleteTag(ctx, 1, 2)
+	assert.NoError(t, err)
+
+	err = tag_service.DeleteTag(ctx, 3, 2)
+	assert.True(t, errors.As(err, &errWrongOwner))
+	tag_deleter.AssertNumberOfCalls(t, "DeleteTagById", 1)
+}
+
+func TestNoteServiceMoveNote(t *testing.T) {
+	note_reader := new(repositorymocks.NoteReaderMock)
+	note_updater := new(repositorymocks.NoteUpdaterMock)
+	note_deleter := new(repositorymocks.NoteDeleterMock)
+	tag_creator := new(repositorymocks.TagCreatorMock)
+	notebook_reader := new(repositorymocks.NotebookReaderMock)
+	revision_creator := new(repositorymocks.NoteRevisionCreatorMock)
+	user_repo := new(repositorymocks.UserRepoMock)
+
+	note_service := NewNoteService(note_reader, note_updater, note_deleter, tag_creator, notebook_reader, revision_creator, user_repo)
+
+	ctx := context.Background()
+	note_reader.On("FindNoteById", ctx, uint(1)).Return(&models.Note{Model: gorm.Model{ID: 1}, UserID: 2, Version: 2}, nil)
+	notebook_reader.On("FindNotebookById", ctx, uint(3)).Return(&models.Notebook{Model: gorm.Model{ID: 3}, UserID: 2}, nil)
+	notebook_reader.On("FindNotebookById", ctx, uint(4)).Return(&models.Notebook{Model: gorm.Model{ID: 4}, UserID: 5}, nil)
+	notebook_reader.On("FindNotebookById", ctx, uint(6)).Return(&models.Notebook{}, gorm.ErrRecordNotFound)
+
+	notebook_id := uint(3)
+	note_updater.On("MoveNote", ctx, mock.Anything, &notebook_id).Return(nil)
+	note_updater.On("MoveNote", ctx, mock.Anything, (*uint)(nil)).Return(nil)
+
+	_, err := note_service.MoveNote(ctx, 1, 2, 2, &notebook_id)
+	assert.NoError(t, err)
+
+	_, err = note_service.MoveNote(ctx, 1, 2, 2, nil)
+	assert.NoError(t, err)
+
+	// the client has to know the current version
+	_, err = note_service.MoveNote(ctx, 1, 2, 1, nil)
+	var errVersionMismatch *ErrorVersionMismatch
+	assert.True(t, errors.As(err, &errVersionMismatch))
+	assert.Equal(t, uint(2), errVersionMismatch.Version)
+
+	// notes cannot be moved into notebooks of other users
+	other_notebook_id := uint(4)
+	_, err = note_service.MoveNote(ctx, 1, 2, 2, &other_notebook_id)
+	var errNotebookWrongOwner *ErrorNotebookWrongOwner
+	assert.True(t, errors.As(err, &errNotebookWrongOwner))
+
+	missing_notebook_id := uint(6)
+	_, err = note_service.MoveNote(ctx, 1, 2, 2, &missing_notebook_id)
+	var errNotebookNotFound *ErrorNotebookNotFound
+	assert.True(t, errors.As(err, &errNotebookNotFound))
+
+	// other users cannot move the note
+	_, err = note_service.MoveNote(ctx, 1, 5, 2, &other_notebook_id)
+	var errWrongOwner *ErrorWrongOwner
+	assert.True(t, errors.As(err, &errWrongOwner))
+
+	note_updater.AssertNumberOfCalls(t, "MoveNote", 2)
+
+	// the note is changed between reading and moving it
+	notebook_reader.On("FindNotebookById", ctx, uint(7)).Return(&models.Notebook{Model: gorm.Model{ID: 7}, UserID: 2}, nil)
+	moved_notebook_id := uint(7)
+	note_updater.On("MoveNote", ctx, mock.Anything, &moved_notebook_id).Return(repositories.ErrNoteVersionMismatch)
+	_, err = note_service.MoveNote(ctx, 1, 2, 2, &moved_notebook_id)
+	assert.True(t, errors.As(err, &errVersionMismatch))
+}
+
+func TestNotebookServiceCreateNotebook(t *testing.T) {
+	notebook_reader := new(repositorymocks.NotebookReaderMock)
+	notebook_creator := new(repositorymocks.NotebookCreatorMock)
+	notebook_updater := new(repositorymocks.NotebookUpdaterMock)
+	notebook_deleter := new(repositorymocks.NotebookDeleterMock)
+
+	notebook_service := NewNotebookService(notebook_reader, notebook_creator, notebook_updater, notebook_deleter)
+
+	ctx := context.Background()
+	notebook_reader.On("FindNotebookById", ctx, uint(1)).Return(&models.Notebook{Model: gorm.Model{ID: 1}, UserID: 2}, nil)
+
+	parent_id := uint(1)
+	notebook_creator.On("CreateNotebook", ctx, &models.Notebook{Name: "projects", UserID: 2, ParentID: &parent_id}).
+		Run(func(args mock.Arguments) {
+			notebook := args.Get(1).(*models.Notebook)
+			notebook.ID = 3
+		}).
+		Return(nil)
+
+	id, err := notebook_service.CreateNotebook(ctx, Notebook{Name: " projects ", Parent: &parent_id}, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, uint(3), id)
+
+	// the parent has to belong to the same user
+	_, err = notebook_service.CreateNotebook(ctx, Notebook{Name: "projects", Parent: &parent_id}, 4)
+	var errWrongOwner *ErrorNotebookWrongOwner
+	assert.True(t, errors.As(err, &errWrongOwner))
+
+	_, err = notebook_service.CreateNotebook(ctx, Notebook{Name: "  "}, 2)
+	var errInvalidNotebook *ErrorInvalidNotebook
+	assert.True(t, errors.As(err, &errInvalidNotebook))
+
+	notebook_creator.AssertNumberOfCalls(t, "CreateNotebook", 1)
+}
+
+func TestNotebookServiceGetNotebookContents(t *testing.T) {
+	notebook_reader := new(repositorymocks.NotebookReaderMock)
+	notebook_creator := new(repositorymocks.NotebookCreatorMock)
+	notebook_updater := new(repositorymocks.NotebookUpdaterMock)
+	notebook_deleter := new(repositorymocks.NotebookDeleterMock)
+
+	notebook_service := NewNotebookService(notebook_reader, notebook_creator, notebook_updater, notebook_deleter)
+
+	ctx := context.Background()
+	work_id, projects_id := uint(1), uint(2)
+	notebook_reader.On("FindNotebookById", ctx, work_id).Return(&models.Notebook{Model: gorm.Model{ID: 1}, Name: "work", UserID: 2}, nil)
+
+	tree := []models.Notebook{
+		{Model: gorm.Model{ID: 3}, Name: "archive", UserID: 2, ParentID: &projects_id},
+		{Model: gorm.Model{ID: 2}, Name: "projects", UserID: 2, ParentID: &work_id},
+		{Model: gorm.Model{ID: 1}, Name: "work", UserID: 2},
+	}
+	notebook_reader.On("FindNotebookTree", ctx, work_id).Return(&tree, nil)
+
+	archive_id := uint(3)
+	notes := []models.Note{
+		{Model: gorm.Model{ID: 10}, Title: "Plan", UserID: 2, NotebookID: &work_id},
+		{Model: gorm.Model{ID: 11}, Title: "Old plan", UserID: 2, NotebookID: &archive_id},
+	}
+	notebook_reader.On("FindNotesInNotebooks", ctx, []uint{3, 2, 1}).Return(&notes, nil)
+
+	contents, err := notebook_service.GetNotebookContents(ctx, work_id, 2)
+	assert.NoError(t, err)
+	expected := NotebookContents{Id: 1, Name: "work", Notes: []NotebookNote{{Id: 10, Title: "Plan"}},
+		Notebooks: []NotebookContents{{Id: 2, Name: "projects", Notes: []NotebookNote{},
+			Notebooks: []NotebookContents{{Id: 3, Name: "archive", Notes: []NotebookNote{{Id: 11, Title: "Old plan"}},
+				Notebooks: []NotebookContents{}}}}}}
+	assert.Equal(t, expected, contents)
+
+	_, err = notebook_service.GetNotebookContents(ctx, work_id, 5)
+	var errWrongOwner *ErrorNotebookWrongOwner
+	assert.True(t, errors.As(err, &errWrongOwner))
+}
+
+func TestNotebookServiceUpdateNotebook(t *testing.T) {
+	notebook_reader := new(repositorymocks.NotebookReaderMock)
+	notebook_creator := new(repositorymocks.NotebookCreatorMock)
+	notebook_updater := new(repositorymocks.NotebookUpdaterMock)
+	notebook_deleter := new(repositorymocks.NotebookDeleterMock)
+
+	notebook_service := NewNotebookService(notebook_reader, notebook_creator, notebook_updater, notebook_deleter)
+
+	ctx := context.Background()
+	work_id, projects_id, personal_id := uint(1), uint(2), uint(3)
+	notebook_reader.On("FindNotebookById", ctx, work_id).Return(&models.Notebook{Model: gorm.Model{ID: 1}, Name: "work", UserID: 2}, nil)
+	notebook_reader.On("FindNotebookById", ctx, projects_id).
+		Return(&models.Notebook{Model: gorm.Model{ID: 2}, Name: "projects", UserID: 2, ParentID: &work_id}, nil)
+	notebook_reader.On("FindNotebookById", ctx, personal_id).Return(&models.Notebook{Model: gorm.Model{ID: 3}, Name: "personal", UserID: 2}, nil)
+
+	tree := []models.Notebook{{Model: gorm.Model{ID: 2}, ParentID: &work_id}, {Model: gorm.Model{ID: 1}}}
+	notebook_reader.On("FindNotebookTree", ctx, work_id).Return(&tree, nil)
+	notebook_reader.On("FindNotebookTree", ctx, personal_id).Return(&[]models.Notebook{{Model: gorm.Model{ID: 3}}}, nil)
+
+	notebook_updater.On("UpdateNotebook", ctx, &models.Notebook{Model: gorm.Model{ID: 3}, Name: "private", UserID: 2, ParentID: &work_id}).
+		Return(nil)
+
+	err := notebook_service.UpdateNotebook(ctx, personal_id, 2, Notebook{Name: "private", Parent: &work_id})
+	assert.NoError(t, err)
+
+	// a notebook cannot be moved into one of its own notebooks
+	err = notebook_service.UpdateNotebook(ctx, work_id, 2, Notebook{Name: "work", Parent: &projects_id})
+	var errInvalidNotebook *ErrorInvalidNotebook
+	assert.True(t, errors.As(err, &errInvalidNotebook))
+
+	err = notebook_service.UpdateNotebook(ctx, work_id, 2, Notebook{Name: "work", Parent: &work_id})
+	assert.True(t, errors.As(err, &errInvalidNotebook))
+
+	notebook_updater.AssertNumberOfCalls(t, "UpdateNotebook", 1)
+}
+
+func TestNotebookServiceDeleteNotebook(t *testing.T) {
+	notebook_reader := new(repositorymocks.NotebookReaderMock)
+	notebook_creator := new(repositorymocks.NotebookCreatorMock)
+	notebook_updater := new(repositorymocks.NotebookUpdaterMock)
+	notebook_deleter := new(repositorymocks.NotebookDeleterMock)
+
+	notebook_service := NewNotebookService(notebook_reader, notebook_creator, notebook_updater, notebook_deleter)
+
+	ctx := context.Background()
+	notebook_reader.On("FindNotebookById", ctx, uint(1)).Return(&models.Notebook{Model: gorm.Model{ID: 1}, UserID: 2}, nil)
+	notebook_deleter.On("DeleteNotebookById", ctx, uint(1), repositories.NotebookDeleteMoveToParent).Return(nil)
+	notebook_deleter.On("DeleteNotebookById", ctx, uint(1), repositories.NotebookDeleteContents).Return(nil)
+
+	err := notebook_service.DeleteNotebook(ctx, 1, 2, "")
+	assert.NoError(t, err)
+
+	err = notebook_service.DeleteNotebook(ctx, 1, 2, "delete")
+	assert.NoError(t, err)
+
+	err = notebook_service.DeleteNotebook(ctx, 1, 2, "archive")
+	var errInvalidOption *ErrorInvalidListOption
+	assert.True(t, errors.As(err, &errInvalidOption))
+
+	err = notebook_service.DeleteNotebook(ctx, 1, 3, "move")
+	var errWrongOwner *ErrorNotebookWrongOwner
+	assert.True(t, errors.As(err, &errWrongOwner))
+
+	notebook_deleter.AssertExpectations(t)
+	notebook_deleter.AssertNumberOfCalls(t, "DeleteNotebookById", 2)
+}
+
+func TestNoteRevisionServiceGetRevisions(t *testing.T) {
+	note_reader := new(repositorymocks.NoteReaderMock)
+	revision_reader := new(repositorymocks.NoteRevisionReaderMock)
+	revision_creator := new(repositorymocks.NoteRevisionCreatorMock)
+
+	revision_service := NewNoteRevisionService(note_reader, revision_reader, revision_creator)
+
+	ctx := context.Background()
+	created_at := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	note_reader.On("FindNoteById", ctx, uint(1)).Return(&models.Note{Model: gorm.Model{ID: 1}, UserID: 2}, nil)
+	revisions := []models.NoteRevision{
+		{NoteID: 1, Revision: 2, Title: "New title", Body: "New content", AuthorID: 2, CreatedAt: created_at},
+		{NoteID: 1, Revision: 1, Title: "Title", Body: "Content", AuthorID: 2, CreatedAt: created_at},
+	}
+	revision_reader.On("FindRevisionsByNoteId", ctx, uint(1)).Return(&revisions, nil)
+	revision_reader.On("FindRevision", ctx, uint(1), uint(1)).Return(&revisions[1], nil)
+	revision_reader.On("FindRevision", ctx, uint(1), uint(3)).Return(&models.NoteRevision{}, gorm.ErrRecordNotFound)
+
+	result, err := revision_service.GetRevisions(ctx, 1, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, []NoteRevisionListResult{{Revision: 2, Title: "New title", AuthorId: 2, CreatedAt: created_at},
+		{Revision: 1, Title: "Title", AuthorId: 2, CreatedAt: created_at}}, result.Result)
+
+	revision, err := revision_service.GetRevision(ctx, 1, 1, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, NoteRevision{Revision: 1, Title: "Title", Content: "Content", AuthorId: 2, CreatedAt: created_at}, revision)
+
+	_, err = revision_service.GetRevision(ctx, 1, 3, 2)
+	var errRevisionNotFound *ErrorRevisionNotFound
+	assert.True(t, errors.As(err, &errRevisionNotFound))
+
+	// other users cannot read the history
+	_, err = revision_service.GetRevisions(ctx, 1, 3)
+	var errWrongOwner *ErrorWrongOwner
+	assert.True(t, errors.As(err, &errWrongOwner))
+	revision_reader.AssertNumberOfCalls(t, "FindRevisionsByNoteId", 1)
+}
+
+func TestNoteRevisionServiceDiffRevisions(t *testing.T) {
+	note_reader := new(repositorymocks.NoteReaderMock)
+	revision_reader := new(repositorymocks.NoteRevisionReaderMock)
+	revision_creator := new(repositorymocks.NoteRevisionCreatorMock)
+
+	revision_service := NewNoteRevisionService(note_reader, revision_reader, revision_creator)
+
+	ctx := context.Background()
+	note_reader.On("FindNoteById", ctx, uint(1)).Return(&models.Note{Model: gorm.Model{ID: 1}, UserID: 2}, nil)
+	revision_reader.On("FindRevision", ctx, uint(1), uint(1)).
+		Return(&models.NoteRevision{NoteID: 1, Revision: 1, Title: "Groceries", Body: "milk\nbread"}, nil)
+	revision_reader.On("FindRevision", ctx, uint(1), uint(2)).
+		Return(&models.NoteRevision{NoteID: 1, Revision: 2, Title: "Groceries", Body: "milk\nbutter"}, nil)
+
+	diff, err := revision_service.DiffRevisions(ctx, 1, 1, 2, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, uint(1), diff.From)
+	assert.Equal(t, uint(2), diff.To)
+	assert.Equal(t, "--- revision 1\n+++ revision 2\n@@ -1,4 +1,4 @@\n Groceries\n \n milk\n-bread\n+butter\n", diff.Diff)
+}
+
+func TestNoteRevisionServiceRestoreRevision(t *testing.T) {
+	note_reader := new(repositorymocks.NoteReaderMock)
+	revision_reader := new(repositorymocks.NoteRevisionReaderMock)
+	revision_creator := new(repositorymocks.NoteRevisionCreatorMock)
+
+	revision_service := NewNoteRevisionService(note_reader, revision_reader, revision_creator)
+
+	ctx := context.Background()
+	tags := []models.Tag{{Model: gorm.Model{ID: 5}, Name: "work", UserID: 2}}
+	note_reader.On("FindNoteById", ctx, uint(1)).
+		Return(&models.Note{Model: gorm.Model{ID: 1}, UserID: 2, Title: "New title", Body: "New content", Tags: tags, Version: 4}, nil)
+	revision_reader.On("FindRevision", ctx, uint(1), uint(1)).
+		Return(&models.NoteRevision{NoteID: 1, Revision: 1, Title: "Title", Body: "Content", AuthorID: 2}, nil)
+
+	// the tags of the note are kept
+	revision_creator.On("UpdateNoteWithRevision", ctx,
+		&models.Note{Model: gorm.Model{ID: 1}, UserID: 2, Title: "Title", Body: "Content", Tags: tags, Version: 4}, uint(2)).
+		Return(uint(3), nil)
+
+	revision, err := revision_service.RestoreRevision(ctx, 1, 1, 2, 4)
+	assert.NoError(t, err)
+	assert.Equal(t, uint(3), revision)
+	revision_creator.AssertExpectations(t)
+
+	// the client has to know the current version
+	_, err = revision_service.RestoreRevision(ctx, 1, 1, 2, 3)
+	var errVersionMismatch *ErrorVersionMismatch
+	assert.True(t, errors.As(err, &errVersionMismatch))
+	assert.Equal(t, uint(4), errVersionMismatch.Version)
+
+	_, err = revision_service.RestoreRevision(ctx, 1, 1, 4, 4)
+	var errWrongOwner *ErrorWrongOwner
+	assert.True(t, errors.As(err, &errWrongOwner))
+	revision_creator.AssertNumberOfCalls(t, "UpdateNoteWithRevision", 1)
+}
+
+func TestTrashService(t *testing.T) {
+	trash_reader := new(repositorymocks.NoteTrashReaderMock)
+	note_restorer := new(repositorymocks.NoteRestorerMock)
+	note_purger := new(repositorymocks.NotePurgerMock)
+
+	trash_service := NewTrashService(trash_reader, note_restorer, note_purger)
+
+	ctx := context.Background()
+	deleted_at := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	note := models.Note{Model: gorm.Model{ID: 1, DeletedAt: gorm.DeletedAt{Time: deleted_at, Valid: true}}, Title: "Title", UserID: 2}
+	trash_reader.On("FindDeletedNotesByUserId", ctx, uint(2)).Return(&[]models.Note{note}, nil)
+	trash_reader.On("FindDeletedNoteById", ctx, uint(1)).Return(&note, nil)
+	trash_reader.On("FindDeletedNoteById", ctx, uint(3)).Return(&models.Note{}, gorm.ErrRecordNotFound)
+	note_restorer.On("RestoreNoteById", ctx, uint(1)).Return(nil)
+	note_purger.On("PurgeNoteById", ctx, uint(1)).Return(nil)
+
+	result, err := trash_service.GetTrash(ctx, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, []TrashResult{{Id: 1, Title: "Title", DeletedAt: deleted_at}}, result.Result)
+
+	err = trash_service.RestoreNote(ctx, 1, 2)
+	assert.NoError(t, err)
+
+	err = trash_service.PurgeNote(ctx, 1, 2)
+	assert.NoError(t, err)
+
+	err = trash_service.RestoreNote(ctx, 3, 2)
+	var errNoteNotFound *ErrorNoteNotFound
+	assert.True(t, errors.As(err, &errNoteNotFound))
+
+	// other users can neither restore nor purge the note
+	err = trash_service.RestoreNote(ctx, 1, 3)
+	var errWrongOwner *ErrorWrongOwner
+	assert.True(t, errors.As(err, &errWrongOwner))
+	err = trash_service.PurgeNote(ctx, 1, 3)
+	assert.True(t, errors.As(err, &errWrongOwner))
+
+	note_restorer.AssertNumberOfCalls(t, "RestoreNoteById", 1)
+	note_purger.AssertNumberOfCalls(t, "PurgeNoteById", 1)
+}
+
+func TestTrashPurger(t *testing.T) {
+	note_purger := new(repositorymocks.NotePurgerMock)
+	purger := NewTrashPurger(note_purger, 24*time.Hour)
+
+	ctx := context.Background()
+	now := time.Date(2025, 1, 2, 12, 0, 0, 0, time.UTC)
+	note_purger.On("PurgeNotesDeletedBefore", ctx, time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)).Return(2, nil)
+
+	count, err := purger.PurgeOnce(ctx, now)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, count)
+}
+
+func TestRevocationStore(t *testing.T) {
+	reader := new(repositorymocks.TokenRevocationReaderMock)
+	revoker := new(repositorymocks.TokenRevokerMock)
+	store := NewRevocationStore(reader, revoker)
+
+	ctx := context.Background()
+	now := time.Now()
+	claims := JwtClaims{UserId: 2, RegisteredClaims: jwt.RegisteredClaims{ID: "jti",
+		IssuedAt: jwt.NewNumericDate(now.Add(-time.Minute)), ExpiresAt: jwt.NewNumericDate(now.Add(time.Hour))}}
+
+	reader.On("IsTokenRevoked", ctx, "jti").Return(false, nil).Once()
+	reader.On("FindTokenGeneration", ctx, uint(2)).Return(uint(0), nil).Once()
+
+	// the answer is cached
+	for range 2 {
+		revoked, err := store.IsRevoked(ctx, &claims)
+		assert.NoError(t, err)
+		assert.False(t, revoked)
+	}
+	reader.AssertNumberOfCalls(t, "IsTokenRevoked", 1)
+
+	// a revocation takes effect right away
+	revoker.On("RevokeToken", ctx, &models.RevokedToken{JTI: "jti", UserID: 2, ExpiresAt: claims.ExpiresAt.Time}).Return(nil)
+	err := store.Revoke(ctx, 2, "jti", claims.ExpiresAt.Time)
+	assert.NoError(t, err)
+
+	revoked, err := store.IsRevoked(ctx, &claims)
+	assert.NoError(t, err)
+	assert.True(t, revoked)
+
+	// logging out from all devices revokes tokens of the old generation, but not the ones issued afterwards,
+	// even within the same second
+	other := JwtClaims{UserId: 2, RegisteredClaims: jwt.RegisteredClaims{ID: "other",
+		IssuedAt: jwt.NewNumericDate(now), ExpiresAt: jwt.NewNumericDate(now.Add(time.Hour))}}
+	later := JwtClaims{UserId: 2, Generation: 1, RegisteredClaims: jwt.RegisteredClaims{ID: "later",
+		IssuedAt: jwt.NewNumericDate(now), ExpiresAt: jwt.NewNumericDate(now.Add(time.Hour))}}
+	reader.On("IsTokenRevoked", ctx, mock.Anything).Return(false, nil)
+	reader.On("FindTokenGeneration", ctx, uint(2)).Return(uint(1), nil).Once()
+	revoker.On("RevokeAllTokens", ctx, uint(2), now).Return(nil)
+
+	err = store.RevokeAll(ctx, 2, now)
+	assert.NoError(t, err)
+
+	revoked, err = store.IsRevoked(ctx, &other)
+	assert.NoError(t, err)
+	assert.True(t, revoked)
+
+	revoked, err = store.IsRevoked(ctx, &later)
+	assert.NoError(t, err)
+	assert.False(t, revoked)
+	reader.AssertNumberOfCalls(t, "FindTokenGeneration", 2)
+
+	// expired entries are dropped from the cache
+	revoker.On("DeleteExpiredRevokedTokens", ctx, now.Add(2*time.Hour)).Return(1, nil)
+	count, err := store.PurgeExpired(ctx, now.Add(2*time.Hour))
+	assert.NoError(t, err)
+	assert.Equal(t, 1, count)
+	assert.Empty(t, store.tokens)
+	assert.Empty(t, store.users)
+}
+
+func TestLogoutService(t *testing.T) {
+	revocations := NewRevocationStore(new(repositorymocks.TokenRevocationReaderMock), new(repositorymocks.TokenRevokerMock))
+	revoker := revocations.Revoker.(*repositorymocks.TokenRevokerMock)
+	refresh_token_reader := new(repositorymocks.RefreshTokenReaderMock)
+	refresh_token_updater := new(repositorymocks.RefreshTokenUpdaterMock)
+	logout_service := NewLogoutService(revocations, refresh_token_reader, refresh_token_updater)
+
+	ctx := context.Background()
+	expires_at := time.Now().Add(time.Hour)
+	revoker.On("RevokeToken", ctx, mock.Anything).Return(nil)
+	refresh_token_reader.On("FindRefreshTokenByHash", ctx, hashRefreshToken("mine")).
+		Return(&models.RefreshToken{UserID: 2, FamilyID: "family"}, nil)
+	refresh_token_reader.On("FindRefreshTokenByHash", ctx, hashRefreshToken("theirs")).
+		Return(&models.RefreshToken{UserID: 3, FamilyID: "other"}, nil)
+	refresh_token_updater.On("RevokeRefreshTokenFamily", ctx, "family").Return(nil)
+
+	err := logout_service.Logout(ctx, 2, "jti", expires_at, "mine")
+	assert.NoError(t, err)
+
+	// refresh tokens of other users are ignored
+	err = logout_service.Logout(ctx, 2, "jti", expires_at, "theirs")
+	assert.NoError(t, err)
+	refresh_token_updater.AssertNumberOfCalls(t, "RevokeRefreshTokenFamily", 1)
+
+	err = logout_service.Logout(ctx, 2, "jti", expires_at, "")
+	assert.NoError(t, err)
+	revoker.AssertNumberOfCalls(t, "RevokeToken", 3)
+
+	revoker.On("RevokeAllTokens", ctx, uint(2), mock.Anything).Return(errors.New("db down"))
+	err = logout_service.LogoutAll(ctx, 2)
+	assert.Error(t, err)
+}
+
+func TestAccountServiceChangePassword(t *testing.T) {
+	password_manager := new(authmocks.MockPasswordManager)
+	revocations := NewRevocationStore(new(repositorymocks.TokenRevocationReaderMock), new(repositorymocks.TokenRevokerMock))
+	revoker := revocations.Revoker.(*repositorymocks.TokenRevokerMock)
+	refresh_token_creator := new(repositorymocks.RefreshTokenCreatorMock)
+	generation_reader := new(repositorymocks.TokenRevocationReaderMock)
+	generation_reader.On("FindTokenGeneration", mock.Anything, mock.Anything).Return(uint(0), nil)
+	token_service := NewTokenService(nil, refresh_token_creator, nil, generation_reader, NewJwtKeys(NewHmacJwtKey("", []byte("jwt_secret"))), time.Hour, 24*time.Hour)
+	throttle := NewLoginThrottle(NewMemoryLoginAttempts(),
+		LoginBackoff{MaxFailures: 2, BaseDelay: time.Minute, MaxDelay: time.Hour}, LoginBackoff{}, time.Hour)
+	user_repo := new(repositorymocks.UserRepoMock)
+	user_repo.On("FindUserById", mock.Anything, uint(2)).Return(&models.User{Username: "Alice"}, nil)
+	reauthentication := NewReauthentication(user_repo, password_manager, 10*time.Minute)
+	account_service := NewAccountService(password_manager, reauthentication, revocations, token_service, nil, throttle)
+
+	ctx := context.Background()
+	wrong := auth.Credentials{Username: "Alice", Password: "wrong"}
+	right := auth.Credentials{Username: "Alice", Password: "old"}
+	password_manager.On("ChangePassword", ctx, uint(2), &wrong, "new").Return(false, nil)
+	password_manager.On("ChangePassword", ctx, uint(2), &right, "new").Return(true, nil)
+	revoker.On("RevokeAllTokens", ctx, uint(2), mock.Anything).Return(nil)
+	refresh_token_creator.On("CreateRefreshToken", ctx, mock.Anything).Return(nil)
+
+	// A wrong current password changes nothing
+	_, err := account_service.ChangePassword(ctx, 2, "Alice", time.Now(), PasswordChange{OldPassword: "wrong", NewPassword: "new"})
+	var errWrongPassword *ErrorWrongPassword
+	assert.True(t, errors.As(err, &errWrongPassword))
+	revoker.AssertNotCalled(t, "RevokeAllTokens", ctx, uint(2), mock.Anything)
+
+	// Otherwise the old tokens are revoked and new ones issued
+	tokens, err := account_service.ChangePassword(ctx, 2, "Alice", time.Now(), PasswordChange{OldPassword: "old", NewPassword: "new"})
+	assert.NoError(t, err)
+	assert.NotEmpty(t, tokens.Token)
+	assert.NotEmpty(t, tokens.RefreshToken)
+	revoker.AssertNumberOfCalls(t, "RevokeAllTokens", 1)
+
+	// Wrong passwords are throttled like failed logins
+	for range 2 {
+		_, err = account_service.ChangePassword(ctx, 2, "Alice", time.Now(), PasswordChange{OldPassword: "wrong", NewPassword: "new"})
+		assert.True(t, errors.As(err, &errWrongPassword))
+	}
+	_, err = account_service.ChangePassword(ctx, 2, "Alice", time.Now(), PasswordChange{OldPassword: "old", NewPassword: "new"})
+	var errThrottled *ErrorLoginThrottled
+	assert.True(t, errors.As(err, &errThrottled))
+	password_manager.AssertNumberOfCalls(t, "ChangePassword", 4)
+	revoker.AssertNumberOfCalls(t, "RevokeAllTokens", 1)
+}
+
+func TestAccountServiceDeleteAccount(t *testing.T) {
+	password_manager := new(authmocks.MockPasswordManager)
+	reader := new(repositorymocks.TokenRevocationReaderMock)
+	revocations := NewRevocationStore(reader, new(repositorymocks.TokenRevokerMock))
+	user_deleter := new(repositorymocks.UserRepoMock)
+	throttle := NewLoginThrottle(NewMemoryLoginAttempts(),
+		LoginBackoff{MaxFailures: 2, BaseDelay: time.Minute, MaxDelay: time.Hour}, LoginBackoff{}, time.Hour)
+	user_deleter.On("FindUserById", mock.Anything, uint(2)).Return(&models.User{Username: "Alice"}, nil)
+	reauthentication := NewReauthentication(user_deleter, password_manager, 10*time.Minute)
+	account_service := NewAccountService(password_manager, reauthentication, revocations, nil, user_deleter, throttle)
+
+	ctx := context.Background()
+	wrong := auth.Credentials{Username: "Alice", Password: "wrong"}
+	right := auth.Credentials{Username: "Alice", Password: "pwd"}
+	password_manager.On("VerifyPassword", ctx, uint(2), &wrong).Return(false, nil)
+	password_manager.On("VerifyPassword", ctx, uint(2), &right).Return(true, nil)
+	user_deleter.On("DeleteUserById", ctx, uint(2)).Return(repositories.UserDeletion{Notes: 3, Notebooks: 1, Tags: 2}, nil)
+
+	// The password has to be confirmed
+	_, err := account_service.DeleteAccount(ctx, 2, "Alice", time.Now(), AccountDeletion{Password: "wrong"})
+	var errWrongPassword *ErrorWrongPassword
+	assert.True(t, errors.As(err, &errWrongPassword))
+	user_deleter.AssertNotCalled(t, "DeleteUserById", ctx, uint(2))
+
+	// and is throttled like a login
+	_, err = account_service.DeleteAccount(ctx, 2, "Alice", time.Now(), AccountDeletion{Password: "wrong"})
+	assert.True(t, errors.As(err, &errWrongPassword))
+	_, err = account_service.DeleteAccount(ctx, 2, "Alice", time.Now(), AccountDeletion{Password: "pwd"})
+	var errThrottled *ErrorLoginThrottled
+	assert.True(t, errors.As(err, &errThrottled))
+	user_deleter.AssertNotCalled(t, "DeleteUserById", ctx, uint(2))
+	assert.NoError(t, throttle.Success(ctx, "Alice", ""))
+
+	// A cached revocation state of the user is dropped
+	now := time.Now()
+	claims := JwtClaims{UserId: 2, RegisteredClaims: jwt.RegisteredClaims{ID: "jti",
+		IssuedAt: jwt.NewNumericDate(now.Add(-time.Minute)), ExpiresAt: jwt.NewNumericDate(now.Add(time.Hour))}}
+	reader.On("IsTokenRevoked", ctx, "jti").Return(false, nil)
+	reader.On("FindTokenGeneration", ctx, uint(2)).Return(uint(0), nil).Once()
+	revoked, err := revocations.IsRevoked(ctx, &claims)
+	assert.NoError(t, err)
+	assert.False(t, revoked)
+
+	result, err := account_service.DeleteAccount(ctx, 2, "Alice", time.Now(), AccountDeletion{Password: "pwd"})
+	assert.NoError(t, err)
+	assert.Equal(t, DeleteAccountResult{Notes: 3, Notebooks: 1, Tags: 2}, result)
+
+	reader.On("FindTokenGeneration", ctx, uint(2)).Return(uint(1), nil).Once()
+	revoked, err = revocations.IsRevoked(ctx, &claims)
+	assert.NoError(t, err)
+	assert.True(t, revoked)
+}
+
+func TestLoginBackoff(t *testing.T) {
+	backoff := LoginBackoff{MaxFailures: 3, BaseDelay: time.Second, MaxDelay: time.Minute}
+
+	assert.Equal(t, time.Duration(0), backoff.Delay(2))
+	assert.Equal(t, time.Second, backoff.Delay(3))
+	assert.Equal(t, 2*time.Second, backoff.Delay(4))
+	assert.Equal(t, 32*time.Second, backoff.Delay(8))
+	assert.Equal(t, time.Minute, backoff.Delay(9))
+	assert.Equal(t, time.Minute, backoff.Delay(1000))
+
+	// no limit
+	assert.Equal(t, time.Duration(0), LoginBackoff{}.Delay(1000))
+}
+
+func TestLoginThrottle(t *testing.T) {
+	ctx := context.Background()
+	attempts := NewMemoryLoginAttempts()
+	throttle := NewLoginThrottle(attempts,
+		LoginBackoff{MaxFailures: 3, BaseDelay: time.Second, MaxDelay: time.Minute},
+		LoginBackoff{MaxFailures: 5, BaseDelay: time.Second, MaxDelay: time.Minute}, time.Hour)
+	now := time.Now()
+
+	// the username is tracked regardless of case, every reservation counts as failed login
+	for i, username := range []string{"Alice", "alice", "ALICE"} {
+		_, err := throttle.Reserve(ctx, username, "10.0.0."+strconv.Itoa(i+1), now)
+		assert.NoError(t, err)
+	}
+
+	var errThrottled *ErrorLoginThrottled
+	_, err := throttle.Reserve(ctx, "Alice", "10.0.0.3", now)
+	assert.True(t, errors.As(err, &errThrottled))
+	assert.Equal(t, time.Second, errThrottled.RetryAfter)
+
+	// the next failure doubles the delay
+	_, err = throttle.Reserve(ctx, "Alice", "10.0.0.3", now.Add(time.Second))
+	assert.NoError(t, err)
+	_, err = throttle.Reserve(ctx, "Alice", "10.0.0.3", now.Add(time.Second))
+	assert.True(t, errors.As(err, &errThrottled))
+	assert.Equal(t, 2*time.Second, errThrottled.RetryAfter)
+
+	// other usernames are locked out from a client IP with too many failures
+	for i := range 5 {
+		_, err = throttle.Reserve(ctx, "user"+strconv.Itoa(i), "10.0.0.4", now)
+		assert.NoError(t, err)
+	}
+	_, err = throttle.Reserve(ctx, "Bob", "10.0.0.4", now)
+	assert.True(t, errors.As(err, &errThrottled))
+	// the refused login did not count for Bob
+	assert.Equal(t, 0, attempts.attempts[usernameAttemptKey("Bob")].failures)
+	_, err = throttle.Reserve(ctx, "Bob", "10.0.0.5", now)
+	assert.NoError(t, err)
+
+	// failures before the window are forgotten
+	for range 2 {
+		_, err = throttle.Reserve(ctx, "Carol", "", now)
+		assert.NoError(t, err)
+	}
+	reservation, err := throttle.Reserve(ctx, "Carol", "", now.Add(2*time.Hour))
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]int{usernameAttemptKey("Carol"): 1}, reservation.failures)
+
+	// a refund gives back the reservation together with its lock
+	for range 2 {
+		reservation, err = throttle.Reserve(ctx, "Dave", "", now)
+		assert.NoError(t, err)
+	}
+	assert.NoError(t, throttle.Refund(ctx, reservation))
+	reservation, err = throttle.Reserve(ctx, "Dave", "", now)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]int{usernameAttemptKey("Dave"): 2}, reservation.failures)
+
+	// success resets the counters
+	assert.NoError(t, throttle.Success(ctx, "Alice", "10.0.0.3"))
+	_, err = throttle.Reserve(ctx, "Alice", "10.0.0.3", now.Add(time.Second))
+	assert.NoError(t, err)
+
+	// all but the recent failure of Carol are stale
+	count, err := throttle.PurgeStale(ctx, now.Add(2*time.Hour))
+	assert.NoError(t, err)
+	assert.Equal(t, 13, count)
+}
+
+func TestLoginThrottleConcurrent(t *testing.T) {
+	ctx := context.Background()
+	throttle := NewLoginThrottle(NewMemoryLoginAttempts(),
+		LoginBackoff{MaxFailures: 5, BaseDelay: time.Minute, MaxDelay: time.Hour}, LoginBackoff{}, time.Hour)
+	now := time.Now()
+
+	// parallel logins cannot get past the limit
+	var wg sync.WaitGroup
+	var reserved atomic.Int32
+	for range 50 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := throttle.Reserve(ctx, "Alice", "10.0.0.1", now)
+			if err == nil {
+				reserved.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int32(5), reserved.Load())
+}
+
+func TestLoginServiceThrottle(t *testing.T) {
+	ctx := context.Background()
+	login_manager := new(authmocks.MockLoginManager)
+	refresh_token_creator := new(repositorymocks.RefreshTokenCreatorMock)
+	jwt_keys := NewJwtKeys(NewHmacJwtKey("", []byte("jwt_secret")))
+	generation_reader := new(repositorymocks.TokenRevocationReaderMock)
+	generation_reader.On("FindTokenGeneration", mock.Anything, mock.Anything).Return(uint(0), nil)
+	token_service := NewTokenService(nil, refresh_token_creator, nil, generation_reader, jwt_keys, time.Hour, 24*time.Hour)
+	throttle := NewLoginThrottle(NewMemoryLoginAttempts(),
+		LoginBackoff{MaxFailures: 2, BaseDelay: time.Minute, MaxDelay: time.Hour}, LoginBackoff{}, time.Hour)
+	user_repo := new(repositorymocks.UserRepoMock)
+	user_repo.On("FindUserById", ctx, uint(1)).Return(&models.User{Username: "Alice"}, nil)
+	login_service := NewLoginService(login_manager, token_service, throttle, NewMfaTokenService(jwt_keys, 5*time.Minute),
+		NewMfaService(user_repo, nil, nil, nil, nil, nil, "User-Notes-API"))
+
+	wrong := auth.Credentials{Username: "Alice", Password: "wrong"}
+	right := auth.Credentials{Username: "Alice", Password: "right"}
+	unknown := auth.Credentials{Username: "Unknown", Password: "pwd"}
+	login_manager.On("LoginUser", ctx, &wrong).Return(1, false, nil)
+	login_manager.On("LoginUser", ctx, &right).Return(1, true, nil)
+	login_manager.On("LoginUser", ctx, &unknown).Return(0, false, &auth.ErrorNotFound{Username: "Unknown"})
+	refresh_token_creator.On("CreateRefreshToken", ctx, mock.Anything).Return(nil)
+
+	// a success resets the failures
+	_, err := login_service.Login(ctx, wrong, "127.0.0.1")
+	var errWrongPassword *ErrorWrongPassword
+	assert.True(t, errors.As(err, &errWrongPassword))
+	_, err = login_service.Login(ctx, right, "127.0.0.1")
+	assert.NoError(t, err)
+	_, err = login_service.Login(ctx, wrong, "127.0.0.1")
+	assert.True(t, errors.As(err, &errWrongPassword))
+
+	// the second failure in a row locks the username, even the right password is refused
+	_, err = login_service.Login(ctx, wrong, "127.0.0.1")
+	assert.True(t, errors.As(err, &errWrongPassword))
+	_, err = login_service.Login(ctx, right, "127.0.0.1")
+	var errThrottled *ErrorLoginThrottled
+	assert.True(t, errors.As(err, &errThrottled))
+	login_manager.AssertNumberOfCalls(t, "LoginUser", 4)
+
+	// unknown users are tracked as well
+	for range 2 {
+		_, err = login_service.Login(ctx, unknown, "127.0.0.1")
+		var errNotFound *auth.ErrorNotFound
+		assert.True(t, errors.As(err, &errNotFound))
+	}
+	_, err = login_service.Login(ctx, unknown, "127.0.0.1")
+	assert.True(t, errors.As(err, &errThrottled))
+
+	// other errors give the attempt back
+	broken := auth.Credentials{Username: "Bob", Password: "pwd"}
+	login_manager.On("LoginUser", ctx, &broken).Return(0, false, errors.New("db down"))
+	for range 3 {
+		_, err = login_service.Login(ctx, broken, "127.0.0.1")
+		assert.EqualError(t, err, "db down")
+	}
+}
+
+func TestJwtKeys(t *testing.T) {
+	rsa_key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	_, ed_key, err := ed25519.GenerateKey(rand.Reader)
+	assert.NoError(t, err)
+	claims := JwtClaims{UserId: 1, RegisteredClaims: jwt.RegisteredClaims{Subject: "Alice"}}
+
+	rsa_pem := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(rsa_key)})
+	pkcs8, err := x509.MarshalPKCS8PrivateKey(ed_key)
+	assert.NoError(t, err)
+	ed_pem := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: pkcs8})
+
+	for _, test := range []struct {
+		pem []byte
+		alg string
+		kty string
+	}{
+		{pem: rsa_pem, alg: "RS256", kty: "RSA"},
+		{pem: ed_pem, alg: "EdDSA", kty: "OKP"},
+	} {
+		key, err := ParseJwtKeyPEM("", test.pem)
+		assert.NoError(t, err)
+		assert.Equal(t, test.alg, key.Method.Alg())
+		assert.NotEmpty(t, key.Kid)
+		keys := NewJwtKeys(key)
+
+		// the kid is in the header and selects the key for verification
+		token_string, err := keys.Sign(&claims)
+		assert.NoError(t, err)
+		token, err := jwt.ParseWithClaims(token_string, &JwtClaims{}, keys.Keyfunc, jwt.WithValidMethods(keys.Methods()))
+		assert.NoError(t, err, test.alg)
+		assert.Equal(t, key.Kid, token.Header["kid"])
+		assert.Equal(t, "Alice", token.Claims.(*JwtClaims).Subject)
+
+		// only the public key is published
+		jwks := keys.Jwks()
+		assert.Len(t, jwks.Keys, 1)
+		assert.Equal(t, test.kty, jwks.Keys[0].Kty)
+		assert.Equal(t, test.alg, jwks.Keys[0].Alg)
+		assert.Equal(t, key.Kid, jwks.Keys[0].Kid)
+
+		// and the published key verifies the tokens
+		published, err := ParseJwk(jwks.Keys[0])
+		assert.NoError(t, err)
+		assert.Equal(t, key.Kid, published.Kid)
+		assert.False(t, published.CanSign())
+		_, err = jwt.ParseWithClaims(token_string, &JwtClaims{}, NewJwtKeys(key, published).Keyfunc, jwt.WithValidMethods(keys.Methods()))
+		assert.NoError(t, err)
+
+		// unknown kids are rejected
+		other := jwt.NewWithClaims(key.Method, &claims)
+		other.Header["kid"] = "other"
+		other_string, err := other.SignedString(key.signingKey)
+		assert.NoError(t, err)
+		_, err = jwt.ParseWithClaims(other_string, &JwtClaims{}, keys.Keyfunc, jwt.WithValidMethods(keys.Methods()))
+		var errUnknownKey *ErrorUnknownJwtKey
+		assert.True(t, errors.As(err, &errUnknownKey))
+	}
+
+	// the thumbprint of the example key of RFC 7638
+	modulus, err := base64.RawURLEncoding.DecodeString("0vx7agoebGcQSuuPiLJXZptN9nndrQmbXEps2aiAFbWhM78LhWx4cbbfAAtVT86zwu1RK7aPFFxuhDR1L6tSoc_BJECPebWKRXjBZCiFV4n3oknjhMstn64tZ_2W-5JsGY4Hc5n9yBXArwl93lqt7_RN5w6Cf0h4QyQ5v-65YGjQR0_FDW2QvzqY368QQMicAtaSqzs8KJZgnYb9c7d0zgdAZHzu6qMQvRL5hajrn1n91CbOpbISD08qNLyrdkt-bFTWhAI4vMQFh6WeZu0fM4lFd2NcRwr3XPksINHaQ-G_xBniIqbw0Ls1jF44-csFCur-kEgU8awapJzKnqDKgw")
+	assert.NoError(t, err)
+	example := JwtKey{Method: jwt.SigningMethodRS256, verificationKey: &rsa.PublicKey{N: new(big.Int).SetBytes(modulus), E: 65537}}
+	assert.Equal(t, "NzbLsXh8uDCcd-6MNwXF4W_7noWXFZAfHkxZsRGC9Xs", example.thumbprint())
+
+	// HMAC secrets are not published
+	assert.Empty(t, NewJwtKeys(NewHmacJwtKey("hmac", []byte("jwt_secret"))).Jwks().Keys)
+
+	// a public key cannot be used as HMAC secret
+	rsa_jwt_key, err := NewRsaJwtKey("rsa", rsa_key)
+	assert.NoError(t, err)
+	keys := NewJwtKeys(rsa_jwt_key)
+	forged := jwt.NewWithClaims(jwt.SigningMethodHS256, &claims)
+	forged.Header["kid"] = "rsa"
+	forged_string, err := forged.SignedString(x509.MarshalPKCS1PublicKey(&rsa_key.PublicKey))
+	assert.NoError(t, err)
+	_, err = jwt.ParseWithClaims(forged_string, &JwtClaims{}, keys.Keyfunc)
+	var errUnknownKey *ErrorUnknownJwtKey
+	assert.True(t, errors.As(err, &errUnknownKey))
+
+	// short RSA keys are rejected
+	short_key, err := rsa.GenerateKey(rand.Reader, 1024)
+	assert.NoError(t, err)
+	_, err = NewRsaJwtKey("", short_key)
+	assert.Error(t, err)
+
+	_, err = ParseJwtKeyPEM("", []byte("not a key"))
+	assert.Error(t, err)
+
+	// only the key types that can sign access tokens are supported
+	_, err = ParseJwk(Jwk{Kty: "EC", Crv: "P-256", Alg: "ES256", Kid: "ec", X: "eA"})
+	assert.Error(t, err)
+	_, err = ParseJwk(Jwk{Kty: "RSA", Alg: "RS512", Kid: "rsa", N: encodeBigInt(rsa_key.N), E: "AQAB"})
+	assert.Error(t, err)
+	_, err = ParseJwk(Jwk{Kty: "OKP", Crv: "Ed25519", Kid: "short", X: "AAAA"})
+	assert.Error(t, err)
+}
+
+func TestJwtKeyring(t *testing.T) {
+	keyring := NewJwtKeyring(t.TempDir())
+	claims := JwtClaims{UserId: 1, RegisteredClaims: jwt.RegisteredClaims{Subject: "Alice"}}
+	parse := func(keys *JwtKeys, token_string string) error {
+		_, err := jwt.ParseWithClaims(token_string, &JwtClaims{}, keys.Keyfunc, jwt.WithValidMethods(keys.Methods()))
+		return err
+	}
+
+	// a keyring needs an active key
+	first, err := keyring.Generate(JwtAlgorithmEdDSA, "")
+	assert.NoError(t, err)
+	_, err = keyring.Load()
+	assert.Error(t, err)
+
+	assert.NoError(t, keyring.Promote(first.Kid))
+	keys, err := keyring.Load()
+	assert.NoError(t, err)
+	assert.Equal(t, first.Kid, keys.SigningKey().Kid)
+	old_token, err := keys.Sign(&claims)
+	assert.NoError(t, err)
+
+	// after the rotation, tokens of the retired key are still accepted
+	second, err := keyring.Generate(JwtAlgorithmHS256, "second")
+	assert.NoError(t, err)
+	assert.NoError(t, keyring.Promote(second.Kid))
+	keys, err = keyring.Load()
+	assert.NoError(t, err)
+	assert.Equal(t, "second", keys.SigningKey().Kid)
+	assert.Len(t, keys.Keys(), 2)
+	assert.NoError(t, parse(keys, old_token))
+	new_token, err := keys.Sign(&claims)
+	assert.NoError(t, err)
+	assert.NoError(t, parse(keys, new_token))
+
+	listed, active, err := keyring.Keys()
+	assert.NoError(t, err)
+	assert.Equal(t, "second", active)
+	assert.Len(t, listed, 2)
+
+	// tokens without a kid are verified with the legacy key
+	legacy, err := jwt.NewWithClaims(jwt.SigningMethodHS256, &claims).SignedString([]byte("jwt_secret"))
+	assert.NoError(t, err)
+	assert.Error(t, parse(keys, legacy))
+	keys, err = keyring.Load(NewHmacJwtKey("", []byte("jwt_secret")))
+	assert.NoError(t, err)
+	assert.NoError(t, parse(keys, legacy))
+	assert.NoError(t, parse(keys, old_token))
+	_, err = keyring.Load(NewHmacJwtKey("second", []byte("jwt_secret")))
+	assert.Error(t, err)
+
+	// public keys only verify tokens
+	public_der, err := x509.MarshalPKIXPublicKey(first.verificationKey)
+	assert.NoError(t, err)
+	public_pem := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: public_der})
+	assert.NoError(t, os.WriteFile(filepath.Join(keyring.Dir, "public.pem"), public_pem, 0o600))
+	public_key, err := ParseJwtKeyPEM("public", public_pem)
+	assert.NoError(t, err)
+	assert.False(t, public_key.CanSign())
+	assert.Error(t, keyring.Promote("public"))
+
+	// invalid and existing kids are rejected
+	_, err = keyring.Generate(JwtAlgorithmEdDSA, "../escape")
+	var errInvalidKid *ErrorInvalidKid
+	assert.True(t, errors.As(err, &errInvalidKid))
+	_, err = keyring.Generate(JwtAlgorithmRS256, "second")
+	assert.Error(t, err)
+	_, err = keyring.Generate("none", "")
+	assert.Error(t, err)
+	assert.Error(t, keyring.Promote("unknown"))
+}
+
+func TestPersonalAccessTokenService(t *testing.T) {
+	ctx := context.Background()
+	token_repo := new(repositorymocks.PersonalAccessTokenRepoMock)
+	token_service := NewPersonalAccessTokenService(token_repo, token_repo, token_repo)
+
+	// the token is returned once, only its hash is stored
+	var stored *models.PersonalAccessToken
+	token_repo.On("CreatePersonalAccessToken", ctx, mock.Anything).Run(func(args mock.Arguments) {
+		stored = args.Get(1).(*models.PersonalAccessToken)
+		stored.ID = 7
+	}).Return(nil).Once()
+	expires_at := time.Now().Add(24 * time.Hour)
+	created, err := token_service.CreateToken(ctx, 1, PersonalAccessTokenCreation{Name: " backup ",
+		Scopes: []string{ScopeNotesWrite, ScopeNotesRead, ScopeNotesWrite}, ExpiresAt: &expires_at})
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(created.Token, PersonalAccessTokenPrefix))
+	assert.Equal(t, uint(7), created.Id)
+	assert.Equal(t, "backup", created.Name)
+	assert.Equal(t, []string{ScopeNotesRead, ScopeNotesWrite}, created.Scopes)
+	assert.Equal(t, hashPersonalAccessToken(created.Token), stored.TokenHash)
+	assert.Equal(t, "notes:read notes:write", stored.Scopes)
+	assert.Equal(t, uint(1), stored.UserID)
+
+	past := time.Now().Add(-time.Minute)
+	for _, creation := range []PersonalAccessTokenCreation{
+		{Name: " ", Scopes: []string{ScopeNotesRead}},
+		{Name: strings.Repeat("a", MaxPersonalAccessTokenNameLength+1), Scopes: []string{ScopeNotesRead}},
+		{Name: "backup", Scopes: []string{}},
+		{Name: "backup", Scopes: []string{"notes:admin"}},
+		{Name: "backup", Scopes: []string{ScopeNotesRead}, ExpiresAt: &past},
+	} {
+		_, err = token_service.CreateToken(ctx, 1, creation)
+		var errInvalid *ErrorInvalidTokenCreation
+		assert.True(t, errors.As(err, &errInvalid), creation)
+	}
+	token_repo.AssertNumberOfCalls(t, "CreatePersonalAccessToken", 1)
+
+	token_repo.On("FindPersonalAccessTokens", ctx, uint(1)).Return([]models.PersonalAccessToken{*stored}, nil)
+	tokens, err := token_service.GetTokens(ctx, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, []PersonalAccessTokenResult{created.PersonalAccessTokenResult}, tokens.Result)
+
+	token_repo.On("DeletePersonalAccessToken", ctx, uint(1), uint(7)).Return(nil)
+	token_repo.On("DeletePersonalAccessToken", ctx, uint(2), uint(7)).Return(gorm.ErrRecordNotFound)
+	assert.NoError(t, token_service.DeleteToken(ctx, 1, 7))
+	err = token_service.DeleteToken(ctx, 2, 7)
+	var errNotFound *ErrorPersonalAccessTokenNotFound
+	assert.True(t, errors.As(err, &errNotFound))
+}
+
+func TestPersonalAccessTokenAuthenticate(t *testing.T) {
+	ctx := context.Background()
+	token_repo := new(repositorymocks.PersonalAccessTokenRepoMock)
+	token_service := NewPersonalAccessTokenService(token_repo, token_repo, token_repo)
+
+	now := time.Now()
+	recently := now.Add(-time.Second)
+	past := now.Add(-time.Hour)
+	user := models.User{Model: gorm.Model{ID: 1}, Username: "Alice"}
+	revoked_user := models.User{Model: gorm.Model{ID: 2}, Username: "Bob", TokensRevokedAt: &now}
+
+	for hash, token := range map[string]*models.PersonalAccessToken{
+		hashPersonalAccessToken("unp_valid"):   {ID: 1, Scopes: "notes:read", UserID: 1, User: user, CreatedAt: past},
+		hashPersonalAccessToken("unp_used"):    {ID: 2, Scopes: "notes:read", UserID: 1, User: user, CreatedAt: past, LastUsedAt: &recently},
+		hashPersonalAccessToken("unp_expired"): {ID: 3, Scopes: "notes:read", UserID: 1, User: user, CreatedAt: past, ExpiresAt: &past},
+		hashPersonalAccessToken("unp_revoked"): {ID: 4, Scopes: "notes:read", UserID: 2, User: revoked_user, CreatedAt: past},
+		hashPersonalAccessToken("unp_deleted"): {ID: 5, Scopes: "notes:read", UserID: 3, CreatedAt: past},
+	} {
+		token_repo.On("FindPersonalAccessTokenByHash", ctx, hash).Return(token, nil)
+	}
+	token_repo.On("FindPersonalAccessTokenByHash", ctx, mock.Anything).Return(&models.PersonalAccessToken{}, gorm.ErrRecordNotFound)
+	token_repo.On("TouchPersonalAccessToken", ctx, uint(1), mock.Anything).Return(errors.New("database is locked"))
+
+	// a failure to record the use does not fail the request
+	token_auth, err := token_service.Authenticate(ctx, "unp_valid")
+	assert.NoError(t, err)
+	assert.Equal(t, PersonalAccessTokenAuth{UserId: 1, Username: "Alice", Scopes: []string{ScopeNotesRead}}, token_auth)
+
+	// recent uses are not recorded again
+	_, err = token_service.Authenticate(ctx, "unp_used")
+	assert.NoError(t, err)
+	token_repo.AssertNumberOfCalls(t, "TouchPersonalAccessToken", 1)
+
+	for _, token := range []string{"unp_expired", "unp_revoked", "unp_deleted", "unp_unknown"} {
+		_, err = token_service.Authenticate(ctx, token)
+		var errInvalid *ErrorInvalidPersonalAccessToken
+		assert.True(t, errors.As(err, &errInvalid), token)
+	}
+}
+
+func TestAccountServicePasswordless(t *testing.T) {
+	password_manager := new(authmocks.MockPasswordManager)
+	user_repo := new(repositorymocks.UserRepoMock)
+	revocations := NewRevocationStore(new(repositorymocks.TokenRevocationReaderMock), new(repositorymocks.TokenRevokerMock))
+	revoker := revocations.Revoker.(*repositorymocks.TokenRevokerMock)
+	refresh_token_creator := new(repositorymocks.RefreshTokenCreatorMock)
+	generation_reader := new(repositorymocks.TokenRevocationReaderMock)
+	generation_reader.On("FindTokenGeneration", mock.Anything, mock.Anything).Return(uint(0), nil)
+	token_service := NewTokenService(nil, refresh_token_creator, nil, generation_reader, NewJwtKeys(NewHmacJwtKey("", []byte("jwt_secret"))), time.Hour, 24*time.Hour)
+	throttle := NewLoginThrottle(NewMemoryLoginAttempts(),
+		LoginBackoff{MaxFailures: 2, BaseDelay: time.Minute, MaxDelay: time.Hour}, LoginBackoff{}, time.Hour)
+	reauthentication := NewReauthentication(user_repo, password_manager, 10*time.Minute)
+	account_service := NewAccountService(password_manager, reauthentication, revocations, token_service, user_repo, throttle)
+	mfa_repo := new(repositorymocks.MfaRepoMock)
+	mfa_service := NewMfaService(user_repo, mfa_repo, mfa_repo, reauthentication, throttle,
+		utils.NewSecretBox(map[uint32][]byte{1: []byte("totp_key")}), "User-Notes-API")
+
+	ctx := context.Background()
+	user_repo.On("FindUserById", ctx, uint(3)).Return(&models.User{Username: "carol", Passwordless: true}, nil)
+	password_manager.On("SetPassword", ctx, uint(3), "carol", "Correct-Horse-7").Return(nil)
+	revoker.On("RevokeAllTokens", ctx, uint(3), mock.Anything).Return(nil)
+	refresh_token_creator.On("CreateRefreshToken", ctx, mock.Anything).Return(nil)
+	user_repo.On("DeleteUserById", ctx, uint(3)).Return(repositories.UserDeletion{}, nil)
+	mfa_repo.On("StartTotpEnrollment", ctx, uint(3), mock.Anything).Return(nil)
+
+	// users without a password confirm changes with a recent login instead, an old or unknown one is not enough
+	var errReauthentication *ErrorReauthenticationRequired
+	for _, auth_time := range []time.Time{{}, time.Now().Add(-time.Hour)} {
+		_, err := account_service.ChangePassword(ctx, 3, "carol", auth_time, PasswordChange{NewPassword: "Correct-Horse-7"})
+		assert.True(t, errors.As(err, &errReauthentication))
+		_, err = account_service.DeleteAccount(ctx, 3, "carol", auth_time, AccountDeletion{})
+		assert.True(t, errors.As(err, &errReauthentication))
+		_, err = mfa_service.StartTotpEnrollment(ctx, 3, "carol", auth_time, TotpEnrollmentStart{})
+		assert.True(t, errors.As(err, &errReauthentication))
+	}
+	password_manager.AssertNotCalled(t, "SetPassword", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+	user_repo.AssertNotCalled(t, "DeleteUserById", mock.Anything, mock.Anything)
+	mfa_repo.AssertNotCalled(t, "StartTotpEnrollment", mock.Anything, mock.Anything, mock.Anything)
+
+	// logins that are too old do not count as failed logins, there is no password to guess
+	_, err := mfa_service.StartTotpEnrollment(ctx, 3, "carol", time.Now().Add(-time.Minute), TotpEnrollmentStart{})
+	assert.NoError(t, err)
+
+	// with a recent login, they set a password without an old one
+	tokens, err := account_service.ChangePassword(ctx, 3, "carol", time.Now().Add(-time.Minute), PasswordChange{NewPassword: "Correct-Horse-7"})
+	assert.NoError(t, err)
+	assert.NotEmpty(t, tokens.Token)
+	password_manager.AssertCalled(t, "SetPassword", ctx, uint(3), "carol", "Correct-Horse-7")
+	password_manager.AssertNotCalled(t, "ChangePassword", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+
+	_, err = account_service.DeleteAccount(ctx, 3, "carol", time.Now().Add(-time.Minute), AccountDeletion{})
+	assert.NoError(t, err)
+	user_repo.AssertCalled(t, "DeleteUserById", ctx, uint(3))
+}
+
+func TestMfaService(t *testing.T) {
+	ctx := context.Background()
+	user_repo := new(repositorymocks.UserRepoMock)
+	mfa_repo := new(repositorymocks.MfaRepoMock)
+	password_manager := new(authmocks.MockPasswordManager)
+	secret_box := utils.NewSecretBox(map[uint32][]byte{1: []byte("totp_key")})
+	throttle := NewLoginThrottle(NewMemoryLoginAttempts(),
+		LoginBackoff{MaxFailures: 5, BaseDelay: time.Minute, MaxDelay: time.Hour}, LoginBackoff{}, time.Hour)
+	mfa_service := NewMfaService(user_repo, mfa_repo, mfa_repo, NewReauthentication(user_repo, password_manager, 10*time.Minute), throttle,
+		secret_box, "User-Notes-API")
+
+	for _, username := range []string{"Alice", "Bob", "Carol"} {
+		password_manager.On("VerifyPassword", ctx, mock.Anything, &auth.Credentials{Username: username, Password: "right"}).Return(true, nil)
+		password_manager.On("VerifyPassword", ctx, mock.Anything, &auth.Credentials{Username: username, Password: "wrong"}).Return(false, nil)
+	}
+	start := TotpEnrollmentStart{Password: "right"}
+	// until the enrollments are started, the users only have a password
+	user_repo.On("FindUserById", ctx, uint(1)).Return(&models.User{Username: "Alice"}, nil).Twice()
+	user_repo.On("FindUserById", ctx, uint(2)).Return(&models.User{Username: "Bob"}, nil).Once()
+
+	// without encryption keys, two-factor authentication cannot be enabled
+	_, err := NewMfaService(user_repo, mfa_repo, mfa_repo, nil, throttle, nil, "User-Notes-API").StartTotpEnrollment(ctx, 1, "Alice", time.Now(), start)
+	assert.ErrorIs(t, err, ErrMfaNotConfigured)
+
+	// a session alone cannot start an enrollment, it needs the password
+	_, err = mfa_service.StartTotpEnrollment(ctx, 1, "Alice", time.Now(), TotpEnrollmentStart{Password: "wrong"})
+	var errWrongPassword *ErrorWrongPassword
+	assert.True(t, errors.As(err, &errWrongPassword))
+	mfa_repo.AssertNotCalled(t, "StartTotpEnrollment", mock.Anything, mock.Anything, mock.Anything)
+
+	// the secret is stored encrypted for the user
+	var sealed string
+	mfa_repo.On("StartTotpEnrollment", ctx, uint(1), mock.Anything).Return(nil).Run(func(args mock.Arguments) {
+		sealed = args.String(2)
+	})
+	enrollment, err := mfa_service.StartTotpEnrollment(ctx, 1, "Alice", time.Now(), start)
+	assert.NoError(t, err)
+	assert.Len(t, enrollment.Secret, 32)
+	assert.True(t, strings.HasPrefix(enrollment.OtpauthUri, "otpauth://totp/User-Notes-API:Alice?"))
+	assert.Contains(t, enrollment.OtpauthUri, "secret="+enrollment.Secret)
+	assert.NotContains(t, sealed, enrollment.Secret)
+	opened, err := secret_box.Open(sealed, totpSecretAdditionalData(1))
+	assert.NoError(t, err)
+	assert.Equal(t, enrollment.Secret, string(opened))
+
+	mfa_repo.On("StartTotpEnrollment", ctx, uint(2), mock.Anything).Return(repositories.ErrTotpEnabled)
+	_, err = mfa_service.StartTotpEnrollment(ctx, 2, "Bob", time.Now(), start)
+	var errState *ErrorMfaState
+	assert.True(t, errors.As(err, &errState))
+
+	now := time.Now()
+	enabled_at := now.Add(-time.Hour)
+	user_repo.On("FindUserById", ctx, uint(1)).Return(&models.User{Username: "Alice", TotpSecret: sealed}, nil)
+	user_repo.On("FindUserById", ctx, uint(2)).Return(&models.User{Username: "Bob", TotpSecret: sealed, TotpEnabledAt: &enabled_at}, nil)
+	user_repo.On("FindUserById", ctx, uint(3)).Return(&models.User{Username: "Carol"}, nil)
+
+	// a wrong code does not confirm the enrollment
+	old_code, err := utils.TotpCode(enrollment.Secret, utils.TotpCounter(now)-5)
+	assert.NoError(t, err)
+	_, err = mfa_service.ConfirmTotpEnrollment(ctx, 1, "Alice", time.Now(), MfaConfirmation{Password: "right", Code: old_code})
+	var errWrongCode *ErrorWrongMfaCode
+	assert.True(t, errors.As(err, &errWrongCode))
+
+	// neither does the right code without the password
+	code, err := utils.TotpCode(enrollment.Secret, utils.TotpCounter(now))
+	assert.NoError(t, err)
+	_, err = mfa_service.ConfirmTotpEnrollment(ctx, 1, "Alice", time.Now(), MfaConfirmation{Password: "wrong", Code: code})
+	assert.True(t, errors.As(err, &errWrongPassword))
+	mfa_repo.AssertNotCalled(t, "EnableTotp", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+
+	// the first code enables it and returns the recovery codes
+	var hashes []string
+	mfa_repo.On("EnableTotp", ctx, uint(1), mock.Anything, mock.Anything).Return(nil).Run(func(args mock.Arguments) {
+		hashes = args.Get(3).([]string)
+	})
+	recovery_codes, err := mfa_service.ConfirmTotpEnrollment(ctx, 1, "Alice", time.Now(), MfaConfirmation{Password: "right", Code: code[:3] + " " + code[3:]})
+	assert.NoError(t, err)
+	assert.Len(t, recovery_codes.RecoveryCodes, 10)
+	assert.Len(t, hashes, 10)
+	for i, recovery_code := range recovery_codes.RecoveryCodes {
+		assert.Regexp(t, `^[a-z2-7]{4}-[a-z2-7]{4}-[a-z2-7]{4}-[a-z2-7]{4}$`, recovery_code)
+		assert.Equal(t, hashRecoveryCode(recovery_code), hashes[i])
+	}
+
+	// only started enrollments of users without two-factor authentication can be confirmed
+	_, err = mfa_service.ConfirmTotpEnrollment(ctx, 2, "Bob", time.Now(), MfaConfirmation{Password: "right", Code: code})
+	assert.True(t, errors.As(err, &errState))
+	_, err = mfa_service.ConfirmTotpEnrollment(ctx, 3, "Carol", time.Now(), MfaConfirmation{Password: "right", Code: code})
+	assert.True(t, errors.As(err, &errState))
+
+	enabled, err := mfa_service.MfaEnabled(ctx, 1)
+	assert.NoError(t, err)
+	assert.False(t, enabled)
+	enabled, err = mfa_service.MfaEnabled(ctx, 2)
+	assert.NoError(t, err)
+	assert.True(t, enabled)
+
+	mfa_repo.On("CountRecoveryCodes", ctx, uint(2)).Return(7, nil)
+	status, err := mfa_service.GetStatus(ctx, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, MfaStatus{TotpEnabled: true, RecoveryCodesLeft: 7}, status)
+
+	// codes of users without two-factor authentication are not checked
+	err = mfa_service.VerifyCode(ctx, 3, code)
+	assert.True(t, errors.As(err, &errState))
+
+	// recovery codes ignore case, spaces and dashes and are used once
+	mfa_repo.On("UseRecoveryCode", ctx, uint(2), hashRecoveryCode("abcdefghijklmnop")).Return(nil).Once()
+	mfa_repo.On("UseRecoveryCode", ctx, uint(2), mock.Anything).Return(repositories.ErrRecoveryCodeUsed)
+	assert.NoError(t, mfa_service.VerifyCode(ctx, 2, "ABCD-efgh ijkl-mnop"))
+	err = mfa_service.VerifyCode(ctx, 2, "abcd-efgh-ijkl-mnop")
+	assert.True(t, errors.As(err, &errWrongCode))
+}
+
+func TestMfaServiceVerifyTotp(t *testing.T) {
+	ctx := context.Background()
+	user_repo := new(repositorymocks.UserRepoMock)
+	mfa_repo := new(repositorymocks.MfaRepoMock)
+	password_manager := new(authmocks.MockPasswordManager)
+	secret_box := utils.NewSecretBox(map[uint32][]byte{1: []byte("totp_key")})
+	throttle := NewLoginThrottle(NewMemoryLoginAttempts(),
+		LoginBackoff{MaxFailures: 3, BaseDelay: time.Minute, MaxDelay: time.Hour}, LoginBackoff{}, time.Hour)
+	mfa_service := NewMfaService(user_repo, mfa_repo, mfa_repo, NewReauthentication(user_repo, password_manager, 10*time.Minute), throttle,
+		secret_box, "User-Notes-API")
+
+	secret, err := utils.GenerateTotpSecret()
+	assert.NoError(t, err)
+	sealed, err := secret_box.Seal([]byte(secret), totpSecretAdditionalData(2))
+	assert.NoError(t, err)
+	enabled_at := time.Now().Add(-time.Hour)
+	user_repo.On("FindUserById", ctx, uint(2)).Return(&models.User{Username: "Bob", TotpSecret: sealed, TotpEnabledAt: &enabled_at}, nil)
+
+	counter := utils.TotpCounter(time.Now())
+	code, err := utils.TotpCode(secret, counter)
+	assert.NoError(t, err)
+	old_code, err := utils.TotpCode(secret, counter-5)
+	assert.NoError(t, err)
+
+	// a code is accepted once, replays are rejected by the repository
+	mfa_repo.On("UseTotpCounter", ctx, uint(2), counter).Return(nil).Once()
+	mfa_repo.On("UseTotpCounter", ctx, uint(2), counter).Return(repositories.ErrTotpCodeUsed)
+	assert.NoError(t, mfa_service.VerifyCode(ctx, 2, code))
+	err = mfa_service.VerifyCode(ctx, 2, code)
+	var errWrongCode *ErrorWrongMfaCode
+	assert.True(t, errors.As(err, &errWrongCode))
+	assert.ErrorIs(t, err, repositories.ErrTotpCodeUsed)
+	err = mfa_service.VerifyCode(ctx, 2, old_code)
+	assert.True(t, errors.As(err, &errWrongCode))
+
+	// disabling and new recovery codes require the password and a code
+	wrong := auth.Credentials{Username: "Bob", Password: "wrong"}
+	right := auth.Credentials{Username: "Bob", Password: "right"}
+	password_manager.On("VerifyPassword", ctx, uint(2), &wrong).Return(false, nil)
+	password_manager.On("VerifyPassword", ctx, uint(2), &right).Return(true, nil)
+	mfa_repo.On("UseRecoveryCode", ctx, uint(2), hashRecoveryCode("abcd-efgh-ijkl-mnop")).Return(nil)
+	mfa_repo.On("UseRecoveryCode", ctx, uint(2), mock.Anything).Return(repositories.ErrRecoveryCodeUsed)
+
+	err = mfa_service.DisableTotp(ctx, 2, "Bob", time.Now(), MfaConfirmation{Password: "wrong", Code: "abcd-efgh-ijkl-mnop"})
+	var errWrongPassword *ErrorWrongPassword
+	assert.True(t, errors.As(err, &errWrongPassword))
+	err = mfa_service.DisableTotp(ctx, 2, "Bob", time.Now(), MfaConfirmation{Password: "right", Code: "zzzz-zzzz-zzzz-zzzz"})
+	assert.True(t, errors.As(err, &errWrongCode))
+	_, err = mfa_service.RegenerateRecoveryCodes(ctx, 2, "Bob", time.Now(), MfaConfirmation{Password: "right", Code: old_code})
+	assert.True(t, errors.As(err, &errWrongCode))
+	mfa_repo.AssertNotCalled(t, "DisableTotp", mock.Anything, mock.Anything)
+	mfa_repo.AssertNotCalled(t, "ReplaceRecoveryCodes", mock.Anything, mock.Anything, mock.Anything)
+
+	// wrong passwords and codes count as failed logins of the user, so they cannot be guessed without limit
+	err = mfa_service.DisableTotp(ctx, 2, "Bob", time.Now(), MfaConfirmation{Password: "right", Code: "abcd-efgh-ijkl-mnop"})
+	var errThrottled *ErrorLoginThrottled
+	assert.True(t, errors.As(err, &errThrottled))
+	password_manager.AssertNumberOfCalls(t, "VerifyPassword", 3)
+	assert.NoError(t, throttle.Success(ctx, "Bob", ""))
+
+	mfa_repo.On("ReplaceRecoveryCodes", ctx, uint(2), mock.Anything).Return(nil)
+	recovery_codes, err := mfa_service.RegenerateRecoveryCodes(ctx, 2, "Bob", time.Now(), MfaConfirmation{Password: "right", Code: "abcd-efgh-ijkl-mnop"})
+	assert.NoError(t, err)
+	assert.Len(t, recovery_codes.RecoveryCodes, 10)
+
+	mfa_repo.On("DisableTotp", ctx, uint(2)).Return(nil)
+	assert.NoError(t, mfa_service.DisableTotp(ctx, 2, "Bob", time.Now(), MfaConfirmation{Password: "right", Code: "abcd-efgh-ijkl-mnop"}))
+	mfa_repo.AssertCalled(t, "DisableTotp", ctx, uint(2))
+}
+
+func TestLoginServiceMfa(t *testing.T) {
+	ctx := context.Background()
+	login_manager := new(authmocks.MockLoginManager)
+	refresh_token_creator := new(repositorymocks.RefreshTokenCreatorMock)
+	jwt_keys := NewJwtKeys(NewHmacJwtKey("", []byte("jwt_secret")))
+	generation_reader := new(repositorymocks.TokenRevocationReaderMock)
+	generation_reader.On("FindTokenGeneration", mock.Anything, mock.Anything).Return(uint(0), nil)
+	token_service := NewTokenService(nil, refresh_token_creator, nil, generation_reader, jwt_keys, time.Hour, 24*time.Hour)
+	throttle := NewLoginThrottle(NewMemoryLoginAttempts(),
+		LoginBackoff{MaxFailures: 2, BaseDelay: time.Minute, MaxDelay: time.Hour}, LoginBackoff{}, time.Hour)
+	user_repo := new(repositorymocks.UserRepoMock)
+	mfa_repo := new(repositorymocks.MfaRepoMock)
+	mfa_token_service := NewMfaTokenService(jwt_keys, 5*time.Minute)
+	login_service := NewLoginService(login_manager, token_service, throttle, mfa_token_service,
+		NewMfaService(user_repo, mfa_repo, mfa_repo, nil, nil, nil, "User-Notes-API"))
+
+	right := auth.Credentials{Username: "Alice", Password: "right"}
+	login_manager.On("LoginUser", ctx, &right).Return(1, true, nil)
+	enabled_at := time.Now().Add(-time.Hour)
+	user_repo.On("FindUserById", ctx, uint(1)).Return(&models.User{Username: "Alice", TotpEnabledAt: &enabled_at}, nil)
+	mfa_repo.On("UseRecoveryCode", ctx, uint(1), hashRecoveryCode("abcd-efgh-ijkl-mnop")).Return(nil)
+	mfa_repo.On("UseRecoveryCode", ctx, uint(1), mock.Anything).Return(repositories.ErrRecoveryCodeUsed)
+	refresh_token_creator.On("CreateRefreshToken", ctx, mock.Anything).Return(nil)
+
+	// the right password only returns the challenge
+	result, err := login_service.Login(ctx, right, "127.0.0.1")
+	assert.NoError(t, err)
+	assert.Empty(t, result.Token)
+	assert.Empty(t, result.RefreshToken)
+	assert.True(t, result.Mfa.MfaRequired)
+	assert.WithinDuration(t, time.Now().Add(5*time.Minute), result.Mfa.ExpiresAt, time.Second)
+	refresh_token_creator.AssertNotCalled(t, "CreateRefreshToken", mock.Anything, mock.Anything)
+
+	// the mfa token is no access token: it has no subject and no user id
+	claims := JwtClaims{}
+	_, err = jwt.ParseWithClaims(result.Mfa.MfaToken, &claims, jwt_keys.Keyfunc)
+	assert.NoError(t, err)
+	assert.Empty(t, claims.Subject)
+	assert.Equal(t, uint(0), claims.UserId)
+
+	// and access tokens are no mfa tokens
+	tokens, err := token_service.IssueTokens(ctx, 1, "Alice")
+	assert.NoError(t, err)
+	_, err = login_service.VerifyMfa(ctx, MfaVerification{MfaToken: tokens.Token, Code: "abcd-efgh-ijkl-mnop"}, "127.0.0.1")
+	var errInvalidToken *ErrorInvalidMfaToken
+	assert.True(t, errors.As(err, &errInvalidToken))
+
+	// expired mfa tokens are rejected
+	expired, err := NewMfaTokenService(jwt_keys, -time.Minute).IssueMfaToken(1, "Alice")
+	assert.NoError(t, err)
+	_, err = login_service.VerifyMfa(ctx, MfaVerification{MfaToken: expired.MfaToken, Code: "abcd-efgh-ijkl-mnop"}, "127.0.0.1")
+	assert.True(t, errors.As(err, &errInvalidToken))
+
+	// a wrong code counts as failed login, the right one issues the tokens
+	_, err = login_service.VerifyMfa(ctx, MfaVerification{MfaToken: result.Mfa.MfaToken, Code: "zzzz-zzzz-zzzz-zzzz"}, "127.0.0.1")
+	var errWrongCode *ErrorWrongMfaCode
+	assert.True(t, errors.As(err, &errWrongCode))
+	tokens, err = login_service.VerifyMfa(ctx, MfaVerification{MfaToken: result.Mfa.MfaToken, Code: "abcd-efgh-ijkl-mnop"}, "127.0.0.1")
+	assert.NoError(t, err)
+	assert.NotEmpty(t, tokens.Token)
+	assert.NotEmpty(t, tokens.RefreshToken)
+
+	// logging in again with the password does not reset the failures of wrong codes
+	for range 2 {
+		result, err = login_service.Login(ctx, right, "127.0.0.1")
+		assert.NoError(t, err)
+		_, err = login_service.VerifyMfa(ctx, MfaVerification{MfaToken: result.Mfa.MfaToken, Code: "zzzz-zzzz-zzzz-zzzz"}, "127.0.0.1")
+		assert.True(t, errors.As(err, &errWrongCode))
+	}
+	_, err = login_service.Login(ctx, right, "127.0.0.1")
+	var errThrottled *ErrorLoginThrottled
+	assert.True(t, errors.As(err, &errThrottled))
+	_, err = login_service.VerifyMfa(ctx, MfaVerification{MfaToken: result.Mfa.MfaToken, Code: "abcd-efgh-ijkl-mnop"}, "127.0.0.1")
+	assert.True(t, errors.As(err, &errThrottled))
+}
+
+// followOidcRedirect opens the authorization URL like a browser and returns the callback the provider
+// redirects back with.
+func followOidcRedirect(t *testing.T, authorization_url string) OidcCallback {
+	client := http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	response, err := client.Get(authorization_url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response.Body.Close()
+	assert.Equal(t, http.StatusFound, response.StatusCode)
+
+	location, err := url.Parse(response.Header.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := location.Query()
+	return OidcCallback{Code: query.Get("code"), State: query.Get("state"), Error: query.Get("error"),
+		ErrorDescription: query.Get("error_description")}
+}
+
+func newTestOidcService(fake_provider *oidcmocks.FakeProvider, identity_repo *repositorymocks.OidcIdentityRepoMock,
+	user_repo *repositorymocks.UserRepoMock, jwt_keys *JwtKeys) *OidcService {
+	refresh_token_creator := new(repositorymocks.RefreshTokenCreatorMock)
+	refresh_token_creator.On("CreateRefreshToken", mock.Anything, mock.Anything).Return(nil)
+	generation_reader := new(repositorymocks.TokenRevocationReaderMock)
+	generation_reader.On("FindTokenGeneration", mock.Anything, mock.Anything).Return(uint(0), nil)
+	token_service := NewTokenService(nil, refresh_token_creator, nil, generation_reader, jwt_keys, time.Hour, 24*time.Hour)
+
+	provider := NewOidcProvider("corp", fake_provider.Issuer(), fake_provider.ClientId, fake_provider.ClientSecret,
+		"http://localhost:8080/auth/oidc/corp/callback", []string{"openid", "profile", "email"})
+	return NewOidcService([]*OidcProvider{provider}, identity_repo, identity_repo, &testutils.MockPwdHasher{}, token_service,
+		NewMfaTokenService(jwt_keys, 5*time.Minute), NewMfaService(user_repo, nil, nil, nil, nil, nil, "User-Notes-API"), jwt_keys)
+}
+
+func TestOidcService(t *testing.T) {
+	ctx := context.Background()
+	fake_provider := oidcmocks.NewFakeProvider("notes", "client_secret")
+	defer fake_provider.Close()
+	fake_provider.User = oidcmocks.FakeUser{Subject: "alice-sub", PreferredUsername: "Alice", Email: "alice@example.com"}
+
+	jwt_keys := NewJwtKeys(NewHmacJwtKey("", []byte("jwt_secret")))
+	identity_repo := new(repositorymocks.OidcIdentityRepoMock)
+	user_repo := new(repositorymocks.UserRepoMock)
+	oidc_service := newTestOidcService(fake_provider, identity_repo, user_repo, jwt_keys)
+
+	_, err := oidc_service.StartLogin(ctx, "other")
+	var errUnknown *ErrorUnknownOidcProvider
+	assert.True(t, errors.As(err, &errUnknown))
+
+	// the authorization URL carries the state, the nonce and the challenge of the code verifier in the state token
+	login, err := oidc_service.StartLogin(ctx, "corp")
+	assert.NoError(t, err)
+	assert.WithinDuration(t, time.Now().Add(10*time.Minute), login.ExpiresAt, time.Second)
+	authorization_url, err := url.Parse(login.AuthorizationUrl)
+	assert.NoError(t, err)
+	assert.Equal(t, fake_provider.Issuer()+"/authorize", authorization_url.Scheme+"://"+authorization_url.Host+authorization_url.Path)
+	query := authorization_url.Query()
+	assert.Equal(t, "code", query.Get("response_type"))
+	assert.Equal(t, "notes", query.Get("client_id"))
+	assert.Equal(t, "http://localhost:8080/auth/oidc/corp/callback", query.Get("redirect_uri"))
+	assert.Equal(t, "openid profile email", query.Get("scope"))
+	assert.Equal(t, "S256", query.Get("code_challenge_method"))
+
+	state, err := oidc_service.parseStateToken(login.StateToken)
+	assert.NoError(t, err)
+	assert.Equal(t, "corp", state.Provider)
+	assert.Equal(t, query.Get("state"), state.State)
+	assert.Equal(t, query.Get("nonce"), state.Nonce)
+	assert.Len(t, state.CodeVerifier, 43)
+	assert.Equal(t, oidcCodeChallenge(state.CodeVerifier), query.Get("code_challenge"))
+	assert.NotContains(t, login.AuthorizationUrl, state.CodeVerifier)
+
+	// the first login creates the user with the username suggested by the provider
+	identity_repo.On("FindUserByOidcIdentity", ctx, fake_provider.Issuer(), "alice-sub").Return(nil, repositories.ErrOidcIdentityNotFound).Once()
+	identity_repo.On("CreateUserWithOidcIdentity", ctx, mock.Anything, fake_provider.Issuer(), "alice-sub").Return(nil)
+	user_repo.On("FindUserById", ctx, uint(1)).Return(&models.User{Username: "Alice"}, nil)
+
+	callback := followOidcRedirect(t, login.AuthorizationUrl)
+	result, err := oidc_service.FinishLogin(ctx, "corp", callback, login.StateToken)
+	assert.NoError(t, err)
+	assert.Nil(t, result.Mfa)
+	assert.NotEmpty(t, result.RefreshToken)
+	created := identity_repo.Calls[1].Arguments.Get(1).(*models.User)
+	assert.Equal(t, "Alice", created.Username)
+	// the user is passwordless and cannot log in with a password
+	assert.True(t, created.Passwordless)
+	assert.True(t, strings.HasPrefix(created.Password, "$mock$"))
+
+	// the same access token as a login with a password is issued
+	claims := JwtClaims{}
+	_, err = jwt.ParseWithClaims(result.Token, &claims, jwt_keys.Keyfunc)
+	assert.NoError(t, err)
+	assert.Equal(t, uint(1), claims.UserId)
+	assert.Equal(t, "Alice", claims.Subject)
+	assert.Equal(t, "auth.user-notes-api.local", claims.Issuer)
+	assert.WithinDuration(t, time.Now(), claims.AuthTime.Time, time.Second)
+
+	// codes can only be redeemed once
+	_, err = oidc_service.FinishLogin(ctx, "corp", callback, login.StateToken)
+	var errLogin *ErrorOidcLogin
+	assert.True(t, errors.As(err, &errLogin))
+
+	// later logins find the user by issuer and subject, even after the username changed at the provider
+	fake_provider.User.PreferredUsername = "Alice.Smith"
+	alice := models.User{Username: "Alice"}
+	alice.ID = 1
+	identity_repo.On("FindUserByOidcIdentity", ctx, fake_provider.Issuer(), "alice-sub").Return(&alice, nil)
+	login, err = oidc_service.StartLogin(ctx, "corp")
+	assert.NoError(t, err)
+	result, err = oidc_service.FinishLogin(ctx, "corp", followOidcRedirect(t, login.AuthorizationUrl), login.StateToken)
+	assert.NoError(t, err)
+	assert.NotEmpty(t, result.Token)
+	identity_repo.AssertNumberOfCalls(t, "CreateUserWithOidcIdentity", 1)
+
+	// the callback has to belong to the login of the state token
+	login, err = oidc_service.StartLogin(ctx, "corp")
+	assert.NoError(t, err)
+	other_login, err := oidc_service.StartLogin(ctx, "corp")
+	assert.NoError(t, err)
+	callback = followOidcRedirect(t, login.AuthorizationUrl)
+	for _, state_token := range []string{"", "invalid", other_login.StateToken} {
+		_, err = oidc_service.FinishLogin(ctx, "corp", callback, state_token)
+		assert.True(t, errors.As(err, &errLogin), state_token)
+	}
+	_, err = oidc_service.FinishLogin(ctx, "corp", OidcCallback{Code: callback.Code}, login.StateToken)
+	assert.True(t, errors.As(err, &errLogin))
+
+	// expired logins are rejected
+	expired_state, err := jwt_keys.Sign(OidcStateClaims{Provider: "corp", State: callback.State, RegisteredClaims: jwt.RegisteredClaims{
+		Issuer: "auth.user-notes-api.local", Audience: jwt.ClaimStrings{oidcStateAudience},
+		ExpiresAt: jwt.NewNumericDate(time.Now().Add(-time.Minute))}})
+	assert.NoError(t, err)
+	_, err = oidc_service.FinishLogin(ctx, "corp", callback, expired_state)
+	assert.True(t, errors.As(err, &errLogin))
+
+	// access tokens are no state tokens
+	tokens, err := oidc_service.TokenIssuer.IssueTokens(ctx, 1, "Alice")
+	assert.NoError(t, err)
+	_, err = oidc_service.FinishLogin(ctx, "corp", callback, tokens.Token)
+	assert.True(t, errors.As(err, &errLogin))
+
+	// the login was cancelled at the provider
+	_, err = oidc_service.FinishLogin(ctx, "corp", OidcCallback{State: callback.State, Error: "access_denied"}, login.StateToken)
+	assert.True(t, errors.As(err, &errLogin))
+	assert.Contains(t, err.Error(), "access_denied")
+
+	// the state token is not valid for another provider
+	_, err = oidc_service.FinishLogin(ctx, "other", callback, login.StateToken)
+	assert.True(t, errors.As(err, &errUnknown))
+}
+
+func TestOidcServiceIdToken(t *testing.T) {
+	ctx := context.Background()
+	fake_provider := oidcmocks.NewFakeProvider("notes", "")
+	defer fake_provider.Close()
+	fake_provider.User = oidcmocks.FakeUser{Subject: "alice-sub"}
+
+	jwt_keys := NewJwtKeys(NewHmacJwtKey("", []byte("jwt_secret")))
+	identity_repo := new(repositorymocks.OidcIdentityRepoMock)
+	user_repo := new(repositorymocks.UserRepoMock)
+	oidc_service := newTestOidcService(fake_provider, identity_repo, user_repo, jwt_keys)
+
+	alice := models.User{Username: "Alice"}
+	alice.ID = 1
+	identity_repo.On("FindUserByOidcIdentity", ctx, fake_provider.Issuer(), "alice-sub").Return(&alice, nil)
+	user_repo.On("FindUserById", ctx, uint(1)).Return(&alice, nil)
+
+	// a public client logs in with PKCE alone
+	login, err := oidc_service.StartLogin(ctx, "corp")
+	assert.NoError(t, err)
+	_, err = oidc_service.FinishLogin(ctx, "corp", followOidcRedirect(t, login.AuthorizationUrl), login.StateToken)
+	assert.NoError(t, err)
+
+	for name, modify := range map[string]func(claims jwt.MapClaims){
+		"nonce of another login": func(claims jwt.MapClaims) { claims["nonce"] = "other" },
+		"other audience":         func(claims jwt.MapClaims) { claims["aud"] = "other-client" },
+		"other authorized party": func(claims jwt.MapClaims) {
+			claims["aud"] = []string{"notes", "other-client"}
+			claims["azp"] = "other-client"
+		},
+		"several audiences":    func(claims jwt.MapClaims) { claims["aud"] = []string{"notes", "other-client"} },
+		"other issuer":         func(claims jwt.MapClaims) { claims["iss"] = "https://other.example.com" },
+		"expired":              func(claims jwt.MapClaims) { claims["exp"] = time.Now().Add(-2 * time.Minute).Unix() },
+		"without expiry":       func(claims jwt.MapClaims) { delete(claims, "exp") },
+		"issued in the future": func(claims jwt.MapClaims) { claims["iat"] = time.Now().Add(time.Hour).Unix() },
+		"without subject":      func(claims jwt.MapClaims) { delete(claims, "sub") },
+	} {
+		fake_provider.ModifyIdToken = modify
+		login, err := oidc_service.StartLogin(ctx, "corp")
+		assert.NoError(t, err)
+		_, err = oidc_service.FinishLogin(ctx, "corp", followOidcRedirect(t, login.AuthorizationUrl), login.StateToken)
+		var errLogin *ErrorOidcLogin
+		assert.True(t, errors.As(err, &errLogin), name)
+	}
+
+	// several audiences are accepted if the client is the authorized party
+	fake_provider.ModifyIdToken = func(claims jwt.MapClaims) { claims["aud"] = []string{"notes", "other-client"}; claims["azp"] = "notes" }
+	login, err = oidc_service.StartLogin(ctx, "corp")
+	assert.NoError(t, err)
+	_, err = oidc_service.FinishLogin(ctx, "corp", followOidcRedirect(t, login.AuthorizationUrl), login.StateToken)
+	assert.NoError(t, err)
+	fake_provider.ModifyIdToken = nil
+
+	// tokens signed with another key or with the secret of the API are rejected
+	provider := oidc_service.Providers["corp"]
+	forged := oidcmocks.NewFakeProvider("notes", "")
+	defer forged.Close()
+	forged_token := forged.SignIdToken(jwt.MapClaims{"iss": fake_provider.Issuer(), "aud": "notes", "sub": "alice-sub",
+		"exp": time.Now().Add(time.Minute).Unix(), "nonce": "nonce"})
+	_, err = provider.VerifyIdToken(ctx, forged_token, "nonce")
+	var errLogin *ErrorOidcLogin
+	assert.True(t, errors.As(err, &errLogin))
+
+	hmac_token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"iss": fake_provider.Issuer(), "aud": "notes",
+		"sub": "alice-sub", "exp": time.Now().Add(time.Minute).Unix(), "nonce": "nonce"})
+	hmac_token.Header["kid"] = fake_provider.Kid
+	hmac_string, err := hmac_token.SignedString([]byte(""))
+	assert.NoError(t, err)
+	_, err = provider.VerifyIdToken(ctx, hmac_string, "nonce")
+	assert.True(t, errors.As(err, &errLogin))
+
+	valid_token := fake_provider.SignIdToken(jwt.MapClaims{"iss": fake_provider.Issuer(), "aud": "notes", "sub": "alice-sub",
+		"exp": time.Now().Add(time.Minute).Unix(), "nonce": "nonce"})
+	claims, err := provider.VerifyIdToken(ctx, valid_token, "nonce")
+	assert.NoError(t, err)
+	assert.Equal(t, "alice-sub", claims.Subject)
+}
+
+func TestOidcServiceNewUsers(t *testing.T) {
+	ctx := context.Background()
+	fake_provider := oidcmocks.NewFakeProvider("notes", "client_secret")
+	defer fake_provider.Close()
+
+	jwt_keys := NewJwtKeys(NewHmacJwtKey("", []byte("jwt_secret")))
+	identity_repo := new(repositorymocks.OidcIdentityRepoMock)
+	user_repo := new(repositorymocks.UserRepoMock)
+	oidc_service := newTestOidcService(fake_provider, identity_repo, user_repo, jwt_keys)
+
+	// the username falls back to the local part of the email and gets a random suffix if it is taken
+	fake_provider.User = oidcmocks.FakeUser{Subject: "bob-sub", PreferredUsername: "b", Email: "bob.smith@example.com"}
+	identity_repo.On("FindUserByOidcIdentity", ctx, fake_provider.Issuer(), "bob-sub").Return(nil, repositories.ErrOidcIdentityNotFound)
+	identity_repo.On("CreateUserWithOidcIdentity", ctx, mock.MatchedBy(func(user *models.User) bool {
+		return user.Username == "bob.smith"
+	}), fake_provider.Issuer(), "bob-sub").Return(&repositories.ErrorUsernameTaken{Username: "bob.smith"})
+	identity_repo.On("CreateUserWithOidcIdentity", ctx, mock.Anything, fake_provider.Issuer(), "bob-sub").Return(nil)
+	user_repo.On("FindUserById", ctx, uint(1)).Return(&models.User{Username: "bob.smith-abcd"}, nil)
+
+	login, err := oidc_service.StartLogin(ctx, "corp")
+	assert.NoError(t, err)
+	_, err = oidc_service.FinishLogin(ctx, "corp", followOidcRedirect(t, login.AuthorizationUrl), login.StateToken)
+	assert.NoError(t, err)
+	identity_repo.AssertNumberOfCalls(t, "CreateUserWithOidcIdentity", 2)
+	assert.Regexp(t, `^bob\.smith-[a-z2-7]{4}$`, identity_repo.Calls[2].Arguments.Get(1).(*models.User).Username)
+
+	// without a valid suggestion the username is "user"
+	assert.Equal(t, "user", oidcUsername(&OidcIdTokenClaims{PreferredUsername: "?", Email: "@example.com"}))
+	assert.Equal(t, "carol", oidcUsername(&OidcIdTokenClaims{PreferredUsername: "carol", Email: "c@example.com"}))
+	long := oidcUsernameWithSuffix(strings.Repeat("ü", 32))
+	assert.Equal(t, 32, utf8.RuneCountInString(long))
+	_, err = auth.ValidateUsername(long)
+	assert.NoError(t, err)
+
+	// a concurrent login created the user first
+	fake_provider.User = oidcmocks.FakeUser{Subject: "carol-sub", PreferredUsername: "carol"}
+	carol := models.User{Username: "carol"}
+	carol.ID = 1
+	identity_repo.On("FindUserByOidcIdentity", ctx, fake_provider.Issuer(), "carol-sub").Return(nil, repositories.ErrOidcIdentityNotFound).Once()
+	identity_repo.On("FindUserByOidcIdentity", ctx, fake_provider.Issuer(), "carol-sub").Return(&carol, nil)
+	identity_repo.On("CreateUserWithOidcIdentity", ctx, mock.Anything, fake_provider.Issuer(), "carol-sub").Return(repositories.ErrOidcIdentityLinked)
+	login, err = oidc_service.StartLogin(ctx, "corp")
+	assert.NoError(t, err)
+	result, err := oidc_service.FinishLogin(ctx, "corp", followOidcRedirect(t, login.AuthorizationUrl), login.StateToken)
+	assert.NoError(t, err)
+	assert.NotEmpty(t, result.Token)
+
+	// users with two-factor authentication get the challenge for the second factor
+	enabled_at := time.Now()
+	dave := models.User{Username: "dave", TotpEnabledAt: &enabled_at}
+	dave.ID = 2
+	fake_provider.User = oidcmocks.FakeUser{Subject: "dave-sub"}
+	identity_repo.On("FindUserByOidcIdentity", ctx, fake_provider.Issuer(), "dave-sub").Return(&dave, nil)
+	user_repo.On("FindUserById", ctx, uint(2)).Return(&dave, nil)
+	login, err = oidc_service.StartLogin(ctx, "corp")
+	assert.NoError(t, err)
+	result, err = oidc_service.FinishLogin(ctx, "corp", followOidcRedirect(t, login.AuthorizationUrl), login.StateToken)
+	assert.NoError(t, err)
+	assert.Empty(t, result.Token)
+	assert.True(t, result.Mfa.MfaRequired)
+	mfa_claims, err := NewMfaTokenService(jwt_keys, 5*time.Minute).ParseMfaToken(result.Mfa.MfaToken)
+	assert.NoError(t, err)
+	assert.Equal(t, uint(2), mfa_claims.UserId)
+}
+
+func TestOidcProviderUnavailable(t *testing.T) {
+	ctx := context.Background()
+	fake_provider := oidcmocks.NewFakeProvider("notes", "client_secret")
+	issuer := fake_provider.Issuer()
+
+	// the issuer of the discovery document has to match exactly
+	provider := NewOidcProvider("corp", issuer+"/", "notes", "client_secret", "http://localhost/callback", []string{"openid"})
+	_, err := provider.AuthorizationUrl(ctx, "state", "nonce", "challenge")
+	var errProvider *ErrorOidcProvider
+	assert.True(t, errors.As(err, &errProvider))
+
+	// the endpoints are cached once they were discovered
+	provider = NewOidcProvider("corp", issuer, "notes", "client_secret", "http://localhost/callback", []string{"openid"})
+	_, err = provider.AuthorizationUrl(ctx, "state", "nonce", "challenge")
+	assert.NoError(t, err)
+
+	fake_provider.Close()
+	_, err = provider.AuthorizationUrl(ctx, "state", "nonce", "challenge")
+	assert.NoError(t, err)
+	_, err = provider.Exchange(ctx, "code", "verifier")
+	assert.True(t, errors.As(err, &errProvider))
+
+	_, err = NewOidcProvider("corp", issuer, "notes", "", "http://localhost/callback", nil).Metadata(ctx)
+	assert.True(t, errors.As(err, &errProvider))
+}
@@ -0,0 +1,201 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"user-notes-api/models"
+	"user-notes-api/repositories"
+)
+
+const MaxTagLength = 64
+
+type TagResult struct {
+	Id        uint   `json:"Id"`
+	Name      string `json:"Name"`
+	NoteCount int    `json:"NoteCount"`
+}
+
+type GetTagsResult struct {
+	Result []TagResult `json:"Result"`
+}
+
+type TagRename struct {
+	Name string `json:"Name"`
+}
+
+type TagMerge struct {
+	Target uint `json:"Target"`
+}
+
+type TagServiceIfc interface {
+	GetTags(ctx context.Context, userId uint) (GetTagsResult, error)
+	RenameTag(ctx context.Context, tagId uint, userId uint, name string) error
+	MergeTags(ctx context.Context, sourceId uint, targetId uint, userId uint) error
+	DeleteTag(ctx context.Context, tagId uint, userId uint) error
+}
+
+type TagService struct {
+	TagReader  repositories.TagReader
+	TagUpdater repositories.TagUpdater
+	TagDeleter repositories.TagDeleter
+}
+
+type ErrorTagNotFound struct {
+	TagId uint
+	Err   error
+}
+
+type ErrorTagWrongOwner struct {
+	TagId  uint
+	UserId uint
+}
+
+type ErrorTagExists struct {
+	Name string
+}
+
+type ErrorInvalidTag struct {
+	Name   string
+	Reason string
+}
+
+func (e *ErrorTagNotFound) Error() string {
+	return fmt.Sprintf("tag with id %d not found: %v", e.TagId, e.Err)
+}
+
+func (e *ErrorTagNotFound) Unwrap() error {
+	return e.Err
+}
+
+func (e *ErrorTagWrongOwner) Error() string {
+	return fmt.Sprintf("user with id %d does not own tag with id %d", e.UserId, e.TagId)
+}
+
+func (e *ErrorTagExists) Error() string {
+	return fmt.Sprintf("tag %q already exists, merge the tags instead", e.Name)
+}
+
+func (e *ErrorInvalidTag) Error() string {
+	return fmt.Sprintf("invalid tag %q: %s", e.Name, e.Reason)
+}
+
+func NewTagService(tag_reader repositories.TagReader, tag_updater repositories.TagUpdater, tag_deleter repositories.TagDeleter) *TagService {
+	tag_service := TagService{TagReader: tag_reader, TagUpdater: tag_updater, TagDeleter: tag_deleter}
+	return &tag_service
+}
+
+// normalizeTagName trims and lowercases a tag name, so that "Work" and "work " are the same tag.
+func normalizeTagName(name string) (string, error) {
+	normalized := strings.ToLower(strings.TrimSpace(name))
+	if normalized == "" {
+		return "", &ErrorInvalidTag{Name: name, Reason: "tag must not be empty"}
+	}
+	if utf8.RuneCountInString(normalized) > MaxTagLength {
+		return "", &ErrorInvalidTag{Name: name, Reason: fmt.Sprintf("tag must not be longer than %d characters", MaxTagLength)}
+	}
+	return normalized, nil
+}
+
+// normalizeTagNames normalizes all names and removes duplicates while keeping the order.
+func normalizeTagNames(names []string) ([]string, error) {
+	var normalized_names []string
+	seen := make(map[string]bool)
+	for _, name := range names {
+		normalized, err := normalizeTagName(name)
+		if err != nil {
+			return nil, err
+		}
+		if !seen[normalized] {
+			seen[normalized] = true
+			normalized_names = append(normalized_names, normalized)
+		}
+	}
+	return normalized_names, nil
+}
+
+func tagNames(tags []models.Tag) []string {
+	var names []string
+	for _, tag := range tags {
+		names = append(names, tag.Name)
+	}
+	return names
+}
+
+func (s *TagService) findOwnedTag(ctx context.Context, tagId uint, userId uint) (*models.Tag, error) {
+	tag, err := s.TagReader.FindTagById(ctx, tagId)
+	if err != nil {
+		return nil, &ErrorTagNotFound{TagId: tagId, Err: err}
+	}
+
+	if tag.UserID != userId {
+		return nil, &ErrorTagWrongOwner{TagId: tagId, UserId: userId}
+	}
+
+	return tag, nil
+}
+
+func (s *TagService) GetTags(ctx context.Context, userId uint) (GetTagsResult, error) {
+	var tag_array GetTagsResult
+	tags, err := s.TagReader.FindTagsWithNoteCount(ctx, userId)
+	if err != nil {
+		return tag_array, fmt.Errorf("get tags: %w", err)
+	}
+
+	for _, tag := range *tags {
+		tag_array.Result = append(tag_array.Result, TagResult{Id: tag.ID, Name: tag.Name, NoteCount: tag.NoteCount})
+	}
+	return tag_array, nil
+}
+
+func (s *TagService) RenameTag(ctx context.Context, tagId uint, userId uint, name string) error {
+	tag, err := s.findOwnedTag(ctx, tagId, userId)
+	if err != nil {
+		return err
+	}
+
+	normalized, err := normalizeTagName(name)
+	if err != nil {
+		return err
+	}
+
+	if normalized == tag.Name {
+		return nil
+	}
+
+	_, err = s.TagReader.FindTagByName(ctx, userId, normalized)
+	if err == nil {
+		return &ErrorTagExists{Name: normalized}
+	}
+
+	return s.TagUpdater.RenameTag(ctx, tagId, normalized)
+}
+
+func (s *TagService) MergeTags(ctx context.Context, sourceId uint, targetId uint, userId uint) error {
+	source, err := s.findOwnedTag(ctx, sourceId, userId)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.findOwnedTag(ctx, targetId, userId)
+	if err != nil {
+		return err
+	}
+
+	if sourceId == targetId {
+		return &ErrorInvalidTag{Name: source.Name, Reason: "a tag cannot be merged into itself"}
+	}
+
+	return s.TagUpdater.MergeTags(ctx, sourceId, targetId)
+}
+
+func (s *TagService) DeleteTag(ctx context.Context, tagId uint, userId uint) error {
+	_, err := s.findOwnedTag(ctx, tagId, userId)
+	if err != nil {
+		return err
+	}
+
+	return s.TagDeleter.DeleteTagById(ctx, tagId)
+}
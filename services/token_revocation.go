@@ -0,0 +1,194 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"user-notes-api/models"
+	"user-notes-api/repositories"
+)
+
+// DefaultRevocationCacheTTL is how long the RevocationStore trusts a cached "not revoked" answer. Tokens
+// revoked through another instance of the API are rejected by this one after at most this time.
+const DefaultRevocationCacheTTL = 30 * time.Second
+
+// DefaultRevocationPurgeInterval is how often RevocationStore.Run deletes revocations of expired tokens.
+const DefaultRevocationPurgeInterval = time.Hour
+
+// TokenRevocationChecker is consulted by the JWT middleware for every request.
+type TokenRevocationChecker interface {
+	IsRevoked(ctx context.Context, claims *JwtClaims) (bool, error)
+}
+
+type TokenRevocations interface {
+	Revoke(ctx context.Context, userId uint, jti string, expiresAt time.Time) error
+	RevokeAll(ctx context.Context, userId uint, now time.Time) error
+	Forget(userId uint)
+}
+
+// RevocationStore keeps track of revoked access tokens. Revocations are stored in the database and cached
+// in memory, so most requests do not query the database. A revoked token is cached until it expires,
+// other answers for CacheTTL.
+type RevocationStore struct {
+	Reader   repositories.TokenRevocationReader
+	Revoker  repositories.TokenRevoker
+	CacheTTL time.Duration
+	Interval time.Duration
+
+	mu     sync.Mutex
+	tokens map[string]revokedTokenEntry
+	users  map[uint]revokedUserEntry
+}
+
+type revokedTokenEntry struct {
+	revoked bool
+	expires time.Time
+}
+
+type revokedUserEntry struct {
+	generation uint
+	expires    time.Time
+}
+
+func NewRevocationStore(reader repositories.TokenRevocationReader, revoker repositories.TokenRevoker) *RevocationStore {
+	store := RevocationStore{Reader: reader, Revoker: revoker, CacheTTL: DefaultRevocationCacheTTL,
+		Interval: DefaultRevocationPurgeInterval, tokens: map[string]revokedTokenEntry{}, users: map[uint]revokedUserEntry{}}
+	return &store
+}
+
+// IsRevoked reports whether the token was revoked by its jti or by a logout of its user from all devices.
+func (s *RevocationStore) IsRevoked(ctx context.Context, claims *JwtClaims) (bool, error) {
+	now := time.Now()
+	revoked, err := s.isTokenRevoked(ctx, claims, now)
+	if err != nil || revoked {
+		return revoked, err
+	}
+
+	generation, err := s.tokenGeneration(ctx, claims.UserId, now)
+	if err != nil {
+		return false, err
+	}
+	return claims.Generation < generation, nil
+}
+
+func (s *RevocationStore) isTokenRevoked(ctx context.Context, claims *JwtClaims, now time.Time) (bool, error) {
+	s.mu.Lock()
+	entry, found := s.tokens[claims.ID]
+	s.mu.Unlock()
+	if found && now.Before(entry.expires) {
+		return entry.revoked, nil
+	}
+
+	revoked, err := s.Reader.IsTokenRevoked(ctx, claims.ID)
+	if err != nil {
+		return false, fmt.Errorf("check token revocation: %w", err)
+	}
+
+	expires := now.Add(s.CacheTTL)
+	if claims.ExpiresAt != nil && (revoked || claims.ExpiresAt.Before(expires)) {
+		expires = claims.ExpiresAt.Time
+	}
+	s.mu.Lock()
+	s.tokens[claims.ID] = revokedTokenEntry{revoked: revoked, expires: expires}
+	s.mu.Unlock()
+	return revoked, nil
+}
+
+func (s *RevocationStore) tokenGeneration(ctx context.Context, userId uint, now time.Time) (uint, error) {
+	s.mu.Lock()
+	entry, found := s.users[userId]
+	s.mu.Unlock()
+	if found && now.Before(entry.expires) {
+		return entry.generation, nil
+	}
+
+	generation, err := s.Reader.FindTokenGeneration(ctx, userId)
+	if err != nil {
+		return 0, fmt.Errorf("check token revocation of user: %w", err)
+	}
+
+	s.mu.Lock()
+	s.users[userId] = revokedUserEntry{generation: generation, expires: now.Add(s.CacheTTL)}
+	s.mu.Unlock()
+	return generation, nil
+}
+
+// Revoke revokes the access token with the given jti until it expires.
+func (s *RevocationStore) Revoke(ctx context.Context, userId uint, jti string, expiresAt time.Time) error {
+	err := s.Revoker.RevokeToken(ctx, &models.RevokedToken{JTI: jti, UserID: userId, ExpiresAt: expiresAt})
+	if err != nil {
+		return fmt.Errorf("revoke token: %w", err)
+	}
+
+	s.mu.Lock()
+	s.tokens[jti] = revokedTokenEntry{revoked: true, expires: expiresAt}
+	s.mu.Unlock()
+	return nil
+}
+
+// RevokeAll revokes all access tokens of the user issued up to now together with its refresh tokens. It
+// starts a new token generation of the user, which is read from the database again by the next check.
+func (s *RevocationStore) RevokeAll(ctx context.Context, userId uint, now time.Time) error {
+	err := s.Revoker.RevokeAllTokens(ctx, userId, now)
+	if err != nil {
+		return fmt.Errorf("revoke all tokens: %w", err)
+	}
+
+	s.Forget(userId)
+	return nil
+}
+
+// Forget drops the cached revocation state of the user, so it is read from the database again. It is
+// used after the tokens of the user were revoked without the store, like on deletion of the user.
+func (s *RevocationStore) Forget(userId uint) {
+	s.mu.Lock()
+	delete(s.users, userId)
+	s.mu.Unlock()
+}
+
+// PurgeExpired deletes the revocations of tokens that expired before now from the database and the cache
+// and returns the number of deleted database rows.
+func (s *RevocationStore) PurgeExpired(ctx context.Context, now time.Time) (int, error) {
+	count, err := s.Revoker.DeleteExpiredRevokedTokens(ctx, now)
+	if err != nil {
+		return 0, fmt.Errorf("purge revoked tokens: %w", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for jti, entry := range s.tokens {
+		if !now.Before(entry.expires) {
+			delete(s.tokens, jti)
+		}
+	}
+	for userId, entry := range s.users {
+		if !now.Before(entry.expires) {
+			delete(s.users, userId)
+		}
+	}
+	return count, nil
+}
+
+// Run purges expired revocations right away and then once every Interval until the context is cancelled.
+func (s *RevocationStore) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.Interval)
+	defer ticker.Stop()
+
+	for {
+		count, err := s.PurgeExpired(ctx, time.Now())
+		if err != nil {
+			log.Println(err)
+		} else if count > 0 {
+			log.Printf("Purged %d expired token revocations", count)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
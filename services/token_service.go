@@ -0,0 +1,218 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"user-notes-api/models"
+	"user-notes-api/repositories"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// refreshTokenBytes is the number of random bytes in a refresh token.
+const refreshTokenBytes = 32
+
+// AuthTokens is the result of a login, registration or refresh. Token is the short-lived access JWT,
+// RefreshToken an opaque token for POST /token/refresh.
+type AuthTokens struct {
+	Token        string `json:"token"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+type TokenIssuer interface {
+	IssueTokens(ctx context.Context, userId uint, username string) (AuthTokens, error)
+}
+
+type TokenRefresher interface {
+	Refresh(ctx context.Context, refreshToken string) (AuthTokens, error)
+}
+
+type TokenService struct {
+	RefreshTokenReader   repositories.RefreshTokenReader
+	RefreshTokenCreator  repositories.RefreshTokenCreator
+	RefreshTokenUpdater  repositories.RefreshTokenUpdater
+	GenerationReader     repositories.TokenRevocationReader
+	AccessTokenLifetime  time.Duration
+	RefreshTokenLifetime time.Duration
+	jwt_keys             *JwtKeys
+}
+
+// ErrorInvalidRefreshToken means that the refresh token is unknown, expired or revoked. Reused is set if a
+// token that was already rotated is presented again, in which case its whole family is revoked.
+type ErrorInvalidRefreshToken struct {
+	Reused bool
+	Err    error
+}
+
+func (e *ErrorInvalidRefreshToken) Error() string {
+	if e.Reused {
+		return "refresh token was already used, all tokens of this login are revoked"
+	}
+	return fmt.Sprintf("invalid refresh token: %v", e.Err)
+}
+
+func (e *ErrorInvalidRefreshToken) Unwrap() error {
+	return e.Err
+}
+
+func NewTokenService(refresh_token_reader repositories.RefreshTokenReader, refresh_token_creator repositories.RefreshTokenCreator,
+	refresh_token_updater repositories.RefreshTokenUpdater, generation_reader repositories.TokenRevocationReader, jwt_keys *JwtKeys,
+	access_token_lifetime time.Duration, refresh_token_lifetime time.Duration) *TokenService {
+	token_service := TokenService{RefreshTokenReader: refresh_token_reader, RefreshTokenCreator: refresh_token_creator,
+		RefreshTokenUpdater: refresh_token_updater, GenerationReader: generation_reader, AccessTokenLifetime: access_token_lifetime,
+		RefreshTokenLifetime: refresh_token_lifetime, jwt_keys: jwt_keys}
+	return &token_service
+}
+
+// hashRefreshToken returns the hash under which a refresh token is stored. Refresh tokens are random, so
+// a fast unsalted hash suffices.
+func hashRefreshToken(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hash[:])
+}
+
+func randomString(length int) (string, error) {
+	b := make([]byte, length)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// newAccessToken returns a signed access token with a random jti, by which it can be revoked, and the
+// current token generation of the user, by which it is revoked on logout from all devices. A zero authTime
+// leaves out the auth_time claim.
+func (s *TokenService) newAccessToken(ctx context.Context, userId uint, username string, authTime time.Time) (string, error) {
+	jti, err := randomString(16)
+	if err != nil {
+		return "", fmt.Errorf("generate token id: %w", err)
+	}
+
+	generation, err := s.GenerationReader.FindTokenGeneration(ctx, userId)
+	if err != nil {
+		return "", fmt.Errorf("read token generation: %w", err)
+	}
+
+	var auth_time *jwt.NumericDate
+	if !authTime.IsZero() {
+		auth_time = jwt.NewNumericDate(authTime)
+	}
+
+	now := time.Now()
+	return s.jwt_keys.Sign(JwtClaims{
+		UserId:     userId,
+		Generation: generation,
+		AuthTime:   auth_time,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        jti,
+			Issuer:    "auth.user-notes-api.local",
+			Subject:   username,
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(s.AccessTokenLifetime)),
+		},
+	})
+}
+
+// newRefreshToken returns a new refresh token of the given family and the model to store for it.
+func (s *TokenService) newRefreshToken(userId uint, familyId string, authenticatedAt *time.Time) (string, *models.RefreshToken, error) {
+	token, err := randomString(refreshTokenBytes)
+	if err != nil {
+		return "", nil, fmt.Errorf("generate refresh token: %w", err)
+	}
+
+	token_model := models.RefreshToken{TokenHash: hashRefreshToken(token), FamilyID: familyId, UserID: userId,
+		ExpiresAt: time.Now().Add(s.RefreshTokenLifetime), AuthenticatedAt: authenticatedAt}
+	return token, &token_model, nil
+}
+
+// IssueTokens returns an access token and a refresh token starting a new family for the user, which just
+// logged in.
+func (s *TokenService) IssueTokens(ctx context.Context, userId uint, username string) (AuthTokens, error) {
+	authenticated_at := time.Now()
+	access_token, err := s.newAccessToken(ctx, userId, username, authenticated_at)
+	if err != nil {
+		return AuthTokens{}, err
+	}
+
+	family_id, err := randomString(16)
+	if err != nil {
+		return AuthTokens{}, fmt.Errorf("generate refresh token family: %w", err)
+	}
+
+	refresh_token, token_model, err := s.newRefreshToken(userId, family_id, &authenticated_at)
+	if err != nil {
+		return AuthTokens{}, err
+	}
+
+	err = s.RefreshTokenCreator.CreateRefreshToken(ctx, token_model)
+	if err != nil {
+		return AuthTokens{}, fmt.Errorf("store refresh token: %w", err)
+	}
+
+	return AuthTokens{Token: access_token, RefreshToken: refresh_token}, nil
+}
+
+// Refresh exchanges a refresh token for a new access token and a new refresh token of the same family.
+// The old refresh token becomes invalid. Presenting it again revokes the whole family, since then either
+// the client or an attacker holds a stolen token.
+func (s *TokenService) Refresh(ctx context.Context, refreshToken string) (AuthTokens, error) {
+	token_model, err := s.RefreshTokenReader.FindRefreshTokenByHash(ctx, hashRefreshToken(refreshToken))
+	if err != nil {
+		return AuthTokens{}, &ErrorInvalidRefreshToken{Err: err}
+	}
+
+	if token_model.RevokedAt != nil {
+		return AuthTokens{}, &ErrorInvalidRefreshToken{Err: errors.New("token is revoked")}
+	}
+
+	if token_model.RotatedAt != nil {
+		return AuthTokens{}, s.revokeFamily(ctx, token_model.FamilyID)
+	}
+
+	if !time.Now().Before(token_model.ExpiresAt) {
+		return AuthTokens{}, &ErrorInvalidRefreshToken{Err: errors.New("token is expired")}
+	}
+
+	new_refresh_token, new_token_model, err := s.newRefreshToken(token_model.UserID, token_model.FamilyID, token_model.AuthenticatedAt)
+	if err != nil {
+		return AuthTokens{}, err
+	}
+
+	err = s.RefreshTokenUpdater.RotateRefreshToken(ctx, token_model.ID, new_token_model)
+	if errors.Is(err, repositories.ErrRefreshTokenUsed) {
+		// the token was used by a concurrent request
+		return AuthTokens{}, s.revokeFamily(ctx, token_model.FamilyID)
+	}
+	if err != nil {
+		return AuthTokens{}, fmt.Errorf("rotate refresh token: %w", err)
+	}
+
+	var authenticated_at time.Time
+	if token_model.AuthenticatedAt != nil {
+		authenticated_at = *token_model.AuthenticatedAt
+	}
+	access_token, err := s.newAccessToken(ctx, token_model.UserID, token_model.User.Username, authenticated_at)
+	if err != nil {
+		return AuthTokens{}, err
+	}
+
+	return AuthTokens{Token: access_token, RefreshToken: new_refresh_token}, nil
+}
+
+// revokeFamily revokes all refresh tokens of the family after one of them was reused.
+func (s *TokenService) revokeFamily(ctx context.Context, familyId string) error {
+	err := s.RefreshTokenUpdater.RevokeRefreshTokenFamily(ctx, familyId)
+	if err != nil {
+		return fmt.Errorf("revoke refresh token family: %w", err)
+	}
+	return &ErrorInvalidRefreshToken{Reused: true, Err: repositories.ErrRefreshTokenUsed}
+}
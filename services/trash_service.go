@@ -0,0 +1,129 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"user-notes-api/models"
+	"user-notes-api/repositories"
+)
+
+// DefaultTrashPurgeInterval is how often the purger looks for notes whose retention period is over.
+const DefaultTrashPurgeInterval = time.Hour
+
+type TrashResult struct {
+	Id        uint      `json:"Id"`
+	Title     string    `json:"Title"`
+	DeletedAt time.Time `json:"DeletedAt"`
+}
+
+type GetTrashResult struct {
+	Result []TrashResult `json:"Result"`
+}
+
+type TrashServiceIfc interface {
+	GetTrash(ctx context.Context, userId uint) (GetTrashResult, error)
+	RestoreNote(ctx context.Context, noteId uint, userId uint) error
+	PurgeNote(ctx context.Context, noteId uint, userId uint) error
+}
+
+type TrashService struct {
+	TrashReader  repositories.NoteTrashReader
+	NoteRestorer repositories.NoteRestorer
+	NotePurger   repositories.NotePurger
+}
+
+func NewTrashService(trash_reader repositories.NoteTrashReader, note_restorer repositories.NoteRestorer,
+	note_purger repositories.NotePurger) *TrashService {
+	trash_service := TrashService{TrashReader: trash_reader, NoteRestorer: note_restorer, NotePurger: note_purger}
+	return &trash_service
+}
+
+// findOwnedDeletedNote returns the note with the given id if it is in the trash of the user with the given id.
+func (s *TrashService) findOwnedDeletedNote(ctx context.Context, noteId uint, userId uint) (*models.Note, error) {
+	note, err := s.TrashReader.FindDeletedNoteById(ctx, noteId)
+	if err != nil {
+		return nil, &ErrorNoteNotFound{NoteId: noteId, Err: err}
+	}
+
+	if note.UserID != userId {
+		return nil, &ErrorWrongOwner{NoteId: noteId, UserId: userId}
+	}
+
+	return note, nil
+}
+
+func (s *TrashService) GetTrash(ctx context.Context, userId uint) (GetTrashResult, error) {
+	var trash_array GetTrashResult
+	notes, err := s.TrashReader.FindDeletedNotesByUserId(ctx, userId)
+	if err != nil {
+		return trash_array, fmt.Errorf("get trash: %w", err)
+	}
+
+	for _, note := range *notes {
+		trash_array.Result = append(trash_array.Result, TrashResult{Id: note.ID, Title: note.Title, DeletedAt: note.DeletedAt.Time})
+	}
+	return trash_array, nil
+}
+
+func (s *TrashService) RestoreNote(ctx context.Context, noteId uint, userId uint) error {
+	_, err := s.findOwnedDeletedNote(ctx, noteId, userId)
+	if err != nil {
+		return err
+	}
+
+	return s.NoteRestorer.RestoreNoteById(ctx, noteId)
+}
+
+func (s *TrashService) PurgeNote(ctx context.Context, noteId uint, userId uint) error {
+	_, err := s.findOwnedDeletedNote(ctx, noteId, userId)
+	if err != nil {
+		return err
+	}
+
+	return s.NotePurger.PurgeNoteById(ctx, noteId)
+}
+
+// TrashPurger permanently deletes notes that have been in the trash for longer than Retention.
+type TrashPurger struct {
+	NotePurger repositories.NotePurger
+	Retention  time.Duration
+	Interval   time.Duration
+}
+
+func NewTrashPurger(note_purger repositories.NotePurger, retention time.Duration) *TrashPurger {
+	purger := TrashPurger{NotePurger: note_purger, Retention: retention, Interval: DefaultTrashPurgeInterval}
+	return &purger
+}
+
+// PurgeOnce deletes the notes whose retention period ended before now and returns their number.
+func (p *TrashPurger) PurgeOnce(ctx context.Context, now time.Time) (int, error) {
+	count, err := p.NotePurger.PurgeNotesDeletedBefore(ctx, now.Add(-p.Retention))
+	if err != nil {
+		return 0, fmt.Errorf("purge trash: %w", err)
+	}
+	return count, nil
+}
+
+// Run purges the trash right away and then once every Interval until the context is cancelled.
+func (p *TrashPurger) Run(ctx context.Context) {
+	ticker := time.NewTicker(p.Interval)
+	defer ticker.Stop()
+
+	for {
+		count, err := p.PurgeOnce(ctx, time.Now())
+		if err != nil {
+			log.Println(err)
+		} else if count > 0 {
+			log.Printf("Purged %d notes from the trash", count)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"io"
 	"net/http"
+	"net/url"
 	"strconv"
 	"testing"
 	"time"
@@ -62,11 +63,63 @@ func callPost(t *testing.T, base_url string, path string, body []byte) string {
 	return token.Token
 }
 
+// callPostTokens posts to an endpoint answering with an access and a refresh token, like /login or /token/refresh.
+func callPostTokens(t *testing.T, base_url string, path string, body []byte) (services.AuthTokens, int) {
+	return callTokens(t, "POST", base_url, path, "", body)
+}
+
+func callAuthPutTokens(t *testing.T, base_url string, path string, jwt_token string, body []byte) (services.AuthTokens, int) {
+	return callTokens(t, "PUT", base_url, path, jwt_token, body)
+}
+
+// callTokens sends a request that responds with new tokens. The Authorization header is only set if
+// jwt_token is not empty.
+func callTokens(t *testing.T, method string, base_url string, path string, jwt_token string, body []byte) (services.AuthTokens, int) {
+	client := &http.Client{}
+	req, _ := http.NewRequest(method, base_url+path, bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
+	if jwt_token != "" {
+		req.Header.Add("Authorization", "Bearer "+jwt_token)
+	}
+
+	resp, err := client.Do(req)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return services.AuthTokens{}, resp.StatusCode
+	}
+
+	resp_body, err := io.ReadAll(resp.Body)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var tokens services.AuthTokens
+	err = json.Unmarshal(resp_body, &tokens)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return tokens, http.StatusOK
+}
+
 func callAuthPost(t *testing.T, base_url string, path string, jwt_token string, body []byte) uint {
+	return callAuthPostIfMatch(t, base_url, path, jwt_token, body, "")
+}
+
+func callAuthPostIfMatch(t *testing.T, base_url string, path string, jwt_token string, body []byte, etag string) uint {
 	client := &http.Client{}
 	req, _ := http.NewRequest("POST", base_url+path, bytes.NewBuffer(body))
 	req.Header.Add("Authorization", "Bearer "+jwt_token)
 	req.Header.Set("Content-Type", "application/json")
+	if etag != "" {
+		req.Header.Set("If-Match", etag)
+	}
 
 	resp, err := client.Do(req)
 
@@ -127,15 +180,38 @@ func callGetSingleNote(t *testing.T, base_url string, note_id uint, jwt_token st
 	return note, http.StatusOK
 }
 
+// callGetNoteETag returns the ETag of a note, sending if_none_match as If-None-Match header unless it is empty.
+func callGetNoteETag(t *testing.T, base_url string, note_id uint, jwt_token string, if_none_match string) (string, int) {
+	client := &http.Client{}
+	req, _ := http.NewRequest("GET", base_url+"/notes/"+strconv.Itoa(int(note_id)), nil)
+	req.Header.Add("Authorization", "Bearer "+jwt_token)
+	if if_none_match != "" {
+		req.Header.Set("If-None-Match", if_none_match)
+	}
+
+	resp, err := client.Do(req)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer resp.Body.Close()
+
+	return resp.Header.Get("ETag"), resp.StatusCode
+}
+
 func callGetNotes(t *testing.T, base_url string, jwt_token string) services.GetNotesResult {
+	return callGetNotesWithQuery(t, base_url, jwt_token, "")
+}
+
+func callGetNotesWithQuery(t *testing.T, base_url string, jwt_token string, query string) services.GetNotesResult {
 	client := &http.Client{}
-	req, _ := http.NewRequest("GET", base_url+"/notes/", nil)
+	req, _ := http.NewRequest("GET", base_url+"/notes?"+query, nil)
 	req.Header.Add("Authorization", "Bearer "+jwt_token)
 
 	resp, err := client.Do(req)
 
 	if err != nil {
-		resp.Body.Close()
 		t.Fatal(err)
 	}
 
@@ -157,3 +233,235 @@ func callGetNotes(t *testing.T, base_url string, jwt_token string) services.GetN
 
 	return note_result
 }
+
+func callAuthPut(t *testing.T, base_url string, path string, jwt_token string, body []byte) int {
+	return callAuthPutIfMatch(t, base_url, path, jwt_token, body, "")
+}
+
+func callAuthPutIfMatch(t *testing.T, base_url string, path string, jwt_token string, body []byte, etag string) int {
+	client := &http.Client{}
+	req, _ := http.NewRequest("PUT", base_url+path, bytes.NewBuffer(body))
+	req.Header.Add("Authorization", "Bearer "+jwt_token)
+	req.Header.Set("Content-Type", "application/json")
+	if etag != "" {
+		req.Header.Set("If-Match", etag)
+	}
+
+	resp, err := client.Do(req)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer resp.Body.Close()
+
+	return resp.StatusCode
+}
+
+func callAuthDelete(t *testing.T, base_url string, path string, jwt_token string) int {
+	return callAuthDeleteIfMatch(t, base_url, path, jwt_token, "")
+}
+
+func callAuthDeleteIfMatch(t *testing.T, base_url string, path string, jwt_token string, etag string) int {
+	client := &http.Client{}
+	req, _ := http.NewRequest("DELETE", base_url+path, nil)
+	req.Header.Add("Authorization", "Bearer "+jwt_token)
+	if etag != "" {
+		req.Header.Set("If-Match", etag)
+	}
+
+	resp, err := client.Do(req)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer resp.Body.Close()
+
+	return resp.StatusCode
+}
+
+func callSearchNotes(t *testing.T, base_url string, jwt_token string, query string) (services.SearchNotesResult, int) {
+	client := &http.Client{}
+	req, _ := http.NewRequest("GET", base_url+"/notes/search?q="+url.QueryEscape(query), nil)
+	req.Header.Add("Authorization", "Bearer "+jwt_token)
+
+	resp, err := client.Do(req)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return services.SearchNotesResult{}, resp.StatusCode
+	}
+	resp_body, err := io.ReadAll(resp.Body)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var search_result services.SearchNotesResult
+	err = json.Unmarshal(resp_body, &search_result)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return search_result, http.StatusOK
+}
+
+func callGetTags(t *testing.T, base_url string, jwt_token string) services.GetTagsResult {
+	client := &http.Client{}
+	req, _ := http.NewRequest("GET", base_url+"/tags", nil)
+	req.Header.Add("Authorization", "Bearer "+jwt_token)
+
+	resp, err := client.Do(req)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", resp.StatusCode)
+	}
+	resp_body, err := io.ReadAll(resp.Body)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var tags_result services.GetTagsResult
+	err = json.Unmarshal(resp_body, &tags_result)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return tags_result
+}
+
+func callGetNotebookContents(t *testing.T, base_url string, notebook_id uint, jwt_token string) (services.NotebookContents, int) {
+	client := &http.Client{}
+	req, _ := http.NewRequest("GET", base_url+"/notebooks/"+strconv.FormatUint(uint64(notebook_id), 10), nil)
+	req.Header.Add("Authorization", "Bearer "+jwt_token)
+
+	resp, err := client.Do(req)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return services.NotebookContents{}, resp.StatusCode
+	}
+	resp_body, err := io.ReadAll(resp.Body)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var contents services.NotebookContents
+	err = json.Unmarshal(resp_body, &contents)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return contents, http.StatusOK
+}
+
+// callAuthGet sends an authenticated GET request and decodes the response into result if the status is OK.
+func callAuthGet(t *testing.T, base_url string, path string, jwt_token string, result any) int {
+	client := &http.Client{}
+	req, _ := http.NewRequest("GET", base_url+path, nil)
+	req.Header.Add("Authorization", "Bearer "+jwt_token)
+
+	resp, err := client.Do(req)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return resp.StatusCode
+	}
+	resp_body, err := io.ReadAll(resp.Body)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = json.Unmarshal(resp_body, result)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return http.StatusOK
+}
+
+func callAuthPostStatus(t *testing.T, base_url string, path string, jwt_token string, body []byte) int {
+	client := &http.Client{}
+	req, _ := http.NewRequest("POST", base_url+path, bytes.NewBuffer(body))
+	req.Header.Add("Authorization", "Bearer "+jwt_token)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := client.Do(req)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer resp.Body.Close()
+	return resp.StatusCode
+}
+
+func callAuthDeleteWithBody(t *testing.T, base_url string, path string, jwt_token string, body []byte, result any) int {
+	client := &http.Client{}
+	req, _ := http.NewRequest("DELETE", base_url+path, bytes.NewBuffer(body))
+	req.Header.Add("Authorization", "Bearer "+jwt_token)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := client.Do(req)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return resp.StatusCode
+	}
+
+	resp_body, err := io.ReadAll(resp.Body)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = json.Unmarshal(resp_body, result)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return http.StatusOK
+}
+
+// callLogin logs in from the given client IP, which the server takes from X-Forwarded-For, and returns
+// the status code and the Retry-After header.
+func callLogin(t *testing.T, base_url string, client_ip string, body []byte) (int, string) {
+	client := &http.Client{}
+	req, _ := http.NewRequest("POST", base_url+"/login", bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Forwarded-For", client_ip)
+
+	resp, err := client.Do(req)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer resp.Body.Close()
+	return resp.StatusCode, resp.Header.Get("Retry-After")
+}
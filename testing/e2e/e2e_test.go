@@ -3,8 +3,13 @@ package e2e
 import (
 	"encoding/json"
 	"net/http"
+	"net/url"
+	"strconv"
 	"testing"
+	"time"
 	"user-notes-api/auth"
+	"user-notes-api/config"
+	"user-notes-api/controllers"
 	"user-notes-api/services"
 
 	"github.com/stretchr/testify/assert"
@@ -139,3 +144,949 @@ func TestGetNotes(t *testing.T) {
 	assert.Equal(t, note1.Title, notes.Result[0].Title)
 	assert.Equal(t, note2.Title, notes.Result[1].Title)
 }
+
+func TestUpdateAndDeleteNote(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping e2e test in short mode.")
+	}
+
+	waitForServer(t, base_url)
+
+	creds := auth.Credentials{Username: "Erin", Password: "secret_pwd"}
+	creds_other := auth.Credentials{Username: "Frank", Password: "secret_pwd"}
+	body, err := json.Marshal(creds)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token := callPost(t, base_url, "/register", body)
+	assert.True(t, len(token) > 0)
+
+	body, err = json.Marshal(creds_other)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token_other := callPost(t, base_url, "/register", body)
+	assert.True(t, len(token_other) > 0)
+
+	note := services.Note{Title: "e2e note", Content: "This note is created for the e2e test."}
+
+	body, err = json.Marshal(note)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	id := callAuthPost(t, base_url, "/notes", token, body)
+	assert.True(t, id > 0)
+	path := "/notes/" + strconv.Itoa(int(id))
+
+	// other user can neither update nor delete the note
+	updated_note := services.Note{Title: "updated e2e note", Content: "This note was updated in the e2e test."}
+	body, err = json.Marshal(updated_note)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	status_code := callAuthPutIfMatch(t, base_url, path, token_other, body, `"1"`)
+	assert.Equal(t, http.StatusUnauthorized, status_code)
+
+	status_code = callAuthDeleteIfMatch(t, base_url, path, token_other, `"1"`)
+	assert.Equal(t, http.StatusUnauthorized, status_code)
+
+	note_resp, status_code := callGetSingleNote(t, base_url, id, token)
+	assert.Equal(t, note, note_resp)
+	assert.Equal(t, http.StatusOK, status_code)
+
+	etag, status_code := callGetNoteETag(t, base_url, id, token, "")
+	assert.Equal(t, http.StatusOK, status_code)
+	assert.Equal(t, `"1"`, etag)
+
+	_, status_code = callGetNoteETag(t, base_url, id, token, etag)
+	assert.Equal(t, http.StatusNotModified, status_code)
+
+	// updates and deletes require the current ETag
+	status_code = callAuthPut(t, base_url, path, token, body)
+	assert.Equal(t, http.StatusPreconditionRequired, status_code)
+
+	// owner can update the note
+	status_code = callAuthPutIfMatch(t, base_url, path, token, body, etag)
+	assert.Equal(t, http.StatusOK, status_code)
+
+	note_resp, status_code = callGetSingleNote(t, base_url, id, token)
+	assert.Equal(t, updated_note, note_resp)
+	assert.Equal(t, http.StatusOK, status_code)
+
+	// a second update based on the old version fails
+	status_code = callAuthPutIfMatch(t, base_url, path, token, body, etag)
+	assert.Equal(t, http.StatusPreconditionFailed, status_code)
+
+	status_code = callAuthDeleteIfMatch(t, base_url, path, token, etag)
+	assert.Equal(t, http.StatusPreconditionFailed, status_code)
+
+	// owner can delete the note
+	etag, _ = callGetNoteETag(t, base_url, id, token, etag)
+	assert.Equal(t, `"2"`, etag)
+	status_code = callAuthDeleteIfMatch(t, base_url, path, token, etag)
+	assert.Equal(t, http.StatusOK, status_code)
+
+	_, status_code = callGetSingleNote(t, base_url, id, token)
+	assert.Equal(t, http.StatusNotFound, status_code)
+}
+
+func TestGetNotesPaging(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping e2e test in short mode.")
+	}
+
+	waitForServer(t, base_url)
+
+	creds := auth.Credentials{Username: "Grace", Password: "secret_pwd"}
+
+	body, err := json.Marshal(creds)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token := callPost(t, base_url, "/register", body)
+	assert.True(t, len(token) > 0)
+
+	var ids []uint
+	for _, title := range []string{"b", "c", "a"} {
+		body, err = json.Marshal(services.Note{Title: title, Content: "This note is created for the e2e test."})
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ids = append(ids, callAuthPost(t, base_url, "/notes", token, body))
+	}
+
+	notes := callGetNotesWithQuery(t, base_url, token, "limit=2&sort=-title")
+	assert.Equal(t, 2, len(notes.Result))
+	assert.Equal(t, ids[1], notes.Result[0].Id)
+	assert.Equal(t, ids[0], notes.Result[1].Id)
+	assert.NotEmpty(t, notes.NextCursor)
+
+	notes = callGetNotesWithQuery(t, base_url, token, "limit=2&sort=-title&cursor="+url.QueryEscape(notes.NextCursor))
+	assert.Equal(t, 1, len(notes.Result))
+	assert.Equal(t, ids[2], notes.Result[0].Id)
+	assert.Empty(t, notes.NextCursor)
+}
+
+func TestSearchNotes(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping e2e test in short mode.")
+	}
+
+	waitForServer(t, base_url)
+
+	creds := auth.Credentials{Username: "Heidi", Password: "secret_pwd"}
+	creds_other := auth.Credentials{Username: "Ivan", Password: "secret_pwd"}
+
+	body, err := json.Marshal(creds)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token := callPost(t, base_url, "/register", body)
+	assert.True(t, len(token) > 0)
+
+	body, err = json.Marshal(creds_other)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token_other := callPost(t, base_url, "/register", body)
+	assert.True(t, len(token_other) > 0)
+
+	body, err = json.Marshal(services.Note{Title: "Groceries", Content: "Buy milk and bread"})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	id := callAuthPost(t, base_url, "/notes", token, body)
+	assert.True(t, id > 0)
+
+	body, err = json.Marshal(services.Note{Title: "Shopping", Content: "Buy more milk"})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	id_other := callAuthPost(t, base_url, "/notes", token_other, body)
+	assert.True(t, id_other > 0)
+
+	result, status_code := callSearchNotes(t, base_url, token, "milk")
+	assert.Equal(t, http.StatusOK, status_code)
+	assert.Equal(t, 1, len(result.Result))
+	assert.Equal(t, id, result.Result[0].Id)
+	assert.Contains(t, result.Result[0].Snippet, "<mark>milk</mark>")
+
+	_, status_code = callSearchNotes(t, base_url, token, "")
+	assert.Equal(t, http.StatusBadRequest, status_code)
+}
+
+func TestTags(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping e2e test in short mode.")
+	}
+
+	waitForServer(t, base_url)
+
+	creds := auth.Credentials{Username: "Judy", Password: "secret_pwd"}
+
+	body, err := json.Marshal(creds)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token := callPost(t, base_url, "/register", body)
+	assert.True(t, len(token) > 0)
+
+	body, err = json.Marshal(services.Note{Title: "Report", Content: "Write report", Tags: []string{"Work", "urgent"}})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	id := callAuthPost(t, base_url, "/notes", token, body)
+	assert.True(t, id > 0)
+
+	body, err = json.Marshal(services.Note{Title: "Call", Content: "Call the bank", Tags: []string{"job"}})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	id_other := callAuthPost(t, base_url, "/notes", token, body)
+	assert.True(t, id_other > 0)
+
+	note, status_code := callGetSingleNote(t, base_url, id, token)
+	assert.Equal(t, http.StatusOK, status_code)
+	assert.Equal(t, []string{"work", "urgent"}, note.Tags)
+
+	result := callGetNotesWithQuery(t, base_url, token, "tag=work&tag=urgent")
+	assert.Equal(t, 1, len(result.Result))
+	assert.Equal(t, id, result.Result[0].Id)
+
+	tags := callGetTags(t, base_url, token)
+	assert.Equal(t, 3, len(tags.Result))
+	assert.Equal(t, "job", tags.Result[0].Name)
+	assert.Equal(t, "work", tags.Result[2].Name)
+
+	body, err = json.Marshal(services.TagMerge{Target: tags.Result[2].Id})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	merge_path := "/tags/" + strconv.FormatUint(uint64(tags.Result[0].Id), 10) + "/merge"
+	callAuthPost(t, base_url, merge_path, token, body)
+
+	result = callGetNotesWithQuery(t, base_url, token, "tag=work")
+	assert.Equal(t, 2, len(result.Result))
+
+	tags = callGetTags(t, base_url, token)
+	assert.Equal(t, 2, len(tags.Result))
+	assert.Equal(t, 2, tags.Result[1].NoteCount)
+}
+
+func TestNotebooks(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping e2e test in short mode.")
+	}
+
+	waitForServer(t, base_url)
+
+	creds := auth.Credentials{Username: "Karl", Password: "secret_pwd"}
+	creds_other := auth.Credentials{Username: "Laura", Password: "secret_pwd"}
+
+	body, err := json.Marshal(creds)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token := callPost(t, base_url, "/register", body)
+	assert.True(t, len(token) > 0)
+
+	body, err = json.Marshal(creds_other)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token_other := callPost(t, base_url, "/register", body)
+	assert.True(t, len(token_other) > 0)
+
+	body, err = json.Marshal(services.Notebook{Name: "work"})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	work_id := callAuthPost(t, base_url, "/notebooks", token, body)
+	assert.True(t, work_id > 0)
+
+	body, err = json.Marshal(services.Notebook{Name: "projects", Parent: &work_id})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	projects_id := callAuthPost(t, base_url, "/notebooks", token, body)
+	assert.True(t, projects_id > 0)
+
+	body, err = json.Marshal(services.Note{Title: "Plan", Content: "Make a plan", Notebook: &projects_id})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	note_id := callAuthPost(t, base_url, "/notes", token, body)
+	assert.True(t, note_id > 0)
+
+	contents, status_code := callGetNotebookContents(t, base_url, work_id, token)
+	assert.Equal(t, http.StatusOK, status_code)
+	assert.Equal(t, 1, len(contents.Notebooks))
+	assert.Equal(t, "projects", contents.Notebooks[0].Name)
+	assert.Equal(t, note_id, contents.Notebooks[0].Notes[0].Id)
+
+	_, status_code = callGetNotebookContents(t, base_url, work_id, token_other)
+	assert.Equal(t, http.StatusUnauthorized, status_code)
+
+	// Move the note to work and delete projects
+	body, err = json.Marshal(services.NoteMove{Notebook: &work_id})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	note_path := "/notes/" + strconv.FormatUint(uint64(note_id), 10)
+	status_code = callAuthPut(t, base_url, note_path+"/notebook", token, body)
+	assert.Equal(t, http.StatusPreconditionRequired, status_code)
+
+	etag, _ := callGetNoteETag(t, base_url, note_id, token, "")
+	status_code = callAuthPutIfMatch(t, base_url, note_path+"/notebook", token, body, etag)
+	assert.Equal(t, http.StatusOK, status_code)
+
+	// the move changed the version
+	status_code = callAuthPutIfMatch(t, base_url, note_path+"/notebook", token, body, etag)
+	assert.Equal(t, http.StatusPreconditionFailed, status_code)
+
+	etag, _ = callGetNoteETag(t, base_url, note_id, token, "")
+	status_code = callAuthPutIfMatch(t, base_url, note_path+"/notebook", token_other, body, etag)
+	assert.Equal(t, http.StatusUnauthorized, status_code)
+
+	status_code = callAuthDelete(t, base_url, "/notebooks/"+strconv.FormatUint(uint64(projects_id), 10), token)
+	assert.Equal(t, http.StatusOK, status_code)
+
+	contents, status_code = callGetNotebookContents(t, base_url, work_id, token)
+	assert.Equal(t, http.StatusOK, status_code)
+	assert.Equal(t, 0, len(contents.Notebooks))
+	assert.Equal(t, note_id, contents.Notes[0].Id)
+
+	// Deleting work with its contents deletes the note
+	status_code = callAuthDelete(t, base_url, "/notebooks/"+strconv.FormatUint(uint64(work_id), 10)+"?mode=delete", token)
+	assert.Equal(t, http.StatusOK, status_code)
+
+	_, status_code = callGetSingleNote(t, base_url, note_id, token)
+	assert.Equal(t, http.StatusNotFound, status_code)
+}
+
+func TestNoteRevisions(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping e2e test in short mode.")
+	}
+
+	waitForServer(t, base_url)
+
+	creds := auth.Credentials{Username: "Mallory", Password: "secret_pwd"}
+	creds_other := auth.Credentials{Username: "Niaj", Password: "secret_pwd"}
+
+	body, err := json.Marshal(creds)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token := callPost(t, base_url, "/register", body)
+	assert.True(t, len(token) > 0)
+
+	body, err = json.Marshal(creds_other)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token_other := callPost(t, base_url, "/register", body)
+	assert.True(t, len(token_other) > 0)
+
+	body, err = json.Marshal(services.Note{Title: "Groceries", Content: "milk\nbread"})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	id := callAuthPost(t, base_url, "/notes", token, body)
+	assert.True(t, id > 0)
+	note_path := "/notes/" + strconv.FormatUint(uint64(id), 10)
+
+	for _, content := range []string{"milk\nbutter", "milk\nbutter\neggs"} {
+		body, err = json.Marshal(services.Note{Title: "Groceries", Content: content})
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		etag, _ := callGetNoteETag(t, base_url, id, token, "")
+		status_code := callAuthPutIfMatch(t, base_url, note_path, token, body, etag)
+		assert.Equal(t, http.StatusOK, status_code)
+	}
+
+	var revisions services.GetNoteRevisionsResult
+	status_code := callAuthGet(t, base_url, note_path+"/revisions", token, &revisions)
+	assert.Equal(t, http.StatusOK, status_code)
+	assert.Equal(t, 3, len(revisions.Result))
+	assert.Equal(t, uint(3), revisions.Result[0].Revision)
+
+	status_code = callAuthGet(t, base_url, note_path+"/revisions", token_other, &revisions)
+	assert.Equal(t, http.StatusUnauthorized, status_code)
+
+	var revision services.NoteRevision
+	status_code = callAuthGet(t, base_url, note_path+"/revisions/2", token, &revision)
+	assert.Equal(t, http.StatusOK, status_code)
+	assert.Equal(t, "milk\nbutter", revision.Content)
+
+	var diff services.NoteRevisionDiff
+	status_code = callAuthGet(t, base_url, note_path+"/revisions/diff?from=1&to=3", token, &diff)
+	assert.Equal(t, http.StatusOK, status_code)
+	assert.Contains(t, diff.Diff, "-bread\n+butter\n+eggs\n")
+
+	// Restoring the first revision creates revision 4
+	etag, _ := callGetNoteETag(t, base_url, id, token, "")
+	restored_id := callAuthPostIfMatch(t, base_url, note_path+"/revisions/1/restore", token, nil, etag)
+	assert.Equal(t, id, restored_id)
+
+	status_code = callAuthPostStatus(t, base_url, note_path+"/revisions/1/restore", token, nil)
+	assert.Equal(t, http.StatusPreconditionRequired, status_code)
+
+	note, status_code := callGetSingleNote(t, base_url, id, token)
+	assert.Equal(t, http.StatusOK, status_code)
+	assert.Equal(t, "milk\nbread", note.Content)
+
+	status_code = callAuthGet(t, base_url, note_path+"/revisions", token, &revisions)
+	assert.Equal(t, http.StatusOK, status_code)
+	assert.Equal(t, 4, len(revisions.Result))
+}
+
+func TestTrash(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping e2e test in short mode.")
+	}
+
+	waitForServer(t, base_url)
+
+	creds := auth.Credentials{Username: "Olivia", Password: "secret_pwd"}
+	creds_other := auth.Credentials{Username: "Peggy", Password: "secret_pwd"}
+
+	body, err := json.Marshal(creds)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token := callPost(t, base_url, "/register", body)
+	assert.True(t, len(token) > 0)
+
+	body, err = json.Marshal(creds_other)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token_other := callPost(t, base_url, "/register", body)
+	assert.True(t, len(token_other) > 0)
+
+	var ids []uint
+	for _, title := range []string{"Keep", "Forget"} {
+		body, err = json.Marshal(services.Note{Title: title, Content: "Content"})
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		id := callAuthPost(t, base_url, "/notes", token, body)
+		assert.True(t, id > 0)
+		ids = append(ids, id)
+
+		status_code := callAuthDeleteIfMatch(t, base_url, "/notes/"+strconv.FormatUint(uint64(id), 10), token, `"1"`)
+		assert.Equal(t, http.StatusOK, status_code)
+	}
+
+	var trash services.GetTrashResult
+	status_code := callAuthGet(t, base_url, "/trash", token, &trash)
+	assert.Equal(t, http.StatusOK, status_code)
+	assert.Equal(t, 2, len(trash.Result))
+
+	keep_path := "/trash/" + strconv.FormatUint(uint64(ids[0]), 10)
+	forget_path := "/trash/" + strconv.FormatUint(uint64(ids[1]), 10)
+
+	// Other users can neither restore nor purge the notes
+	status_code = callAuthDelete(t, base_url, forget_path, token_other)
+	assert.Equal(t, http.StatusUnauthorized, status_code)
+
+	restored_id := callAuthPost(t, base_url, keep_path+"/restore", token, nil)
+	assert.Equal(t, ids[0], restored_id)
+
+	note, status_code := callGetSingleNote(t, base_url, ids[0], token)
+	assert.Equal(t, http.StatusOK, status_code)
+	assert.Equal(t, "Keep", note.Title)
+
+	status_code = callAuthDelete(t, base_url, forget_path, token)
+	assert.Equal(t, http.StatusOK, status_code)
+
+	status_code = callAuthDelete(t, base_url, forget_path, token)
+	assert.Equal(t, http.StatusNotFound, status_code)
+
+	status_code = callAuthGet(t, base_url, "/trash", token, &trash)
+	assert.Equal(t, http.StatusOK, status_code)
+	assert.Equal(t, 0, len(trash.Result))
+}
+
+func TestRefreshToken(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping e2e test in short mode.")
+	}
+
+	waitForServer(t, base_url)
+
+	creds := auth.Credentials{Username: "Quentin", Password: "secret_pwd"}
+	body, err := json.Marshal(creds)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tokens, status_code := callPostTokens(t, base_url, "/register", body)
+	assert.Equal(t, http.StatusOK, status_code)
+	assert.True(t, len(tokens.RefreshToken) > 0)
+
+	body, err = json.Marshal(controllers.RefreshRequest{RefreshToken: tokens.RefreshToken})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The refreshed access token can be used
+	refreshed, status_code := callPostTokens(t, base_url, "/token/refresh", body)
+	assert.Equal(t, http.StatusOK, status_code)
+	assert.NotEqual(t, tokens.RefreshToken, refreshed.RefreshToken)
+
+	notes := callGetNotes(t, base_url, refreshed.Token)
+	assert.Equal(t, 0, len(notes.Result))
+
+	// Reusing the old refresh token revokes the new one as well
+	_, status_code = callPostTokens(t, base_url, "/token/refresh", body)
+	assert.Equal(t, http.StatusUnauthorized, status_code)
+
+	body, err = json.Marshal(controllers.RefreshRequest{RefreshToken: refreshed.RefreshToken})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, status_code = callPostTokens(t, base_url, "/token/refresh", body)
+	assert.Equal(t, http.StatusUnauthorized, status_code)
+
+	// A new login starts a new family
+	body, err = json.Marshal(creds)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tokens, status_code = callPostTokens(t, base_url, "/login", body)
+	assert.Equal(t, http.StatusOK, status_code)
+
+	body, err = json.Marshal(controllers.RefreshRequest{RefreshToken: tokens.RefreshToken})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, status_code = callPostTokens(t, base_url, "/token/refresh", body)
+	assert.Equal(t, http.StatusOK, status_code)
+}
+
+func TestLogout(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping e2e test in short mode.")
+	}
+
+	waitForServer(t, base_url)
+
+	creds := auth.Credentials{Username: "Rupert", Password: "secret_pwd"}
+	body, err := json.Marshal(creds)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first, status_code := callPostTokens(t, base_url, "/register", body)
+	assert.Equal(t, http.StatusOK, status_code)
+	second, status_code := callPostTokens(t, base_url, "/login", body)
+	assert.Equal(t, http.StatusOK, status_code)
+
+	// Logging out revokes the access token and the given refresh token, but not the other login
+	logout_body, err := json.Marshal(controllers.LogoutRequest{RefreshToken: first.RefreshToken})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	status_code = callAuthPostStatus(t, base_url, "/logout", first.Token, logout_body)
+	assert.Equal(t, http.StatusOK, status_code)
+
+	var notes services.GetNotesResult
+	status_code = callAuthGet(t, base_url, "/notes", first.Token, &notes)
+	assert.Equal(t, http.StatusUnauthorized, status_code)
+
+	refresh_body, err := json.Marshal(controllers.RefreshRequest{RefreshToken: first.RefreshToken})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, status_code = callPostTokens(t, base_url, "/token/refresh", refresh_body)
+	assert.Equal(t, http.StatusUnauthorized, status_code)
+
+	status_code = callAuthGet(t, base_url, "/notes", second.Token, &notes)
+	assert.Equal(t, http.StatusOK, status_code)
+
+	// Logging out from all devices revokes every token issued so far, but not those of a new login
+	status_code = callAuthPostStatus(t, base_url, "/logout/all", second.Token, nil)
+	assert.Equal(t, http.StatusOK, status_code)
+
+	status_code = callAuthGet(t, base_url, "/notes", second.Token, &notes)
+	assert.Equal(t, http.StatusUnauthorized, status_code)
+
+	refresh_body, err = json.Marshal(controllers.RefreshRequest{RefreshToken: second.RefreshToken})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, status_code = callPostTokens(t, base_url, "/token/refresh", refresh_body)
+	assert.Equal(t, http.StatusUnauthorized, status_code)
+
+	third, status_code := callPostTokens(t, base_url, "/login", body)
+	assert.Equal(t, http.StatusOK, status_code)
+
+	status_code = callAuthGet(t, base_url, "/notes", third.Token, &notes)
+	assert.Equal(t, http.StatusOK, status_code)
+}
+
+func TestChangePassword(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping e2e test in short mode.")
+	}
+
+	waitForServer(t, base_url)
+
+	creds := auth.Credentials{Username: "Sybil", Password: "secret_pwd"}
+	body, err := json.Marshal(creds)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first, status_code := callPostTokens(t, base_url, "/register", body)
+	assert.Equal(t, http.StatusOK, status_code)
+	second, status_code := callPostTokens(t, base_url, "/login", body)
+	assert.Equal(t, http.StatusOK, status_code)
+
+	// The current password has to be correct
+	change_body, err := json.Marshal(services.PasswordChange{OldPassword: "wrong_pwd", NewPassword: "new_secret_pwd"})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, status_code = callAuthPutTokens(t, base_url, "/me/password", first.Token, change_body)
+	assert.Equal(t, http.StatusUnauthorized, status_code)
+
+	change_body, err = json.Marshal(services.PasswordChange{OldPassword: "secret_pwd", NewPassword: "new_secret_pwd"})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	changed, status_code := callAuthPutTokens(t, base_url, "/me/password", first.Token, change_body)
+	assert.Equal(t, http.StatusOK, status_code)
+
+	// Only the returned tokens remain valid
+	var notes services.GetNotesResult
+	for _, token := range []string{first.Token, second.Token} {
+		status_code = callAuthGet(t, base_url, "/notes", token, &notes)
+		assert.Equal(t, http.StatusUnauthorized, status_code)
+	}
+
+	status_code = callAuthGet(t, base_url, "/notes", changed.Token, &notes)
+	assert.Equal(t, http.StatusOK, status_code)
+
+	refresh_body, err := json.Marshal(controllers.RefreshRequest{RefreshToken: second.RefreshToken})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, status_code = callPostTokens(t, base_url, "/token/refresh", refresh_body)
+	assert.Equal(t, http.StatusUnauthorized, status_code)
+
+	// Login works with the new password only
+	_, status_code = callPostTokens(t, base_url, "/login", body)
+	assert.Equal(t, http.StatusUnauthorized, status_code)
+
+	body, err = json.Marshal(auth.Credentials{Username: "Sybil", Password: "new_secret_pwd"})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, status_code = callPostTokens(t, base_url, "/login", body)
+	assert.Equal(t, http.StatusOK, status_code)
+}
+
+func TestDeleteAccount(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping e2e test in short mode.")
+	}
+
+	waitForServer(t, base_url)
+
+	creds := auth.Credentials{Username: "Trent", Password: "secret_pwd"}
+	body, err := json.Marshal(creds)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tokens, status_code := callPostTokens(t, base_url, "/register", body)
+	assert.Equal(t, http.StatusOK, status_code)
+
+	note, err := json.Marshal(services.Note{Title: "Secret", Content: "Content", Tags: []string{"private"}})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	callAuthPost(t, base_url, "/notes", tokens.Token, note)
+
+	// The password has to be confirmed
+	deletion, err := json.Marshal(services.AccountDeletion{Password: "wrong_pwd"})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var result services.DeleteAccountResult
+	status_code = callAuthDeleteWithBody(t, base_url, "/me", tokens.Token, deletion, &result)
+	assert.Equal(t, http.StatusUnauthorized, status_code)
+
+	deletion, err = json.Marshal(services.AccountDeletion{Password: "secret_pwd"})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	status_code = callAuthDeleteWithBody(t, base_url, "/me", tokens.Token, deletion, &result)
+	assert.Equal(t, http.StatusOK, status_code)
+	assert.Equal(t, services.DeleteAccountResult{Notes: 1, Notebooks: 0, Tags: 1}, result)
+
+	// The tokens and the login are gone, but the name can be registered again
+	var notes services.GetNotesResult
+	status_code = callAuthGet(t, base_url, "/notes", tokens.Token, &notes)
+	assert.Equal(t, http.StatusUnauthorized, status_code)
+
+	_, status_code = callPostTokens(t, base_url, "/login", body)
+	assert.NotEqual(t, http.StatusOK, status_code)
+
+	tokens, status_code = callPostTokens(t, base_url, "/register", body)
+	assert.Equal(t, http.StatusOK, status_code)
+
+	status_code = callAuthGet(t, base_url, "/notes", tokens.Token, &notes)
+	assert.Equal(t, http.StatusOK, status_code)
+	assert.Equal(t, 0, len(notes.Result))
+}
+
+func TestRegisterPasswordPolicy(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping e2e test in short mode.")
+	}
+
+	waitForServer(t, base_url)
+
+	for _, password := range []string{"", "short", "password123", "Ursula-secret"} {
+		body, err := json.Marshal(auth.Credentials{Username: "Ursula", Password: password})
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, status_code := callPostTokens(t, base_url, "/register", body)
+		assert.Equal(t, http.StatusUnprocessableEntity, status_code, password)
+	}
+
+	body, err := json.Marshal(auth.Credentials{Username: "Ursula", Password: "secret_pwd"})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tokens, status_code := callPostTokens(t, base_url, "/register", body)
+	assert.Equal(t, http.StatusOK, status_code)
+
+	// Changing the password is subject to the policy as well
+	change_body, err := json.Marshal(services.PasswordChange{OldPassword: "secret_pwd", NewPassword: "short"})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, status_code = callAuthPutTokens(t, base_url, "/me/password", tokens.Token, change_body)
+	assert.Equal(t, http.StatusUnprocessableEntity, status_code)
+}
+
+func TestRegisterUsername(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping e2e test in short mode.")
+	}
+
+	waitForServer(t, base_url)
+
+	body, err := json.Marshal(auth.Credentials{Username: "Victor", Password: "secret_pwd"})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, status_code := callPostTokens(t, base_url, "/register", body)
+	assert.Equal(t, http.StatusOK, status_code)
+
+	for _, username := range []string{"Victor", "victor", "VICTOR"} {
+		body, err := json.Marshal(auth.Credentials{Username: username, Password: "secret_pwd"})
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, status_code := callPostTokens(t, base_url, "/register", body)
+		assert.Equal(t, http.StatusConflict, status_code, username)
+	}
+
+	for _, username := range []string{"Vi", "Victor Hugo", "_victor"} {
+		body, err := json.Marshal(auth.Credentials{Username: username, Password: "secret_pwd"})
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, status_code := callPostTokens(t, base_url, "/register", body)
+		assert.Equal(t, http.StatusUnprocessableEntity, status_code, username)
+	}
+
+	// Login ignores the case of the username
+	body, err = json.Marshal(auth.Credentials{Username: "vIcToR", Password: "secret_pwd"})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, status_code = callPostTokens(t, base_url, "/login", body)
+	assert.Equal(t, http.StatusOK, status_code)
+}
+
+func TestLoginThrottle(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping e2e test in short mode.")
+	}
+
+	waitForServer(t, base_url)
+
+	body, err := json.Marshal(auth.Credentials{Username: "Xavier", Password: "secret_pwd"})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, status_code := callPostTokens(t, base_url, "/register", body)
+	assert.Equal(t, http.StatusOK, status_code)
+
+	wrong_body, err := json.Marshal(auth.Credentials{Username: "Xavier", Password: "wrong_pwd"})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for range config.DefaultLoginUsernameMaxFailures {
+		status_code, _ := callLogin(t, base_url, "203.0.113.7", wrong_body)
+		assert.Equal(t, http.StatusUnauthorized, status_code)
+	}
+
+	// Even the right password is refused until the backoff is over, from any client IP
+	status_code, retry_after := callLogin(t, base_url, "203.0.113.8", body)
+	assert.Equal(t, http.StatusTooManyRequests, status_code)
+	assert.Equal(t, "1", retry_after)
+
+	time.Sleep(config.DefaultLoginBackoffBase)
+
+	status_code, _ = callLogin(t, base_url, "203.0.113.7", body)
+	assert.Equal(t, http.StatusOK, status_code)
+
+	// The successful login reset the failures
+	status_code, _ = callLogin(t, base_url, "203.0.113.7", wrong_body)
+	assert.Equal(t, http.StatusUnauthorized, status_code)
+}
+
+func TestLoginUnknownUser(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping e2e test in short mode.")
+	}
+
+	waitForServer(t, base_url)
+
+	body, err := json.Marshal(auth.Credentials{Username: "Yvonne", Password: "secret_pwd"})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, status_code := callPostTokens(t, base_url, "/register", body)
+	assert.Equal(t, http.StatusOK, status_code)
+
+	// A wrong password and an unknown user get the same response
+	for _, credentials := range []auth.Credentials{
+		{Username: "Yvonne", Password: "wrong_pwd"},
+		{Username: "Yvette", Password: "secret_pwd"},
+	} {
+		body, err := json.Marshal(credentials)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		status_code, _ := callLogin(t, base_url, "203.0.113.9", body)
+		assert.Equal(t, http.StatusUnauthorized, status_code, credentials.Username)
+	}
+}
@@ -7,16 +7,24 @@ import (
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"testing"
 	"time"
 	"user-notes-api/auth"
+	"user-notes-api/config"
 	"user-notes-api/controllers"
+	"user-notes-api/models"
+	"user-notes-api/repositories"
 	"user-notes-api/services"
+	"user-notes-api/testing/testutils"
 	"user-notes-api/testing/testutils/authmocks"
+	"user-notes-api/testing/testutils/oidcmocks"
+	"user-notes-api/testing/testutils/repositorymocks"
 
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
 )
 
 /*
@@ -25,7 +33,8 @@ import (
 */
 
 type JwtToken struct {
-	Token string `json:"token"`
+	Token        string `json:"token"`
+	RefreshToken string `json:"refresh_token"`
 }
 
 func TestAuthControllerRegistrationAndLoginSuccess(t *testing.T) {
@@ -36,8 +45,20 @@ func TestAuthControllerRegistrationAndLoginSuccess(t *testing.T) {
 	login_manager := new(authmocks.MockLoginManager)
 	registration_manager := new(authmocks.MockRegistrationManager)
 
-	login_service := services.NewLoginService(login_manager, jwt_secret)
-	registration_service := services.NewRegistrationService(registration_manager, jwt_secret)
+	refresh_token_repo := new(repositorymocks.RefreshTokenCreatorMock)
+	refresh_token_repo.On("CreateRefreshToken", mock.Anything, mock.Anything).Return(nil)
+	jwt_keys := services.NewJwtKeys(services.NewHmacJwtKey("", []byte(jwt_secret)))
+	generation_reader := new(repositorymocks.TokenRevocationReaderMock)
+	generation_reader.On("FindTokenGeneration", mock.Anything, mock.Anything).Return(uint(0), nil)
+	token_service := services.NewTokenService(nil, refresh_token_repo, nil, generation_reader, jwt_keys, 4*time.Hour, 30*24*time.Hour)
+
+	user_repo := new(repositorymocks.UserRepoMock)
+	user_repo.On("FindUserById", mock.Anything, uint(1)).Return(&models.User{Username: "Alice"}, nil)
+	mfa_service := services.NewMfaService(user_repo, nil, nil, nil, nil, nil, "User-Notes-API")
+	login_service := services.NewLoginService(login_manager, token_service,
+		services.NewLoginThrottle(services.NewMemoryLoginAttempts(), services.LoginBackoff{}, services.LoginBackoff{}, time.Hour),
+		services.NewMfaTokenService(jwt_keys, 5*time.Minute), mfa_service)
+	registration_service := services.NewRegistrationService(registration_manager, token_service)
 
 	authController := controllers.NewAuthController(login_service, registration_service)
 
@@ -66,6 +87,7 @@ func TestAuthControllerRegistrationAndLoginSuccess(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
+	assert.NotEmpty(t, ttoken.RefreshToken)
 
 	token, err := jwt.ParseWithClaims(ttoken.Token, &services.JwtClaims{}, func(token *jwt.Token) (any, error) {
 		return []byte(jwt_secret), nil
@@ -152,8 +174,20 @@ func TestAuthControllerRegistrationAndLoginFailure(t *testing.T) {
 	login_manager := new(authmocks.MockLoginManager)
 	registration_manager := new(authmocks.MockRegistrationManager)
 
-	login_service := services.NewLoginService(login_manager, jwt_secret)
-	registration_service := services.NewRegistrationService(registration_manager, jwt_secret)
+	refresh_token_repo := new(repositorymocks.RefreshTokenCreatorMock)
+	refresh_token_repo.On("CreateRefreshToken", mock.Anything, mock.Anything).Return(nil)
+	jwt_keys := services.NewJwtKeys(services.NewHmacJwtKey("", []byte(jwt_secret)))
+	generation_reader := new(repositorymocks.TokenRevocationReaderMock)
+	generation_reader.On("FindTokenGeneration", mock.Anything, mock.Anything).Return(uint(0), nil)
+	token_service := services.NewTokenService(nil, refresh_token_repo, nil, generation_reader, jwt_keys, 4*time.Hour, 30*24*time.Hour)
+
+	user_repo := new(repositorymocks.UserRepoMock)
+	user_repo.On("FindUserById", mock.Anything, uint(1)).Return(&models.User{Username: "Alice"}, nil)
+	mfa_service := services.NewMfaService(user_repo, nil, nil, nil, nil, nil, "User-Notes-API")
+	login_service := services.NewLoginService(login_manager, token_service,
+		services.NewLoginThrottle(services.NewMemoryLoginAttempts(), services.LoginBackoff{}, services.LoginBackoff{}, time.Hour),
+		services.NewMfaTokenService(jwt_keys, 5*time.Minute), mfa_service)
+	registration_service := services.NewRegistrationService(registration_manager, token_service)
 
 	authController := controllers.NewAuthController(login_service, registration_service)
 
@@ -183,7 +217,7 @@ func TestAuthControllerRegistrationAndLoginFailure(t *testing.T) {
 	authController.Login(c)
 
 	assert.Equal(t, http.StatusUnauthorized, w.Code)
-	assert.Contains(t, w.Body.String(), "wrong password")
+	assert.Contains(t, w.Body.String(), "invalid username or password")
 	login_manager.AssertExpectations(t)
 
 	body = []byte(`{"username": "Bob", "password": "secret_pwd"}`)
@@ -199,8 +233,96 @@ func TestAuthControllerRegistrationAndLoginFailure(t *testing.T) {
 
 	authController.Login(c)
 
-	assert.Equal(t, http.StatusNotFound, w.Code)
-	assert.Contains(t, w.Body.String(), "user not found")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Contains(t, w.Body.String(), "invalid username or password")
 	registration_manager.AssertExpectations(t)
 
 }
+
+func TestOidcLogin(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	fake_provider := oidcmocks.NewFakeProvider("notes", "client_secret")
+	defer fake_provider.Close()
+	fake_provider.User = oidcmocks.FakeUser{Subject: "alice-sub", PreferredUsername: "Alice", Email: "alice@example.com"}
+
+	refresh_token_repo := new(repositorymocks.RefreshTokenCreatorMock)
+	refresh_token_repo.On("CreateRefreshToken", mock.Anything, mock.Anything).Return(nil)
+	jwt_keys := services.NewJwtKeys(services.NewHmacJwtKey("", []byte("jwt_secret")))
+	generation_reader := new(repositorymocks.TokenRevocationReaderMock)
+	generation_reader.On("FindTokenGeneration", mock.Anything, mock.Anything).Return(uint(0), nil)
+	token_service := services.NewTokenService(nil, refresh_token_repo, nil, generation_reader, jwt_keys, 4*time.Hour, 30*24*time.Hour)
+
+	user_repo := new(repositorymocks.UserRepoMock)
+	user_repo.On("FindUserById", mock.Anything, uint(1)).Return(&models.User{Username: "Alice"}, nil)
+	identity_repo := new(repositorymocks.OidcIdentityRepoMock)
+	identity_repo.On("FindUserByOidcIdentity", mock.Anything, fake_provider.Issuer(), "alice-sub").
+		Return(nil, repositories.ErrOidcIdentityNotFound)
+	identity_repo.On("CreateUserWithOidcIdentity", mock.Anything, mock.Anything, fake_provider.Issuer(), "alice-sub").Return(nil)
+
+	provider := services.NewOidcProvider("corp", fake_provider.Issuer(), fake_provider.ClientId, fake_provider.ClientSecret,
+		"http://localhost:8080/auth/oidc/corp/callback", config.DefaultOidcScopes)
+	oidc_service := services.NewOidcService([]*services.OidcProvider{provider}, identity_repo, identity_repo,
+		&testutils.MockPwdHasher{}, token_service, services.NewMfaTokenService(jwt_keys, 5*time.Minute),
+		services.NewMfaService(user_repo, nil, nil, nil, nil, nil, "User-Notes-API"), jwt_keys)
+
+	oidc_controller := controllers.NewOidcController(oidc_service)
+	r := gin.New()
+	r.GET("/auth/oidc/:provider/start", oidc_controller.Start)
+	r.GET("/auth/oidc/:provider/callback", oidc_controller.Callback)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/auth/oidc/corp/start", nil)
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusFound, w.Code)
+	cookies := w.Result().Cookies()
+	assert.Len(t, cookies, 1)
+
+	// the provider logs in the user and redirects back to the callback
+	client := http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	response, err := client.Get(w.Header().Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	response.Body.Close()
+	assert.Equal(t, http.StatusFound, response.StatusCode)
+	callback, err := url.Parse(response.Header.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "/auth/oidc/corp/callback", callback.Path)
+
+	// without the state cookie, e.g. in another browser, the login fails
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", callback.RequestURI(), nil)
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", callback.RequestURI(), nil)
+	req.AddCookie(cookies[0])
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var ttoken JwtToken
+	err = json.Unmarshal(w.Body.Bytes(), &ttoken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NotEmpty(t, ttoken.RefreshToken)
+
+	claims := services.JwtClaims{}
+	_, err = jwt.ParseWithClaims(ttoken.Token, &claims, jwt_keys.Keyfunc)
+	assert.NoError(t, err)
+	assert.Equal(t, uint(1), claims.UserId)
+	assert.Equal(t, "Alice", claims.Subject)
+	assert.Equal(t, "auth.user-notes-api.local", claims.Issuer)
+	identity_repo.AssertNumberOfCalls(t, "CreateUserWithOidcIdentity", 1)
+
+	// the code was redeemed, so the callback cannot be replayed
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", callback.RequestURI(), nil)
+	req.AddCookie(cookies[0])
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
@@ -15,6 +15,10 @@ type MockRegistrationManager struct {
 	mock.Mock
 }
 
+type MockPasswordManager struct {
+	mock.Mock
+}
+
 func (m *MockLoginManager) LoginUser(ctx context.Context, credentials *auth.Credentials) (uint, bool, error) {
 	args := m.Called(ctx, credentials)
 	return uint(args.Int(0)), args.Bool(1), args.Error(2)
@@ -24,3 +28,18 @@ func (m *MockRegistrationManager) RegisterUser(ctx context.Context, credentials
 	args := m.Called(ctx, credentials)
 	return uint(args.Int(0)), args.Error(1)
 }
+
+func (m *MockPasswordManager) ChangePassword(ctx context.Context, userId uint, credentials *auth.Credentials, newPassword string) (bool, error) {
+	args := m.Called(ctx, userId, credentials, newPassword)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *MockPasswordManager) SetPassword(ctx context.Context, userId uint, username string, newPassword string) error {
+	args := m.Called(ctx, userId, username, newPassword)
+	return args.Error(0)
+}
+
+func (m *MockPasswordManager) VerifyPassword(ctx context.Context, userId uint, credentials *auth.Credentials) (bool, error) {
+	args := m.Called(ctx, userId, credentials)
+	return args.Bool(0), args.Error(1)
+}
@@ -1,11 +1,12 @@
 package testutils
 
 import (
-	"bytes"
 	"context"
+	"crypto/subtle"
 	"errors"
 
 	"user-notes-api/models"
+	"user-notes-api/repositories"
 	"user-notes-api/utils"
 
 	"gorm.io/gorm"
@@ -17,7 +18,11 @@ type MockUserCreatorReader struct {
 }
 
 type MockPwdHasher struct {
-	Hash []byte
+	// Rehash is returned by NeedsRehash.
+	Rehash bool
+	// Hashes and Verifications count the calls of Hash and Verify.
+	Hashes        int
+	Verifications int
 }
 
 func (m *MockUserCreatorReader) CreateUser(ctx context.Context, user *models.User) error {
@@ -34,8 +39,7 @@ func (m *MockUserCreatorReader) CreateUser(ctx context.Context, user *models.Use
 
 func (m *MockUserCreatorReader) CreateUserByNameAndPassword(ctx context.Context, username string, password string) (*models.User, error) {
 	if m.Registered {
-		msg := "username " + username + " already exists"
-		return nil, errors.New(msg)
+		return nil, &repositories.ErrorUsernameTaken{Username: username}
 	}
 
 	m.User = &models.User{Username: username, Password: password, Model: gorm.Model{ID: 1}}
@@ -44,7 +48,10 @@ func (m *MockUserCreatorReader) CreateUserByNameAndPassword(ctx context.Context,
 }
 
 func (m *MockUserCreatorReader) FindUserById(ctx context.Context, id uint) (*models.User, error) {
-	return nil, errors.New("function not supported")
+	if id == m.User.ID && m.Registered {
+		return m.User, nil
+	}
+	return nil, errors.New("wrong user")
 }
 
 func (m *MockUserCreatorReader) FindUserByName(ctx context.Context, username string) (*models.User, error) {
@@ -54,13 +61,38 @@ func (m *MockUserCreatorReader) FindUserByName(ctx context.Context, username str
 	return nil, errors.New("wrong user")
 }
 
-func (m *MockPwdHasher) GenerateHash(password, salt []byte) ([]byte, error) {
-	return password, nil
+func (m *MockUserCreatorReader) UpdatePassword(ctx context.Context, userId uint, password string) error {
+	if !m.Registered || m.User.ID != userId {
+		return errors.New("wrong user")
+	}
+	m.User.Password = password
+	return nil
+}
+
+func (m *MockUserCreatorReader) RehashPassword(ctx context.Context, userId uint, oldPassword string, password string) error {
+	if !m.Registered || m.User.ID != userId {
+		return errors.New("wrong user")
+	}
+	if m.User.Password != oldPassword {
+		return repositories.ErrPasswordChanged
+	}
+	m.User.Password = password
+	return nil
 }
-func (m *MockPwdHasher) GenerateSalt() ([]byte, error) {
-	salt := []byte("random_salt")
-	return salt, nil
+
+// Hash stores the password itself as hash.
+func (m *MockPwdHasher) Hash(password []byte) (string, error) {
+	m.Hashes++
+	return utils.EncodeHashString(&utils.ParsedHashString{Id: "mock", Hash: password, Salt: []byte("random_salt")})
+}
+func (m *MockPwdHasher) Verify(hash_string string, password []byte) (bool, error) {
+	m.Verifications++
+	ph, err := utils.ParseHashString(hash_string)
+	if err != nil {
+		return false, err
+	}
+	return subtle.ConstantTimeCompare(ph.Hash, password) == 1, nil
 }
-func (m *MockPwdHasher) Compare(hash, salt, password []byte) (bool, error) {
-	return bytes.Equal(hash, password), nil
+func (m *MockPwdHasher) NeedsRehash(hash_string string) bool {
+	return m.Rehash
 }
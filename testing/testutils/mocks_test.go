@@ -13,20 +13,14 @@ func TestMocks(t *testing.T) {
 
 	var pwd_hasher MockPwdHasher
 
-	salt, err := pwd_hasher.GenerateSalt()
+	hash_string, err := pwd_hasher.Hash(password)
 	assert.NoError(t, err)
 
-	hash, err := pwd_hasher.GenerateHash(password, salt)
-	assert.NoError(t, err)
-
-	isValid, err := pwd_hasher.Compare(hash, salt, password)
+	isValid, err := pwd_hasher.Verify(hash_string, password)
 	assert.NoError(t, err)
 	assert.True(t, isValid)
 
-	hash, err = pwd_hasher.GenerateHash(wrong_pwd, salt)
-	assert.NoError(t, err)
-
-	isValid, err = pwd_hasher.Compare(hash, salt, password)
+	isValid, err = pwd_hasher.Verify(hash_string, wrong_pwd)
 	assert.NoError(t, err)
 	assert.False(t, isValid)
 
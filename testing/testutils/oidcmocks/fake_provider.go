@@ -0,0 +1,225 @@
+package oidcmocks
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// FakeProvider is an in-process OpenID Connect provider for tests. It serves discovery, authorization,
+// token and JWKS endpoints for a single client like a real provider, checks PKCE and signs ID tokens with
+// RS256. The authorization endpoint logs in User without asking, so a test can follow its redirect to the
+// callback of the API.
+type FakeProvider struct {
+	Server       *httptest.Server
+	ClientId     string
+	ClientSecret string
+	Kid          string
+
+	// User is the account that logs in at the authorization endpoint.
+	User FakeUser
+	// ModifyIdToken, if set, changes the claims of every ID token before it is signed, e.g. to test that
+	// invalid tokens are rejected.
+	ModifyIdToken func(claims jwt.MapClaims)
+
+	key   *rsa.PrivateKey
+	mutex sync.Mutex
+	codes map[string]fakeAuthorization
+}
+
+// FakeUser is an account at the FakeProvider.
+type FakeUser struct {
+	Subject           string
+	PreferredUsername string
+	Email             string
+}
+
+// fakeAuthorization is what the authorization endpoint remembers about a code until it is redeemed.
+type fakeAuthorization struct {
+	User          FakeUser
+	RedirectUri   string
+	Nonce         string
+	CodeChallenge string
+	ExpiresAt     time.Time
+}
+
+// NewFakeProvider starts a provider with one client. Without a client secret, the client is a public
+// client. The provider has to be closed after the test.
+func NewFakeProvider(client_id string, client_secret string) *FakeProvider {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		panic(err)
+	}
+
+	provider := FakeProvider{ClientId: client_id, ClientSecret: client_secret, Kid: "fake-key", key: key,
+		codes: map[string]fakeAuthorization{}}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", provider.discovery)
+	mux.HandleFunc("GET /authorize", provider.authorize)
+	mux.HandleFunc("POST /token", provider.token)
+	mux.HandleFunc("GET /jwks", provider.jwks)
+	provider.Server = httptest.NewServer(mux)
+	return &provider
+}
+
+// Issuer returns the issuer of the provider, the URL of its server.
+func (p *FakeProvider) Issuer() string {
+	return p.Server.URL
+}
+
+func (p *FakeProvider) Close() {
+	p.Server.Close()
+}
+
+// SignIdToken signs the claims with the key of the provider, like the ID tokens of the token endpoint.
+func (p *FakeProvider) SignIdToken(claims jwt.MapClaims) string {
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = p.Kid
+	signed, err := token.SignedString(p.key)
+	if err != nil {
+		panic(err)
+	}
+	return signed
+}
+
+func (p *FakeProvider) discovery(w http.ResponseWriter, r *http.Request) {
+	writeJson(w, http.StatusOK, map[string]any{
+		"issuer":                                p.Issuer(),
+		"authorization_endpoint":                p.Issuer() + "/authorize",
+		"token_endpoint":                        p.Issuer() + "/token",
+		"jwks_uri":                              p.Issuer() + "/jwks",
+		"response_types_supported":              []string{"code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+		"code_challenge_methods_supported":      []string{"S256"},
+	})
+}
+
+// authorize logs in User and redirects to the redirect URI with a code, or with an error if the request
+// is invalid.
+func (p *FakeProvider) authorize(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	redirect_uri := query.Get("redirect_uri")
+	if query.Get("client_id") != p.ClientId || redirect_uri == "" {
+		http.Error(w, "unknown client or redirect_uri", http.StatusBadRequest)
+		return
+	}
+
+	redirect, err := url.Parse(redirect_uri)
+	if err != nil {
+		http.Error(w, "invalid redirect_uri", http.StatusBadRequest)
+		return
+	}
+	redirect_query := redirect.Query()
+	redirect_query.Set("state", query.Get("state"))
+
+	scopes := strings.Fields(query.Get("scope"))
+	if query.Get("response_type") != "code" || !slices.Contains(scopes, "openid") ||
+		query.Get("code_challenge_method") != "S256" || query.Get("code_challenge") == "" {
+		redirect_query.Set("error", "invalid_request")
+		redirect_query.Set("error_description", "expected a code request for openid with an S256 code challenge")
+	} else {
+		code := rand.Text()
+		p.mutex.Lock()
+		p.codes[code] = fakeAuthorization{User: p.User, RedirectUri: redirect_uri, Nonce: query.Get("nonce"),
+			CodeChallenge: query.Get("code_challenge"), ExpiresAt: time.Now().Add(time.Minute)}
+		p.mutex.Unlock()
+		redirect_query.Set("code", code)
+	}
+
+	redirect.RawQuery = redirect_query.Encode()
+	http.Redirect(w, r, redirect.String(), http.StatusFound)
+}
+
+// token redeems a code once, if the client, the redirect URI and the code verifier match its authorization.
+func (p *FakeProvider) token(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		writeJson(w, http.StatusBadRequest, map[string]string{"error": "invalid_request"})
+		return
+	}
+
+	client_id, client_secret, found := r.BasicAuth()
+	if found {
+		client_id, _ = url.QueryUnescape(client_id)
+		client_secret, _ = url.QueryUnescape(client_secret)
+	} else {
+		client_id = r.PostForm.Get("client_id")
+		client_secret = r.PostForm.Get("client_secret")
+	}
+	if client_id != p.ClientId || client_secret != p.ClientSecret {
+		writeJson(w, http.StatusUnauthorized, map[string]string{"error": "invalid_client"})
+		return
+	}
+
+	code := r.PostForm.Get("code")
+	p.mutex.Lock()
+	authorization, found := p.codes[code]
+	delete(p.codes, code)
+	p.mutex.Unlock()
+
+	challenge := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	if r.PostForm.Get("grant_type") != "authorization_code" || !found || time.Now().After(authorization.ExpiresAt) ||
+		authorization.RedirectUri != r.PostForm.Get("redirect_uri") ||
+		base64.RawURLEncoding.EncodeToString(challenge[:]) != authorization.CodeChallenge {
+		writeJson(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	now := time.Now()
+	claims := jwt.MapClaims{
+		"iss":   p.Issuer(),
+		"sub":   authorization.User.Subject,
+		"aud":   p.ClientId,
+		"iat":   now.Unix(),
+		"exp":   now.Add(5 * time.Minute).Unix(),
+		"nonce": authorization.Nonce,
+	}
+	if authorization.User.PreferredUsername != "" {
+		claims["preferred_username"] = authorization.User.PreferredUsername
+	}
+	if authorization.User.Email != "" {
+		claims["email"] = authorization.User.Email
+	}
+	if p.ModifyIdToken != nil {
+		p.ModifyIdToken(claims)
+	}
+
+	writeJson(w, http.StatusOK, map[string]any{
+		"access_token": rand.Text(),
+		"token_type":   "Bearer",
+		"expires_in":   300,
+		"id_token":     p.SignIdToken(claims),
+	})
+}
+
+func (p *FakeProvider) jwks(w http.ResponseWriter, r *http.Request) {
+	public_key := p.key.PublicKey
+	writeJson(w, http.StatusOK, map[string]any{"keys": []map[string]string{{
+		"kty": "RSA",
+		"use": "sig",
+		"alg": "RS256",
+		"kid": p.Kid,
+		"n":   base64.RawURLEncoding.EncodeToString(public_key.N.Bytes()),
+		"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public_key.E)).Bytes()),
+	}}})
+}
+
+func writeJson(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
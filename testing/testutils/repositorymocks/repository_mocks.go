@@ -2,17 +2,95 @@ package repositorymocks
 
 import (
 	"context"
+	"time"
 
 	"user-notes-api/models"
+	"user-notes-api/repositories"
 
 	"github.com/stretchr/testify/mock"
 )
 
-type NoteCreatorMock struct {
+type NoteReaderMock struct {
 	mock.Mock
 }
 
-type NoteReaderMock struct {
+type NoteUpdaterMock struct {
+	mock.Mock
+}
+
+type NoteDeleterMock struct {
+	mock.Mock
+}
+
+type TagReaderMock struct {
+	mock.Mock
+}
+
+type TagCreatorMock struct {
+	mock.Mock
+}
+
+type TagUpdaterMock struct {
+	mock.Mock
+}
+
+type TagDeleterMock struct {
+	mock.Mock
+}
+
+type NotebookReaderMock struct {
+	mock.Mock
+}
+
+type NotebookCreatorMock struct {
+	mock.Mock
+}
+
+type NotebookUpdaterMock struct {
+	mock.Mock
+}
+
+type NotebookDeleterMock struct {
+	mock.Mock
+}
+
+type NoteRevisionReaderMock struct {
+	mock.Mock
+}
+
+type NoteRevisionCreatorMock struct {
+	mock.Mock
+}
+
+type NoteTrashReaderMock struct {
+	mock.Mock
+}
+
+type NoteRestorerMock struct {
+	mock.Mock
+}
+
+type NotePurgerMock struct {
+	mock.Mock
+}
+
+type RefreshTokenReaderMock struct {
+	mock.Mock
+}
+
+type RefreshTokenCreatorMock struct {
+	mock.Mock
+}
+
+type RefreshTokenUpdaterMock struct {
+	mock.Mock
+}
+
+type TokenRevocationReaderMock struct {
+	mock.Mock
+}
+
+type TokenRevokerMock struct {
 	mock.Mock
 }
 
@@ -20,6 +98,18 @@ type UserRepoMock struct {
 	mock.Mock
 }
 
+type PersonalAccessTokenRepoMock struct {
+	mock.Mock
+}
+
+type MfaRepoMock struct {
+	mock.Mock
+}
+
+type OidcIdentityRepoMock struct {
+	mock.Mock
+}
+
 func (m *NoteReaderMock) FindNoteById(ctx context.Context, id uint) (*models.Note, error) {
 	args := m.Called(ctx, id)
 	return args.Get(0).(*models.Note), args.Error(1)
@@ -29,11 +119,116 @@ func (m *NoteReaderMock) FindNotesByUserId(ctx context.Context, userId uint) (*[
 	return args.Get(0).(*[]models.Note), args.Error(1)
 }
 
-func (m *NoteCreatorMock) CreateNote(ctx context.Context, note *models.Note) error {
+func (m *NoteReaderMock) FindNotesPage(ctx context.Context, query repositories.NotePageQuery) (*[]models.Note, error) {
+	args := m.Called(ctx, query)
+	return args.Get(0).(*[]models.Note), args.Error(1)
+}
+
+func (m *NoteReaderMock) SearchNotes(ctx context.Context, userId uint, query string, limit int) (*[]repositories.NoteSearchHit, error) {
+	args := m.Called(ctx, userId, query, limit)
+	return args.Get(0).(*[]repositories.NoteSearchHit), args.Error(1)
+}
+
+func (m *NoteUpdaterMock) MoveNote(ctx context.Context, note *models.Note, notebookId *uint) error {
+	args := m.Called(ctx, note, notebookId)
+	return args.Error(0)
+}
+
+func (m *NoteDeleterMock) DeleteNote(ctx context.Context, note *models.Note) error {
 	args := m.Called(ctx, note)
 	return args.Error(0)
 }
 
+func (m *TagReaderMock) FindTagById(ctx context.Context, id uint) (*models.Tag, error) {
+	args := m.Called(ctx, id)
+	return args.Get(0).(*models.Tag), args.Error(1)
+}
+
+func (m *TagReaderMock) FindTagByName(ctx context.Context, userId uint, name string) (*models.Tag, error) {
+	args := m.Called(ctx, userId, name)
+	return args.Get(0).(*models.Tag), args.Error(1)
+}
+
+func (m *TagReaderMock) FindTagsWithNoteCount(ctx context.Context, userId uint) (*[]repositories.TagWithNoteCount, error) {
+	args := m.Called(ctx, userId)
+	return args.Get(0).(*[]repositories.TagWithNoteCount), args.Error(1)
+}
+
+func (m *TagCreatorMock) FindOrCreateTags(ctx context.Context, userId uint, names []string) (*[]models.Tag, error) {
+	args := m.Called(ctx, userId, names)
+	return args.Get(0).(*[]models.Tag), args.Error(1)
+}
+
+func (m *TagUpdaterMock) RenameTag(ctx context.Context, id uint, name string) error {
+	args := m.Called(ctx, id, name)
+	return args.Error(0)
+}
+
+func (m *TagUpdaterMock) MergeTags(ctx context.Context, sourceId uint, targetId uint) error {
+	args := m.Called(ctx, sourceId, targetId)
+	return args.Error(0)
+}
+
+func (m *TagDeleterMock) DeleteTagById(ctx context.Context, id uint) error {
+	args := m.Called(ctx, id)
+	return args.Error(0)
+}
+
+func (m *NotebookReaderMock) FindNotebookById(ctx context.Context, id uint) (*models.Notebook, error) {
+	args := m.Called(ctx, id)
+	return args.Get(0).(*models.Notebook), args.Error(1)
+}
+
+func (m *NotebookReaderMock) FindNotebooksByUserId(ctx context.Context, userId uint) (*[]models.Notebook, error) {
+	args := m.Called(ctx, userId)
+	return args.Get(0).(*[]models.Notebook), args.Error(1)
+}
+
+func (m *NotebookReaderMock) FindNotebookTree(ctx context.Context, id uint) (*[]models.Notebook, error) {
+	args := m.Called(ctx, id)
+	return args.Get(0).(*[]models.Notebook), args.Error(1)
+}
+
+func (m *NotebookReaderMock) FindNotesInNotebooks(ctx context.Context, ids []uint) (*[]models.Note, error) {
+	args := m.Called(ctx, ids)
+	return args.Get(0).(*[]models.Note), args.Error(1)
+}
+
+func (m *NotebookCreatorMock) CreateNotebook(ctx context.Context, notebook *models.Notebook) error {
+	args := m.Called(ctx, notebook)
+	return args.Error(0)
+}
+
+func (m *NotebookUpdaterMock) UpdateNotebook(ctx context.Context, notebook *models.Notebook) error {
+	args := m.Called(ctx, notebook)
+	return args.Error(0)
+}
+
+func (m *NotebookDeleterMock) DeleteNotebookById(ctx context.Context, id uint, mode repositories.NotebookDeleteMode) error {
+	args := m.Called(ctx, id, mode)
+	return args.Error(0)
+}
+
+func (m *NoteRevisionReaderMock) FindRevisionsByNoteId(ctx context.Context, noteId uint) (*[]models.NoteRevision, error) {
+	args := m.Called(ctx, noteId)
+	return args.Get(0).(*[]models.NoteRevision), args.Error(1)
+}
+
+func (m *NoteRevisionReaderMock) FindRevision(ctx context.Context, noteId uint, revision uint) (*models.NoteRevision, error) {
+	args := m.Called(ctx, noteId, revision)
+	return args.Get(0).(*models.NoteRevision), args.Error(1)
+}
+
+func (m *NoteRevisionCreatorMock) CreateNoteWithRevision(ctx context.Context, note *models.Note, authorId uint) (uint, error) {
+	args := m.Called(ctx, note, authorId)
+	return args.Get(0).(uint), args.Error(1)
+}
+
+func (m *NoteRevisionCreatorMock) UpdateNoteWithRevision(ctx context.Context, note *models.Note, authorId uint) (uint, error) {
+	args := m.Called(ctx, note, authorId)
+	return args.Get(0).(uint), args.Error(1)
+}
+
 func (m *UserRepoMock) FindUserById(ctx context.Context, id uint) (*models.User, error) {
 	args := m.Called(ctx, id)
 	return args.Get(0).(*models.User), args.Error(1)
@@ -42,3 +237,153 @@ func (m *UserRepoMock) FindUserByName(ctx context.Context, username string) (*mo
 	args := m.Called(ctx, username)
 	return args.Get(0).(*models.User), args.Error(1)
 }
+
+func (m *UserRepoMock) DeleteUserById(ctx context.Context, id uint) (repositories.UserDeletion, error) {
+	args := m.Called(ctx, id)
+	return args.Get(0).(repositories.UserDeletion), args.Error(1)
+}
+
+func (m *NoteTrashReaderMock) FindDeletedNotesByUserId(ctx context.Context, userId uint) (*[]models.Note, error) {
+	args := m.Called(ctx, userId)
+	return args.Get(0).(*[]models.Note), args.Error(1)
+}
+
+func (m *NoteTrashReaderMock) FindDeletedNoteById(ctx context.Context, id uint) (*models.Note, error) {
+	args := m.Called(ctx, id)
+	return args.Get(0).(*models.Note), args.Error(1)
+}
+
+func (m *NoteRestorerMock) RestoreNoteById(ctx context.Context, id uint) error {
+	args := m.Called(ctx, id)
+	return args.Error(0)
+}
+
+func (m *NotePurgerMock) PurgeNoteById(ctx context.Context, id uint) error {
+	args := m.Called(ctx, id)
+	return args.Error(0)
+}
+
+func (m *NotePurgerMock) PurgeNotesDeletedBefore(ctx context.Context, before time.Time) (int, error) {
+	args := m.Called(ctx, before)
+	return args.Int(0), args.Error(1)
+}
+
+func (m *RefreshTokenReaderMock) FindRefreshTokenByHash(ctx context.Context, tokenHash string) (*models.RefreshToken, error) {
+	args := m.Called(ctx, tokenHash)
+	return args.Get(0).(*models.RefreshToken), args.Error(1)
+}
+
+func (m *RefreshTokenCreatorMock) CreateRefreshToken(ctx context.Context, token *models.RefreshToken) error {
+	args := m.Called(ctx, token)
+	return args.Error(0)
+}
+
+func (m *RefreshTokenUpdaterMock) RotateRefreshToken(ctx context.Context, oldId uint, token *models.RefreshToken) error {
+	args := m.Called(ctx, oldId, token)
+	return args.Error(0)
+}
+
+func (m *RefreshTokenUpdaterMock) RevokeRefreshTokenFamily(ctx context.Context, familyId string) error {
+	args := m.Called(ctx, familyId)
+	return args.Error(0)
+}
+
+func (m *TokenRevocationReaderMock) IsTokenRevoked(ctx context.Context, jti string) (bool, error) {
+	args := m.Called(ctx, jti)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *TokenRevocationReaderMock) FindTokenGeneration(ctx context.Context, userId uint) (uint, error) {
+	args := m.Called(ctx, userId)
+	return args.Get(0).(uint), args.Error(1)
+}
+
+func (m *TokenRevokerMock) RevokeToken(ctx context.Context, token *models.RevokedToken) error {
+	args := m.Called(ctx, token)
+	return args.Error(0)
+}
+
+func (m *TokenRevokerMock) RevokeAllTokens(ctx context.Context, userId uint, revokedAt time.Time) error {
+	args := m.Called(ctx, userId, revokedAt)
+	return args.Error(0)
+}
+
+func (m *TokenRevokerMock) DeleteExpiredRevokedTokens(ctx context.Context, now time.Time) (int, error) {
+	args := m.Called(ctx, now)
+	return args.Int(0), args.Error(1)
+}
+
+func (m *PersonalAccessTokenRepoMock) FindPersonalAccessTokenByHash(ctx context.Context, tokenHash string) (*models.PersonalAccessToken, error) {
+	args := m.Called(ctx, tokenHash)
+	return args.Get(0).(*models.PersonalAccessToken), args.Error(1)
+}
+
+func (m *PersonalAccessTokenRepoMock) FindPersonalAccessTokens(ctx context.Context, userId uint) ([]models.PersonalAccessToken, error) {
+	args := m.Called(ctx, userId)
+	return args.Get(0).([]models.PersonalAccessToken), args.Error(1)
+}
+
+func (m *PersonalAccessTokenRepoMock) CreatePersonalAccessToken(ctx context.Context, token *models.PersonalAccessToken) error {
+	args := m.Called(ctx, token)
+	return args.Error(0)
+}
+
+func (m *PersonalAccessTokenRepoMock) TouchPersonalAccessToken(ctx context.Context, id uint, usedAt time.Time) error {
+	args := m.Called(ctx, id, usedAt)
+	return args.Error(0)
+}
+
+func (m *PersonalAccessTokenRepoMock) DeletePersonalAccessToken(ctx context.Context, userId uint, id uint) error {
+	args := m.Called(ctx, userId, id)
+	return args.Error(0)
+}
+
+func (m *MfaRepoMock) CountRecoveryCodes(ctx context.Context, userId uint) (int, error) {
+	args := m.Called(ctx, userId)
+	return args.Int(0), args.Error(1)
+}
+
+func (m *MfaRepoMock) StartTotpEnrollment(ctx context.Context, userId uint, secret string) error {
+	args := m.Called(ctx, userId, secret)
+	return args.Error(0)
+}
+
+func (m *MfaRepoMock) EnableTotp(ctx context.Context, userId uint, counter int64, codeHashes []string) error {
+	args := m.Called(ctx, userId, counter, codeHashes)
+	return args.Error(0)
+}
+
+func (m *MfaRepoMock) DisableTotp(ctx context.Context, userId uint) error {
+	args := m.Called(ctx, userId)
+	return args.Error(0)
+}
+
+func (m *MfaRepoMock) UseTotpCounter(ctx context.Context, userId uint, counter int64) error {
+	args := m.Called(ctx, userId, counter)
+	return args.Error(0)
+}
+
+func (m *MfaRepoMock) UseRecoveryCode(ctx context.Context, userId uint, codeHash string) error {
+	args := m.Called(ctx, userId, codeHash)
+	return args.Error(0)
+}
+
+func (m *MfaRepoMock) ReplaceRecoveryCodes(ctx context.Context, userId uint, codeHashes []string) error {
+	args := m.Called(ctx, userId, codeHashes)
+	return args.Error(0)
+}
+
+func (m *OidcIdentityRepoMock) FindUserByOidcIdentity(ctx context.Context, issuer string, subject string) (*models.User, error) {
+	args := m.Called(ctx, issuer, subject)
+	user, _ := args.Get(0).(*models.User)
+	return user, args.Error(1)
+}
+
+// CreateUserWithOidcIdentity sets the ID of the user to 1 unless it fails.
+func (m *OidcIdentityRepoMock) CreateUserWithOidcIdentity(ctx context.Context, user *models.User, issuer string, subject string) error {
+	args := m.Called(ctx, user, issuer, subject)
+	if args.Error(0) == nil {
+		user.ID = 1
+	}
+	return args.Error(0)
+}
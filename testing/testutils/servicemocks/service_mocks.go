@@ -2,6 +2,7 @@ package servicemocks
 
 import (
 	"context"
+	"time"
 
 	"user-notes-api/auth"
 	"user-notes-api/services"
@@ -25,18 +26,67 @@ type MockNoteReaderService struct {
 	mock.Mock
 }
 
-func (m *MockLoginService) Login(ctx context.Context, credentials auth.Credentials) (string, error) {
-	args := m.Called(ctx, credentials)
-	return args.String(0), args.Error(1)
+type MockTagService struct {
+	mock.Mock
+}
+
+type MockNotebookService struct {
+	mock.Mock
+}
+
+type MockNoteRevisionService struct {
+	mock.Mock
+}
+
+type MockTrashService struct {
+	mock.Mock
+}
+
+type MockTokenService struct {
+	mock.Mock
 }
 
-func (m *MockRegistrationService) Register(ctx context.Context, credentials auth.Credentials) (string, error) {
+type MockLogoutService struct {
+	mock.Mock
+}
+
+type MockTokenRevocationChecker struct {
+	mock.Mock
+}
+
+type MockAccountService struct {
+	mock.Mock
+}
+
+type MockPersonalAccessTokenService struct {
+	mock.Mock
+}
+
+type MockMfaService struct {
+	mock.Mock
+}
+
+type MockOidcService struct {
+	mock.Mock
+}
+
+func (m *MockLoginService) Login(ctx context.Context, credentials auth.Credentials, clientIp string) (services.LoginResult, error) {
+	args := m.Called(ctx, credentials, clientIp)
+	return args.Get(0).(services.LoginResult), args.Error(1)
+}
+
+func (m *MockLoginService) VerifyMfa(ctx context.Context, verification services.MfaVerification, clientIp string) (services.AuthTokens, error) {
+	args := m.Called(ctx, verification, clientIp)
+	return args.Get(0).(services.AuthTokens), args.Error(1)
+}
+
+func (m *MockRegistrationService) Register(ctx context.Context, credentials auth.Credentials) (services.AuthTokens, error) {
 	args := m.Called(ctx, credentials)
-	return args.String(0), args.Error(1)
+	return args.Get(0).(services.AuthTokens), args.Error(1)
 }
 
-func (m *MockNoteReaderService) GetNotes(ctx context.Context, userId uint) (services.GetNotesResult, error) {
-	args := m.Called(ctx, userId)
+func (m *MockNoteReaderService) GetNotes(ctx context.Context, userId uint, options services.NoteListOptions) (services.GetNotesResult, error) {
+	args := m.Called(ctx, userId, options)
 	return args.Get(0).(services.GetNotesResult), args.Error(1)
 }
 
@@ -45,15 +95,204 @@ func (m *MockNoteReaderService) GetNote(ctx context.Context, noteId uint, userId
 	return args.Get(0).(services.Note), args.Error(1)
 }
 
+func (m *MockNoteReaderService) SearchNotes(ctx context.Context, userId uint, options services.NoteSearchOptions) (services.SearchNotesResult, error) {
+	args := m.Called(ctx, userId, options)
+	return args.Get(0).(services.SearchNotesResult), args.Error(1)
+}
+
 func (m *MockNoteModificationService) CreateNote(ctx context.Context, note services.Note, username string) (uint, error) {
 	args := m.Called(ctx, note, username)
 	return uint(args.Int(0)), args.Error(1)
 }
-func (m *MockNoteModificationService) UpdateNote(ctx context.Context, id uint, note services.Note) error {
-	args := m.Called(ctx, id, note)
+func (m *MockNoteModificationService) UpdateNote(ctx context.Context, noteId uint, userId uint, version uint, note services.Note) (uint, error) {
+	args := m.Called(ctx, noteId, userId, version, note)
+	return uint(args.Int(0)), args.Error(1)
+}
+func (m *MockNoteModificationService) DeleteNote(ctx context.Context, noteId uint, userId uint, version uint) error {
+	args := m.Called(ctx, noteId, userId, version)
+	return args.Error(0)
+}
+func (m *MockNoteModificationService) MoveNote(ctx context.Context, noteId uint, userId uint, version uint, notebookId *uint) (uint, error) {
+	args := m.Called(ctx, noteId, userId, version, notebookId)
+	return uint(args.Int(0)), args.Error(1)
+}
+
+func (m *MockTagService) GetTags(ctx context.Context, userId uint) (services.GetTagsResult, error) {
+	args := m.Called(ctx, userId)
+	return args.Get(0).(services.GetTagsResult), args.Error(1)
+}
+
+func (m *MockTagService) RenameTag(ctx context.Context, tagId uint, userId uint, name string) error {
+	args := m.Called(ctx, tagId, userId, name)
+	return args.Error(0)
+}
+
+func (m *MockTagService) MergeTags(ctx context.Context, sourceId uint, targetId uint, userId uint) error {
+	args := m.Called(ctx, sourceId, targetId, userId)
+	return args.Error(0)
+}
+
+func (m *MockTagService) DeleteTag(ctx context.Context, tagId uint, userId uint) error {
+	args := m.Called(ctx, tagId, userId)
+	return args.Error(0)
+}
+
+func (m *MockNotebookService) CreateNotebook(ctx context.Context, notebook services.Notebook, userId uint) (uint, error) {
+	args := m.Called(ctx, notebook, userId)
+	return uint(args.Int(0)), args.Error(1)
+}
+
+func (m *MockNotebookService) GetNotebooks(ctx context.Context, userId uint) (services.GetNotebooksResult, error) {
+	args := m.Called(ctx, userId)
+	return args.Get(0).(services.GetNotebooksResult), args.Error(1)
+}
+
+func (m *MockNotebookService) GetNotebookContents(ctx context.Context, notebookId uint, userId uint) (services.NotebookContents, error) {
+	args := m.Called(ctx, notebookId, userId)
+	return args.Get(0).(services.NotebookContents), args.Error(1)
+}
+
+func (m *MockNotebookService) UpdateNotebook(ctx context.Context, notebookId uint, userId uint, notebook services.Notebook) error {
+	args := m.Called(ctx, notebookId, userId, notebook)
 	return args.Error(0)
 }
-func (m *MockNoteModificationService) DeleteNote(ctx context.Context, id uint) error {
-	args := m.Called(ctx, id)
+
+func (m *MockNotebookService) DeleteNotebook(ctx context.Context, notebookId uint, userId uint, mode string) error {
+	args := m.Called(ctx, notebookId, userId, mode)
 	return args.Error(0)
 }
+
+func (m *MockNoteRevisionService) GetRevisions(ctx context.Context, noteId uint, userId uint) (services.GetNoteRevisionsResult, error) {
+	args := m.Called(ctx, noteId, userId)
+	return args.Get(0).(services.GetNoteRevisionsResult), args.Error(1)
+}
+
+func (m *MockNoteRevisionService) GetRevision(ctx context.Context, noteId uint, revision uint, userId uint) (services.NoteRevision, error) {
+	args := m.Called(ctx, noteId, revision, userId)
+	return args.Get(0).(services.NoteRevision), args.Error(1)
+}
+
+func (m *MockNoteRevisionService) DiffRevisions(ctx context.Context, noteId uint, from uint, to uint, userId uint) (services.NoteRevisionDiff, error) {
+	args := m.Called(ctx, noteId, from, to, userId)
+	return args.Get(0).(services.NoteRevisionDiff), args.Error(1)
+}
+
+func (m *MockNoteRevisionService) RestoreRevision(ctx context.Context, noteId uint, revision uint, userId uint, version uint) (uint, error) {
+	args := m.Called(ctx, noteId, revision, userId, version)
+	return uint(args.Int(0)), args.Error(1)
+}
+
+func (m *MockTrashService) GetTrash(ctx context.Context, userId uint) (services.GetTrashResult, error) {
+	args := m.Called(ctx, userId)
+	return args.Get(0).(services.GetTrashResult), args.Error(1)
+}
+
+func (m *MockTrashService) RestoreNote(ctx context.Context, noteId uint, userId uint) error {
+	args := m.Called(ctx, noteId, userId)
+	return args.Error(0)
+}
+
+func (m *MockTrashService) PurgeNote(ctx context.Context, noteId uint, userId uint) error {
+	args := m.Called(ctx, noteId, userId)
+	return args.Error(0)
+}
+
+func (m *MockTokenService) IssueTokens(ctx context.Context, userId uint, username string) (services.AuthTokens, error) {
+	args := m.Called(ctx, userId, username)
+	return args.Get(0).(services.AuthTokens), args.Error(1)
+}
+
+func (m *MockTokenService) Refresh(ctx context.Context, refreshToken string) (services.AuthTokens, error) {
+	args := m.Called(ctx, refreshToken)
+	return args.Get(0).(services.AuthTokens), args.Error(1)
+}
+
+func (m *MockLogoutService) Logout(ctx context.Context, userId uint, jti string, expiresAt time.Time, refreshToken string) error {
+	args := m.Called(ctx, userId, jti, expiresAt, refreshToken)
+	return args.Error(0)
+}
+
+func (m *MockLogoutService) LogoutAll(ctx context.Context, userId uint) error {
+	args := m.Called(ctx, userId)
+	return args.Error(0)
+}
+
+func (m *MockTokenRevocationChecker) IsRevoked(ctx context.Context, claims *services.JwtClaims) (bool, error) {
+	args := m.Called(ctx, claims)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *MockAccountService) ChangePassword(ctx context.Context, userId uint, username string, authTime time.Time, change services.PasswordChange) (services.AuthTokens, error) {
+	args := m.Called(ctx, userId, username, authTime, change)
+	return args.Get(0).(services.AuthTokens), args.Error(1)
+}
+
+func (m *MockAccountService) DeleteAccount(ctx context.Context, userId uint, username string, authTime time.Time, deletion services.AccountDeletion) (services.DeleteAccountResult, error) {
+	args := m.Called(ctx, userId, username, authTime, deletion)
+	return args.Get(0).(services.DeleteAccountResult), args.Error(1)
+}
+
+func (m *MockPersonalAccessTokenService) CreateToken(ctx context.Context, userId uint, creation services.PersonalAccessTokenCreation) (services.CreatedPersonalAccessToken, error) {
+	args := m.Called(ctx, userId, creation)
+	return args.Get(0).(services.CreatedPersonalAccessToken), args.Error(1)
+}
+
+func (m *MockPersonalAccessTokenService) GetTokens(ctx context.Context, userId uint) (services.GetPersonalAccessTokensResult, error) {
+	args := m.Called(ctx, userId)
+	return args.Get(0).(services.GetPersonalAccessTokensResult), args.Error(1)
+}
+
+func (m *MockPersonalAccessTokenService) DeleteToken(ctx context.Context, userId uint, tokenId uint) error {
+	args := m.Called(ctx, userId, tokenId)
+	return args.Error(0)
+}
+
+func (m *MockPersonalAccessTokenService) Authenticate(ctx context.Context, token string) (services.PersonalAccessTokenAuth, error) {
+	args := m.Called(ctx, token)
+	return args.Get(0).(services.PersonalAccessTokenAuth), args.Error(1)
+}
+
+func (m *MockMfaService) GetStatus(ctx context.Context, userId uint) (services.MfaStatus, error) {
+	args := m.Called(ctx, userId)
+	return args.Get(0).(services.MfaStatus), args.Error(1)
+}
+
+func (m *MockMfaService) StartTotpEnrollment(ctx context.Context, userId uint, username string, authTime time.Time, start services.TotpEnrollmentStart) (services.TotpEnrollment, error) {
+	args := m.Called(ctx, userId, username, authTime, start)
+	return args.Get(0).(services.TotpEnrollment), args.Error(1)
+}
+
+func (m *MockMfaService) ConfirmTotpEnrollment(ctx context.Context, userId uint, username string, authTime time.Time, confirmation services.MfaConfirmation) (services.RecoveryCodes, error) {
+	args := m.Called(ctx, userId, username, authTime, confirmation)
+	return args.Get(0).(services.RecoveryCodes), args.Error(1)
+}
+
+func (m *MockMfaService) DisableTotp(ctx context.Context, userId uint, username string, authTime time.Time, confirmation services.MfaConfirmation) error {
+	args := m.Called(ctx, userId, username, authTime, confirmation)
+	return args.Error(0)
+}
+
+func (m *MockMfaService) RegenerateRecoveryCodes(ctx context.Context, userId uint, username string, authTime time.Time, confirmation services.MfaConfirmation) (services.RecoveryCodes, error) {
+	args := m.Called(ctx, userId, username, authTime, confirmation)
+	return args.Get(0).(services.RecoveryCodes), args.Error(1)
+}
+
+func (m *MockMfaService) MfaEnabled(ctx context.Context, userId uint) (bool, error) {
+	args := m.Called(ctx, userId)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *MockMfaService) VerifyCode(ctx context.Context, userId uint, code string) error {
+	args := m.Called(ctx, userId, code)
+	return args.Error(0)
+}
+
+func (m *MockOidcService) StartLogin(ctx context.Context, provider string) (services.OidcLogin, error) {
+	args := m.Called(ctx, provider)
+	return args.Get(0).(services.OidcLogin), args.Error(1)
+}
+
+func (m *MockOidcService) FinishLogin(ctx context.Context, provider string, callback services.OidcCallback, stateToken string) (services.LoginResult, error) {
+	args := m.Called(ctx, provider, callback, stateToken)
+	return args.Get(0).(services.LoginResult), args.Error(1)
+}
@@ -0,0 +1,164 @@
+package utils
+
+import (
+	"fmt"
+	"strings"
+)
+
+// DiffContextLines is the number of unchanged lines shown around each change in a unified diff.
+const DiffContextLines = 3
+
+// maxDiffCells bounds the size of the table used to find the longest common subsequence. Texts whose
+// differing parts are larger than this are diffed as a single replaced block.
+const maxDiffCells = 4_000_000
+
+type diffOp struct {
+	kind byte // ' ', '-' or '+'
+	line string
+}
+
+// UnifiedDiff returns the line-based difference between from and to in the unified diff format, labelled
+// with fromName and toName. It returns an empty string if both texts are equal.
+func UnifiedDiff(fromName, toName, from, to string) string {
+	if from == to {
+		return ""
+	}
+
+	ops := diffLines(strings.Split(from, "\n"), strings.Split(to, "\n"))
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "--- %s\n+++ %s\n", fromName, toName)
+
+	// line numbers before each op, 1-based
+	from_line, to_line := 1, 1
+	from_lines, to_lines := make([]int, len(ops)), make([]int, len(ops))
+	for i, op := range ops {
+		from_lines[i], to_lines[i] = from_line, to_line
+		if op.kind != '+' {
+			from_line++
+		}
+		if op.kind != '-' {
+			to_line++
+		}
+	}
+
+	for start := 0; start < len(ops); {
+		// find the next change and the extent of its hunk
+		first := start
+		for first < len(ops) && ops[first].kind == ' ' {
+			first++
+		}
+		if first == len(ops) {
+			break
+		}
+		hunk_start := max(first-DiffContextLines, start)
+		hunk_end := first
+		for i := first; i < len(ops); i++ {
+			if ops[i].kind != ' ' {
+				hunk_end = i + 1
+			} else if i-hunk_end >= 2*DiffContextLines {
+				break
+			}
+		}
+		hunk_end = min(hunk_end+DiffContextLines, len(ops))
+
+		from_count, to_count := 0, 0
+		for _, op := range ops[hunk_start:hunk_end] {
+			if op.kind != '+' {
+				from_count++
+			}
+			if op.kind != '-' {
+				to_count++
+			}
+		}
+		fmt.Fprintf(&b, "@@ -%s +%s @@\n", hunkRange(from_lines[hunk_start], from_count), hunkRange(to_lines[hunk_start], to_count))
+		for _, op := range ops[hunk_start:hunk_end] {
+			b.WriteByte(op.kind)
+			b.WriteString(op.line)
+			b.WriteByte('\n')
+		}
+		start = hunk_end
+	}
+
+	return b.String()
+}
+
+// hunkRange formats the range of a hunk. Empty ranges start at the line before the hunk.
+func hunkRange(start, count int) string {
+	if count == 0 {
+		start--
+	}
+	return fmt.Sprintf("%d,%d", start, count)
+}
+
+// diffLines returns an edit script turning from into to, based on their longest common subsequence.
+func diffLines(from, to []string) []diffOp {
+	prefix := 0
+	for prefix < len(from) && prefix < len(to) && from[prefix] == to[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(from)-prefix && suffix < len(to)-prefix && from[len(from)-1-suffix] == to[len(to)-1-suffix] {
+		suffix++
+	}
+
+	var ops []diffOp
+	for _, line := range from[:prefix] {
+		ops = append(ops, diffOp{kind: ' ', line: line})
+	}
+
+	a, b := from[prefix:len(from)-suffix], to[prefix:len(to)-suffix]
+	if (len(a)+1)*(len(b)+1) > maxDiffCells {
+		for _, line := range a {
+			ops = append(ops, diffOp{kind: '-', line: line})
+		}
+		for _, line := range b {
+			ops = append(ops, diffOp{kind: '+', line: line})
+		}
+	} else {
+		ops = append(ops, lcsDiff(a, b)...)
+	}
+
+	for _, line := range from[len(from)-suffix:] {
+		ops = append(ops, diffOp{kind: ' ', line: line})
+	}
+	return ops
+}
+
+func lcsDiff(a, b []string) []diffOp {
+	// lengths[i][j] is the length of the longest common subsequence of a[i:] and b[j:]
+	width := len(b) + 1
+	lengths := make([]int32, (len(a)+1)*width)
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lengths[i*width+j] = lengths[(i+1)*width+j+1] + 1
+			} else {
+				lengths[i*width+j] = max(lengths[(i+1)*width+j], lengths[i*width+j+1])
+			}
+		}
+	}
+
+	var ops []diffOp
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		if a[i] == b[j] {
+			ops = append(ops, diffOp{kind: ' ', line: a[i]})
+			i++
+			j++
+		} else if lengths[(i+1)*width+j] >= lengths[i*width+j+1] {
+			ops = append(ops, diffOp{kind: '-', line: a[i]})
+			i++
+		} else {
+			ops = append(ops, diffOp{kind: '+', line: b[j]})
+			j++
+		}
+	}
+	for ; i < len(a); i++ {
+		ops = append(ops, diffOp{kind: '-', line: a[i]})
+	}
+	for ; j < len(b); j++ {
+		ops = append(ops, diffOp{kind: '+', line: b[j]})
+	}
+	return ops
+}
@@ -0,0 +1,261 @@
+package utils
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// ParsedHashString holds the parts of a hash string in the PHC string format
+// https://github.com/P-H-C/phc-string-format/blob/master/phc-sf-spec.md:
+//
+//	$<id>[$v=<version>][$<param>=<value>(,<param>=<value>)*][$<salt>[$<hash>]]
+//
+// Parameter values are decimal numbers, salt and hash are encoded in base64 without padding.
+type ParsedHashString struct {
+	Hash    []byte
+	Salt    []byte
+	Id      string
+	Version int
+	Params  map[string]uint32
+	// ParamOrder is the order of the parameters in the hash string. Parameters that are not listed follow
+	// in alphabetical order.
+	ParamOrder []string
+}
+
+// Limits of hash strings, longer ones are rejected before they are parsed.
+const (
+	MaxHashStringLength = 1024
+	MaxHashStringParams = 16
+	maxHashStringName   = 32
+)
+
+// Malformations of hash strings, wrapped in ErrorMalformedHashString.
+var (
+	ErrHashStringTooLong         = errors.New("hash string is too long")
+	ErrHashStringStructure       = errors.New("hash string does not have the structure $id[$v=version][$params][$salt[$hash]]")
+	ErrHashStringId              = errors.New("id must consist of 1 to 32 letters, digits and dashes")
+	ErrHashStringVersion         = errors.New("version must be a positive decimal number of at most 31 bits")
+	ErrHashStringParamName       = errors.New("parameter names must consist of 1 to 32 lowercase letters, digits and dashes and must not be v")
+	ErrHashStringParamValue      = errors.New("parameter values must be decimal numbers without leading zeros of at most 32 bits")
+	ErrHashStringDuplicateParam  = errors.New("parameter is given more than once")
+	ErrHashStringTooManyParams   = errors.New("hash string has too many parameters")
+	ErrHashStringBase64          = errors.New("salt and hash must be non-empty canonical base64 without padding")
+	ErrHashStringHashWithoutSalt = errors.New("hash requires a salt")
+)
+
+// ErrorMalformedHashString is returned for hash strings that do not follow the PHC string format. Part is
+// the part of the hash string that is malformed, Err one of the ErrHashString errors.
+type ErrorMalformedHashString struct {
+	Part string
+	Err  error
+}
+
+func (e *ErrorMalformedHashString) Error() string {
+	if e.Part == "" {
+		return fmt.Sprintf("malformed hash string: %v", e.Err)
+	}
+	return fmt.Sprintf("malformed hash string: %s: %v", e.Part, e.Err)
+}
+
+func (e *ErrorMalformedHashString) Unwrap() error {
+	return e.Err
+}
+
+func malformed(part string, err error) error {
+	return &ErrorMalformedHashString{Part: part, Err: err}
+}
+
+// validName reports whether name consists of 1 to 32 lowercase letters, digits and dashes. Ids may contain
+// uppercase letters as well, since Argon2IdLegacyId does.
+func validName(name string, uppercase bool) bool {
+	if len(name) == 0 || len(name) > maxHashStringName {
+		return false
+	}
+	for _, c := range []byte(name) {
+		if !('a' <= c && c <= 'z' || '0' <= c && c <= '9' || c == '-' || uppercase && 'A' <= c && c <= 'Z') {
+			return false
+		}
+	}
+	return true
+}
+
+// parseDecimal parses a decimal number without sign and leading zeros, so it is encoded the same way again.
+func parseDecimal(value string) (uint32, bool) {
+	if len(value) == 0 || len(value) > 1 && value[0] == '0' || strings.TrimLeft(value, "0123456789") != "" {
+		return 0, false
+	}
+	parsed, err := strconv.ParseUint(value, 10, 32)
+	return uint32(parsed), err == nil
+}
+
+// decodeBase64 decodes non-empty base64 without padding. The decoder ignores line breaks and Strict rejects
+// unused bits that are set, so only the canonical encoding of the bytes is accepted.
+func decodeBase64(encoded string) ([]byte, bool) {
+	if len(encoded) == 0 || strings.ContainsAny(encoded, "\r\n") {
+		return nil, false
+	}
+	decoded, err := base64.RawStdEncoding.Strict().DecodeString(encoded)
+	return decoded, err == nil
+}
+
+// sortedParams returns the parameter names in the order of ParamOrder, followed by the remaining names
+// in alphabetical order.
+func (ph *ParsedHashString) sortedParams() []string {
+	names := make([]string, 0, len(ph.Params))
+	for _, name := range ph.ParamOrder {
+		if _, found := ph.Params[name]; found && !slices.Contains(names, name) {
+			names = append(names, name)
+		}
+	}
+
+	remaining := make([]string, 0, len(ph.Params)-len(names))
+	for name := range ph.Params {
+		if !slices.Contains(names, name) {
+			remaining = append(remaining, name)
+		}
+	}
+	slices.Sort(remaining)
+	return append(names, remaining...)
+}
+
+// EncodeHashString encodes the parts into a hash string, parameters in the order of sortedParams. Hash
+// strings that would not be parsed by ParseHashString are rejected, so both round-trip.
+func EncodeHashString(ph *ParsedHashString) (string, error) {
+	if !validName(ph.Id, true) {
+		return "", malformed("id", ErrHashStringId)
+	}
+	if ph.Version < 0 || ph.Version > math.MaxInt32 {
+		return "", malformed("version", ErrHashStringVersion)
+	}
+	if len(ph.Params) > MaxHashStringParams {
+		return "", malformed("params", ErrHashStringTooManyParams)
+	}
+	if len(ph.Hash) > 0 && len(ph.Salt) == 0 {
+		return "", malformed("hash", ErrHashStringHashWithoutSalt)
+	}
+
+	var hash_string strings.Builder
+	hash_string.WriteString("$" + ph.Id)
+
+	if ph.Version > 0 {
+		hash_string.WriteString("$v=" + strconv.Itoa(ph.Version))
+	}
+
+	for i, name := range ph.sortedParams() {
+		if !validName(name, false) || name == "v" {
+			return "", malformed("params", ErrHashStringParamName)
+		}
+
+		separator := ","
+		if i == 0 {
+			separator = "$"
+		}
+		hash_string.WriteString(separator + name + "=" + strconv.FormatUint(uint64(ph.Params[name]), 10))
+	}
+
+	// hash may only be present if a salt is present
+	if len(ph.Salt) > 0 {
+		hash_string.WriteString("$" + base64.RawStdEncoding.EncodeToString(ph.Salt))
+	}
+	if len(ph.Hash) > 0 {
+		hash_string.WriteString("$" + base64.RawStdEncoding.EncodeToString(ph.Hash))
+	}
+
+	if hash_string.Len() > MaxHashStringLength {
+		return "", malformed("", ErrHashStringTooLong)
+	}
+	return hash_string.String(), nil
+}
+
+// ParseHashString parses a hash string in the PHC string format. Every malformation, including hash strings
+// that are not encoded like EncodeHashString would encode their parts, is returned as ErrorMalformedHashString.
+func ParseHashString(hash_string string) (ParsedHashString, error) {
+	if len(hash_string) > MaxHashStringLength {
+		return ParsedHashString{}, malformed("", ErrHashStringTooLong)
+	}
+
+	// at most id, version, params, salt and hash follow the leading $
+	segments := strings.Split(hash_string, "$")
+	if segments[0] != "" || len(segments) < 2 || len(segments) > 6 {
+		return ParsedHashString{}, malformed("", ErrHashStringStructure)
+	}
+	segments = segments[1:]
+
+	var ph ParsedHashString
+	ph.Id = segments[0]
+	if !validName(ph.Id, true) {
+		return ParsedHashString{}, malformed("id", ErrHashStringId)
+	}
+	segments = segments[1:]
+
+	if len(segments) > 0 && strings.HasPrefix(segments[0], "v=") {
+		version, ok := parseDecimal(strings.TrimPrefix(segments[0], "v="))
+		if !ok || version == 0 || version > math.MaxInt32 {
+			return ParsedHashString{}, malformed("version", ErrHashStringVersion)
+		}
+		ph.Version = int(version)
+		segments = segments[1:]
+	}
+
+	if len(segments) > 0 && strings.Contains(segments[0], "=") {
+		err := ph.parseParams(segments[0])
+		if err != nil {
+			return ParsedHashString{}, err
+		}
+		segments = segments[1:]
+	}
+
+	if len(segments) > 2 {
+		return ParsedHashString{}, malformed("", ErrHashStringStructure)
+	}
+
+	if len(segments) > 0 {
+		salt, ok := decodeBase64(segments[0])
+		if !ok {
+			return ParsedHashString{}, malformed("salt", ErrHashStringBase64)
+		}
+		ph.Salt = salt
+	}
+
+	if len(segments) > 1 {
+		hash, ok := decodeBase64(segments[1])
+		if !ok {
+			return ParsedHashString{}, malformed("hash", ErrHashStringBase64)
+		}
+		ph.Hash = hash
+	}
+
+	return ph, nil
+}
+
+func (ph *ParsedHashString) parseParams(segment string) error {
+	pairs := strings.Split(segment, ",")
+	if len(pairs) > MaxHashStringParams {
+		return malformed("params", ErrHashStringTooManyParams)
+	}
+
+	ph.Params = make(map[string]uint32, len(pairs))
+	ph.ParamOrder = make([]string, 0, len(pairs))
+	for _, pair := range pairs {
+		name, value, found := strings.Cut(pair, "=")
+		if !found || !validName(name, false) || name == "v" {
+			return malformed("params", ErrHashStringParamName)
+		}
+		if _, duplicate := ph.Params[name]; duplicate {
+			return malformed("params", ErrHashStringDuplicateParam)
+		}
+
+		parsed, ok := parseDecimal(value)
+		if !ok {
+			return malformed("params", ErrHashStringParamValue)
+		}
+		ph.Params[name] = parsed
+		ph.ParamOrder = append(ph.ParamOrder, name)
+	}
+	return nil
+}
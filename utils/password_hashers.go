@@ -0,0 +1,221 @@
+package utils
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/crypto/scrypt"
+)
+
+// PasswordAlgorithm hashes passwords into hash strings and verifies passwords against hash strings
+// with the parameters stored in them, so stored passwords stay valid when the parameters change.
+type PasswordAlgorithm interface {
+	Hash(password []byte) (string, error)
+	Verify(hash_string string, password []byte) (bool, error)
+	// NeedsRehash reports whether the hash string was created with weaker parameters than the current ones.
+	NeedsRehash(hash_string string) bool
+}
+
+// ErrorUnsupportedHash is returned for hash strings of algorithms that are not registered.
+type ErrorUnsupportedHash struct {
+	Id string
+}
+
+func (e *ErrorUnsupportedHash) Error() string {
+	return fmt.Sprintf("unsupported password hash algorithm %q", e.Id)
+}
+
+// HashStringId returns the id of the algorithm of a hash string, like "argon2id" for
+// "$argon2id$v=19$...". It also works for bcrypt hashes like "$2b$12$...", which are not PHC strings.
+func HashStringId(hash_string string) (string, error) {
+	args := strings.SplitN(hash_string, "$", 3)
+	if len(args) < 3 || args[0] != "" || args[1] == "" {
+		return "", errors.New("hash string does not start with $id$")
+	}
+	return args[1], nil
+}
+
+// PasswordHashers is a PasswordAlgorithm that hashes passwords with the default algorithm and verifies
+// hash strings with the algorithm registered for their id. Hash strings of other algorithms than the
+// default need a rehash, so passwords are upgraded to the default algorithm on login.
+type PasswordHashers struct {
+	Default    PasswordAlgorithm
+	algorithms map[string]PasswordAlgorithm
+}
+
+// NewPasswordHashers returns hashers without registered algorithms, the default algorithm has to be
+// registered as well to verify its hash strings.
+func NewPasswordHashers(default_algorithm PasswordAlgorithm) *PasswordHashers {
+	hashers := PasswordHashers{Default: default_algorithm, algorithms: map[string]PasswordAlgorithm{}}
+	return &hashers
+}
+
+// Register makes the algorithm verify the hash strings with the given ids.
+func (h *PasswordHashers) Register(algorithm PasswordAlgorithm, ids ...string) {
+	for _, id := range ids {
+		h.algorithms[id] = algorithm
+	}
+}
+
+func (h *PasswordHashers) algorithm(hash_string string) (PasswordAlgorithm, error) {
+	id, err := HashStringId(hash_string)
+	if err != nil {
+		return nil, err
+	}
+
+	algorithm, found := h.algorithms[id]
+	if !found {
+		return nil, &ErrorUnsupportedHash{Id: id}
+	}
+	return algorithm, nil
+}
+
+func (h *PasswordHashers) Hash(password []byte) (string, error) {
+	return h.Default.Hash(password)
+}
+
+func (h *PasswordHashers) Verify(hash_string string, password []byte) (bool, error) {
+	algorithm, err := h.algorithm(hash_string)
+	if err != nil {
+		return false, err
+	}
+	return algorithm.Verify(hash_string, password)
+}
+
+func (h *PasswordHashers) NeedsRehash(hash_string string) bool {
+	algorithm, err := h.algorithm(hash_string)
+	if err != nil {
+		return true
+	}
+	return algorithm != h.Default || algorithm.NeedsRehash(hash_string)
+}
+
+// Ids of bcrypt hash strings, they differ in the handling of non-ASCII passwords by old implementations.
+var BcryptIds = []string{"2a", "2b", "2y"}
+
+// BcryptHasher hashes passwords with bcrypt. Passwords longer than 72 bytes cannot be hashed.
+type BcryptHasher struct {
+	Cost int
+}
+
+func (h *BcryptHasher) Hash(password []byte) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword(password, h.Cost)
+	return string(hash), err
+}
+
+func (h *BcryptHasher) Verify(hash_string string, password []byte) (bool, error) {
+	err := bcrypt.CompareHashAndPassword([]byte(hash_string), password)
+	if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (h *BcryptHasher) NeedsRehash(hash_string string) bool {
+	cost, err := bcrypt.Cost([]byte(hash_string))
+	return err != nil || cost < h.Cost
+}
+
+// ScryptId is the id of hash strings created by ScryptHasher, like "$scrypt$ln=15,r=8,p=1$<salt>$<hash>".
+const ScryptId = "scrypt"
+
+// scrypt parameter names in hash strings: the binary logarithm of the cost N, block size and parallelism.
+const (
+	scryptLogNParam = "ln"
+	scryptRParam    = "r"
+	scryptPParam    = "p"
+)
+
+var scryptParamOrder = []string{scryptLogNParam, scryptRParam, scryptPParam, pepperKeyIdParam}
+
+// ScryptHasher hashes passwords with scrypt, the cost parameter N is 2^LogN. If Peppers is set, passwords
+// are combined with the current pepper before they are hashed.
+type ScryptHasher struct {
+	LogN    uint32
+	R       uint32
+	P       uint32
+	KeyLen  uint32
+	SaltLen uint32
+	Peppers *Peppers
+}
+
+func (h *ScryptHasher) Hash(password []byte) (string, error) {
+	salt := make([]byte, h.SaltLen)
+	_, err := rand.Read(salt)
+	if err != nil {
+		return "", fmt.Errorf("could not generate salt: %w", err)
+	}
+
+	params := map[string]uint32{scryptLogNParam: h.LogN, scryptRParam: h.R, scryptPParam: h.P}
+	password, err = h.Peppers.current(params, password)
+	if err != nil {
+		return "", err
+	}
+
+	hash, err := scrypt.Key(password, salt, 1<<h.LogN, int(h.R), int(h.P), int(h.KeyLen))
+	if err != nil {
+		return "", fmt.Errorf("could not generate hash: %w", err)
+	}
+
+	return EncodeHashString(&ParsedHashString{Id: ScryptId, Hash: hash, Salt: salt, Params: params, ParamOrder: scryptParamOrder})
+}
+
+// storedHasher returns the hasher the hash string was created with.
+func (h *ScryptHasher) storedHasher(ph *ParsedHashString) (*ScryptHasher, error) {
+	log_n, has_log_n := ph.Params[scryptLogNParam]
+	r, has_r := ph.Params[scryptRParam]
+	p, has_p := ph.Params[scryptPParam]
+	if !has_log_n || !has_r || !has_p {
+		return nil, errors.New("scrypt hash string requires ln, r and p parameters")
+	}
+	// scrypt.Key rejects r*p >= 2^30 itself
+	if log_n == 0 || log_n > 30 || r == 0 || p == 0 {
+		return nil, fmt.Errorf("invalid scrypt parameters ln=%d, r=%d, p=%d", log_n, r, p)
+	}
+	return &ScryptHasher{LogN: log_n, R: r, P: p, KeyLen: uint32(len(ph.Hash)), SaltLen: uint32(len(ph.Salt))}, nil
+}
+
+func (h *ScryptHasher) Verify(hash_string string, password []byte) (bool, error) {
+	ph, err := ParseHashString(hash_string)
+	if err != nil {
+		return false, err
+	}
+	if len(ph.Hash) == 0 || len(ph.Salt) == 0 {
+		return false, errors.New("scrypt hash string requires salt and hash")
+	}
+
+	stored, err := h.storedHasher(&ph)
+	if err != nil {
+		return false, err
+	}
+
+	password, err = h.Peppers.apply(ph.Params, password)
+	if err != nil {
+		return false, err
+	}
+
+	hash, err := scrypt.Key(password, ph.Salt, 1<<stored.LogN, int(stored.R), int(stored.P), len(ph.Hash))
+	if err != nil {
+		return false, err
+	}
+	return subtle.ConstantTimeCompare(hash, ph.Hash) == 1, nil
+}
+
+// NeedsRehash reports whether cost, block size, key length or salt length of the hash string are lower
+// than those of h, or the password was not combined with the current pepper.
+func (h *ScryptHasher) NeedsRehash(hash_string string) bool {
+	ph, err := ParseHashString(hash_string)
+	if err != nil {
+		return true
+	}
+
+	stored, err := h.storedHasher(&ph)
+	if err != nil || h.Peppers.needsRehash(ph.Params) {
+		return true
+	}
+	return stored.LogN < h.LogN || stored.R < h.R || stored.KeyLen < h.KeyLen || stored.SaltLen < h.SaltLen
+}
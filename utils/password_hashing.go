@@ -1,13 +1,11 @@
 package utils
 
 import (
-	"bytes"
 	"crypto/rand"
-	"encoding/base64"
+	"crypto/subtle"
 	"errors"
 	"fmt"
-	"strconv"
-	"strings"
+	"math"
 
 	"golang.org/x/crypto/argon2"
 )
@@ -21,20 +19,15 @@ type PasswordComparer interface {
 	Compare(hash, salt, password []byte) (bool, error)
 }
 
-type ParsedHashString struct {
-	Hash    []byte
-	Salt    []byte
-	Id      string
-	Version int
-	Params  map[string]uint32
-}
-
+// Argon2IdHasher hashes passwords with Argon2id. If Peppers is set, passwords are combined with the
+// current pepper before they are hashed.
 type Argon2IdHasher struct {
 	Time    uint32
 	Memory  uint32
 	Threads uint8
 	KeyLen  uint32
 	SaltLen uint32
+	Peppers *Peppers
 }
 
 func (h *Argon2IdHasher) GenerateHash(password, salt []byte) ([]byte, error) {
@@ -69,93 +62,122 @@ func (h *Argon2IdHasher) Compare(hash, salt, password []byte) (bool, error) {
 		return false, err
 	}
 
-	return (bytes.Equal(hashed_pw, hash)), nil
+	return subtle.ConstantTimeCompare(hashed_pw, hash) == 1, nil
 }
 
-// hash string encoding according to https://github.com/P-H-C/phc-string-format/blob/master/phc-sf-spec.md
-func EncodeHashString(ph *ParsedHashString) (string, error) {
-	if len(ph.Id) == 0 {
-		return "", errors.New("no id")
-	}
+// Argon2IdId is the id of hash strings created by Argon2IdHasher. Argon2IdLegacyId was used before the
+// parameters were stored in the hash string.
+const (
+	Argon2IdId       = "argon2id"
+	Argon2IdLegacyId = "Argon2id"
+)
 
-	hash_string := "$" + ph.Id
+// Argon2 parameter names in hash strings: memory in KiB, iterations and parallelism.
+const (
+	argon2MemoryParam  = "m"
+	argon2TimeParam    = "t"
+	argon2ThreadsParam = "p"
+)
 
-	if ph.Version > 0 {
-		hash_string += "$v=" + strconv.Itoa(ph.Version)
+// argon2ParamOrder is the order of the parameters in the hash strings of the reference implementation.
+var argon2ParamOrder = []string{argon2MemoryParam, argon2TimeParam, argon2ThreadsParam, pepperKeyIdParam}
+
+// Params returns the memory, time and thread parameters. Key and salt length are given by the lengths
+// of hash and salt in the hash string.
+func (h *Argon2IdHasher) Params() map[string]uint32 {
+	return map[string]uint32{argon2MemoryParam: h.Memory, argon2TimeParam: h.Time, argon2ThreadsParam: uint32(h.Threads)}
+}
+
+// storedHasher returns the hasher the hash string was created with. Hash strings without parameters
+// were created before they were stored and use the parameters of h.
+func (h *Argon2IdHasher) storedHasher(ph *ParsedHashString) (*Argon2IdHasher, error) {
+	stored := Argon2IdHasher{Time: h.Time, Memory: h.Memory, Threads: h.Threads, KeyLen: uint32(len(ph.Hash)),
+		SaltLen: uint32(len(ph.Salt))}
+	if len(ph.Params) == 0 {
+		return &stored, nil
 	}
 
-	if len(ph.Params) > 0 {
-		hash_string += "$"
-		for key, val := range ph.Params {
-			if key == "v" {
-				return "", errors.New("parameter name must not be equal to v")
-			}
-			hash_string += key + "=" + strconv.FormatUint(uint64(val), 10) + ","
-		}
-		// remove trailing comma
-		hash_string = strings.TrimSuffix(hash_string, ",")
+	memory, has_memory := ph.Params[argon2MemoryParam]
+	time, has_time := ph.Params[argon2TimeParam]
+	threads, has_threads := ph.Params[argon2ThreadsParam]
+	if !has_memory || !has_time || !has_threads {
+		return nil, errors.New("argon2 hash string requires m, t and p parameters")
+	}
+	if memory == 0 || time == 0 || threads == 0 || threads > math.MaxUint8 {
+		return nil, fmt.Errorf("invalid argon2 parameters m=%d, t=%d, p=%d", memory, time, threads)
 	}
 
-	if len(ph.Hash) > 0 && len(ph.Salt) == 0 {
-		return "", errors.New("received hash without salt")
+	stored.Memory, stored.Time, stored.Threads = memory, time, uint8(threads)
+	return &stored, nil
+}
+
+// Hash returns the hash string of the password with a new salt, including the parameters.
+func (h *Argon2IdHasher) Hash(password []byte) (string, error) {
+	salt, err := h.GenerateSalt()
+	if err != nil {
+		return "", fmt.Errorf("could not generate salt: %w", err)
 	}
 
-	if len(ph.Salt) > 0 {
-		encoded_salt := "$" + base64.RawStdEncoding.EncodeToString(ph.Salt)
-		hash_string += encoded_salt
+	params := h.Params()
+	password, err = h.Peppers.current(params, password)
+	if err != nil {
+		return "", err
+	}
 
-		// hash may only be present if a salt is present
-		if len(ph.Hash) > 0 {
-			encoded_hash := "$" + base64.RawStdEncoding.EncodeToString(ph.Hash)
-			hash_string += encoded_hash
-		}
+	hash, err := h.GenerateHash(password, salt)
+	if err != nil {
+		return "", fmt.Errorf("could not generate hash: %w", err)
 	}
 
-	return hash_string, nil
+	return EncodeHashString(&ParsedHashString{Id: Argon2IdId, Version: argon2.Version, Hash: hash, Salt: salt, Params: params,
+		ParamOrder: argon2ParamOrder})
 }
 
-func ParseHashString(hash_string string) (ParsedHashString, error) {
-	args := strings.Split(hash_string, "$")
-
-	id := args[1]
-	var version int
-	i := 2
-	if args[i][:2] == "v=" {
-		version_slice := strings.Split(args[i], "=")
-		var err error
-		version, err = strconv.Atoi(version_slice[1])
-		if err != nil {
-			return ParsedHashString{}, fmt.Errorf("parse hash string: string to int failed: %w", err)
-		}
-		i++
-	}
-
-	params := make(map[string]uint32)
-	if strings.Contains(args[i], "=") {
-		kv_pairs := strings.Split(args[i], ",")
-		for idx := range kv_pairs {
-			kv := strings.Split(kv_pairs[idx], "=")
-			if len(kv) != 2 {
-				return ParsedHashString{}, errors.New("bad kv pair")
-			}
-
-			key := kv[0]
-			val, err := strconv.ParseUint(kv[1], 10, 32)
-			if err != nil {
-				return ParsedHashString{}, fmt.Errorf("parse hash string: failed to parse parameter value to uint32: %w", err)
-			}
-			params[key] = uint32(val)
-		}
-	}
-
-	salt, err := base64.RawStdEncoding.DecodeString(args[len(args)-2])
+// Verify hashes the password with the parameters of the hash string and compares it to its hash.
+func (h *Argon2IdHasher) Verify(hash_string string, password []byte) (bool, error) {
+	ph, err := ParseHashString(hash_string)
 	if err != nil {
-		return ParsedHashString{}, fmt.Errorf("parse hash string: base64 decoding of salt failed: %w", err)
+		return false, err
 	}
-	hash, err := base64.RawStdEncoding.DecodeString(args[len(args)-1])
+	return h.verify(&ph, password)
+}
+
+func (h *Argon2IdHasher) verify(ph *ParsedHashString, password []byte) (bool, error) {
+	if len(ph.Hash) == 0 || len(ph.Salt) == 0 {
+		return false, errors.New("argon2 hash string requires salt and hash")
+	}
+
+	stored, err := h.storedHasher(ph)
+	if err != nil {
+		return false, err
+	}
+
+	password, err = h.Peppers.apply(ph.Params, password)
+	if err != nil {
+		return false, err
+	}
+	return stored.Compare(ph.Hash, ph.Salt, password)
+}
+
+// NeedsRehash reports whether memory, time, key length or salt length of the hash string are lower than
+// those of h, the parameters were not stored or the password was not combined with the current pepper.
+// The number of threads does not change the strength of a hash.
+func (h *Argon2IdHasher) NeedsRehash(hash_string string) bool {
+	ph, err := ParseHashString(hash_string)
 	if err != nil {
-		return ParsedHashString{}, fmt.Errorf("parse hash string: base64 decoding of hash failed: %w", err)
+		return true
+	}
+	return h.needsRehash(&ph)
+}
+
+func (h *Argon2IdHasher) needsRehash(ph *ParsedHashString) bool {
+	if len(ph.Params) == 0 {
+		return true
 	}
 
-	return ParsedHashString{Hash: hash, Id: id, Salt: salt, Version: version, Params: params}, nil
+	stored, err := h.storedHasher(ph)
+	if err != nil || h.Peppers.needsRehash(ph.Params) {
+		return true
+	}
+	return stored.Memory < h.Memory || stored.Time < h.Time || stored.KeyLen < h.KeyLen || stored.SaltLen < h.SaltLen
 }
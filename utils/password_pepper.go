@@ -0,0 +1,68 @@
+package utils
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"fmt"
+	"maps"
+	"slices"
+)
+
+// pepperKeyIdParam is the hash string parameter holding the id of the pepper the password was hashed with.
+const pepperKeyIdParam = "keyid"
+
+// Peppers are secret keys, kept out of the database, that passwords are combined with by HMAC-SHA256
+// before they are hashed. New passwords use the key with the highest id, the id is stored in the hash
+// string, so older keys can still verify their hashes while passwords are rehashed on login.
+type Peppers struct {
+	CurrentId uint32
+	keys      map[uint32][]byte
+}
+
+// NewPeppers returns nil if there are no keys, so no pepper is applied. Key ids must not be 0.
+func NewPeppers(keys map[uint32][]byte) *Peppers {
+	if len(keys) == 0 {
+		return nil
+	}
+	return &Peppers{CurrentId: slices.Max(slices.Collect(maps.Keys(keys))), keys: keys}
+}
+
+// apply returns the password combined with the pepper of the hash string parameters, or the password itself
+// if the parameters do not name a pepper.
+func (p *Peppers) apply(params map[string]uint32, password []byte) ([]byte, error) {
+	id, found := params[pepperKeyIdParam]
+	if !found {
+		return password, nil
+	}
+
+	var key []byte
+	if p != nil {
+		key = p.keys[id]
+	}
+	if len(key) == 0 {
+		return nil, fmt.Errorf("unknown pepper key id %d", id)
+	}
+
+	mac := hmac.New(sha256.New, key)
+	mac.Write(password)
+	return mac.Sum(nil), nil
+}
+
+// current adds the id of the current pepper to the hash string parameters and returns the password
+// combined with it.
+func (p *Peppers) current(params map[string]uint32, password []byte) ([]byte, error) {
+	if p == nil {
+		return password, nil
+	}
+	params[pepperKeyIdParam] = p.CurrentId
+	return p.apply(params, password)
+}
+
+// needsRehash reports whether the hash string parameters name another pepper than the current one.
+func (p *Peppers) needsRehash(params map[string]uint32) bool {
+	id, found := params[pepperKeyIdParam]
+	if p == nil {
+		return found
+	}
+	return !found || id != p.CurrentId
+}
@@ -0,0 +1,87 @@
+package utils
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// secretBoxVersion starts every sealed secret, so the format can be changed later.
+const secretBoxVersion = "v1"
+
+// SecretBox encrypts secrets that have to be stored in the database but cannot be hashed, like TOTP
+// secrets, with AES-256-GCM. Like Peppers, it holds keys by id: new secrets are sealed with the key with the
+// highest id, which is stored with the ciphertext, so older keys can still open their secrets.
+type SecretBox struct {
+	CurrentId uint32
+	aeads     map[uint32]cipher.AEAD
+}
+
+// NewSecretBox returns nil if there are no keys. The AES keys are the SHA-256 hashes of the given keys, so
+// these should be long random strings. Key ids must not be 0.
+func NewSecretBox(keys map[uint32][]byte) *SecretBox {
+	if len(keys) == 0 {
+		return nil
+	}
+
+	aeads := map[uint32]cipher.AEAD{}
+	for id, key := range keys {
+		aes_key := sha256.Sum256(key)
+		// a 32 byte key is always valid for AES-256 and GCM accepts every AES cipher
+		block, _ := aes.NewCipher(aes_key[:])
+		aeads[id], _ = cipher.NewGCM(block)
+	}
+	return &SecretBox{CurrentId: slices.Max(slices.Collect(maps.Keys(keys))), aeads: aeads}
+}
+
+// Seal encrypts the secret with the current key as "v1$<key id>$<base64 of nonce and ciphertext>". The
+// additional data, e.g. the id of the owner, is authenticated but not stored, so a secret can only be
+// opened with the same additional data and cannot be copied to another user.
+func (b *SecretBox) Seal(secret []byte, additional_data []byte) (string, error) {
+	aead := b.aeads[b.CurrentId]
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(secret)+aead.Overhead())
+	_, err := rand.Read(nonce)
+	if err != nil {
+		return "", fmt.Errorf("generate nonce: %w", err)
+	}
+
+	sealed := aead.Seal(nonce, nonce, secret, additional_data)
+	return secretBoxVersion + "$" + strconv.FormatUint(uint64(b.CurrentId), 10) + "$" +
+		base64.RawStdEncoding.EncodeToString(sealed), nil
+}
+
+// Open decrypts a secret sealed by Seal with the same additional data.
+func (b *SecretBox) Open(sealed string, additional_data []byte) ([]byte, error) {
+	parts := strings.Split(sealed, "$")
+	if len(parts) != 3 || parts[0] != secretBoxVersion {
+		return nil, errors.New("malformed sealed secret")
+	}
+
+	id, err := strconv.ParseUint(parts[1], 10, 32)
+	if err != nil {
+		return nil, errors.New("malformed sealed secret")
+	}
+	aead := b.aeads[uint32(id)]
+	if aead == nil {
+		return nil, fmt.Errorf("unknown secret key id %d", id)
+	}
+
+	data, err := base64.RawStdEncoding.DecodeString(parts[2])
+	if err != nil || len(data) < aead.NonceSize() {
+		return nil, errors.New("malformed sealed secret")
+	}
+
+	secret, err := aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], additional_data)
+	if err != nil {
+		return nil, fmt.Errorf("open sealed secret: %w", err)
+	}
+	return secret, nil
+}
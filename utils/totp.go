@@ -0,0 +1,112 @@
+package utils
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	// TotpDigits and TotpPeriod are the defaults of RFC 6238, which all authenticator apps support.
+	TotpDigits = 6
+	TotpPeriod = 30 * time.Second
+
+	// totpSecretBytes is the size of generated secrets, the length of a SHA-1 HMAC as RFC 4226 recommends.
+	totpSecretBytes = 20
+)
+
+// totpEncoding is the unpadded base32 encoding of secrets in otpauth URIs.
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// GenerateTotpSecret returns a random secret, encoded in base32 as authenticator apps expect it.
+func GenerateTotpSecret() (string, error) {
+	secret := make([]byte, totpSecretBytes)
+	_, err := rand.Read(secret)
+	if err != nil {
+		return "", err
+	}
+	return totpEncoding.EncodeToString(secret), nil
+}
+
+// decodeTotpSecret decodes a base32 secret, ignoring case and padding.
+func decodeTotpSecret(secret string) ([]byte, error) {
+	key, err := totpEncoding.DecodeString(strings.TrimRight(strings.ToUpper(secret), "="))
+	if err != nil {
+		return nil, fmt.Errorf("invalid totp secret: %w", err)
+	}
+	return key, nil
+}
+
+// HotpCode returns the HMAC-SHA1 one-time password of RFC 4226 for the counter.
+func HotpCode(key []byte, counter uint64, digits int) string {
+	mac := hmac.New(sha1.New, key)
+	binary.Write(mac, binary.BigEndian, counter)
+	sum := mac.Sum(nil)
+
+	// dynamic truncation
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	modulo := uint32(1)
+	for range digits {
+		modulo *= 10
+	}
+	return fmt.Sprintf("%0*d", digits, value%modulo)
+}
+
+// TotpCounter returns the time step of RFC 6238 that t falls into.
+func TotpCounter(t time.Time) int64 {
+	return t.Unix() / int64(TotpPeriod/time.Second)
+}
+
+// TotpCode returns the code of the base32 secret for the time step.
+func TotpCode(secret string, counter int64) (string, error) {
+	key, err := decodeTotpSecret(secret)
+	if err != nil {
+		return "", err
+	}
+	return HotpCode(key, uint64(counter), TotpDigits), nil
+}
+
+// VerifyTotp checks the code against the time step of t and up to skew steps before and after it, which
+// allows for clocks that are slightly off. It returns the time step the code belongs to, so callers can
+// reject codes of steps that were already used.
+func VerifyTotp(secret string, code string, t time.Time, skew int64) (int64, bool, error) {
+	key, err := decodeTotpSecret(secret)
+	if err != nil {
+		return 0, false, err
+	}
+	if len(code) != TotpDigits {
+		return 0, false, nil
+	}
+
+	current := TotpCounter(t)
+	for counter := current - skew; counter <= current+skew; counter++ {
+		expected := HotpCode(key, uint64(counter), TotpDigits)
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			return counter, true, nil
+		}
+	}
+	return 0, false, nil
+}
+
+// TotpUri returns the otpauth URI for enrolling the secret in an authenticator app, usually shown as
+// QR code. The issuer and the account name label the entry in the app.
+func TotpUri(issuer string, account string, secret string) string {
+	query := url.Values{}
+	query.Set("secret", secret)
+	query.Set("issuer", issuer)
+	query.Set("algorithm", "SHA1")
+	query.Set("digits", fmt.Sprint(TotpDigits))
+	query.Set("period", fmt.Sprint(int(TotpPeriod/time.Second)))
+
+	label := url.PathEscape(issuer) + ":" + url.PathEscape(account)
+	return "otpauth://totp/" + label + "?" + query.Encode()
+}
@@ -0,0 +1,18 @@
+package utils
+
+import (
+	"golang.org/x/text/cases"
+	"golang.org/x/text/unicode/norm"
+)
+
+// NormalizeUsername returns the NFC form of the username, in which usernames are stored.
+func NormalizeUsername(username string) string {
+	return norm.NFC.String(username)
+}
+
+// UsernameKey returns the form of the username used to look users up and to keep usernames unique.
+// Usernames that only differ in case or in their Unicode representation, like "Ǆ" and "dž", have the
+// same key.
+func UsernameKey(username string) string {
+	return norm.NFKC.String(cases.Fold().String(norm.NFKC.String(username)))
+}
@@ -2,8 +2,12 @@ package utils
 
 import (
 	"bytes"
+	"errors"
 	"runtime"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -83,9 +87,9 @@ func TestHashStringEncoding(t *testing.T) {
 	assert.Equal(t, "argon2id", decoded_ph.Id)
 
 	params := make(map[string]uint32)
-	params["Time"] = 1
-	params["SaltLen"] = 32
-	params["Memory"] = 64 * 1024
+	params["time"] = 1
+	params["saltlen"] = 32
+	params["memory"] = 64 * 1024
 
 	// success with all args
 	ph = ParsedHashString{Hash: hash, Salt: salt, Id: "argon2id", Version: 19, Params: params}
@@ -130,3 +134,471 @@ func TestHashStringEncoding(t *testing.T) {
 	assert.Equal(t, "argon2id", decoded_ph.Id)
 	assert.Equal(t, params, decoded_ph.Params)
 }
+
+func TestHashStringParamOrder(t *testing.T) {
+	salt := []byte("random_salt")
+	params := map[string]uint32{"t": 1, "m": 65536, "p": 4, "keyid": 2, "data": 7}
+
+	// parameters are emitted in the given order, the others sorted
+	for range 10 {
+		str, err := EncodeHashString(&ParsedHashString{Id: "argon2id", Version: 19, Params: params, ParamOrder: []string{"m", "t", "p", "x"}, Salt: salt})
+		assert.NoError(t, err)
+		assert.Equal(t, "$argon2id$v=19$m=65536,t=1,p=4,data=7,keyid=2$cmFuZG9tX3NhbHQ", str)
+	}
+
+	// the order of parsed hash strings is kept
+	str := "$scrypt$r=8,ln=15,p=1$cmFuZG9tX3NhbHQ$aGFzaA"
+	ph, err := ParseHashString(str)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"r", "ln", "p"}, ph.ParamOrder)
+	encoded, err := EncodeHashString(&ph)
+	assert.NoError(t, err)
+	assert.Equal(t, str, encoded)
+
+	hash_string, err := (&Argon2IdHasher{Time: 1, SaltLen: 16, Memory: 8 * 1024, Threads: 1, KeyLen: 32}).Hash([]byte("password"))
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(hash_string, "$argon2id$v=19$m=8192,t=1,p=1$"), hash_string)
+}
+
+func TestParseHashStringMalformed(t *testing.T) {
+	for hash_string, expected := range map[string]error{
+		"":                            ErrHashStringStructure,
+		"argon2id$c2FsdA$aGFzaA":      ErrHashStringStructure,
+		"$":                           ErrHashStringId,
+		"$$c2FsdA":                    ErrHashStringId,
+		"$argon_2$c2FsdA":             ErrHashStringId,
+		"$" + strings.Repeat("a", 33): ErrHashStringId,
+		"$argon2id$v=":                ErrHashStringVersion,
+		"$argon2id$v=0":               ErrHashStringVersion,
+		"$argon2id$v=019":             ErrHashStringVersion,
+		"$argon2id$v=-1":              ErrHashStringVersion,
+		"$argon2id$v=2147483648":      ErrHashStringVersion,
+		"$argon2id$v=19,m=1":          ErrHashStringVersion,
+		"$argon2id$v=19$m=1,,t=1":     ErrHashStringParamName,
+		"$argon2id$v=19$M=1":          ErrHashStringParamName,
+		"$argon2id$v=19$=1":           ErrHashStringParamName,
+		"$argon2id$v=19$v=1":          ErrHashStringParamName,
+		"$argon2id$v=19$m=1,t":        ErrHashStringParamName,
+		"$argon2id$m=":                ErrHashStringParamValue,
+		"$argon2id$m=01":              ErrHashStringParamValue,
+		"$argon2id$m=+1":              ErrHashStringParamValue,
+		"$argon2id$m=4294967296":      ErrHashStringParamValue,
+		"$argon2id$m=1=2":             ErrHashStringParamValue,
+		"$argon2id$m=abc":             ErrHashStringParamValue,
+		"$argon2id$m=1,m=2":           ErrHashStringDuplicateParam,
+		"$argon2id$" + strings.Repeat("a=1,", 16) + "b=1": ErrHashStringTooManyParams,
+		"$argon2id$v=19$m=1$c2FsdA$aGFzaA$aGFzaA":         ErrHashStringStructure,
+		"$argon2id$v=19$m=1$$aGFzaA":                      ErrHashStringBase64,
+		"$argon2id$v=19$m=1$c2FsdA$":                      ErrHashStringBase64,
+		"$argon2id$c2FsdA==$aGFzaA":                       ErrHashStringParamName,
+		"$argon2id$c2Fsd$aGFzaA":                          ErrHashStringBase64,
+		"$argon2id$c2FsdB$aGFzaA":                         ErrHashStringBase64,
+		"$argon2id$c2Fs\ndA$aGFzaA":                       ErrHashStringBase64,
+		"$argon2id$c2FsdA$aGF-zaA":                        ErrHashStringBase64,
+		"$argon2id$c2FsdA$" + strings.Repeat("a", 1024):   ErrHashStringTooLong,
+	} {
+		_, err := ParseHashString(hash_string)
+		var errMalformed *ErrorMalformedHashString
+		assert.True(t, errors.As(err, &errMalformed), hash_string)
+		assert.ErrorIs(t, err, expected, hash_string)
+	}
+
+	for _, ph := range []ParsedHashString{
+		{Id: "Argon 2", Salt: []byte("salt")},
+		{Id: "argon2id", Version: -1},
+		{Id: "argon2id", Params: map[string]uint32{"v": 1}},
+		{Id: "argon2id", Params: map[string]uint32{"Time": 1}},
+		{Id: "argon2id", Hash: []byte("hash")},
+		{Id: "argon2id", Salt: make([]byte, MaxHashStringLength)},
+	} {
+		_, err := EncodeHashString(&ph)
+		var errMalformed *ErrorMalformedHashString
+		assert.True(t, errors.As(err, &errMalformed), ph)
+	}
+}
+
+// FuzzParseHashString checks that ParseHashString does not panic and that accepted hash strings are
+// encoded the same way again.
+func FuzzParseHashString(f *testing.F) {
+	for _, seed := range []string{
+		"$argon2id$v=19$m=65536,t=1,p=8$cmFuZG9tX3NhbHQ$aGFzaA",
+		"$Argon2id$v=19$cmFuZG9tX3NhbHQ$aGFzaA",
+		"$scrypt$ln=15,r=8,p=1,keyid=2$cmFuZG9tX3NhbHQ$aGFzaA",
+		"$mock$cmFuZG9tX3NhbHQ",
+		"$2b$12$R9h/cIPz0gi.URNNX3kh2OPST9/PgBkqquzi.Ss7KIUgO2t0jWMUW",
+		"$argon2id$v=19$m=1,m=2",
+		"$",
+		"",
+	} {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, hash_string string) {
+		ph, err := ParseHashString(hash_string)
+		if err != nil {
+			var errMalformed *ErrorMalformedHashString
+			if !errors.As(err, &errMalformed) {
+				t.Fatalf("ParseHashString(%q) returned untyped error %v", hash_string, err)
+			}
+			return
+		}
+
+		encoded, err := EncodeHashString(&ph)
+		if err != nil {
+			t.Fatalf("EncodeHashString of parsed %q failed: %v", hash_string, err)
+		}
+		if encoded != hash_string {
+			t.Fatalf("ParseHashString(%q) encodes to %q", hash_string, encoded)
+		}
+	})
+}
+
+// FuzzEncodeHashString checks that the parts of accepted hash strings are parsed again.
+func FuzzEncodeHashString(f *testing.F) {
+	f.Add("argon2id", 19, "m", uint32(65536), "t", uint32(1), []byte("random_salt"), []byte("hash"))
+	f.Add("scrypt", 0, "ln", uint32(15), "keyid", uint32(0), []byte("random_salt"), []byte{})
+	f.Add("v", 1, "v", uint32(1), "", uint32(0), []byte{}, []byte("hash"))
+
+	f.Fuzz(func(t *testing.T, id string, version int, name1 string, value1 uint32, name2 string, value2 uint32, salt, hash []byte) {
+		ph := ParsedHashString{Id: id, Version: version, Salt: salt, Hash: hash, Params: map[string]uint32{}}
+		for name, value := range map[string]uint32{name1: value1, name2: value2} {
+			if name != "" {
+				ph.Params[name] = value
+			}
+		}
+
+		hash_string, err := EncodeHashString(&ph)
+		if err != nil {
+			var errMalformed *ErrorMalformedHashString
+			if !errors.As(err, &errMalformed) {
+				t.Fatalf("EncodeHashString(%+v) returned untyped error %v", ph, err)
+			}
+			return
+		}
+
+		parsed, err := ParseHashString(hash_string)
+		if err != nil {
+			t.Fatalf("ParseHashString(%q) of encoded %+v failed: %v", hash_string, ph, err)
+		}
+		if parsed.Id != ph.Id || parsed.Version != ph.Version || !bytes.Equal(parsed.Salt, ph.Salt) ||
+			!bytes.Equal(parsed.Hash, ph.Hash) || len(parsed.Params) != len(ph.Params) {
+			t.Fatalf("ParseHashString(%q) returned %+v instead of %+v", hash_string, parsed, ph)
+		}
+		for name, value := range ph.Params {
+			if parsed.Params[name] != value {
+				t.Fatalf("ParseHashString(%q) returned %+v instead of %+v", hash_string, parsed, ph)
+			}
+		}
+	})
+}
+
+func TestUnifiedDiff(t *testing.T) {
+	assert.Equal(t, "", UnifiedDiff("a", "b", "same\ntext", "same\ntext"))
+
+	from := "one\ntwo\nthree"
+	to := "one\n2\nthree\nfour"
+	expected := "--- a\n+++ b\n@@ -1,3 +1,4 @@\n one\n-two\n+2\n three\n+four\n"
+	assert.Equal(t, expected, UnifiedDiff("a", "b", from, to))
+
+	// changes far apart end up in separate hunks
+	var lines []string
+	for i := 1; i <= 20; i++ {
+		lines = append(lines, strconv.Itoa(i))
+	}
+	from = strings.Join(lines, "\n")
+	lines[1] = "two"
+	lines[18] = "nineteen"
+	to = strings.Join(lines, "\n")
+	expected = "--- a\n+++ b\n" +
+		"@@ -1,5 +1,5 @@\n 1\n-2\n+two\n 3\n 4\n 5\n" +
+		"@@ -16,5 +16,5 @@\n 16\n 17\n 18\n-19\n+nineteen\n 20\n"
+	assert.Equal(t, expected, UnifiedDiff("a", "b", from, to))
+
+	// insertion into an empty text
+	expected = "--- a\n+++ b\n@@ -1,1 +1,2 @@\n-\n+new\n+text\n"
+	assert.Equal(t, expected, UnifiedDiff("a", "b", "", "new\ntext"))
+}
+
+func TestUsernameKey(t *testing.T) {
+	for _, test := range []struct {
+		a, b  string
+		equal bool
+	}{
+		{a: "Alice", b: "alice", equal: true},
+		{a: "Alice", b: "ALICE", equal: true},
+		{a: "Zoë", b: "Zoe\u0308", equal: true},
+		{a: "Straße", b: "STRASSE", equal: true},
+		{a: "Ǆ", b: "dž", equal: true},
+		{a: "ｂｏｂ", b: "bob", equal: true},
+		{a: "Alice", b: "Alicia", equal: false},
+		{a: "Zoe", b: "Zoë", equal: false},
+	} {
+		assert.Equal(t, test.equal, UsernameKey(test.a) == UsernameKey(test.b), test.a+" "+test.b)
+	}
+
+	assert.Equal(t, "Zoë", NormalizeUsername("Zoe\u0308"))
+}
+
+func TestArgon2Params(t *testing.T) {
+	password := []byte("password")
+	weak := Argon2IdHasher{Time: 1, SaltLen: 16, Memory: 8 * 1024, Threads: 1, KeyLen: 32}
+	strong := Argon2IdHasher{Time: 2, SaltLen: 16, Memory: 16 * 1024, Threads: 2, KeyLen: 32}
+
+	str, err := weak.Hash(password)
+	assert.NoError(t, err)
+	assert.Contains(t, str, "$argon2id$v=19$")
+
+	ph, err := ParseHashString(str)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]uint32{"m": 8192, "t": 1, "p": 1}, ph.Params)
+
+	// the stored parameters are used for verification
+	isValid, err := strong.Verify(str, password)
+	assert.NoError(t, err)
+	assert.True(t, isValid)
+	isValid, err = strong.Verify(str, []byte("wrong_password"))
+	assert.NoError(t, err)
+	assert.False(t, isValid)
+
+	assert.True(t, strong.NeedsRehash(str))
+	assert.False(t, weak.NeedsRehash(str))
+	// fewer threads do not make a hash weaker
+	assert.False(t, (&Argon2IdHasher{Time: 1, SaltLen: 16, Memory: 8 * 1024, Threads: 4, KeyLen: 32}).NeedsRehash(str))
+	// neither does a shorter key or salt of the current hasher
+	assert.False(t, (&Argon2IdHasher{Time: 1, SaltLen: 8, Memory: 8 * 1024, Threads: 1, KeyLen: 16}).NeedsRehash(str))
+	assert.True(t, (&Argon2IdHasher{Time: 1, SaltLen: 16, Memory: 8 * 1024, Threads: 1, KeyLen: 64}).NeedsRehash(str))
+
+	// hash strings without parameters are verified with the current ones and need a rehash
+	legacy, err := EncodeHashString(&ParsedHashString{Id: Argon2IdLegacyId, Version: 19, Hash: ph.Hash, Salt: ph.Salt})
+	assert.NoError(t, err)
+	isValid, err = weak.Verify(legacy, password)
+	assert.NoError(t, err)
+	assert.True(t, isValid)
+	assert.True(t, weak.NeedsRehash(legacy))
+
+	for _, params := range []map[string]uint32{
+		{"m": 8192, "t": 1},
+		{"m": 0, "t": 1, "p": 1},
+		{"m": 8192, "t": 1, "p": 256},
+	} {
+		invalid, err := EncodeHashString(&ParsedHashString{Id: Argon2IdId, Version: 19, Hash: ph.Hash, Salt: ph.Salt, Params: params})
+		assert.NoError(t, err)
+		_, err = strong.Verify(invalid, password)
+		assert.Error(t, err)
+		assert.True(t, strong.NeedsRehash(invalid))
+	}
+}
+
+func TestPasswordHashers(t *testing.T) {
+	password := []byte("password")
+	argon2id := &Argon2IdHasher{Time: 1, SaltLen: 16, Memory: 8 * 1024, Threads: 1, KeyLen: 32}
+	scrypt := &ScryptHasher{LogN: 10, R: 8, P: 1, KeyLen: 32, SaltLen: 16}
+	bcrypt := &BcryptHasher{Cost: 4}
+
+	hashers := NewPasswordHashers(argon2id)
+	hashers.Register(argon2id, Argon2IdId, Argon2IdLegacyId)
+	hashers.Register(scrypt, ScryptId)
+	hashers.Register(bcrypt, BcryptIds...)
+
+	argon2id_hash, err := hashers.Hash(password)
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(argon2id_hash, "$argon2id$"))
+	scrypt_hash, err := scrypt.Hash(password)
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(scrypt_hash, "$scrypt$"))
+	bcrypt_hash, err := bcrypt.Hash(password)
+	assert.NoError(t, err)
+
+	// hashes of all algorithms are verified, only the default ones do not need a rehash
+	for _, hash_string := range []string{argon2id_hash, scrypt_hash, bcrypt_hash} {
+		isValid, err := hashers.Verify(hash_string, password)
+		assert.NoError(t, err)
+		assert.True(t, isValid, hash_string)
+
+		isValid, err = hashers.Verify(hash_string, []byte("wrong_password"))
+		assert.NoError(t, err)
+		assert.False(t, isValid, hash_string)
+
+		assert.Equal(t, hash_string != argon2id_hash, hashers.NeedsRehash(hash_string), hash_string)
+	}
+
+	// bcrypt hashes of other implementations use other ids
+	for _, id := range []string{"2b", "2y"} {
+		hash_string := "$" + id + strings.TrimPrefix(bcrypt_hash, "$2a")
+		isValid, err := hashers.Verify(hash_string, password)
+		assert.NoError(t, err)
+		assert.True(t, isValid, hash_string)
+	}
+
+	// scrypt and bcrypt hashes with lower costs need a rehash
+	assert.True(t, (&ScryptHasher{LogN: 11, R: 8, P: 1, KeyLen: 32, SaltLen: 16}).NeedsRehash(scrypt_hash))
+	assert.False(t, scrypt.NeedsRehash(scrypt_hash))
+	assert.True(t, (&BcryptHasher{Cost: 5}).NeedsRehash(bcrypt_hash))
+	assert.False(t, bcrypt.NeedsRehash(bcrypt_hash))
+
+	for _, hash_string := range []string{"$md5$salt$hash", "plaintext", "$$", ""} {
+		_, err := hashers.Verify(hash_string, password)
+		assert.Error(t, err, hash_string)
+		assert.True(t, hashers.NeedsRehash(hash_string))
+	}
+	_, err = hashers.Verify("$md5$salt$hash", password)
+	var errUnsupported *ErrorUnsupportedHash
+	assert.True(t, errors.As(err, &errUnsupported))
+	assert.Equal(t, "md5", errUnsupported.Id)
+}
+
+func TestPasswordPeppers(t *testing.T) {
+	password := []byte("password")
+	first := NewPeppers(map[uint32][]byte{1: []byte("first_pepper")})
+	rotated := NewPeppers(map[uint32][]byte{1: []byte("first_pepper"), 2: []byte("second_pepper")})
+	assert.Nil(t, NewPeppers(nil))
+	assert.Equal(t, uint32(2), rotated.CurrentId)
+
+	hasher := func(peppers *Peppers) []PasswordAlgorithm {
+		return []PasswordAlgorithm{
+			&Argon2IdHasher{Time: 1, SaltLen: 16, Memory: 8 * 1024, Threads: 1, KeyLen: 32, Peppers: peppers},
+			&ScryptHasher{LogN: 10, R: 8, P: 1, KeyLen: 32, SaltLen: 16, Peppers: peppers},
+		}
+	}
+	unpeppered, peppered, rotated_hashers := hasher(nil), hasher(first), hasher(rotated)
+	other_secret := hasher(NewPeppers(map[uint32][]byte{1: []byte("other_pepper")}))
+
+	for i := range unpeppered {
+		plain_hash, err := unpeppered[i].Hash(password)
+		assert.NoError(t, err)
+		first_hash, err := peppered[i].Hash(password)
+		assert.NoError(t, err)
+
+		ph, err := ParseHashString(first_hash)
+		assert.NoError(t, err)
+		assert.Equal(t, uint32(1), ph.Params["keyid"])
+
+		isValid, err := peppered[i].Verify(first_hash, password)
+		assert.NoError(t, err)
+		assert.True(t, isValid)
+		isValid, err = peppered[i].Verify(first_hash, []byte("wrong_password"))
+		assert.NoError(t, err)
+		assert.False(t, isValid)
+		assert.False(t, peppered[i].NeedsRehash(first_hash))
+
+		// the hash depends on the secret of the pepper
+		isValid, err = other_secret[i].Verify(first_hash, password)
+		assert.NoError(t, err)
+		assert.False(t, isValid)
+
+		// hashes without pepper stay valid and get one on the next login
+		isValid, err = peppered[i].Verify(plain_hash, password)
+		assert.NoError(t, err)
+		assert.True(t, isValid)
+		assert.True(t, peppered[i].NeedsRehash(plain_hash))
+
+		// after a rotation, hashes of the previous pepper stay valid and need a rehash
+		isValid, err = rotated_hashers[i].Verify(first_hash, password)
+		assert.NoError(t, err)
+		assert.True(t, isValid)
+		assert.True(t, rotated_hashers[i].NeedsRehash(first_hash))
+
+		second_hash, err := rotated_hashers[i].Hash(password)
+		assert.NoError(t, err)
+		assert.False(t, rotated_hashers[i].NeedsRehash(second_hash))
+		ph, err = ParseHashString(second_hash)
+		assert.NoError(t, err)
+		assert.Equal(t, uint32(2), ph.Params["keyid"])
+
+		// hashes of removed peppers cannot be verified
+		_, err = peppered[i].Verify(second_hash, password)
+		assert.Error(t, err)
+		assert.True(t, peppered[i].NeedsRehash(second_hash))
+		_, err = unpeppered[i].Verify(first_hash, password)
+		assert.Error(t, err)
+		assert.True(t, unpeppered[i].NeedsRehash(first_hash))
+	}
+}
+
+func TestTotp(t *testing.T) {
+	// test vectors of RFC 4226 and RFC 6238 for SHA-1
+	key := []byte("12345678901234567890")
+	for counter, code := range []string{"755224", "287082", "359152", "969429", "338314", "254676", "287922", "162583", "399871", "520489"} {
+		assert.Equal(t, code, HotpCode(key, uint64(counter), 6))
+	}
+	for unix, code := range map[int64]string{59: "94287082", 1111111109: "07081804", 1111111111: "14050471",
+		1234567890: "89005924", 2000000000: "69279037", 20000000000: "65353130"} {
+		assert.Equal(t, code, HotpCode(key, uint64(TotpCounter(time.Unix(unix, 0))), 8))
+	}
+
+	secret, err := GenerateTotpSecret()
+	assert.NoError(t, err)
+	assert.Len(t, secret, 32)
+
+	now := time.Unix(1111111111, 0)
+	code, err := TotpCode(secret, TotpCounter(now))
+	assert.NoError(t, err)
+	counter, ok, err := VerifyTotp(secret, code, now, 1)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, TotpCounter(now), counter)
+
+	// codes of the neighbouring time steps are accepted, older ones are not
+	counter, ok, err = VerifyTotp(secret, code, now.Add(TotpPeriod), 1)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, TotpCounter(now), counter)
+	_, ok, err = VerifyTotp(secret, code, now.Add(2*TotpPeriod), 1)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	// secrets are case-insensitive, codes have to have all digits
+	_, ok, _ = VerifyTotp(strings.ToLower(secret), code, now, 0)
+	assert.True(t, ok)
+	_, ok, _ = VerifyTotp(secret, code[1:], now, 1)
+	assert.False(t, ok)
+	_, _, err = VerifyTotp("not base32!", code, now, 1)
+	assert.Error(t, err)
+
+	assert.Equal(t, "otpauth://totp/User-Notes-API:alice%20smith?algorithm=SHA1&digits=6&issuer=User-Notes-API&period=30&secret=JBSWY3DPEHPK3PXP",
+		TotpUri("User-Notes-API", "alice smith", "JBSWY3DPEHPK3PXP"))
+}
+
+func TestSecretBox(t *testing.T) {
+	secret := []byte("JBSWY3DPEHPK3PXP")
+	first := NewSecretBox(map[uint32][]byte{1: []byte("first_key")})
+	rotated := NewSecretBox(map[uint32][]byte{1: []byte("first_key"), 2: []byte("second_key")})
+	assert.Nil(t, NewSecretBox(nil))
+
+	sealed, err := first.Seal(secret, []byte("1"))
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(sealed, "v1$1$"))
+	assert.NotContains(t, sealed, string(secret))
+
+	// every seal uses a new nonce
+	sealed_again, err := first.Seal(secret, []byte("1"))
+	assert.NoError(t, err)
+	assert.NotEqual(t, sealed, sealed_again)
+
+	opened, err := first.Open(sealed, []byte("1"))
+	assert.NoError(t, err)
+	assert.Equal(t, secret, opened)
+
+	// the additional data has to match
+	_, err = first.Open(sealed, []byte("2"))
+	assert.Error(t, err)
+
+	// after a rotation, secrets of the previous key can be opened and new ones use the new key
+	opened, err = rotated.Open(sealed, []byte("1"))
+	assert.NoError(t, err)
+	assert.Equal(t, secret, opened)
+	rotated_sealed, err := rotated.Seal(secret, []byte("1"))
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(rotated_sealed, "v1$2$"))
+	_, err = first.Open(rotated_sealed, []byte("1"))
+	assert.ErrorContains(t, err, "unknown secret key id 2")
+
+	// keys with the same id but another secret cannot open it
+	_, err = NewSecretBox(map[uint32][]byte{1: []byte("other_key")}).Open(sealed, []byte("1"))
+	assert.Error(t, err)
+
+	for _, malformed := range []string{"", "v1$1", "v2$1$" + sealed[5:], "v1$x$" + sealed[5:], "v1$1$!!!", "v1$1$AAAA"} {
+		_, err = first.Open(malformed, []byte("1"))
+		assert.Error(t, err, malformed)
+	}
+}